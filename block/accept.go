@@ -0,0 +1,239 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+)
+
+var (
+	ErrNotGenesis   = errors.New("block is not a genesis block")
+	ErrOrphanBlock  = errors.New("block does not connect to the chain tip")
+	ErrBadSignature = transaction.ErrBadSignature
+	ErrBadCoinbase  = errors.New("only the first transaction of a block may be a coinbase")
+	ErrBadValue     = transaction.ErrBadValue
+	ErrNegativeFee  = transaction.ErrNegativeFee
+	ErrMissingInput = errors.New("input is spent or does not exist")
+	ErrBadSubsidy   = errors.New("coinbase pays more than the subsidy and the fees")
+	ErrBadBlock     = errors.New("block is malformed")
+
+	errNoCoinLookup = errors.New("block: no coin lookup is registered, import the utxo package")
+)
+
+// CoinLookup returns the unspent output vout of the transaction txID on the
+// chain c, nil when it is spent or doesn't exist
+type CoinLookup func(c *BlockChain, txID []byte, vout int) (*transaction.TxOutput, error)
+
+var lookupCoin CoinLookup
+
+// RegisterCoinLookup sets the lookup of the outputs spent by the blocks
+// accepted from peers, the UTXO set registers it in its init
+func RegisterCoinLookup(f CoinLookup) {
+	lookupCoin = f
+}
+
+// ChainExists reports whether the node already has a chain
+func ChainExists(nodeID string) bool {
+	return dbExists(nodeID)
+}
+
+// CreateBlockChainWithGenesis creates the chain of a new node from the genesis
+// block of its peers, the chain is then synced block by block
+func CreateBlockChainWithGenesis(genesis *Block, nodeID string) (*BlockChain, error) {
+	if len(genesis.PrevHash) != 0 || genesis.Height != 0 {
+		return nil, ErrNotGenesis
+	}
+	if len(genesis.Transactions) == 0 {
+		return nil, fmt.Errorf("%w: no transactions", ErrBadBlock)
+	}
+	if err := genesis.Header().CheckProofOfWork(); err != nil {
+		return nil, err
+	}
+
+	if dbExists(nodeID) {
+		return nil, ErrBlockchainExists
+	}
+	db, err := openDB(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucket([]byte(blocksBucket))
+		if err != nil {
+			return err
+		}
+		if err := b.Put(genesis.Hash, genesis.Serialize()); err != nil {
+			return err
+		}
+		if err := putHeight(tx, 0, genesis.Hash); err != nil {
+			return err
+		}
+		return b.Put([]byte("l"), genesis.Hash)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BlockChain{tip: genesis.Hash, DB: db}, nil
+}
+
+// AcceptBlock checks a block received from a peer and connects it to the tip
+// of the chain
+func (c *BlockChain) AcceptBlock(b *Block) error {
+	return c.acceptBlock(b, true)
+}
+
+// AcceptAssumedValidBlock is AcceptBlock without verifying the signatures of
+// the transactions. b must be an ancestor of AssumeValid in the best header
+// chain, see netsync.
+func (c *BlockChain) AcceptAssumedValidBlock(b *Block) error {
+	return c.acceptBlock(b, false)
+}
+
+func (c *BlockChain) acceptBlock(b *Block, verifySigs bool) error {
+	// 没有交易的块算不出 merkle 根
+	if len(b.Transactions) == 0 {
+		return fmt.Errorf("%w: no transactions", ErrBadBlock)
+	}
+	header := b.Header()
+	if err := header.CheckProofOfWork(); err != nil {
+		return err
+	}
+	if err := header.CheckCheckpoint(); err != nil {
+		return err
+	}
+	if c.isInvalid(b.Hash) {
+		return ErrInvalidBlock
+	}
+
+	return c.checkAndConnect(b, verifySigs)
+}
+
+// checkAndConnect connects b after checking that it extends the tip and that
+// its transactions are valid
+func (c *BlockChain) checkAndConnect(b *Block, verifySigs bool) error {
+	tip, err := c.GetBlock(c.Tip())
+	if err != nil {
+		return err
+	}
+	if err := b.Header().CheckConnects(tip.Header()); err != nil {
+		return ErrOrphanBlock
+	}
+	if err := c.checkTransactions(b, lookupCoin, verifySigs); err != nil {
+		return err
+	}
+
+	return c.ConnectBlock(b)
+}
+
+// checkTransactions verifies the signatures and the values of the
+// transactions of b, their inputs spend the outputs found by lookup or outputs
+// of earlier transactions of the same block. The coinbase may claim the
+// subsidy and the fees. The signatures are skipped unless verifySigs.
+func (c *BlockChain) checkTransactions(b *Block, lookup CoinLookup, verifySigs bool) error {
+	if lookup == nil {
+		return errNoCoinLookup
+	}
+
+	inBlock := make(map[string]*transaction.Transaction)
+	// 块内已经花费的输出，不能被后面的交易再花
+	spent := make(map[string]bool)
+	prevOut := func(in *transaction.TxInput) (*transaction.TxOutput, error) {
+		key := fmt.Sprintf("%x:%d", in.TxID, in.Vout)
+		if spent[key] {
+			return nil, fmt.Errorf("%w: %s", ErrMissingInput, key)
+		}
+		spent[key] = true
+
+		if prev, ok := inBlock[hex.EncodeToString(in.TxID)]; ok {
+			if in.Vout >= len(prev.Vout) {
+				return nil, fmt.Errorf("%w: %s", ErrMissingInput, key)
+			}
+			return &prev.Vout[in.Vout], nil
+		}
+		out, err := lookup(c, in.TxID, in.Vout)
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			return nil, fmt.Errorf("%w: %s", ErrMissingInput, key)
+		}
+		return out, nil
+	}
+
+	fees := 0
+	for i, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			if i != 0 {
+				return ErrBadCoinbase
+			}
+			inBlock[hex.EncodeToString(tx.ID)] = tx
+			continue
+		}
+
+		fee, prevTxs, err := tx.CheckInputs(prevOut)
+		if err != nil {
+			return err
+		}
+		if verifySigs {
+			if err := tx.Verify(prevTxs); err != nil {
+				return err
+			}
+		}
+		inBlock[hex.EncodeToString(tx.ID)] = tx
+		if fees > math.MaxInt-fee {
+			return ErrBadValue
+		}
+		fees += fee
+	}
+
+	return checkSubsidy(b, fees)
+}
+
+// checkSubsidy checks that the coinbase of b pays positive values summing to
+// at most the subsidy and the fees
+func checkSubsidy(b *Block, fees int) error {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return nil
+	}
+
+	value, err := b.Transactions[0].CheckOutputs()
+	if err != nil {
+		return err
+	}
+	if value-transaction.Subsidy > fees {
+		return ErrBadSubsidy
+	}
+
+	return nil
+}
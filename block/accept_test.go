@@ -0,0 +1,218 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+// newBlock mines a block on the tip of bc with a coinbase paying value to w
+// and txs, it is not connected
+func newBlock(t *testing.T, bc *blk.BlockChain, w *wallet.Wallet, value int, txs ...*transaction.Transaction) *blk.Block {
+	t.Helper()
+
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase, err := transaction.NewCoinbaseTx(string(w.GetAddress()), fmt.Sprintf("block %d", height+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase.Vout[0].Value = value
+	if err := coinbase.SetID(); err != nil {
+		t.Fatal(err)
+	}
+
+	return blk.NewBlock(append([]*transaction.Transaction{coinbase}, txs...), bc.Tip(), height+1)
+}
+
+func outputs(t *testing.T, w *wallet.Wallet, values ...int) []transaction.TxOutput {
+	t.Helper()
+
+	var outs []transaction.TxOutput
+	for _, v := range values {
+		out, err := transaction.NewTxOut(v, string(w.GetAddress()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outs = append(outs, out)
+	}
+	return outs
+}
+
+func TestAcceptBlock(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	to := chaintest.NewWallet(t)
+
+	// 同一块中后面的交易花费前面交易的输出
+	parent := chaintest.NewTx(t, bc, w, string(to.GetAddress()), transaction.Subsidy)
+	child := &transaction.Transaction{
+		Vin:  []transaction.TxInput{{TxID: parent.ID, Vout: 0, PubKey: to.PublicKey}},
+		Vout: outputs(t, w, transaction.Subsidy),
+	}
+	if err := child.SetID(); err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Sign(to.PrivateKey, map[string]transaction.Transaction{fmt.Sprintf("%x", parent.ID): *parent}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBlock(t, bc, w, transaction.Subsidy, parent, child)
+	if err := bc.AcceptBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	if string(bc.Tip()) != string(b.Hash) {
+		t.Errorf("the block is not the tip")
+	}
+}
+
+func TestRejectBadTransactions(t *testing.T) {
+	thief := chaintest.NewWallet(t)
+
+	// 每个用例都改写一笔花费创世奖励的交易
+	tests := []struct {
+		name   string
+		change func(t *testing.T, bc *blk.BlockChain, w *wallet.Wallet, tx *transaction.Transaction) *wallet.Wallet
+		want   error
+	}{
+		{"zero output", func(t *testing.T, _ *blk.BlockChain, _ *wallet.Wallet, tx *transaction.Transaction) *wallet.Wallet {
+			tx.Vout = outputs(t, thief, transaction.Subsidy, 0)
+			return nil
+		}, blk.ErrBadValue},
+		{"negative output", func(t *testing.T, _ *blk.BlockChain, _ *wallet.Wallet, tx *transaction.Transaction) *wallet.Wallet {
+			tx.Vout = outputs(t, thief, -1000, 1000+transaction.Subsidy)
+			return nil
+		}, blk.ErrBadValue},
+		{"overflow", func(t *testing.T, _ *blk.BlockChain, _ *wallet.Wallet, tx *transaction.Transaction) *wallet.Wallet {
+			tx.Vout = outputs(t, thief, math.MaxInt, 2)
+			return nil
+		}, blk.ErrBadValue},
+		{"more than inputs", func(t *testing.T, _ *blk.BlockChain, _ *wallet.Wallet, tx *transaction.Transaction) *wallet.Wallet {
+			tx.Vout = outputs(t, thief, transaction.Subsidy+1)
+			return nil
+		}, blk.ErrNegativeFee},
+		{"duplicate input", func(t *testing.T, _ *blk.BlockChain, _ *wallet.Wallet, tx *transaction.Transaction) *wallet.Wallet {
+			tx.Vin = append(tx.Vin, tx.Vin[0])
+			tx.Vout = outputs(t, thief, 2*transaction.Subsidy)
+			return nil
+		}, transaction.ErrDuplicateInput},
+		{"key of another wallet", func(t *testing.T, _ *blk.BlockChain, _ *wallet.Wallet, tx *transaction.Transaction) *wallet.Wallet {
+			// 用自己的密钥签名，签名本身是有效的
+			tx.Vin[0].PubKey = thief.PublicKey
+			return thief
+		}, transaction.ErrWrongKey},
+		{"spent output", func(t *testing.T, bc *blk.BlockChain, w *wallet.Wallet, _ *transaction.Transaction) *wallet.Wallet {
+			// 创世奖励先被另一笔交易花掉
+			spend := chaintest.NewTx(t, bc, w, string(w.GetAddress()), transaction.Subsidy)
+			if _, err := bc.MineBlock([]*transaction.Transaction{spend}); err != nil {
+				t.Fatal(err)
+			}
+			return nil
+		}, blk.ErrMissingInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := chaintest.NewChain(t)
+			tx := chaintest.NewTx(t, bc, w, string(thief.GetAddress()), transaction.Subsidy)
+			signer := tt.change(t, bc, w, tx)
+			if signer == nil {
+				signer = w
+			}
+			chaintest.Sign(t, bc, tx, signer)
+
+			tip := bc.Tip()
+			err := bc.AcceptBlock(newBlock(t, bc, w, transaction.Subsidy, tx))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if string(bc.Tip()) != string(tip) {
+				t.Errorf("the block was connected")
+			}
+		})
+	}
+}
+
+func TestRejectDoubleSpendInBlock(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	to := string(chaintest.NewWallet(t).GetAddress())
+
+	// 两笔交易花费同一个输出
+	first := chaintest.NewTx(t, bc, w, to, 1)
+	second := chaintest.NewTx(t, bc, w, to, 2)
+	err := bc.AcceptBlock(newBlock(t, bc, w, transaction.Subsidy, first, second))
+	if !errors.Is(err, blk.ErrMissingInput) {
+		t.Fatalf("got %v, want %v", err, blk.ErrMissingInput)
+	}
+}
+
+func TestRejectBadCoinbase(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []int
+		want   error
+	}{
+		{"subsidy and fees", []int{transaction.Subsidy + 2}, blk.ErrBadSubsidy},
+		{"negative output", []int{-1000, 1000 + transaction.Subsidy}, blk.ErrBadValue},
+		{"zero output", []int{transaction.Subsidy, 0}, blk.ErrBadValue},
+		{"overflow", []int{math.MaxInt, 2}, blk.ErrBadValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, w := chaintest.NewChain(t)
+			// 交易付 1 的手续费
+			tx := chaintest.NewTx(t, bc, w, string(w.GetAddress()), transaction.Subsidy)
+			tx.Vout = outputs(t, w, transaction.Subsidy-1)
+			chaintest.Sign(t, bc, tx, w)
+
+			b := newBlock(t, bc, w, transaction.Subsidy, tx)
+			b.Transactions[0].Vout = outputs(t, w, tt.values...)
+			if err := b.Transactions[0].SetID(); err != nil {
+				t.Fatal(err)
+			}
+			b = blk.NewBlock(b.Transactions, b.PrevHash, b.Height)
+			if err := bc.AcceptBlock(b); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// 领取补贴和手续费的 coinbase 可以接受
+	bc, w := chaintest.NewChain(t)
+	tx := chaintest.NewTx(t, bc, w, string(w.GetAddress()), transaction.Subsidy)
+	tx.Vout = outputs(t, w, transaction.Subsidy-1)
+	chaintest.Sign(t, bc, tx, w)
+	if err := bc.AcceptBlock(newBlock(t, bc, w, transaction.Subsidy+1, tx)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -30,6 +30,8 @@
 package block
 
 import (
+	"context"
+	"fmt"
 	"time"
 	"encoding/gob"
 	"bytes"
@@ -44,6 +46,10 @@ type Block struct {
 	Transactions []*transaction.Transaction
 	Hash         []byte
 	Nonce        int
+	Height       int
+
+	// 被裁剪的块只剩区块头，不会被序列化
+	header *BlockHeader
 }
 
 /*
@@ -54,17 +60,15 @@ func (b *Block) SetHash() {
 	b.Hash = hash[:]
 }*/
 
-func NewBlock(transactions []*transaction.Transaction, prevHash []byte) *Block {
-	b := &Block{time.Now().Unix(), prevHash, transactions, []byte{}, 0}
-	pow := NewProofOfWork(b)
-	nonce, hash := pow.Run()
-	b.Hash = hash
-	b.Nonce = nonce
+func NewBlock(transactions []*transaction.Transaction, prevHash []byte, height int) *Block {
+	b := &Block{TimeStamp: time.Now().Unix(), PrevHash: prevHash, Transactions: transactions, Hash: []byte{}, Height: height}
+	// 没有 ctx 时只会在找到解后返回
+	DefaultMiner.Mine(context.Background(), b)
 	return b
 }
 
 func NewGenesisBlock(coinbase *transaction.Transaction) *Block {
-	return NewBlock([]*transaction.Transaction{coinbase}, []byte{})
+	return NewBlock([]*transaction.Transaction{coinbase}, []byte{}, 0)
 }
 
 func (b *Block) Serialize() []byte {
@@ -74,19 +78,29 @@ func (b *Block) Serialize() []byte {
 	return res.Bytes()
 }
 
-func DeSerialize(b []byte) *Block {
+func DeSerialize(b []byte) (*Block, error) {
 	var block *Block
 	decoder := gob.NewDecoder(bytes.NewReader(b))
-	decoder.Decode(&block)
-	return block
+	if err := decoder.Decode(&block); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadBlockData, err)
+	}
+	if block == nil || len(block.Hash) == 0 {
+		return nil, ErrBadBlockData
+	}
+	return block, nil
 }
 
+// HashTransactions returns the merkle root of the transactions, nil for a
+// block without transactions, which is not valid
 func (b *Block) HashTransactions() []byte {
 	var tr [][]byte
 
 	for _, t := range b.Transactions {
 		tr = append(tr, t.Serialize())
 	}
-	hashed := merkle.NewMerkleTree(tr)
+	hashed, err := merkle.NewMerkleTree(tr)
+	if err != nil {
+		return nil
+	}
 	return hashed.RootNode.Data
 }
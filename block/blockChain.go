@@ -30,166 +30,247 @@
 package block
 
 import (
-	"github.com/boltdb/bolt"
 	"fmt"
-	"os"
 	"encoding/hex"
-	"log"
 	"bytes"
 	"errors"
+	"myBitCoin/storage"
 	"myBitCoin/transaction"
 	"myBitCoin/wallet"
 	"crypto/ecdsa"
+	"sync"
+	"time"
+	"context"
 )
 
 const (
-	dbFile              = "%s/blockchain.db"
+	// 数据库的路径，文件名后缀由存储引擎决定，bolt 是 blockchain.db
+	dbPath              = "%s/blockchain"
 	blocksBucket        = "blocks"
 	genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
 )
 
+var (
+	ErrNoBlockchain      = errors.New("no existing blockchain found, create one first")
+	ErrBlockchainExists  = errors.New("blockchain already exists")
+	ErrBlockNotFound     = errors.New("block is not found")
+	ErrTxNotFound        = errors.New("transaction is not found")
+	ErrInsufficientFunds = errors.New("not enough funds")
+	// ErrBadBlockData is returned when a stored or received block can't be decoded
+	ErrBadBlockData = errors.New("block data is corrupt")
+)
+
+// DBEngine is the storage engine of the new chains, an existing chain is
+// opened with the engine which created it
+var DBEngine = storage.Bolt
+
 type BlockChain struct {
 	tip []byte
-	DB  *bolt.DB
+	DB  storage.DB
+
+	mu          sync.RWMutex
+	tipChanged  chan struct{}
+	pruneHeight int
+
+	// 保证连接块的提交回调按顺序执行
+	connectMu sync.Mutex
+
+	pruneMu     sync.Mutex
+	pruneTarget int64
+	bodiesSize  int64
+
+	notificationsLock sync.RWMutex
+	notifications     []NotificationCallback
 }
 
 // 创建一个有创世块的新链
-func NewBlockChain(nodeID string) *BlockChain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
-	if dbExists(dbFile) == false {
-		fmt.Println("No existing blockchain found. Create one first.")
-		os.Exit(1)
+func NewBlockChain(nodeID string) (*BlockChain, error) {
+	if dbExists(nodeID) == false {
+		return nil, ErrNoBlockchain
 	}
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	var (
+		tip         []byte
+		pruneHeight int
+	)
+	db, err := openDB(nodeID)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
+	err = db.Update(func(tx storage.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+		if b == nil {
+			return ErrNoBlockchain
+		}
+		// 返回的切片只在事务内有效
+		tip = append([]byte{}, b.Get([]byte("l"))...)
+		pruneHeight = readPruneHeight(tx)
 
-		return nil
+		return indexHeights(tx, tip)
 	})
 
 	if err != nil {
-		log.Panic(err)
+		db.Close()
+		return nil, err
 	}
 
-	bc := BlockChain{tip, db}
+	bc := BlockChain{tip: tip, DB: db, pruneHeight: pruneHeight}
 
-	return &bc
+	return &bc, nil
 }
 
-func CreateBlockChain(addr, nodeID string) *BlockChain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
-	if dbExists(dbFile) {
-		fmt.Println("Blockchain already exists.")
-		os.Exit(1)
+func CreateBlockChain(addr, nodeID string) (*BlockChain, error) {
+	if dbExists(nodeID) {
+		return nil, ErrBlockchainExists
 	}
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	cbtx, err := transaction.NewCoinbaseTx(addr, genesisCoinbaseData)
 	if err != nil {
-		fmt.Println(err)
-		panic(err)
+		return nil, err
 	}
+	genesis := NewGenesisBlock(cbtx)
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
+	db, err := openDB(nodeID)
+	if err != nil {
+		return nil, err
+	}
 
-		if b == nil {
-			cbtx := transaction.NewCoinbaseTx(addr, genesisCoinbaseData)
-			genesis := NewGenesisBlock(cbtx)
-
-			b, _ = tx.CreateBucket([]byte(blocksBucket))
-			err = b.Put(genesis.Hash, genesis.Serialize())
-			err = b.Put([]byte("l"), genesis.Hash)
-			tip = genesis.Hash
-		} else {
-			tip = b.Get([]byte("l"))
+	err = db.Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucket([]byte(blocksBucket))
+		if err != nil {
+			return err
 		}
-		return nil
+		if err := b.Put(genesis.Hash, genesis.Serialize()); err != nil {
+			return err
+		}
+		if err := putHeight(tx, 0, genesis.Hash); err != nil {
+			return err
+		}
+		return b.Put([]byte("l"), genesis.Hash)
 	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	bc := &BlockChain{tip, db}
-	return bc
+	bc := &BlockChain{tip: genesis.Hash, DB: db}
+	return bc, nil
 }
 
-func dbExists(dbFile string) bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
-		return false
-	}
-
-	return true
+func dbExists(nodeID string) bool {
+	_, ok := storage.Exists(fmt.Sprintf(dbPath, nodeID))
+	return ok
 }
 
-func (c *BlockChain) AddBlock(transactions []*transaction.Transaction) {
-	var lastHash []byte
-	c.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
-		return nil
-	})
+func openDB(nodeID string) (storage.DB, error) {
+	return storage.Open(DBEngine, fmt.Sprintf(dbPath, nodeID))
+}
 
-	newBlock := NewBlock(transactions, lastHash)
+func (c *BlockChain) AddBlock(transactions []*transaction.Transaction) error {
+	last, err := c.GetBlock(c.Tip())
+	if err != nil {
+		return err
+	}
 
-	c.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		b.Put(newBlock.Hash, newBlock.Serialize())
-		b.Put([]byte("l"), newBlock.Hash)
-		c.tip = newBlock.Hash
-		return nil
-	})
+	newBlock := NewBlock(transactions, last.Hash, last.Height+1)
+	return c.ConnectBlock(newBlock)
 }
 
-func (c *BlockChain) MineBlock(transactions []*transaction.Transaction) *Block {
-	var (
-		lastHash []byte
-	)
-
-	db := c.DB
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucket))
-		lastHash = bucket.Get([]byte("l"))
+func (c *BlockChain) MineBlock(transactions []*transaction.Transaction) (*Block, error) {
+	return c.MineBlockContext(context.Background(), transactions)
+}
 
-		//lastBlock := bucket.Get(lastHash)
-		return nil
-	})
+// MineBlockContext mines a block of transactions on the tip with DefaultMiner.
+// It gives up with ErrOrphanBlock when the tip changes first, for example when
+// a block is received from a peer, and with ctx.Err() when ctx is done.
+func (c *BlockChain) MineBlockContext(ctx context.Context, transactions []*transaction.Transaction) (*Block, error) {
+	// 先拿到 channel 再读 tip，读完之后 tip 的变化都会关闭它
+	tipChanged := c.TipChanged()
+	lastBlock, err := c.GetBlock(c.Tip())
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	newBlock := NewBlock(transactions, lastHash)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-tipChanged:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucket))
-		err := bucket.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
+	newBlock := &Block{
+		TimeStamp:    time.Now().Unix(),
+		PrevHash:     lastBlock.Hash,
+		Transactions: transactions,
+		Height:       lastBlock.Height + 1,
+	}
+	if err := DefaultMiner.Mine(ctx, newBlock); err != nil {
+		select {
+		case <-tipChanged:
+			return nil, ErrOrphanBlock
+		default:
+			return nil, err
 		}
+	}
 
-		err = bucket.Put([]byte("l"), newBlock.Hash)
-		if err != nil {
-			log.Panic(err)
+	if err := c.ConnectBlock(newBlock); err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
+
+// GetBlock finds a block by its hash
+func (c *BlockChain) GetBlock(hash []byte) (*Block, error) {
+	var block *Block
+
+	err := c.DB.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		data := b.Get(hash)
+		if data == nil {
+			if h, err := prunedHeader(tx, hash); err != nil || h != nil {
+				if err == nil {
+					err = ErrBlockPruned
+				}
+				return err
+			}
+			return ErrBlockNotFound
 		}
-		c.tip = lastHash
 
-		return nil
+		var err error
+		block, err = DeSerialize(data)
+		return err
 	})
+
+	return block, err
+}
+
+// GetBestHeight returns the height of the last block
+func (c *BlockChain) GetBestHeight() (int, error) {
+	block, err := c.GetHeader(c.Tip())
 	if err != nil {
-		log.Panic(err)
+		return 0, err
 	}
 
-	return newBlock
+	return block.Height, nil
 }
 
-// FindTransaction finds a transaction by its ID
+// FindTransaction finds a transaction by its ID. In the pruned blocks only the
+// transactions with unspent outputs are found, ErrBlockPruned is returned for
+// the others.
 func (bc *BlockChain) FindTransaction(ID []byte) (transaction.Transaction, error) {
 	bci := bc.Iterator()
 
 	for {
-		block := bci.Next()
+		block, err := bci.Next()
+		if err != nil {
+			return transaction.Transaction{}, err
+		}
+		if block.Pruned() {
+			return bc.findPrunedTx(ID)
+		}
 
 		for _, tx := range block.Transactions {
 			if bytes.Compare(tx.ID, ID) == 0 {
@@ -202,15 +283,21 @@ func (bc *BlockChain) FindTransaction(ID []byte) (transaction.Transaction, error
 		}
 	}
 
-	return transaction.Transaction{}, errors.New("Transaction is not found")
+	return transaction.Transaction{}, ErrTxNotFound
 }
 
-func (c *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []transaction.Transaction {
+func (c *BlockChain) FindUnspentTransactions(pubKeyHash []byte) ([]transaction.Transaction, error) {
 	var unspentTXs []transaction.Transaction
 	spentTXOS := make(map[string][]int)
 	bci := c.Iterator()
 	for {
-		block := bci.Next()
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+		if block.Pruned() {
+			break
+		}
 
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
@@ -242,7 +329,23 @@ func (c *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []transaction.Tr
 		}
 	}
 
-	return unspentTXs
+	// 被裁剪的块只保留了还有未花费输出的交易
+	err := c.forEachPrunedTx(func(tx *transaction.Transaction, _ int, unspent []int) {
+		txID := hex.EncodeToString(tx.ID)
+	Flag:
+		for _, outIdx := range unspent {
+			for _, spentOut := range spentTXOS[txID] {
+				if spentOut == outIdx {
+					continue Flag
+				}
+			}
+			if tx.Vout[outIdx].IsLockedWithKey(pubKeyHash) {
+				unspentTXs = append(unspentTXs, *tx)
+			}
+		}
+	})
+
+	return unspentTXs, err
 }
 
 /*func (c *BlockChain) FindUTXO(pubKeyHash []byte) []transaction.TxOutput {
@@ -257,54 +360,14 @@ func (c *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []transaction.Tr
 	}
 	return outputs
 }*/
-// FindUTXO finds all unspent transaction outputs and returns transactions with spent outputs removed
-func (bc *BlockChain) FindUTXO() map[string]transaction.TxOutPuts {
-	UTXO := make(map[string]transaction.TxOutPuts)
-	spentTXOs := make(map[string][]int)
-	bci := bc.Iterator()
-
-	for {
-		block := bci.Next()
-
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Vout {
-				// Was the output spent?
-				if spentTXOs[txID] != nil {
-					for _, spentOutIdx := range spentTXOs[txID] {
-						if spentOutIdx == outIdx {
-							continue Outputs
-						}
-					}
-				}
-
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
-			}
-
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Vin {
-					inTxID := hex.EncodeToString(in.TxID)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
-				}
-			}
-		}
-
-		if len(block.PrevHash) == 0 {
-			break
-		}
-	}
-
-	return UTXO
-}
 
-func (c *BlockChain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+func (c *BlockChain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int, error) {
 	unspendOutputs := make(map[string][]int)
 	accumulation := 0
-	unspentTr := c.FindUnspentTransactions(pubKeyHash)
+	unspentTr, err := c.FindUnspentTransactions(pubKeyHash)
+	if err != nil {
+		return 0, nil, err
+	}
 
 Find:
 	for _, tx := range unspentTr {
@@ -320,43 +383,93 @@ Find:
 		}
 	}
 
-	return accumulation, unspendOutputs
+	return accumulation, unspendOutputs, nil
+}
+
+// Tip returns the hash of the last block in the chain
+func (c *BlockChain) Tip() []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.tip
+}
+
+func (c *BlockChain) setTip(hash []byte) {
+	c.mu.Lock()
+	c.tip = hash
+	if c.tipChanged != nil {
+		close(c.tipChanged)
+		c.tipChanged = nil
+	}
+	c.mu.Unlock()
+}
+
+// TipChanged returns a channel closed when the tip of the chain changes
+func (c *BlockChain) TipChanged() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tipChanged == nil {
+		c.tipChanged = make(chan struct{})
+	}
+	return c.tipChanged
 }
 
 func (c *BlockChain) Iterator() *BlockchainIterator {
-	return &BlockchainIterator{c.tip, c.DB}
+	return &BlockchainIterator{c.Tip(), c.DB}
 }
 
 // iterator the chain
 type BlockchainIterator struct {
 	currentHash []byte
-	db          *bolt.DB
+	db          storage.DB
 }
 
-func (i *BlockchainIterator) Next() *Block {
+// Next returns the current block and moves to its parent, the caller stops
+// after the genesis block
+func (i *BlockchainIterator) Next() (*Block, error) {
 	var block *Block
 
-	i.db.View(func(tx *bolt.Tx) error {
+	err := i.db.View(func(tx storage.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		encodedBlock := b.Get(i.currentHash)
-		block = DeSerialize(encodedBlock)
-		return nil
+		if encodedBlock == nil {
+			header, err := prunedHeader(tx, i.currentHash)
+			if err != nil {
+				return err
+			}
+			if header == nil {
+				return fmt.Errorf("%w: %x", ErrBlockNotFound, i.currentHash)
+			}
+			block = header.prunedBlock()
+			return nil
+		}
+
+		var err error
+		block, err = DeSerialize(encodedBlock)
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
 	i.currentHash = block.PrevHash
-	return block
+	return block, nil
 }
 
-func (c *BlockChain) NewUTXOTransaction(wlt *wallet.Wallet, to string, amount int) *transaction.Transaction {
+func (c *BlockChain) NewUTXOTransaction(wlt *wallet.Wallet, to string, amount int) (*transaction.Transaction, error) {
 	var (
 		outputs []transaction.TxOutput
 		inputs  []transaction.TxInput
 	)
 
 	pubKeyHash := wallet.HashPubKey(wlt.PublicKey)
-	acc, validOuts := c.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOuts, err := c.FindSpendableOutputs(pubKeyHash, amount)
 	//acc, validOuts := utxo.FindSpendableOutputs(pubKeyHash, amount)
+	if err != nil {
+		return nil, err
+	}
 	if acc < amount {
-		log.Panic("ERROR: Not enough funds")
+		return nil, ErrInsufficientFunds
 	}
 
 	for id, outs := range validOuts {
@@ -369,43 +482,58 @@ func (c *BlockChain) NewUTXOTransaction(wlt *wallet.Wallet, to string, amount in
 	}
 
 	from := wlt.GetAddress()
-	outputs = append(outputs, transaction.NewTxOut(amount, to))
+	out, err := transaction.NewTxOut(amount, to)
+	if err != nil {
+		return nil, err
+	}
+	outputs = append(outputs, out)
 	if acc > amount {
 		delta := acc - amount
-		outputs = append(outputs, transaction.NewTxOut(delta, string(from)))
+		change, err := transaction.NewTxOut(delta, string(from))
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, change)
 	}
 
 	tx := &transaction.Transaction{nil, inputs, outputs}
-	tx.SetID()
+	if err := tx.SetID(); err != nil {
+		return nil, err
+	}
 
-	return tx
+	return tx, nil
 }
 
-func (bc *BlockChain) SignTransactions(tx *transaction.Transaction, private ecdsa.PrivateKey) {
+// prevTransactions 找到 tx 的输入花费的交易
+func (bc *BlockChain) prevTransactions(tx *transaction.Transaction) (map[string]transaction.Transaction, error) {
 	prevTxs := make(map[string]transaction.Transaction)
 
 	for _, in := range tx.Vin {
 		prev, err := bc.FindTransaction(in.TxID)
 		if err != nil {
-			log.Panic(err)
+			return nil, fmt.Errorf("input %x: %w", in.TxID, err)
 		}
 		id := hex.EncodeToString(in.TxID)
 		prevTxs[id] = prev
 	}
 
-	tx.Sign(private, prevTxs)
+	return prevTxs, nil
 }
 
-func (bc *BlockChain) VerifyTransaction(tr *transaction.Transaction) bool {
-	prevTxs := make(map[string]transaction.Transaction)
+func (bc *BlockChain) SignTransactions(tx *transaction.Transaction, private ecdsa.PrivateKey) error {
+	prevTxs, err := bc.prevTransactions(tx)
+	if err != nil {
+		return err
+	}
 
-	for _, in := range tr.Vin {
-		prev, err := bc.FindTransaction(in.TxID)
-		if err != nil {
-			log.Panic(err)
-		}
-		id := hex.EncodeToString(in.TxID)
-		prevTxs[id] = prev
+	return tx.Sign(private, prevTxs)
+}
+
+// VerifyTransaction verifies the signatures of tr against the chain
+func (bc *BlockChain) VerifyTransaction(tr *transaction.Transaction) error {
+	prevTxs, err := bc.prevTransactions(tr)
+	if err != nil {
+		return err
 	}
 
 	return tr.Verify(prevTxs)
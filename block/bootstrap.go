@@ -0,0 +1,126 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// NetworkMagic starts every record of a bootstrap file, a file of another
+// format is rejected at its first record
+var NetworkMagic = [4]byte{0x6d, 0x79, 0x42, 0x43}
+
+// 单个块记录的上限，防止损坏的长度字段申请过多内存
+const maxBootstrapRecord = 32 << 20
+
+var ErrBadBootstrap = errors.New("not a bootstrap file of this network")
+
+// ExportChain writes the blocks from the genesis block to the tip to w in
+// height order. Each block is framed as NetworkMagic, the length of the
+// serialized block as a little endian uint32, and the serialized block. It
+// fails with ErrBlockPruned when the history isn't stored. No block may be
+// connected while it runs.
+func (c *BlockChain) ExportChain(w io.Writer) (int, error) {
+	// 先从 tip 往回收集哈希，再按高度顺序写出
+	var hashes [][]byte
+	for hash := c.Tip(); len(hash) != 0; {
+		b, err := c.GetBlock(hash)
+		if err != nil {
+			return 0, err
+		}
+		hashes = append(hashes, b.Hash)
+		hash = b.PrevHash
+	}
+
+	bw := bufio.NewWriter(w)
+	for i := len(hashes) - 1; i >= 0; i-- {
+		b, err := c.GetBlock(hashes[i])
+		if err != nil {
+			return 0, err
+		}
+		if err := writeBootstrapRecord(bw, b.Serialize()); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(hashes), bw.Flush()
+}
+
+func writeBootstrapRecord(w io.Writer, data []byte) error {
+	var head [8]byte
+	copy(head[:4], NetworkMagic[:])
+	binary.LittleEndian.PutUint32(head[4:], uint32(len(data)))
+
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+
+	return err
+}
+
+// BootstrapReader reads the blocks of a file written by ExportChain
+type BootstrapReader struct {
+	r *bufio.Reader
+}
+
+func NewBootstrapReader(r io.Reader) *BootstrapReader {
+	return &BootstrapReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next block, or io.EOF after the last one. A file cut in
+// the middle of a record returns io.ErrUnexpectedEOF.
+func (r *BootstrapReader) Next() (*Block, error) {
+	var head [8]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(head[:4], NetworkMagic[:]) {
+		return nil, ErrBadBootstrap
+	}
+	size := binary.LittleEndian.Uint32(head[4:])
+	if size == 0 || size > maxBootstrapRecord {
+		return nil, ErrBadBootstrap
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	b, err := DeSerialize(data)
+	if err != nil {
+		return nil, ErrBadBootstrap
+	}
+
+	return b, nil
+}
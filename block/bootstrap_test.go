@@ -0,0 +1,146 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block_test
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+)
+
+// importChain creates a chain in a new directory from the genesis block read
+// from r and accepts the blocks after it
+func importChain(t *testing.T, r *blk.BootstrapReader) *blk.BlockChain {
+	t.Helper()
+
+	genesis, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := blk.CreateBlockChainWithGenesis(genesis, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := utxo.UTXOSet{bc}
+	if err := u.Reindex(); err != nil {
+		bc.DB.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		u.Close()
+		bc.DB.Close()
+	})
+
+	for {
+		b, err := r.Next()
+		if err == io.EOF {
+			return bc
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AcceptBlock(b); err != nil {
+			t.Fatalf("block at height %d: %v", b.Height, err)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	to := chaintest.NewWallet(t)
+	chaintest.MineBlocks(t, bc, string(w.GetAddress()), 3)
+	if _, err := bc.MineBlock([]*transaction.Transaction{chaintest.NewTx(t, bc, w, string(to.GetAddress()), 12)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := bc.ExportChain(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Fatalf("exported %d blocks", n)
+	}
+
+	imported := importChain(t, blk.NewBootstrapReader(bytes.NewReader(buf.Bytes())))
+	if !bytes.Equal(imported.Tip(), bc.Tip()) {
+		t.Fatalf("imported tip %x, want %x", imported.Tip(), bc.Tip())
+	}
+	want, err := utxo.UTXOSet{bc}.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := utxo.UTXOSet{imported}.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("imported UTXO set %+v, want %+v", got, want)
+	}
+}
+
+func TestBootstrapErrors(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	chaintest.MineBlocks(t, bc, string(w.GetAddress()), 1)
+	var buf bytes.Buffer
+	if _, err := bc.ExportChain(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// 记录中间截断
+	r := blk.NewBootstrapReader(bytes.NewReader(data[:len(data)-1]))
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated file: %v", err)
+	}
+
+	// 别的网络的文件
+	other := append([]byte{}, data...)
+	other[0]++
+	if _, err := blk.NewBootstrapReader(bytes.NewReader(other)).Next(); err != blk.ErrBadBootstrap {
+		t.Fatalf("other magic: %v", err)
+	}
+
+	// 块在已有的链上
+	r = blk.NewBootstrapReader(bytes.NewReader(data))
+	for i := 0; i < 2; i++ {
+		b, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AcceptBlock(b); err == nil {
+			t.Fatalf("block at height %d was accepted twice", b.Height)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"bytes"
+
+	"myBitCoin/storage"
+)
+
+// ConnectHandler writes the changes of a block to the chain DB, in the
+// transaction connecting the block. The block is not connected when a
+// handler fails. A handler must not open another transaction on the chain DB,
+// the state it keeps in memory is changed with tx.OnCommit.
+type ConnectHandler func(c *BlockChain, tx storage.Tx, b *Block) error
+
+var connectHandlers []ConnectHandler
+
+// RegisterConnectHandler adds a handler run for the blocks connected to every
+// chain. The packages keeping state in the chain DB, like the UTXO set and the
+// filter index, register it in their init.
+func RegisterConnectHandler(h ConnectHandler) {
+	connectHandlers = append(connectHandlers, h)
+}
+
+// ConnectBlock writes b, the tip pointer and the changes of the connect
+// handlers in one transaction, so that a crash never leaves the UTXO set and
+// the indexes behind the blocks. b must extend the tip, ErrOrphanBlock is
+// returned otherwise.
+func (c *BlockChain) ConnectBlock(b *Block) error {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+
+	err := c.DB.Update(func(tx storage.Tx) error {
+		bucket := tx.Bucket([]byte(blocksBucket))
+		// 检查和写入之间 tip 可能被挖矿改变
+		if !bytes.Equal(bucket.Get([]byte("l")), b.PrevHash) {
+			return ErrOrphanBlock
+		}
+		if err := bucket.Put(b.Hash, b.Serialize()); err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte("l"), b.Hash); err != nil {
+			return err
+		}
+		if err := putHeight(tx, b.Height, b.Hash); err != nil {
+			return err
+		}
+
+		for _, h := range connectHandlers {
+			if err := h(c, tx, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.setTip(b.Hash)
+	c.sendNotification(NTBlockConnected, b)
+	c.blockConnected(b)
+
+	return nil
+}
+
+// DisconnectHandler reverts the changes of a connect handler, in the
+// transaction disconnecting the block
+type DisconnectHandler func(c *BlockChain, tx storage.Tx, b *Block) error
+
+var disconnectHandlers []DisconnectHandler
+
+// RegisterDisconnectHandler adds a handler run for the blocks disconnected
+// from every chain
+func RegisterDisconnectHandler(h DisconnectHandler) {
+	disconnectHandlers = append(disconnectHandlers, h)
+}
+
+// DisconnectBlock removes the tip block from the chain, the tip pointer moves
+// back to its parent and the disconnect handlers restore the UTXO set and the
+// indexes in the same transaction. The block itself is kept, it can be
+// connected again.
+func (c *BlockChain) DisconnectBlock() (*Block, error) {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+
+	b, err := c.GetBlock(c.Tip())
+	if err != nil {
+		return nil, err
+	}
+	if len(b.PrevHash) == 0 {
+		return nil, ErrDisconnectGenesis
+	}
+
+	err = c.DB.Update(func(tx storage.Tx) error {
+		bucket := tx.Bucket([]byte(blocksBucket))
+		if !bytes.Equal(bucket.Get([]byte("l")), b.Hash) {
+			return ErrOrphanBlock
+		}
+		// 父块被裁剪或者是快照的基准块之下，不能成为链尾
+		if bucket.Get(b.PrevHash) == nil {
+			return ErrBlockPruned
+		}
+		if err := bucket.Put([]byte("l"), b.PrevHash); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(heightsBucket)).Delete(heightValue(b.Height)); err != nil {
+			return err
+		}
+
+		for _, h := range disconnectHandlers {
+			if err := h(c, tx, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.setTip(b.PrevHash)
+	c.sendNotification(NTBlockDisconnected, b)
+
+	return b, nil
+}
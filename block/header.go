@@ -0,0 +1,171 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBadProofOfWork = errors.New("header has invalid proof of work")
+	ErrBadLinkage     = errors.New("header does not connect to the previous one")
+)
+
+// BlockHeader is a block without its transactions, the merkle root commits to them
+type BlockHeader struct {
+	TimeStamp  int64
+	PrevHash   []byte
+	MerkleRoot []byte
+	Hash       []byte
+	Nonce      int
+	Height     int
+}
+
+func (b *Block) Header() *BlockHeader {
+	if b.header != nil {
+		header := *b.header
+		return &header
+	}
+
+	return &BlockHeader{
+		TimeStamp:  b.TimeStamp,
+		PrevHash:   b.PrevHash,
+		MerkleRoot: b.HashTransactions(),
+		Hash:       b.Hash,
+		Nonce:      b.Nonce,
+		Height:     b.Height,
+	}
+}
+
+// CheckProofOfWork checks the nonce and that Hash is really the hash of the header
+func (h *BlockHeader) CheckProofOfWork() error {
+	pow := NewHeaderProofOfWork(h)
+	if !pow.Validate() || !bytes.Equal(pow.CalcHash(), h.Hash) {
+		return ErrBadProofOfWork
+	}
+
+	return nil
+}
+
+// CheckConnects checks that h is the child of prev
+func (h *BlockHeader) CheckConnects(prev *BlockHeader) error {
+	if !bytes.Equal(h.PrevHash, prev.Hash) || h.Height != prev.Height+1 {
+		return ErrBadLinkage
+	}
+
+	return nil
+}
+
+func (h *BlockHeader) Serialize() []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	encoder.Encode(h)
+
+	return res.Bytes()
+}
+
+func DeserializeHeader(data []byte) (*BlockHeader, error) {
+	var header BlockHeader
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadBlockData, err)
+	}
+
+	return &header, nil
+}
+
+// BlockLocator returns hashes of the chain ending at tip, dense near the tip
+// and exponentially sparser towards the genesis block, which is always last.
+// hashAt returns the hash of the block at a height on that chain.
+func BlockLocator(tipHeight int, hashAt func(height int) []byte) [][]byte {
+	var locator [][]byte
+
+	step := 1
+	for height := tipHeight; height > 0; height -= step {
+		locator = append(locator, hashAt(height))
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+
+	return append(locator, hashAt(0))
+}
+
+// maxHeadersPerMsg 每次最多返回的区块头数量
+const maxHeadersPerMsg = 2000
+
+// maxLocatorHashes 定位器最多检查这么多哈希，BlockLocator 对很长的链也只有几十个
+const maxLocatorHashes = 101
+
+// LocateHeaders returns the headers following the first block of locator found
+// in the main chain, or starting at the genesis block when none is found. The
+// result is in height order and contains at most maxHeadersPerMsg headers,
+// ending at hashStop when it is given.
+func (c *BlockChain) LocateHeaders(locator [][]byte, hashStop []byte) ([]*BlockHeader, error) {
+	if len(locator) > maxLocatorHashes {
+		locator = locator[:maxLocatorHashes]
+	}
+
+	start := 0
+	for _, hash := range locator {
+		h, err := c.GetHeader(hash)
+		if err == ErrBlockNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 分叉上的块不算
+		if main, err := c.HashAtHeight(h.Height); err == nil && bytes.Equal(main, hash) {
+			start = h.Height + 1
+			break
+		}
+	}
+
+	var headers []*BlockHeader
+	for height := start; len(headers) < maxHeadersPerMsg; height++ {
+		hash, err := c.HashAtHeight(height)
+		if err == ErrBlockNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		h, err := c.GetHeader(hash)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, h)
+
+		if bytes.Equal(h.Hash, hashStop) {
+			break
+		}
+	}
+
+	return headers, nil
+}
@@ -0,0 +1,98 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"myBitCoin/storage"
+)
+
+// 主链上每个高度的块的哈希，键是 heightValue
+const heightsBucket = "heights"
+
+// putHeight records hash as the block at height of the main chain
+func putHeight(tx storage.Tx, height int, hash []byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(heightsBucket))
+	if err != nil {
+		return err
+	}
+
+	return b.Put(heightValue(height), hash)
+}
+
+// indexHeights builds the height index of a chain created before it existed,
+// from the tip down to the genesis block. The index is then kept by
+// ConnectBlock and DisconnectBlock.
+func indexHeights(tx storage.Tx, tip []byte) error {
+	if tx.Bucket([]byte(heightsBucket)) != nil {
+		return nil
+	}
+
+	for hash := tip; len(hash) > 0; {
+		var header *BlockHeader
+		if data := tx.Bucket([]byte(blocksBucket)).Get(hash); data != nil {
+			b, err := DeSerialize(data)
+			if err != nil {
+				return err
+			}
+			header = b.Header()
+		} else {
+			h, err := prunedHeader(tx, hash)
+			if err != nil {
+				return err
+			}
+			if h == nil {
+				return ErrBlockNotFound
+			}
+			header = h
+		}
+
+		if err := putHeight(tx, header.Height, header.Hash); err != nil {
+			return err
+		}
+		hash = header.PrevHash
+	}
+
+	return nil
+}
+
+// HashAtHeight returns the hash of the block at height of the main chain,
+// pruned or not. ErrBlockNotFound is returned above the tip.
+func (c *BlockChain) HashAtHeight(height int) ([]byte, error) {
+	var hash []byte
+
+	err := c.DB.View(func(tx storage.Tx) error {
+		if height < 0 {
+			return ErrBlockNotFound
+		}
+		v := tx.Bucket([]byte(heightsBucket)).Get(heightValue(height))
+		if v == nil {
+			return ErrBlockNotFound
+		}
+		hash = append([]byte{}, v...)
+		return nil
+	})
+
+	return hash, err
+}
@@ -0,0 +1,190 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"errors"
+	"log"
+
+	"myBitCoin/storage"
+)
+
+// 被 invalidateblock 标记的块 -> 标记时的链尾，reconsiderblock 时从那里重新连接
+const invalidBucket = "invalid"
+
+var (
+	ErrDisconnectGenesis = errors.New("the genesis block can't be disconnected")
+	ErrNotInChain        = errors.New("block is not in the chain")
+	ErrInvalidBlock      = errors.New("block was marked invalid")
+	ErrNotInvalid        = errors.New("block is not marked invalid")
+)
+
+// InvalidateBlock marks a block of the chain invalid and disconnects it with
+// all the blocks after it. The block is refused until ReconsiderBlock. When a
+// block can't be disconnected, the ones already disconnected are connected
+// again and the mark is removed.
+func (c *BlockChain) InvalidateBlock(hash []byte) (int, error) {
+	tip := c.Tip()
+
+	found, err := c.GetHeader(hash)
+	if err == ErrBlockNotFound {
+		return 0, ErrNotInChain
+	}
+	if err != nil {
+		return 0, err
+	}
+	// 不在高度索引中的是分叉上的块
+	if inChain, err := c.HashAtHeight(found.Height); err != nil || !bytes.Equal(inChain, hash) {
+		return 0, ErrNotInChain
+	}
+	if len(found.PrevHash) == 0 {
+		return 0, ErrDisconnectGenesis
+	}
+	if found.Height <= LastCheckpoint() {
+		return 0, ErrForkBelowCheckpoint
+	}
+	// 父块要成为链尾
+	if _, err := c.GetBlock(found.PrevHash); err != nil {
+		return 0, err
+	}
+
+	// 先标记，断开期间同步不会把它重新连上
+	err = c.DB.Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(invalidBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(hash, tip)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var disconnected []*Block
+	for {
+		b, err := c.DisconnectBlock()
+		if err != nil {
+			c.undoInvalidate(hash, disconnected)
+			return 0, err
+		}
+		disconnected = append(disconnected, b)
+		if bytes.Equal(b.Hash, hash) {
+			return len(disconnected), nil
+		}
+	}
+}
+
+// undoInvalidate 断开失败时把已经断开的块接回去，再去掉标记
+func (c *BlockChain) undoInvalidate(hash []byte, disconnected []*Block) {
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		if err := c.ConnectBlock(disconnected[i]); err != nil {
+			log.Printf("invalidateblock: reconnecting block %x: %v", disconnected[i].Hash, err)
+			return
+		}
+	}
+	err := c.DB.Update(func(tx storage.Tx) error {
+		return tx.Bucket([]byte(invalidBucket)).Delete(hash)
+	})
+	if err != nil {
+		log.Printf("invalidateblock: %v", err)
+	}
+}
+
+// ReconsiderBlock connects a block marked by InvalidateBlock and the blocks
+// which were after it again, and removes the mark. It returns ErrOrphanBlock
+// when the block no longer extends the tip. When a block is refused the
+// blocks connected before it are disconnected and the mark is kept. It
+// returns the number of blocks connected.
+func (c *BlockChain) ReconsiderBlock(hash []byte) (int, error) {
+	var oldTip []byte
+	err := c.DB.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(invalidBucket))
+		if b == nil || b.Get(hash) == nil {
+			return ErrNotInvalid
+		}
+		oldTip = append([]byte{}, b.Get(hash)...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	first, err := c.GetBlock(hash)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(first.PrevHash, c.Tip()) {
+		return 0, ErrOrphanBlock
+	}
+
+	// 从标记时的链尾往回找到这个块
+	var blocks []*Block
+	for h := oldTip; ; {
+		b, err := c.GetBlock(h)
+		if err != nil {
+			return 0, err
+		}
+		blocks = append(blocks, b)
+		if bytes.Equal(b.Hash, hash) {
+			break
+		}
+		h = b.PrevHash
+	}
+
+	// 标记还在，跳过 AcceptBlock 对标记的检查
+	n := 0
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if err := c.checkAndConnect(blocks[i], true); err != nil {
+			for ; n > 0; n-- {
+				if _, err := c.DisconnectBlock(); err != nil {
+					log.Printf("reconsiderblock: %v", err)
+					break
+				}
+			}
+			return 0, err
+		}
+		n++
+	}
+
+	err = c.DB.Update(func(tx storage.Tx) error {
+		return tx.Bucket([]byte(invalidBucket)).Delete(hash)
+	})
+
+	return n, err
+}
+
+// isInvalid reports whether the block was marked by InvalidateBlock
+func (c *BlockChain) isInvalid(hash []byte) bool {
+	invalid := false
+	c.DB.View(func(tx storage.Tx) error {
+		if b := tx.Bucket([]byte(invalidBucket)); b != nil {
+			invalid = b.Get(hash) != nil
+		}
+		return nil
+	})
+
+	return invalid
+}
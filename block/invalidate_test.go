@@ -0,0 +1,220 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+)
+
+var errHandler = errors.New("handler failed")
+
+// failing 中的块在接上或断开时失败
+var failing = struct {
+	sync.Mutex
+	connect, disconnect []byte
+}{}
+
+func init() {
+	blk.RegisterConnectHandler(func(c *blk.BlockChain, tx storage.Tx, b *blk.Block) error {
+		failing.Lock()
+		defer failing.Unlock()
+		if bytes.Equal(b.Hash, failing.connect) {
+			return errHandler
+		}
+		return nil
+	})
+	blk.RegisterDisconnectHandler(func(c *blk.BlockChain, tx storage.Tx, b *blk.Block) error {
+		failing.Lock()
+		defer failing.Unlock()
+		if bytes.Equal(b.Hash, failing.disconnect) {
+			return errHandler
+		}
+		return nil
+	})
+}
+
+// failOn makes the handlers fail for these blocks until the test ends
+func failOn(t *testing.T, connect, disconnect []byte) {
+	failing.Lock()
+	failing.connect, failing.disconnect = connect, disconnect
+	failing.Unlock()
+	t.Cleanup(func() {
+		failing.Lock()
+		failing.connect, failing.disconnect = nil, nil
+		failing.Unlock()
+	})
+}
+
+func stats(t *testing.T, bc *blk.BlockChain) string {
+	t.Helper()
+
+	info, err := utxo.UTXOSet{bc}.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%+v", *info)
+}
+
+// invalidateChain mines three blocks after the genesis block, the second one
+// with a payment, and returns them with the UTXO stats at each height
+func invalidateChain(t *testing.T) (*blk.BlockChain, []*blk.Block, []string) {
+	t.Helper()
+
+	bc, w := chaintest.NewChain(t)
+	address := string(w.GetAddress())
+	states := []string{stats(t, bc)}
+	blocks := chaintest.MineBlocks(t, bc, address, 1)
+	states = append(states, stats(t, bc))
+
+	tx := chaintest.NewTx(t, bc, w, string(chaintest.NewWallet(t).GetAddress()), 3)
+	coinbase, err := transaction.NewCoinbaseTx(address, "block 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := bc.MineBlock([]*transaction.Transaction{coinbase, tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks = append(blocks, b)
+	states = append(states, stats(t, bc))
+
+	blocks = append(blocks, chaintest.MineBlocks(t, bc, address, 1)...)
+	states = append(states, stats(t, bc))
+
+	return bc, blocks, states
+}
+
+func TestInvalidateAndReconsider(t *testing.T) {
+	bc, blocks, states := invalidateChain(t)
+
+	n, err := bc.InvalidateBlock(blocks[1].Hash)
+	if err != nil || n != 2 {
+		t.Fatalf("invalidate: %d, %v", n, err)
+	}
+	if !bytes.Equal(bc.Tip(), blocks[0].Hash) {
+		t.Fatal("the parent of the invalid block is not the tip")
+	}
+	if got := stats(t, bc); got != states[1] {
+		t.Fatalf("UTXO set after invalidate %s, want %s", got, states[1])
+	}
+	if err := bc.AcceptBlock(blocks[1]); err != blk.ErrInvalidBlock {
+		t.Fatalf("accepted the invalid block: %v", err)
+	}
+	// 断开的块已经不在链上
+	if _, err := bc.InvalidateBlock(blocks[2].Hash); err != blk.ErrNotInChain {
+		t.Fatalf("invalidate a block out of the chain: %v", err)
+	}
+
+	n, err = bc.ReconsiderBlock(blocks[1].Hash)
+	if err != nil || n != 2 {
+		t.Fatalf("reconsider: %d, %v", n, err)
+	}
+	if !bytes.Equal(bc.Tip(), blocks[2].Hash) {
+		t.Fatal("the old tip is not connected again")
+	}
+	if got := stats(t, bc); got != states[3] {
+		t.Fatalf("UTXO set after reconsider %s, want %s", got, states[3])
+	}
+	if _, err := bc.ReconsiderBlock(blocks[1].Hash); err != blk.ErrNotInvalid {
+		t.Fatalf("reconsider twice: %v", err)
+	}
+}
+
+func TestInvalidateErrors(t *testing.T) {
+	bc, blocks, _ := invalidateChain(t)
+
+	genesis, err := bc.HashAtHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.InvalidateBlock(genesis); err != blk.ErrDisconnectGenesis {
+		t.Errorf("invalidate the genesis block: %v", err)
+	}
+	if _, err := bc.InvalidateBlock([]byte("missing")); err != blk.ErrNotInChain {
+		t.Errorf("invalidate a missing block: %v", err)
+	}
+	if _, err := bc.ReconsiderBlock(blocks[0].Hash); err != blk.ErrNotInvalid {
+		t.Errorf("reconsider a valid block: %v", err)
+	}
+}
+
+func TestInvalidateRollsBack(t *testing.T) {
+	bc, blocks, states := invalidateChain(t)
+
+	// 断开链尾之后的第二个块失败
+	failOn(t, nil, blocks[1].Hash)
+	if _, err := bc.InvalidateBlock(blocks[0].Hash); !errors.Is(err, errHandler) {
+		t.Fatalf("invalidate: %v", err)
+	}
+	if !bytes.Equal(bc.Tip(), blocks[2].Hash) {
+		t.Fatal("the disconnected blocks are not connected again")
+	}
+	if got := stats(t, bc); got != states[3] {
+		t.Fatalf("UTXO set after the failed invalidate %s, want %s", got, states[3])
+	}
+	if _, err := bc.ReconsiderBlock(blocks[0].Hash); err != blk.ErrNotInvalid {
+		t.Fatalf("the block is still marked: %v", err)
+	}
+}
+
+func TestReconsiderRollsBack(t *testing.T) {
+	bc, blocks, states := invalidateChain(t)
+	if _, err := bc.InvalidateBlock(blocks[1].Hash); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一个块接上了，第二个失败
+	failOn(t, blocks[2].Hash, nil)
+	if _, err := bc.ReconsiderBlock(blocks[1].Hash); !errors.Is(err, errHandler) {
+		t.Fatalf("reconsider: %v", err)
+	}
+	if !bytes.Equal(bc.Tip(), blocks[0].Hash) {
+		t.Fatal("the reconnected block is not disconnected again")
+	}
+	if got := stats(t, bc); got != states[1] {
+		t.Fatalf("UTXO set after the failed reconsider %s, want %s", got, states[1])
+	}
+	if err := bc.AcceptBlock(blocks[1]); err != blk.ErrInvalidBlock {
+		t.Fatalf("the mark was removed: %v", err)
+	}
+
+	failOn(t, nil, nil)
+	if n, err := bc.ReconsiderBlock(blocks[1].Hash); err != nil || n != 2 {
+		t.Fatalf("reconsider: %d, %v", n, err)
+	}
+	if got := stats(t, bc); got != states[3] {
+		t.Fatalf("UTXO set after reconsider %s, want %s", got, states[3])
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"context"
+	"encoding/binary"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const hashRateInterval = 2 * time.Second
+
+// Miner solves the proof of work of blocks with several goroutines
+type Miner struct {
+	// Threads is the number of goroutines searching nonces, runtime.NumCPU() when 0
+	Threads int
+	// HashRate is called every hashRateInterval while mining, with the hashes per second
+	HashRate func(hashesPerSec float64)
+}
+
+// DefaultMiner mines the blocks of NewBlock and MineBlock
+var DefaultMiner = &Miner{}
+
+// Mine sets the nonce and the hash of b. The nonce space is split between the
+// goroutines, and when it is exhausted the timestamp is updated and the
+// extranonce in the coinbase, if b has one, is rolled. Mine returns ctx.Err()
+// when ctx is done before a solution is found.
+func (m *Miner) Mine(ctx context.Context, b *Block) error {
+	threads := m.threads()
+	var hashes uint64
+	defer m.ReportHashRate(&hashes)()
+
+	var coinbaseData []byte
+	if len(b.Transactions) > 0 && b.Transactions[0].IsCoinbase() {
+		coinbaseData = b.Transactions[0].Vin[0].PubKey
+	}
+
+	for extraNonce := uint64(1); ; extraNonce++ {
+		if nonce, hash, ok := solve(ctx, NewProofOfWork(b), threads, &hashes); ok {
+			b.Nonce = nonce
+			b.Hash = hash
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// nonce 用完了，换一个模板
+		timeStamp := time.Now().Unix()
+		if timeStamp <= b.TimeStamp {
+			timeStamp = b.TimeStamp + 1
+		}
+		b.TimeStamp = timeStamp
+
+		if coinbaseData != nil {
+			coinbase := b.Transactions[0]
+			coinbase.Vin[0].PubKey = make([]byte, len(coinbaseData)+8)
+			copy(coinbase.Vin[0].PubKey, coinbaseData)
+			binary.BigEndian.PutUint64(coinbase.Vin[0].PubKey[len(coinbaseData):], extraNonce)
+			coinbase.ID = nil
+			if err := coinbase.SetID(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SolveHeader searches the whole nonce space of h for a hash below target,
+// pool miners use it with the share target. It returns false when no nonce
+// is found or ctx is done, hashes counts the work, see ReportHashRate.
+func (m *Miner) SolveHeader(ctx context.Context, h *BlockHeader, target *big.Int, hashes *uint64) (int, []byte, bool) {
+	return solve(ctx, &ProofOfWork{h, target}, m.threads(), hashes)
+}
+
+func (m *Miner) threads() int {
+	if m.Threads <= 0 {
+		return runtime.NumCPU()
+	}
+	return m.Threads
+}
+
+// ReportHashRate calls HashRate with the rate of hashes every hashRateInterval,
+// until the returned function is called
+func (m *Miner) ReportHashRate(hashes *uint64) func() {
+	stop := make(chan struct{})
+	if m.HashRate != nil {
+		go m.reportHashRate(hashes, stop)
+	}
+
+	return func() { close(stop) }
+}
+
+// solve searches the whole nonce space of pow, split in threads ranges
+func solve(ctx context.Context, pow *ProofOfWork, threads int, hashes *uint64) (int, []byte, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type solution struct {
+		nonce int
+		hash  []byte
+	}
+	found := make(chan solution, threads)
+
+	var wg sync.WaitGroup
+	step := (maxNonce + 1) / threads
+	for i := 0; i < threads; i++ {
+		start, end := i*step, (i+1)*step
+		if i == threads-1 {
+			end = maxNonce + 1
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			if nonce, hash, ok := pow.search(ctx, start, end, hashes); ok {
+				found <- solution{nonce, hash}
+				// 其他 goroutine 可以停了
+				cancel()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	select {
+	case s := <-found:
+		return s.nonce, s.hash, true
+	default:
+		return 0, nil, false
+	}
+}
+
+func (m *Miner) reportHashRate(hashes *uint64, stop <-chan struct{}) {
+	ticker := time.NewTicker(hashRateInterval)
+	defer ticker.Stop()
+
+	var last uint64
+	for {
+		select {
+		case <-ticker.C:
+			total := atomic.LoadUint64(hashes)
+			m.HashRate(float64(total-last) / hashRateInterval.Seconds())
+			last = total
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+)
+
+// 目标是 2，实际上找不到解
+const impossibleBits = 255
+
+func TestMineThreads(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+
+	for _, threads := range []int{1, 3, 8} {
+		b := newBlock(t, bc, w, 10)
+		b.Nonce, b.Hash = 0, nil
+		m := &blk.Miner{Threads: threads}
+		if err := m.Mine(context.Background(), b); err != nil {
+			t.Fatal(err)
+		}
+		if !blk.NewProofOfWork(b).Validate() {
+			t.Fatalf("%d threads: the proof of work is not valid", threads)
+		}
+		if err := bc.AcceptBlock(b); err != nil {
+			t.Fatalf("%d threads: %v", threads, err)
+		}
+	}
+}
+
+func TestMineCancel(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	b := newBlock(t, bc, w, 10)
+	blk.SetTargetBits(impossibleBits)
+
+	var rates atomic.Int32
+	m := &blk.Miner{
+		Threads: 2,
+		HashRate: func(hashesPerSec float64) {
+			if hashesPerSec > 0 {
+				rates.Add(1)
+			}
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := m.Mine(ctx, b); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Mine: %v", err)
+	}
+	if d := time.Since(start); d > 4*time.Second {
+		t.Fatalf("Mine returned %v after the deadline", d)
+	}
+	if rates.Load() == 0 {
+		t.Fatal("the hash rate was not reported")
+	}
+}
+
+func TestSolveHeaderCancel(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	h := newBlock(t, bc, w, 10).Header()
+	blk.SetTargetBits(impossibleBits)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	var hashes uint64
+	if _, _, ok := (&blk.Miner{Threads: 4}).SolveHeader(ctx, h, blk.Target(), &hashes); ok {
+		t.Fatal("a header was solved with an impossible target")
+	}
+	if atomic.LoadUint64(&hashes) == 0 {
+		t.Fatal("no hash was counted")
+	}
+}
+
+// 别的块先连上的时候放弃挖矿
+func TestMineBlockOrphaned(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	b := newBlock(t, bc, w, 10)
+	blk.SetTargetBits(impossibleBits)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := bc.MineBlockContext(ctx, nil)
+		errc <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if err := bc.ConnectBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != blk.ErrOrphanBlock {
+		t.Fatalf("MineBlockContext: %v", err)
+	}
+}
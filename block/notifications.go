@@ -0,0 +1,73 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+// NotificationType 链上事件的类型
+type NotificationType int
+
+const (
+	// NTBlockConnected 新块接到了主链上，Data 为 *Block
+	NTBlockConnected NotificationType = iota
+	// NTBlockDisconnected 块从主链上移除，Data 为 *Block
+	NTBlockDisconnected
+)
+
+var notificationTypeStrings = map[NotificationType]string{
+	NTBlockConnected:    "NTBlockConnected",
+	NTBlockDisconnected: "NTBlockDisconnected",
+}
+
+func (n NotificationType) String() string {
+	if s, ok := notificationTypeStrings[n]; ok {
+		return s
+	}
+
+	return "Unknown Notification Type"
+}
+
+type Notification struct {
+	Type NotificationType
+	Data interface{}
+}
+
+// NotificationCallback is called synchronously after the change is committed to the db
+type NotificationCallback func(*Notification)
+
+// Subscribe registers a callback for all the chain notifications
+func (c *BlockChain) Subscribe(callback NotificationCallback) {
+	c.notificationsLock.Lock()
+	c.notifications = append(c.notifications, callback)
+	c.notificationsLock.Unlock()
+}
+
+func (c *BlockChain) sendNotification(typ NotificationType, data interface{}) {
+	n := Notification{Type: typ, Data: data}
+
+	c.notificationsLock.RLock()
+	for _, callback := range c.notifications {
+		callback(&n)
+	}
+	c.notificationsLock.RUnlock()
+}
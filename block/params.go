@@ -0,0 +1,171 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Checkpoint is a block known to be in the chain of the network
+type Checkpoint struct {
+	Height int
+	Hash   []byte
+}
+
+// Checkpoints of the network in height order. A block at the height of a
+// checkpoint must have its hash, and no fork below the last one is accepted.
+// The -checkpoint option of the node adds more, see AddCheckpoints.
+var Checkpoints = []Checkpoint{
+	// 网络运行一段时间后，把足够深的块按高度追加到这里
+}
+
+// AssumeUTXO is a snapshot of the UTXO set known to be the one of the network
+// at its base block
+type AssumeUTXO struct {
+	BaseHash    []byte
+	ContentHash []byte
+}
+
+// AssumeUTXOs are the snapshots a node can be created from. The content hash
+// of a snapshot only shows that the file is consistent, the snapshot must
+// also be listed here.
+var AssumeUTXOs = []AssumeUTXO{
+	// 和检查点一样，网络运行一段时间后把 dumptxoutset 的结果追加到这里
+}
+
+// AssumeValid is the default of the -assumevalid option. The signatures of
+// the ancestors of this block are not verified while syncing, all the other
+// checks still run. nil verifies every signature.
+var AssumeValid []byte
+
+var (
+	ErrCheckpointMismatch  = errors.New("block does not match the checkpoint at its height")
+	ErrForkBelowCheckpoint = errors.New("fork below the last checkpoint")
+	ErrUnknownSnapshot     = errors.New("snapshot is not in the assumeutxo list of the network")
+)
+
+// LastCheckpoint returns the height of the last checkpoint, or -1
+func LastCheckpoint() int {
+	if len(Checkpoints) == 0 {
+		return -1
+	}
+
+	return Checkpoints[len(Checkpoints)-1].Height
+}
+
+// CheckCheckpoint checks h against the checkpoint at its height
+func (h *BlockHeader) CheckCheckpoint() error {
+	for _, cp := range Checkpoints {
+		if cp.Height == h.Height && !bytes.Equal(cp.Hash, h.Hash) {
+			return ErrCheckpointMismatch
+		}
+	}
+
+	return nil
+}
+
+// AddCheckpoints adds the comma separated HEIGHT:HASH blocks given to
+// -checkpoint to Checkpoints, replacing the ones at the same heights
+func AddCheckpoints(checkpoints string) error {
+	if checkpoints == "" {
+		return nil
+	}
+
+	for _, cp := range strings.Split(checkpoints, ",") {
+		height, hash, ok := strings.Cut(strings.TrimSpace(cp), ":")
+		h, err := strconv.Atoi(height)
+		if err != nil || !ok || h < 0 {
+			return errors.New("-checkpoint is not HEIGHT:HASH")
+		}
+		blockHash, err := hex.DecodeString(hash)
+		if err != nil || len(blockHash) == 0 {
+			return errors.New("-checkpoint is not HEIGHT:HASH")
+		}
+
+		i := sort.Search(len(Checkpoints), func(i int) bool {
+			return Checkpoints[i].Height >= h
+		})
+		if i < len(Checkpoints) && Checkpoints[i].Height == h {
+			Checkpoints[i].Hash = blockHash
+			continue
+		}
+		Checkpoints = append(Checkpoints, Checkpoint{})
+		copy(Checkpoints[i+1:], Checkpoints[i:])
+		Checkpoints[i] = Checkpoint{h, blockHash}
+	}
+
+	return nil
+}
+
+// SetAssumeValid sets AssumeValid from the hex hash given to -assumevalid,
+// "0" verifies every signature
+func SetAssumeValid(hash string) error {
+	if hash == "0" {
+		AssumeValid = nil
+		return nil
+	}
+
+	h, err := hex.DecodeString(hash)
+	if err != nil {
+		return errors.New("-assumevalid is not a block hash")
+	}
+	AssumeValid = h
+
+	return nil
+}
+
+// CheckAssumeUTXO checks that the base block and the content hash of the
+// snapshot are those of one of AssumeUTXOs
+func (m *SnapshotMetadata) CheckAssumeUTXO() error {
+	for _, au := range AssumeUTXOs {
+		if bytes.Equal(au.BaseHash, m.BaseHash) && bytes.Equal(au.ContentHash, m.ContentHash) {
+			return nil
+		}
+	}
+
+	return ErrUnknownSnapshot
+}
+
+// AddAssumeUTXO adds the snapshot BASEHASH:CONTENTHASH given to -assumeutxo
+// to AssumeUTXOs
+func AddAssumeUTXO(snapshot string) error {
+	base, content, ok := strings.Cut(snapshot, ":")
+	baseHash, err := hex.DecodeString(base)
+	if err != nil || !ok {
+		return errors.New("-assumeutxo is not BASEHASH:CONTENTHASH")
+	}
+	contentHash, err := hex.DecodeString(content)
+	if err != nil {
+		return errors.New("-assumeutxo is not BASEHASH:CONTENTHASH")
+	}
+	AssumeUTXOs = append(AssumeUTXOs, AssumeUTXO{baseHash, contentHash})
+
+	return nil
+}
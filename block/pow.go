@@ -35,30 +35,54 @@ import (
 	"strconv"
 	"math"
 	"crypto/sha256"
-	"fmt"
+	"context"
+	"sync/atomic"
 )
 
-const targetBits = 24
+// 难度，回归测试网络用 SetTargetBits 调低
+var targetBits = 24
 
 type ProofOfWork struct {
-	block  *Block
+	header *BlockHeader
 	target *big.Int
 }
 
 func NewProofOfWork(b *Block) *ProofOfWork {
+	return NewHeaderProofOfWork(b.Header())
+}
+
+// NewHeaderProofOfWork works on the header only, so light clients can check
+// the proof of work without the transactions
+func NewHeaderProofOfWork(h *BlockHeader) *ProofOfWork {
+	pow := &ProofOfWork{h, Target()}
+
+	return pow
+}
+
+// Target returns the value the hash of a block must be below
+func Target() *big.Int {
 	target := big.NewInt(1)
 	target.Lsh(target, uint(256-targetBits))
 
-	pow := &ProofOfWork{b, target}
+	return target
+}
 
-	return pow
+// TargetBits returns the difficulty, it is hashed with the header
+func TargetBits() int {
+	return targetBits
+}
+
+// SetTargetBits sets the difficulty of the network, it must be called before
+// any block is mined or validated
+func SetTargetBits(bits int) {
+	targetBits = bits
 }
 
 func (pow *ProofOfWork) prepareData(nounce int) []byte {
 	data := bytes.Join([][]byte{
-		pow.block.PrevHash,
-		pow.block.HashTransactions(),
-		IntToHex(pow.block.TimeStamp),
+		pow.header.PrevHash,
+		pow.header.MerkleRoot,
+		IntToHex(pow.header.TimeStamp),
 		IntToHex(int64(targetBits)),
 		IntToHex(int64(nounce)),
 	}, []byte{})
@@ -69,35 +93,39 @@ func IntToHex(n int64) []byte {
 	return []byte(strconv.FormatInt(n, 16))
 }
 
-const maxNounce = math.MaxInt64
+// maxNonce 每个模板可搜索的 nonce 空间，用完后要换 extranonce 或时间戳
+const maxNonce = math.MaxUint32
 
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var (
-		hashInt big.Int
-		hash    [32]byte
-		nonce   = 0
-	)
+// hashBatch 每算这么多次哈希检查一次是否要停下来
+const hashBatch = 1 << 10
 
-	fmt.Printf("Mining a new block \n")
-	for nonce < maxNounce {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
-		hashInt.SetBytes(hash[:])
+// search tries the nonces in [start, end) and returns the first one whose hash
+// is below the target. It gives up when ctx is done, hashes counts the work.
+func (pow *ProofOfWork) search(ctx context.Context, start, end int, hashes *uint64) (int, []byte, bool) {
+	var hashInt big.Int
+
+	for nonce := start; nonce < end; nonce++ {
+		if (nonce-start)%hashBatch == hashBatch-1 {
+			atomic.AddUint64(hashes, hashBatch)
+			if ctx.Err() != nil {
+				return 0, nil, false
+			}
+		}
 
+		hash := sha256.Sum256(pow.prepareData(nonce))
+		hashInt.SetBytes(hash[:])
 		if hashInt.Cmp(pow.target) == -1 {
-			fmt.Printf("\r%x \n", hash)
-			break
-		} else {
-			nonce += 1
+			return nonce, hash[:], true
 		}
 	}
-	return nonce, hash[:]
+
+	return 0, nil, false
 }
 
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce)
+	data := pow.prepareData(pow.header.Nonce)
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
 
@@ -105,3 +133,9 @@ func (pow *ProofOfWork) Validate() bool {
 
 	return isValid
 }
+
+// CalcHash returns the hash of the header with its nonce
+func (pow *ProofOfWork) CalcHash() []byte {
+	hash := sha256.Sum256(pow.prepareData(pow.header.Nonce))
+	return hash[:]
+}
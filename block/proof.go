@@ -0,0 +1,182 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+
+	"myBitCoin/merkle"
+	"myBitCoin/transaction"
+)
+
+var (
+	ErrProofMismatch = errors.New("proof does not match the block")
+	ErrTxNotInBlock  = errors.New("transaction is not in the block")
+	ErrNoTxToProve   = errors.New("no transaction to prove")
+)
+
+// TxOutProof proves that Transactions are included in the block BlockHash.
+// The leaves of the merkle tree are hashes of the serialized transactions,
+// so the matched transactions travel with the proof.
+type TxOutProof struct {
+	BlockHash    []byte
+	Tree         *merkle.PartialMerkleTree
+	Transactions []*transaction.Transaction
+}
+
+func (b *Block) txLeaves() [][]byte {
+	var leaves [][]byte
+	for _, tx := range b.Transactions {
+		leaves = append(leaves, merkle.LeafHash(tx.Serialize()))
+	}
+
+	return leaves
+}
+
+// TxOutProof builds a proof for the transactions of b whose ID is in txIDs
+func (b *Block) TxOutProof(txIDs [][]byte) (*TxOutProof, error) {
+	proof := &TxOutProof{BlockHash: b.Hash}
+	matches := make([]bool, len(b.Transactions))
+
+	for _, id := range txIDs {
+		found := false
+		for i, tx := range b.Transactions {
+			if bytes.Equal(tx.ID, id) {
+				if !matches[i] {
+					proof.Transactions = append(proof.Transactions, tx)
+				}
+				matches[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, ErrTxNotInBlock
+		}
+	}
+
+	proof.Tree = merkle.NewPartialMerkleTree(b.txLeaves(), matches)
+	return proof, nil
+}
+
+// Verify checks the proof against the merkle root of the block and returns
+// the proven transactions
+func (p *TxOutProof) Verify(merkleRoot []byte) ([]*transaction.Transaction, error) {
+	root, leaves, _, err := p.Tree.ExtractMatches()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(root, merkleRoot) || len(leaves) != len(p.Transactions) {
+		return nil, ErrProofMismatch
+	}
+
+	byLeaf := make(map[string]*transaction.Transaction)
+	for _, tx := range p.Transactions {
+		byLeaf[string(merkle.LeafHash(tx.Serialize()))] = tx
+	}
+
+	var txs []*transaction.Transaction
+	for _, leaf := range leaves {
+		tx, ok := byLeaf[string(leaf)]
+		if !ok {
+			return nil, ErrProofMismatch
+		}
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
+
+func (p *TxOutProof) Serialize() []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	encoder.Encode(p)
+
+	return res.Bytes()
+}
+
+func DeserializeTxOutProof(data []byte) (*TxOutProof, error) {
+	var proof TxOutProof
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&proof); err != nil {
+		return nil, err
+	}
+	if proof.Tree == nil {
+		return nil, merkle.ErrBadPartialTree
+	}
+
+	return &proof, nil
+}
+
+// GetTxOutProof builds a proof for txIDs, they must all be in the same block.
+// When blockHash is nil the block is found by walking the chain.
+func (c *BlockChain) GetTxOutProof(txIDs [][]byte, blockHash []byte) (*TxOutProof, error) {
+	if len(txIDs) == 0 {
+		return nil, ErrNoTxToProve
+	}
+
+	if blockHash != nil {
+		block, err := c.GetBlock(blockHash)
+		if err != nil {
+			return nil, err
+		}
+		return block.TxOutProof(txIDs)
+	}
+
+	bci := c.Iterator()
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+		if block.Pruned() {
+			return nil, ErrBlockPruned
+		}
+
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, txIDs[0]) {
+				return block.TxOutProof(txIDs)
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return nil, ErrTxNotFound
+}
+
+// VerifyTxOutProof verifies the proof against the header of the block in the
+// chain, the body of the block may be pruned
+func (c *BlockChain) VerifyTxOutProof(proof *TxOutProof) ([]*transaction.Transaction, error) {
+	header, err := c.GetHeader(proof.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return proof.Verify(header.MerkleRoot)
+}
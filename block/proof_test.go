@@ -0,0 +1,121 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block_test
+
+import (
+	"bytes"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/transaction"
+)
+
+// mineProven mines a block with a coinbase and a payment of the genesis
+// reward, and returns it with the payment
+func mineProven(t *testing.T) (*blk.BlockChain, *blk.Block, *transaction.Transaction) {
+	t.Helper()
+
+	bc, w := chaintest.NewChain(t)
+	tx := chaintest.NewTx(t, bc, w, string(chaintest.NewWallet(t).GetAddress()), 3)
+	coinbase, err := transaction.NewCoinbaseTx(string(w.GetAddress()), "proven")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := bc.MineBlock([]*transaction.Transaction{coinbase, tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bc, b, tx
+}
+
+func TestTxOutProof(t *testing.T) {
+	bc, b, tx := mineProven(t)
+
+	proof, err := bc.GetTxOutProof([][]byte{tx.ID}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(proof.BlockHash, b.Hash) {
+		t.Fatalf("proof of block %x, want %x", proof.BlockHash, b.Hash)
+	}
+	decoded, err := blk.DeserializeTxOutProof(proof.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, err := bc.VerifyTxOutProof(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || !bytes.Equal(txs[0].ID, tx.ID) {
+		t.Fatalf("proven %d transactions", len(txs))
+	}
+
+	// 换成块中的另一笔交易
+	decoded.Transactions = []*transaction.Transaction{b.Transactions[0]}
+	if _, err := bc.VerifyTxOutProof(decoded); err != blk.ErrProofMismatch {
+		t.Fatalf("proof with another transaction: %v", err)
+	}
+
+	if _, err := bc.GetTxOutProof(nil, nil); err != blk.ErrNoTxToProve {
+		t.Fatalf("proof of nothing: %v", err)
+	}
+	if _, err := bc.GetTxOutProof([][]byte{tx.ID, []byte("missing")}, b.Hash); err != blk.ErrTxNotInBlock {
+		t.Fatalf("proof of a missing transaction: %v", err)
+	}
+	if _, err := bc.GetTxOutProof([][]byte{[]byte("missing")}, nil); err != blk.ErrTxNotFound {
+		t.Fatalf("proof of a transaction not in the chain: %v", err)
+	}
+}
+
+func TestVerifyProofOfPrunedBlock(t *testing.T) {
+	bc, b, tx := mineProven(t)
+	proof, err := bc.GetTxOutProof([][]byte{tx.ID}, b.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := chaintest.NewWallet(t)
+	chaintest.MineBlocks(t, bc, string(w.GetAddress()), blk.MinBlocksToKeep+1)
+	if err := bc.SetPruneTarget(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.GetBlock(b.Hash); err != blk.ErrBlockPruned {
+		t.Fatalf("the block is not pruned: %v", err)
+	}
+
+	// 只用区块头中的 merkle root 验证
+	txs, err := bc.VerifyTxOutProof(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || !bytes.Equal(txs[0].ID, tx.ID) {
+		t.Fatalf("proven %d transactions", len(txs))
+	}
+	if _, err := bc.GetTxOutProof([][]byte{tx.ID}, b.Hash); err != blk.ErrBlockPruned {
+		t.Fatalf("proof built from a pruned block: %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+)
+
+const (
+	headersBucket   = "headers"   // hash -> header of a pruned block
+	prunedTxsBucket = "prunedtxs" // txid -> prunedTx
+	pruneHeightKey  = "p"         // blocks bucket: lowest height whose body is kept
+
+	// MinBlocksToKeep is the number of blocks below the tip which are never
+	// pruned, so that the node can still serve and reorganize recent blocks
+	MinBlocksToKeep = 288
+)
+
+var ErrBlockPruned = errors.New("block is pruned")
+
+// prunedTx 是被裁剪的块中还有未花费输出的交易，花费它的交易仍然需要验证签名
+type prunedTx struct {
+	Tx      *transaction.Transaction
+	Unspent []int
+	Height  int
+}
+
+func (p *prunedTx) serialize() []byte {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(p)
+
+	return buf.Bytes()
+}
+
+func deserializePrunedTx(data []byte) (*prunedTx, error) {
+	var p prunedTx
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&p); err != nil {
+		return nil, fmt.Errorf("%w: pruned transaction: %v", ErrBadBlockData, err)
+	}
+
+	return &p, nil
+}
+
+// Pruned reports whether the body of b was pruned, only its header is left
+func (b *Block) Pruned() bool {
+	return b.header != nil
+}
+
+// prunedBlock 是只有区块头的块，迭代器遇到被裁剪的块时返回它
+func (h *BlockHeader) prunedBlock() *Block {
+	return &Block{
+		TimeStamp: h.TimeStamp,
+		PrevHash:  h.PrevHash,
+		Hash:      h.Hash,
+		Nonce:     h.Nonce,
+		Height:    h.Height,
+		header:    h,
+	}
+}
+
+// prunedHeader returns nil when the block isn't pruned
+func prunedHeader(tx storage.Tx, hash []byte) (*BlockHeader, error) {
+	b := tx.Bucket([]byte(headersBucket))
+	if b == nil {
+		return nil, nil
+	}
+	data := b.Get(hash)
+	if data == nil {
+		return nil, nil
+	}
+
+	return DeserializeHeader(data)
+}
+
+// GetHeader finds the header of a block, pruned or not
+func (c *BlockChain) GetHeader(hash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
+
+	err := c.DB.View(func(tx storage.Tx) error {
+		if data := tx.Bucket([]byte(blocksBucket)).Get(hash); data != nil {
+			b, err := DeSerialize(data)
+			if err != nil {
+				return err
+			}
+			header = b.Header()
+			return nil
+		}
+
+		var err error
+		if header, err = prunedHeader(tx, hash); err == nil && header == nil {
+			err = ErrBlockNotFound
+		}
+		return err
+	})
+
+	return header, err
+}
+
+// PruneHeight returns the height of the lowest block whose body is kept, the
+// bodies of all the blocks below it are pruned
+func (c *BlockChain) PruneHeight() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.pruneHeight
+}
+
+func readPruneHeight(tx storage.Tx) int {
+	data := tx.Bucket([]byte(blocksBucket)).Get([]byte(pruneHeightKey))
+	if data == nil {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint64(data))
+}
+
+func heightValue(height int) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(height))
+
+	return value
+}
+
+// SetPruneTarget enables pruning: the bodies of the oldest blocks are deleted
+// whenever the bodies take more than target bytes, keeping at least
+// MinBlocksToKeep blocks. Headers are kept, and so are the transactions of the
+// pruned blocks which still have unspent outputs. 0 disables pruning.
+func (c *BlockChain) SetPruneTarget(target int64) error {
+	c.pruneMu.Lock()
+	c.pruneTarget = target
+	c.bodiesSize = 0
+	if target > 0 {
+		bodies, err := c.bodies()
+		if err != nil {
+			c.pruneMu.Unlock()
+			return err
+		}
+		for _, body := range bodies {
+			c.bodiesSize += body.size
+		}
+	}
+	c.pruneMu.Unlock()
+
+	return c.prune()
+}
+
+// blockConnected 记录新块的大小，超过目标时裁剪
+func (c *BlockChain) blockConnected(b *Block) {
+	c.pruneMu.Lock()
+	if c.pruneTarget > 0 {
+		c.bodiesSize += int64(len(b.Serialize()))
+	}
+	c.pruneMu.Unlock()
+
+	if err := c.prune(); err != nil {
+		log.Printf("prune: %v", err)
+	}
+}
+
+type body struct {
+	hash   []byte
+	height int
+	size   int64
+}
+
+// bodies returns the blocks which are not pruned, from the tip down
+func (c *BlockChain) bodies() ([]body, error) {
+	var result []body
+
+	err := c.DB.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		for hash := c.Tip(); len(hash) > 0; {
+			data := b.Get(hash)
+			if data == nil {
+				break
+			}
+			block, err := DeSerialize(data)
+			if err != nil {
+				return err
+			}
+			result = append(result, body{block.Hash, block.Height, int64(len(data))})
+			hash = block.PrevHash
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+func (c *BlockChain) prune() error {
+	c.pruneMu.Lock()
+	defer c.pruneMu.Unlock()
+
+	if c.pruneTarget <= 0 || c.bodiesSize <= c.pruneTarget {
+		return nil
+	}
+
+	// 从链尾往回保留，超出目标的块连同更早的块都裁剪掉
+	bodies, err := c.bodies()
+	if err != nil {
+		return err
+	}
+	var kept int64
+	first := len(bodies)
+	for i, body := range bodies {
+		if i >= MinBlocksToKeep && kept+body.size > c.pruneTarget {
+			first = i
+			break
+		}
+		kept += body.size
+	}
+	if first == len(bodies) {
+		return nil
+	}
+	pruneHeight := bodies[first].height + 1
+
+	err = c.DB.Update(func(tx storage.Tx) error {
+		for i := len(bodies) - 1; i >= first; i-- {
+			if err := pruneBlock(tx, bodies[i].hash); err != nil {
+				return err
+			}
+			for _, h := range pruneHandlers {
+				if err := h(c, tx, bodies[i].hash); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Bucket([]byte(blocksBucket)).Put([]byte(pruneHeightKey), heightValue(pruneHeight))
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.pruneHeight = pruneHeight
+	c.mu.Unlock()
+	c.bodiesSize = kept
+	log.Printf("pruned blocks below height %d", pruneHeight)
+
+	return nil
+}
+
+// PruneHandler deletes what a package keeps for a block whose body is pruned,
+// in the transaction pruning it. The block can no longer be disconnected.
+type PruneHandler func(c *BlockChain, tx storage.Tx, hash []byte) error
+
+var pruneHandlers []PruneHandler
+
+// RegisterPruneHandler adds a handler run for the blocks pruned from every
+// chain
+func RegisterPruneHandler(h PruneHandler) {
+	pruneHandlers = append(pruneHandlers, h)
+}
+
+// pruneBlock replaces the body of a block by its header, the blocks must be
+// pruned in height order so that the spent outputs are removed from prunedtxs
+func pruneBlock(tx storage.Tx, hash []byte) error {
+	blocks := tx.Bucket([]byte(blocksBucket))
+	headers, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+	if err != nil {
+		return err
+	}
+	prunedTxs, err := tx.CreateBucketIfNotExists([]byte(prunedTxsBucket))
+	if err != nil {
+		return err
+	}
+
+	b, err := DeSerialize(blocks.Get(hash))
+	if err != nil {
+		return err
+	}
+	if err := headers.Put(hash, b.Header().Serialize()); err != nil {
+		return err
+	}
+
+	for _, t := range b.Transactions {
+		if !t.IsCoinbase() {
+			for _, in := range t.Vin {
+				data := prunedTxs.Get(in.TxID)
+				if data == nil {
+					continue
+				}
+				p, err := deserializePrunedTx(data)
+				if err != nil {
+					return err
+				}
+				for i, vout := range p.Unspent {
+					if vout == in.Vout {
+						p.Unspent = append(p.Unspent[:i], p.Unspent[i+1:]...)
+						break
+					}
+				}
+
+				if len(p.Unspent) == 0 {
+					err = prunedTxs.Delete(in.TxID)
+				} else {
+					err = prunedTxs.Put(in.TxID, p.serialize())
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		p := &prunedTx{Tx: t, Height: b.Height}
+		for i := range t.Vout {
+			p.Unspent = append(p.Unspent, i)
+		}
+		if err := prunedTxs.Put(t.ID, p.serialize()); err != nil {
+			return err
+		}
+	}
+
+	return blocks.Delete(hash)
+}
+
+// forEachPrunedTx calls f with the transactions of the pruned blocks which
+// have unspent outputs
+func (c *BlockChain) forEachPrunedTx(f func(tx *transaction.Transaction, height int, unspent []int)) error {
+	return c.DB.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(prunedTxsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			p, err := deserializePrunedTx(v)
+			if err != nil {
+				return err
+			}
+			f(p.Tx, p.Height, p.Unspent)
+			return nil
+		})
+	})
+}
+
+// findPrunedTx 在被裁剪的块中找交易，只能找到还有未花费输出的交易
+func (c *BlockChain) findPrunedTx(ID []byte) (transaction.Transaction, error) {
+	var found *transaction.Transaction
+
+	err := c.DB.View(func(tx storage.Tx) error {
+		if b := tx.Bucket([]byte(prunedTxsBucket)); b != nil {
+			if data := b.Get(ID); data != nil {
+				p, err := deserializePrunedTx(data)
+				if err != nil {
+					return err
+				}
+				found = p.Tx
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return transaction.Transaction{}, err
+	}
+	if found == nil {
+		return transaction.Transaction{}, ErrBlockPruned
+	}
+
+	return *found, nil
+}
@@ -0,0 +1,129 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block_test
+
+import (
+	"bytes"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/transaction"
+)
+
+// checkPruned checks that the bodies below height are pruned and the others kept
+func checkPruned(t *testing.T, bc *blk.BlockChain, height int) {
+	t.Helper()
+
+	if got := bc.PruneHeight(); got != height {
+		t.Fatalf("prune height %d, want %d", got, height)
+	}
+	if height == 0 {
+		return
+	}
+
+	hash := mustHash(t, bc, height-1)
+	if _, err := bc.GetBlock(hash); err != blk.ErrBlockPruned {
+		t.Fatalf("block %d: %v", height-1, err)
+	}
+	// 头还在
+	if h, err := bc.GetHeader(hash); err != nil || !bytes.Equal(h.Hash, hash) {
+		t.Fatalf("header %d: %v", height-1, err)
+	}
+
+	if _, err := bc.GetBlock(mustHash(t, bc, height)); err != nil {
+		t.Fatalf("block %d: %v", height, err)
+	}
+}
+
+func TestPruneTarget(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	to := chaintest.NewWallet(t)
+	genesis, err := bc.GetBlock(mustHash(t, bc, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 创世块的输出全部花掉，tx 还有未花费的输出
+	tx := chaintest.NewTx(t, bc, w, string(to.GetAddress()), transaction.Subsidy)
+	if _, err := bc.MineBlock([]*transaction.Transaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+	chaintest.MineBlocks(t, bc, string(w.GetAddress()), blk.MinBlocksToKeep+5)
+	tip, err := bc.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bc.SetPruneTarget(1 << 40); err != nil {
+		t.Fatal(err)
+	}
+	checkPruned(t, bc, 0)
+
+	// 目标正好放得下最后 MinBlocksToKeep+2 个块
+	var target int64
+	for h := tip; h > tip-blk.MinBlocksToKeep-2; h-- {
+		b, err := bc.GetBlock(mustHash(t, bc, h))
+		if err != nil {
+			t.Fatal(err)
+		}
+		target += int64(len(b.Serialize()))
+	}
+	if err := bc.SetPruneTarget(target); err != nil {
+		t.Fatal(err)
+	}
+	checkPruned(t, bc, tip-blk.MinBlocksToKeep-1)
+
+	// 目标再小也保留 MinBlocksToKeep 个块
+	if err := bc.SetPruneTarget(1); err != nil {
+		t.Fatal(err)
+	}
+	checkPruned(t, bc, tip-blk.MinBlocksToKeep+1)
+	chaintest.MineBlocks(t, bc, string(w.GetAddress()), 1)
+	checkPruned(t, bc, tip-blk.MinBlocksToKeep+2)
+
+	if _, err := bc.FindTransaction(genesis.Transactions[0].ID); err != blk.ErrBlockPruned {
+		t.Fatalf("spent transaction of a pruned block: %v", err)
+	}
+	if found, err := bc.FindTransaction(tx.ID); err != nil || !bytes.Equal(found.ID, tx.ID) {
+		t.Fatalf("unspent transaction of a pruned block: %v", err)
+	}
+
+	// 花费被裁剪的块中的输出，签名照样能验证
+	spend := chaintest.NewTx(t, bc, to, string(w.GetAddress()), transaction.Subsidy)
+	if _, err := bc.MineBlock([]*transaction.Transaction{spend}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustHash(t *testing.T, bc *blk.BlockChain, height int) []byte {
+	t.Helper()
+
+	hash, err := bc.HashAtHeight(height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
@@ -0,0 +1,558 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"sort"
+
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+)
+
+const (
+	// blocks bucket: 从快照加载、还没验证历史时保存快照的元数据
+	snapshotKey = "s"
+	// blocks bucket: 快照验证失败的标记
+	snapshotInvalidKey = "si"
+)
+
+var (
+	ErrBadSnapshot      = errors.New("snapshot does not match its content hash")
+	ErrSnapshotMismatch = errors.New("snapshot does not match the chain")
+	ErrSnapshotInvalid  = errors.New("the blocks below the snapshot do not lead to its UTXO set")
+)
+
+// SnapshotMetadata describes a UTXO set snapshot taken at the block BaseHash
+type SnapshotMetadata struct {
+	BaseHash    []byte
+	BaseHeight  int
+	TxCount     int
+	ContentHash []byte
+}
+
+// SnapshotCoin is a transaction with unspent outputs at the base of the
+// snapshot. Tx only has the ID and the outputs of the transaction, the spent
+// outputs are zero, and the input of a coinbase: the inputs spending it are
+// verified against it. Height is the height of its block.
+type SnapshotCoin struct {
+	Tx      *transaction.Transaction
+	Unspent []int
+	Height  int
+}
+
+// NewSnapshotCoin returns the coin of the unspent outputs outs of the
+// transaction txID
+func NewSnapshotCoin(txID []byte, height int, coinbase bool, outs map[int]transaction.TxOutput) *SnapshotCoin {
+	coin := &SnapshotCoin{Tx: &transaction.Transaction{ID: txID}, Height: height}
+	if coinbase {
+		coin.Tx.Vin = []transaction.TxInput{{Vout: -1}}
+	}
+	for vout := range outs {
+		coin.Unspent = append(coin.Unspent, vout)
+	}
+	sort.Ints(coin.Unspent)
+	if len(coin.Unspent) > 0 {
+		coin.Tx.Vout = make([]transaction.TxOutput, coin.Unspent[len(coin.Unspent)-1]+1)
+	}
+	for vout, out := range outs {
+		coin.Tx.Vout[vout] = out
+	}
+
+	return coin
+}
+
+func (m *SnapshotMetadata) serialize() []byte {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(m)
+
+	return buf.Bytes()
+}
+
+// snapshotHash 按交易 ID 的顺序哈希，同一个 UTXO 集合在所有节点上得到同一个值。
+// gob 的类型编号和进程有关，高度和未花费输出的序号按定长整数写入
+type snapshotHash struct {
+	h     hash.Hash
+	last  []byte
+	count int
+}
+
+func newSnapshotHash() *snapshotHash {
+	return &snapshotHash{h: sha256.New()}
+}
+
+// add 要求交易 ID 递增，快照中每笔交易只出现一次
+func (s *snapshotHash) add(coin *SnapshotCoin) error {
+	if s.last != nil && bytes.Compare(coin.Tx.ID, s.last) <= 0 {
+		return fmt.Errorf("%w: transaction %x is out of order", ErrBadSnapshot, coin.Tx.ID)
+	}
+	s.last = coin.Tx.ID
+	s.count++
+
+	s.h.Write(coin.Tx.ID)
+	s.h.Write(coin.Tx.Serialize())
+	binary.Write(s.h, binary.BigEndian, int64(coin.Height))
+	for _, i := range coin.Unspent {
+		binary.Write(s.h, binary.BigEndian, int64(i))
+	}
+
+	return nil
+}
+
+// ForEachUnspentCoin calls f with each transaction with unspent outputs at
+// the tip, walking the blocks from the tip down. The blocks are read one at a
+// time, only the IDs of the transactions, the outputs spent by the blocks
+// walked and the transactions of the pruned blocks are kept in memory.
+func (c *BlockChain) ForEachUnspentCoin(f func(coin *SnapshotCoin) error) error {
+	seen := make(map[string]bool)
+	spent := make(map[string]map[int]bool)
+	markSpent := func(tx *transaction.Transaction) {
+		if tx.IsCoinbase() {
+			return
+		}
+		for _, in := range tx.Vin {
+			id := string(in.TxID)
+			if spent[id] == nil {
+				spent[id] = make(map[int]bool)
+			}
+			spent[id][in.Vout] = true
+		}
+	}
+	// 花掉它的交易都在更高的块或同一块的后面，已经走过了
+	emit := func(tx *transaction.Transaction, height int, outs []int) error {
+		id := string(tx.ID)
+		// 相同 ID 的交易只保留最新的
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+
+		coin := &SnapshotCoin{Tx: tx, Height: height}
+		for _, i := range outs {
+			if !spent[id][i] {
+				coin.Unspent = append(coin.Unspent, i)
+			}
+		}
+		delete(spent, id)
+		if len(coin.Unspent) == 0 {
+			return nil
+		}
+		return f(coin)
+	}
+
+	bci := c.Iterator()
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		if block.Pruned() {
+			break
+		}
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			tx := block.Transactions[i]
+			var outs []int
+			for vout := range tx.Vout {
+				outs = append(outs, vout)
+			}
+			if err := emit(tx, block.Height, outs); err != nil {
+				return err
+			}
+			markSpent(tx)
+		}
+
+		if len(block.PrevHash) == 0 {
+			return nil
+		}
+	}
+
+	var pruned []*prunedTx
+	err := c.forEachPrunedTx(func(tx *transaction.Transaction, height int, unspent []int) {
+		pruned = append(pruned, &prunedTx{tx, unspent, height})
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range pruned {
+		if err := emit(p.Tx, p.Height, p.Unspent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteSnapshot writes a snapshot of the UTXO set at the block baseHash to w.
+// coins calls its argument with each coin in transaction ID order, it is
+// called twice: once for the metadata and once to write the coins.
+func WriteSnapshot(w io.Writer, baseHash []byte, baseHeight int, coins func(f func(coin *SnapshotCoin) error) error) (*SnapshotMetadata, error) {
+	h := newSnapshotHash()
+	if err := coins(h.add); err != nil {
+		return nil, err
+	}
+	meta := &SnapshotMetadata{
+		BaseHash:    baseHash,
+		BaseHeight:  baseHeight,
+		TxCount:     h.count,
+		ContentHash: h.h.Sum(nil),
+	}
+
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(meta); err != nil {
+		return nil, err
+	}
+	written := 0
+	err := coins(func(coin *SnapshotCoin) error {
+		written++
+		return enc.Encode(coin)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if written != meta.TxCount {
+		return nil, errors.New("snapshot: the coins changed while they were written")
+	}
+
+	return meta, nil
+}
+
+// SnapshotReader reads the coins of a snapshot written by DumpSnapshot
+type SnapshotReader struct {
+	Metadata SnapshotMetadata
+	dec      *gob.Decoder
+	read     int
+}
+
+func NewSnapshotReader(r io.Reader) (*SnapshotReader, error) {
+	s := &SnapshotReader{dec: gob.NewDecoder(r)}
+	if err := s.dec.Decode(&s.Metadata); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Next returns the next coin, or io.EOF after the last one
+func (s *SnapshotReader) Next() (*SnapshotCoin, error) {
+	if s.read == s.Metadata.TxCount {
+		return nil, io.EOF
+	}
+
+	var coin SnapshotCoin
+	if err := s.dec.Decode(&coin); err != nil {
+		return nil, err
+	}
+	s.read++
+
+	return &coin, nil
+}
+
+// CreateBlockChainFromSnapshot creates the chain of a new node from a
+// snapshot. headers are the headers from the genesis block to the base block
+// of the snapshot, and base is the base block. The node starts at the base
+// block as if the blocks below it were pruned, its UTXO set must then be
+// reindexed by the caller. The history is validated later, see
+// SnapshotReplay.
+func CreateBlockChainFromSnapshot(nodeID string, snap *SnapshotReader, headers []*BlockHeader, base *Block) (*BlockChain, error) {
+	meta := &snap.Metadata
+	if err := meta.CheckAssumeUTXO(); err != nil {
+		return nil, err
+	}
+	if err := checkSnapshotHeaders(meta, headers, base); err != nil {
+		return nil, err
+	}
+
+	if dbExists(nodeID) {
+		return nil, ErrBlockchainExists
+	}
+	db, err := openDB(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	baseTxs := make(map[string]bool)
+	for _, tx := range base.Transactions {
+		baseTxs[string(tx.ID)] = true
+	}
+
+	err = db.Update(func(tx storage.Tx) error {
+		blocks, err := tx.CreateBucket([]byte(blocksBucket))
+		if err != nil {
+			return err
+		}
+		headersB, err := tx.CreateBucket([]byte(headersBucket))
+		if err != nil {
+			return err
+		}
+		prunedTxs, err := tx.CreateBucket([]byte(prunedTxsBucket))
+		if err != nil {
+			return err
+		}
+
+		for _, h := range headers[:len(headers)-1] {
+			if err := headersB.Put(h.Hash, h.Serialize()); err != nil {
+				return err
+			}
+		}
+		for _, h := range headers {
+			if err := putHeight(tx, h.Height, h.Hash); err != nil {
+				return err
+			}
+		}
+		if err := blocks.Put(base.Hash, base.Serialize()); err != nil {
+			return err
+		}
+
+		// 基准块的交易在块里，其余的当作被裁剪的块中的交易
+		h := newSnapshotHash()
+		for {
+			coin, err := snap.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := h.add(coin); err != nil {
+				return err
+			}
+			if baseTxs[string(coin.Tx.ID)] {
+				continue
+			}
+			if err := prunedTxs.Put(coin.Tx.ID, (*prunedTx)(coin).serialize()); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(h.h.Sum(nil), meta.ContentHash) {
+			return ErrBadSnapshot
+		}
+
+		if err := blocks.Put([]byte(snapshotKey), meta.serialize()); err != nil {
+			return err
+		}
+		if err := blocks.Put([]byte(pruneHeightKey), heightValue(base.Height)); err != nil {
+			return err
+		}
+		return blocks.Put([]byte("l"), base.Hash)
+	})
+	if err != nil {
+		db.Close()
+		storage.Remove(fmt.Sprintf(dbPath, nodeID))
+		return nil, err
+	}
+
+	return &BlockChain{tip: base.Hash, DB: db, pruneHeight: base.Height}, nil
+}
+
+// checkSnapshotHeaders 检查区块头从创世块连到快照的基准块
+func checkSnapshotHeaders(meta *SnapshotMetadata, headers []*BlockHeader, base *Block) error {
+	if len(headers) != meta.BaseHeight+1 || len(headers[0].PrevHash) != 0 {
+		return ErrSnapshotMismatch
+	}
+	for i, h := range headers {
+		if err := h.CheckProofOfWork(); err != nil {
+			return err
+		}
+		if h.Height != i || i > 0 && h.CheckConnects(headers[i-1]) != nil {
+			return ErrBadLinkage
+		}
+		if err := h.CheckCheckpoint(); err != nil {
+			return err
+		}
+	}
+
+	last := headers[len(headers)-1]
+	if !bytes.Equal(last.Hash, meta.BaseHash) || !bytes.Equal(base.Hash, meta.BaseHash) ||
+		!bytes.Equal(base.HashTransactions(), last.MerkleRoot) {
+		return ErrSnapshotMismatch
+	}
+
+	return nil
+}
+
+// SnapshotInvalid reports whether the blocks below the snapshot the chain was
+// loaded from do not lead to its UTXO set
+func (c *BlockChain) SnapshotInvalid() bool {
+	invalid := false
+
+	c.DB.View(func(tx storage.Tx) error {
+		invalid = tx.Bucket([]byte(blocksBucket)).Get([]byte(snapshotInvalidKey)) != nil
+		return nil
+	})
+
+	return invalid
+}
+
+// SetSnapshotInvalid records that the validation of the snapshot failed, the
+// node does not start again with this chain
+func (c *BlockChain) SetSnapshotInvalid() error {
+	return c.DB.Update(func(tx storage.Tx) error {
+		return tx.Bucket([]byte(blocksBucket)).Put([]byte(snapshotInvalidKey), []byte{1})
+	})
+}
+
+// Snapshot returns the metadata of the snapshot the chain was loaded from,
+// or nil when the chain was synced from the genesis block or the history
+// below the snapshot was validated
+func (c *BlockChain) Snapshot() *SnapshotMetadata {
+	var meta *SnapshotMetadata
+
+	c.DB.View(func(tx storage.Tx) error {
+		data := tx.Bucket([]byte(blocksBucket)).Get([]byte(snapshotKey))
+		if data == nil {
+			return nil
+		}
+		meta = &SnapshotMetadata{}
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(meta)
+	})
+
+	return meta
+}
+
+// SetSnapshotValidated records that the history below the snapshot was validated
+func (c *BlockChain) SetSnapshotValidated() error {
+	return c.DB.Update(func(tx storage.Tx) error {
+		return tx.Bucket([]byte(blocksBucket)).Delete([]byte(snapshotKey))
+	})
+}
+
+// SnapshotReplay rebuilds the UTXO set of a snapshot from the genesis block,
+// the blocks must be connected in height order
+type SnapshotReplay struct {
+	meta   *SnapshotMetadata
+	coins  map[string]*SnapshotCoin
+	height int
+}
+
+func NewSnapshotReplay(meta *SnapshotMetadata) *SnapshotReplay {
+	return &SnapshotReplay{meta: meta, coins: make(map[string]*SnapshotCoin), height: -1}
+}
+
+// ConnectBlock checks the transactions of b against the coins of the blocks
+// before it and applies them
+func (r *SnapshotReplay) ConnectBlock(b *Block) error {
+	if b.Height != r.height+1 {
+		return ErrBadLinkage
+	}
+
+	fees := 0
+	for i, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			if i != 0 {
+				return ErrBadCoinbase
+			}
+		} else {
+			var spent []*SnapshotCoin
+			fee, prevTxs, err := tx.CheckInputs(func(in *transaction.TxInput) (*transaction.TxOutput, error) {
+				coin, ok := r.coins[hex.EncodeToString(in.TxID)]
+				if !ok || !coin.spend(in.Vout) {
+					return nil, fmt.Errorf("%w: %x:%d", ErrMissingInput, in.TxID, in.Vout)
+				}
+				spent = append(spent, coin)
+				return &coin.Tx.Vout[in.Vout], nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, coin := range spent {
+				if len(coin.Unspent) == 0 {
+					delete(r.coins, hex.EncodeToString(coin.Tx.ID))
+				}
+			}
+			if err := tx.Verify(prevTxs); err != nil {
+				return err
+			}
+			if fees > math.MaxInt-fee {
+				return ErrBadValue
+			}
+			fees += fee
+		}
+
+		coin := &SnapshotCoin{Tx: tx, Height: b.Height}
+		for i := range tx.Vout {
+			coin.Unspent = append(coin.Unspent, i)
+		}
+		r.coins[hex.EncodeToString(tx.ID)] = coin
+	}
+
+	if err := checkSubsidy(b, fees); err != nil {
+		return err
+	}
+	r.height = b.Height
+
+	return nil
+}
+
+func (coin *SnapshotCoin) spend(vout int) bool {
+	for i, out := range coin.Unspent {
+		if out == vout {
+			coin.Unspent = append(coin.Unspent[:i], coin.Unspent[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Verify compares the rebuilt UTXO set with the snapshot, once the base block
+// is connected
+func (r *SnapshotReplay) Verify() error {
+	if r.height != r.meta.BaseHeight {
+		return ErrSnapshotMismatch
+	}
+
+	// 和 DumpSnapshot 一样只哈希未花费的输出
+	var coins []*SnapshotCoin
+	for _, coin := range r.coins {
+		outs := make(map[int]transaction.TxOutput)
+		for _, vout := range coin.Unspent {
+			outs[vout] = coin.Tx.Vout[vout]
+		}
+		coins = append(coins, NewSnapshotCoin(coin.Tx.ID, coin.Height, coin.Tx.IsCoinbase(), outs))
+	}
+	sort.Slice(coins, func(i, j int) bool {
+		return bytes.Compare(coins[i].Tx.ID, coins[j].Tx.ID) < 0
+	})
+	h := newSnapshotHash()
+	for _, coin := range coins {
+		if err := h.add(coin); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(h.h.Sum(nil), r.meta.ContentHash) {
+		return ErrBadSnapshot
+	}
+
+	return nil
+}
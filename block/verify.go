@@ -0,0 +1,146 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"myBitCoin/transaction"
+)
+
+// The levels of VerifyChain, each level also runs the checks of the lower ones
+const (
+	// 块能读出，哈希满足难度，和父块的哈希、高度连续
+	VerifyHeaders = iota
+	// 用交易的 merkle 根重新计算区块头的哈希
+	VerifyMerkle
+	// 交易的签名和金额，和 AcceptBlock 的检查相同
+	VerifyTransactions
+	// 每个块的 undo 数据和它花费的输出一致
+	VerifyUndo
+	// 按链重建 UTXO 集合，和 chainstate 比较
+	VerifyUTXO
+)
+
+// BlockError is the first corrupt block found by VerifyBlocks
+type BlockError struct {
+	Hash   []byte
+	Height int
+	Err    error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("block %x at height %d: %v", e.Hash, e.Height, e.Err)
+}
+
+// VerifyBlocks checks the depth blocks below the tip, or all of them when
+// depth is 0, at the given level. check runs the checks of the higher levels
+// on each block, it may be nil. The pruned blocks are not checked. It returns
+// the number of blocks checked.
+func (c *BlockChain) VerifyBlocks(level, depth int, check func(b *Block) error) (int, error) {
+	n := 0
+	var child *Block
+	// 不用 Iterator，损坏的块要报告出来而不是 panic
+	for hash := c.Tip(); depth == 0 || n < depth; {
+		b, err := c.GetBlock(hash)
+		if err == ErrBlockPruned {
+			break
+		}
+		if err == nil && (b == nil || !bytes.Equal(b.Hash, hash)) {
+			err = errors.New("block can't be decoded")
+		}
+		if err != nil {
+			height := -1
+			if child != nil {
+				height = child.Height - 1
+			}
+			return n, &BlockError{hash, height, err}
+		}
+
+		if err := c.verifyBlock(b, child, level, check); err != nil {
+			return n, &BlockError{b.Hash, b.Height, err}
+		}
+		n++
+
+		if len(b.PrevHash) == 0 {
+			break
+		}
+		child, hash = b, b.PrevHash
+	}
+
+	return n, nil
+}
+
+func (c *BlockChain) verifyBlock(b, child *Block, level int, check func(b *Block) error) error {
+	var hashInt big.Int
+	if hashInt.SetBytes(b.Hash).Cmp(Target()) != -1 {
+		return ErrBadProofOfWork
+	}
+	if child != nil && (!bytes.Equal(child.PrevHash, b.Hash) || child.Height != b.Height+1) {
+		return ErrBadLinkage
+	}
+	if len(b.PrevHash) == 0 && b.Height != 0 {
+		return ErrBadLinkage
+	}
+	if level < VerifyMerkle {
+		return nil
+	}
+
+	if err := b.Header().CheckProofOfWork(); err != nil {
+		return errors.New("merkle root does not match the block hash")
+	}
+	if level < VerifyTransactions {
+		return nil
+	}
+
+	if err := c.checkTransactions(b, chainCoin, true); err != nil {
+		return err
+	}
+	if check != nil {
+		return check(b)
+	}
+
+	return nil
+}
+
+// chainCoin finds an output in the transactions of the chain, spent or not:
+// the blocks checked are connected, their inputs are spent by now
+func chainCoin(c *BlockChain, txID []byte, vout int) (*transaction.TxOutput, error) {
+	tx, err := c.FindTransaction(txID)
+	if err == ErrTxNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if vout < 0 || vout >= len(tx.Vout) {
+		return nil, nil
+	}
+
+	return &tx.Vout[vout], nil
+}
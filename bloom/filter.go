@@ -0,0 +1,211 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+
+	"myBitCoin/transaction"
+)
+
+// UpdateType tells the node whether to add the outpoints of matched outputs
+// to the filter, so that the transactions spending them match as well
+type UpdateType uint8
+
+const (
+	UpdateNone UpdateType = iota
+	UpdateAll
+)
+
+const (
+	MaxFilterSize    = 36000 // bytes
+	MaxHashFuncs     = 50
+	MaxFilterAddSize = 520 // 一次 filteradd 最多添加的字节数
+
+	ln2Squared = math.Ln2 * math.Ln2
+)
+
+var (
+	ErrFilterTooLarge   = errors.New("bloom: filter is too large")
+	ErrTooManyHashFuncs = errors.New("bloom: too many hash functions")
+	ErrDataTooLarge     = errors.New("bloom: data to add is too large")
+)
+
+// FilterLoad is the filter a peer sends to the node, see filterload in BIP37
+type FilterLoad struct {
+	Filter    []byte
+	HashFuncs uint32
+	Tweak     uint32
+	Flags     UpdateType
+}
+
+// Filter is a BIP37 bloom filter, safe for concurrent use
+type Filter struct {
+	mu  sync.Mutex
+	msg *FilterLoad
+}
+
+// NewFilter creates a filter holding elements items with the false positive
+// rate fpRate. The tweak makes the filters of different clients differ.
+func NewFilter(elements int, fpRate float64, tweak uint32, flags UpdateType) *Filter {
+	if elements < 1 {
+		elements = 1
+	}
+	fpRate = math.Max(math.Min(fpRate, 1), 1e-9)
+
+	// 先取整到字节，哈希函数的个数按取整后的大小算，和 BIP37 一致
+	size := int(math.Max(math.Min(-1/ln2Squared*float64(elements)*math.Log(fpRate)/8, MaxFilterSize), 1))
+	hashFuncs := math.Min(float64(size*8)/float64(elements)*math.Ln2, MaxHashFuncs)
+
+	return &Filter{msg: &FilterLoad{
+		Filter:    make([]byte, size),
+		HashFuncs: uint32(math.Max(hashFuncs, 1)),
+		Tweak:     tweak,
+		Flags:     flags,
+	}}
+}
+
+// LoadFilter creates a filter from the filterload of a peer
+func LoadFilter(msg *FilterLoad) (*Filter, error) {
+	if len(msg.Filter) > MaxFilterSize {
+		return nil, ErrFilterTooLarge
+	}
+	if msg.HashFuncs > MaxHashFuncs {
+		return nil, ErrTooManyHashFuncs
+	}
+
+	return &Filter{msg: &FilterLoad{
+		Filter:    append([]byte{}, msg.Filter...),
+		HashFuncs: msg.HashFuncs,
+		Tweak:     msg.Tweak,
+		Flags:     msg.Flags,
+	}}, nil
+}
+
+// MsgFilterLoad returns a copy of the filter to send to a node
+func (f *Filter) MsgFilterLoad() *FilterLoad {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	msg := *f.msg
+	msg.Filter = append([]byte{}, f.msg.Filter...)
+	return &msg
+}
+
+func (f *Filter) hash(hashNum uint32, data []byte) uint32 {
+	// 种子的取法和 BIP37 一致
+	return murmurHash3(hashNum*0xfba4c795+f.msg.Tweak, data) % uint32(len(f.msg.Filter)*8)
+}
+
+func (f *Filter) matches(data []byte) bool {
+	if len(f.msg.Filter) == 0 {
+		return false
+	}
+
+	for i := uint32(0); i < f.msg.HashFuncs; i++ {
+		idx := f.hash(i, data)
+		if f.msg.Filter[idx>>3]&(1<<(idx&7)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *Filter) add(data []byte) {
+	if len(f.msg.Filter) == 0 {
+		return
+	}
+
+	for i := uint32(0); i < f.msg.HashFuncs; i++ {
+		idx := f.hash(i, data)
+		f.msg.Filter[idx>>3] |= 1 << (idx & 7)
+	}
+}
+
+// Matches reports whether data may have been added to the filter
+func (f *Filter) Matches(data []byte) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.matches(data)
+}
+
+func (f *Filter) Add(data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.add(data)
+}
+
+// OutPoint serializes an output the way it is added to a filter: the
+// transaction ID followed by the little endian output index
+func OutPoint(txID []byte, vout int) []byte {
+	op := make([]byte, len(txID)+4)
+	copy(op, txID)
+	binary.LittleEndian.PutUint32(op[len(txID):], uint32(vout))
+
+	return op
+}
+
+func (f *Filter) AddOutPoint(txID []byte, vout int) {
+	f.Add(OutPoint(txID, vout))
+}
+
+// MatchTxAndUpdate reports whether tx is relevant to the filter: its ID, the
+// public key hash of an output, an outpoint it spends or the signature or
+// public key of an input was added. With UpdateAll the outpoints of the
+// matched outputs are added to the filter.
+func (f *Filter) MatchTxAndUpdate(tx *transaction.Transaction) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	matched := f.matches(tx.ID)
+
+	for i, out := range tx.Vout {
+		if !f.matches(out.PubKeyHash) {
+			continue
+		}
+		matched = true
+		if f.msg.Flags == UpdateAll {
+			f.add(OutPoint(tx.ID, i))
+		}
+	}
+
+	if matched || tx.IsCoinbase() {
+		return matched
+	}
+
+	for _, in := range tx.Vin {
+		if f.matches(OutPoint(in.TxID, in.Vout)) || f.matches(in.Signature) || f.matches(in.PubKey) {
+			return true
+		}
+	}
+
+	return false
+}
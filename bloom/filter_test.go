@@ -0,0 +1,154 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package bloom_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"myBitCoin/bloom"
+	"myBitCoin/chaintest"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// 测试向量来自 BIP37 的参考实现
+func TestFilterVector(t *testing.T) {
+	f := bloom.NewFilter(3, 0.01, 0, bloom.UpdateAll)
+	f.Add(mustDecode(t, "99108ad8ed9bb6274d3980bab5a85c048f0950c8"))
+	if !f.Matches(mustDecode(t, "99108ad8ed9bb6274d3980bab5a85c048f0950c8")) {
+		t.Fatal("the added element does not match")
+	}
+	if f.Matches(mustDecode(t, "19108ad8ed9bb6274d3980bab5a85c048f0950c8")) {
+		t.Fatal("an element differing in one bit matches")
+	}
+	f.Add(mustDecode(t, "b5a2c786d9ef4658287ced5914b37a1b4aa32eee"))
+	f.Add(mustDecode(t, "b9300670b4c5366e95b2699e8b18bc75e5f729c5"))
+
+	msg := f.MsgFilterLoad()
+	if !bytes.Equal(msg.Filter, mustDecode(t, "614e9b")) || msg.HashFuncs != 5 {
+		t.Fatalf("filter %x with %d hash functions", msg.Filter, msg.HashFuncs)
+	}
+
+	// 加了 tweak 之后位置不同
+	f = bloom.NewFilter(3, 0.01, 2147483649, bloom.UpdateAll)
+	f.Add(mustDecode(t, "99108ad8ed9bb6274d3980bab5a85c048f0950c8"))
+	f.Add(mustDecode(t, "b5a2c786d9ef4658287ced5914b37a1b4aa32eee"))
+	f.Add(mustDecode(t, "b9300670b4c5366e95b2699e8b18bc75e5f729c5"))
+	if msg := f.MsgFilterLoad(); !bytes.Equal(msg.Filter, mustDecode(t, "ce4299")) {
+		t.Fatalf("tweaked filter %x", msg.Filter)
+	}
+}
+
+func TestLoadFilter(t *testing.T) {
+	f := bloom.NewFilter(10, 0.001, 7, bloom.UpdateNone)
+	f.Add([]byte("data"))
+
+	loaded, err := bloom.LoadFilter(f.MsgFilterLoad())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Matches([]byte("data")) {
+		t.Fatal("the loaded filter does not match")
+	}
+
+	if _, err := bloom.LoadFilter(&bloom.FilterLoad{Filter: make([]byte, bloom.MaxFilterSize+1)}); err != bloom.ErrFilterTooLarge {
+		t.Fatalf("large filter: %v", err)
+	}
+	if _, err := bloom.LoadFilter(&bloom.FilterLoad{Filter: []byte{0}, HashFuncs: bloom.MaxHashFuncs + 1}); err != bloom.ErrTooManyHashFuncs {
+		t.Fatalf("too many hash functions: %v", err)
+	}
+	// 空的过滤器什么都不匹配
+	if empty, _ := bloom.LoadFilter(&bloom.FilterLoad{}); empty.Matches([]byte("data")) {
+		t.Fatal("the empty filter matches")
+	}
+}
+
+func TestMatchTxAndUpdate(t *testing.T) {
+	for _, flags := range []bloom.UpdateType{bloom.UpdateNone, bloom.UpdateAll} {
+		bc, w := chaintest.NewChain(t)
+		to := chaintest.NewWallet(t)
+		pay := chaintest.NewTx(t, bc, w, string(to.GetAddress()), 3)
+		if _, err := bc.MineBlock([]*transaction.Transaction{pay}); err != nil {
+			t.Fatal(err)
+		}
+		// 全部花掉，没有找零
+		spend := chaintest.NewTx(t, bc, to, string(w.GetAddress()), 3)
+
+		f := bloom.NewFilter(10, 0.0001, 0, flags)
+		f.Add(wallet.HashPubKey(to.PublicKey))
+		if !f.MatchTxAndUpdate(pay) {
+			t.Fatal("the payment does not match")
+		}
+		// 只有 UpdateAll 把收到的输出加入过滤器，花费它的交易才匹配
+		if got := f.MatchTxAndUpdate(spend); got != (flags == bloom.UpdateAll) {
+			t.Fatalf("flags %d: spending transaction matches = %v", flags, got)
+		}
+		if got := f.Matches(bloom.OutPoint(pay.ID, 0)); got != (flags == bloom.UpdateAll) {
+			t.Fatalf("flags %d: outpoint in the filter = %v", flags, got)
+		}
+	}
+}
+
+func TestMerkleBlock(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	to := chaintest.NewWallet(t)
+	pay := chaintest.NewTx(t, bc, w, string(to.GetAddress()), 3)
+	cb, err := transaction.NewCoinbaseTx(string(w.GetAddress()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := bc.MineBlock([]*transaction.Transaction{cb, pay})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := bloom.NewFilter(10, 0.0001, 0, bloom.UpdateNone)
+	f.Add(wallet.HashPubKey(to.PublicKey))
+	txs, err := bloom.NewMerkleBlock(b, f).Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || !bytes.Equal(txs[0].ID, pay.ID) {
+		t.Fatalf("matched %d transactions", len(txs))
+	}
+
+	mb := bloom.NewMerkleBlock(b, f)
+	mb.Header.MerkleRoot = mb.Header.Hash
+	if _, err := mb.Verify(); err == nil {
+		t.Fatal("a merkle block with a wrong root verifies")
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package bloom
+
+import (
+	"myBitCoin/block"
+	"myBitCoin/merkle"
+	"myBitCoin/transaction"
+)
+
+// MerkleBlock is the header of a block with a partial merkle tree of the
+// transactions matching a filter, and the matched transactions themselves
+type MerkleBlock struct {
+	Header       *block.BlockHeader
+	Tree         *merkle.PartialMerkleTree
+	Transactions []*transaction.Transaction
+}
+
+// NewMerkleBlock filters the transactions of b, updating the filter as it
+// goes, and builds the merkle block of the matches
+func NewMerkleBlock(b *block.Block, f *Filter) *MerkleBlock {
+	var txIDs [][]byte
+	for _, tx := range b.Transactions {
+		if f.MatchTxAndUpdate(tx) {
+			txIDs = append(txIDs, tx.ID)
+		}
+	}
+
+	// 交易都在块里，不会出错
+	proof, _ := b.TxOutProof(txIDs)
+
+	return &MerkleBlock{
+		Header:       b.Header(),
+		Tree:         proof.Tree,
+		Transactions: proof.Transactions,
+	}
+}
+
+// Verify checks the partial merkle tree against the merkle root of the header
+// and returns the matched transactions in block order
+func (m *MerkleBlock) Verify() ([]*transaction.Transaction, error) {
+	proof := &block.TxOutProof{
+		BlockHash:    m.Header.Hash,
+		Tree:         m.Tree,
+		Transactions: m.Transactions,
+	}
+
+	return proof.Verify(m.Header.MerkleRoot)
+}
@@ -0,0 +1,86 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cfilter
+
+import (
+	"crypto/sha256"
+
+	blk "myBitCoin/block"
+)
+
+// Key derives the filter key of a block from its hash
+func Key(blockHash []byte) [KeySize]byte {
+	var key [KeySize]byte
+	copy(key[:], blockHash)
+
+	return key
+}
+
+// OutPointItem is the filter item of a spent output
+func OutPointItem(txID []byte, vout int) []byte {
+	item := make([]byte, 0, len(txID)+4)
+	item = append(item, txID...)
+
+	return append(item, byte(vout>>24), byte(vout>>16), byte(vout>>8), byte(vout))
+}
+
+// BlockFilter builds the filter of a block over the public key hashes of its
+// outputs and the outpoints spent by its inputs
+func BlockFilter(b *blk.Block) *Filter {
+	var items [][]byte
+
+	for _, tx := range b.Transactions {
+		for _, out := range tx.Vout {
+			if len(out.PubKeyHash) > 0 {
+				items = append(items, out.PubKeyHash)
+			}
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Vin {
+			items = append(items, OutPointItem(in.TxID, in.Vout))
+		}
+	}
+
+	return BuildGCS(Key(b.Hash), items)
+}
+
+// FilterHeader chains the filter hashes like block headers chain blocks.
+// The previous header of the genesis block is all zero.
+func FilterHeader(filterHash, prevHeader []byte) []byte {
+	if prevHeader == nil {
+		prevHeader = make([]byte, sha256.Size)
+	}
+
+	data := make([]byte, 0, len(filterHash)+len(prevHeader))
+	data = append(data, filterHash...)
+	data = append(data, prevHeader...)
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+
+	return second[:]
+}
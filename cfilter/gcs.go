@@ -0,0 +1,291 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cfilter
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+// Golomb-Rice 参数，和 BIP158 的 basic filter 相同
+const (
+	DefaultP = 19
+	DefaultM = 784931
+
+	KeySize = 16
+)
+
+var ErrMalformedFilter = errors.New("cfilter: malformed filter")
+
+// Filter is a Golomb-coded set: the items are hashed into [0, N*M), sorted,
+// and the differences between them are written with Golomb-Rice coding.
+type Filter struct {
+	n    uint32
+	p    uint8
+	m    uint64
+	data []byte
+}
+
+// BuildGCS builds a filter over items, keyed so that the hashes differ per block
+func BuildGCS(key [KeySize]byte, items [][]byte) *Filter {
+	items = dedup(items)
+	f := &Filter{n: uint32(len(items)), p: DefaultP, m: DefaultM}
+
+	values := hashItems(key, items, uint64(f.n)*f.m)
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	w := &bitWriter{}
+	var last uint64
+	for _, v := range values {
+		delta := v - last
+		last = v
+
+		// 商用一元码，余数用 p 位
+		for q := delta >> f.p; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, uint(f.p))
+	}
+	f.data = w.bytes
+
+	return f
+}
+
+func dedup(items [][]byte) [][]byte {
+	seen := make(map[string]bool)
+	var result [][]byte
+	for _, item := range items {
+		if !seen[string(item)] {
+			seen[string(item)] = true
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+func hashItems(key [KeySize]byte, items [][]byte, modulus uint64) []uint64 {
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+
+	values := make([]uint64, 0, len(items))
+	for _, item := range items {
+		// 把 64 位哈希映射到 [0, modulus)，比取模快而且均匀
+		hi, _ := bits.Mul64(sipHash(k0, k1, item), modulus)
+		values = append(values, hi)
+	}
+
+	return values
+}
+
+// N returns the number of items in the filter
+func (f *Filter) N() uint32 {
+	return f.n
+}
+
+// Bytes serializes the filter: the item count as a uvarint then the bit stream
+func (f *Filter) Bytes() []byte {
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(f.data))
+	n := binary.PutUvarint(buf, uint64(f.n))
+
+	return append(buf[:n], f.data...)
+}
+
+// FromBytes parses a filter serialized by Bytes
+func FromBytes(data []byte) (*Filter, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > 1<<32-1 {
+		return nil, ErrMalformedFilter
+	}
+
+	return &Filter{n: uint32(n), p: DefaultP, m: DefaultM, data: data[size:]}, nil
+}
+
+// Hash is the double sha256 of the serialized filter
+func (f *Filter) Hash() []byte {
+	first := sha256.Sum256(f.Bytes())
+	second := sha256.Sum256(first[:])
+
+	return second[:]
+}
+
+// Match tells whether item may be in the filter. False positives happen
+// with a probability of 1/M.
+func (f *Filter) Match(key [KeySize]byte, item []byte) bool {
+	return f.MatchAny(key, [][]byte{item})
+}
+
+// MatchAny tells whether any of items may be in the filter
+func (f *Filter) MatchAny(key [KeySize]byte, items [][]byte) bool {
+	if f.n == 0 || len(items) == 0 {
+		return false
+	}
+
+	query := hashItems(key, items, uint64(f.n)*f.m)
+	sort.Slice(query, func(i, j int) bool { return query[i] < query[j] })
+
+	r := &bitReader{data: f.data}
+	var value uint64
+	for i := uint32(0); i < f.n; i++ {
+		delta, err := r.readGolomb(f.p)
+		if err != nil {
+			return false
+		}
+		value += delta
+
+		for len(query) > 0 && query[0] < value {
+			query = query[1:]
+		}
+		if len(query) == 0 {
+			return false
+		}
+		if query[0] == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+type bitWriter struct {
+	bytes []byte
+	bits  uint
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.bits%8 == 0 {
+		w.bytes = append(w.bytes, 0)
+	}
+	if bit {
+		w.bytes[len(w.bytes)-1] |= 0x80 >> (w.bits % 8)
+	}
+	w.bits++
+}
+
+// writeBits writes the low n bits of value, most significant first
+func (w *bitWriter) writeBits(value uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.writeBit(value&(1<<(i-1)) != 0)
+	}
+}
+
+type bitReader struct {
+	data []byte
+	pos  uint
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= uint(len(r.data))*8 {
+		return false, ErrMalformedFilter
+	}
+	bit := r.data[r.pos/8]&(0x80>>(r.pos%8)) != 0
+	r.pos++
+
+	return bit, nil
+}
+
+func (r *bitReader) readGolomb(p uint8) (uint64, error) {
+	var q uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		q++
+	}
+
+	var rem uint64
+	for i := uint8(0); i < p; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		rem <<= 1
+		if bit {
+			rem |= 1
+		}
+	}
+
+	return q<<p | rem, nil
+}
+
+// sipHash is SipHash-2-4
+func sipHash(k0, k1 uint64, p []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	length := len(p)
+	for len(p) >= 8 {
+		m := binary.LittleEndian.Uint64(p)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+		p = p[8:]
+	}
+
+	var tail [8]byte
+	copy(tail[:], p)
+	tail[7] = byte(length)
+	m := binary.LittleEndian.Uint64(tail[:])
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
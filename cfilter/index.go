@@ -0,0 +1,195 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cfilter
+
+import (
+	"errors"
+
+	blk "myBitCoin/block"
+	"myBitCoin/storage"
+)
+
+const (
+	filtersBucket = "cfilters"
+	headersBucket = "cfheaders"
+
+	// 每次请求最多返回的过滤器和过滤器头的数量
+	MaxFiltersPerMsg = 1000
+	MaxHeadersPerMsg = 2000
+)
+
+var (
+	ErrNotIndexed = errors.New("cfilter: block is not indexed")
+	ErrBadRange   = errors.New("cfilter: bad height range")
+)
+
+// Index keeps the filter and the filter header of every block of the chain
+// in the chain database
+type Index struct {
+	bc *blk.BlockChain
+}
+
+// NewIndex opens the index and builds the filters missing for the chain
+func NewIndex(bc *blk.BlockChain) (*Index, error) {
+	idx := &Index{bc}
+
+	err := bc.DB.Update(func(tx storage.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(filtersBucket)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 从链尾往回找到最后一个建好索引的块，再往前补
+	var missing []*blk.Block
+	bci := bc.Iterator()
+	for {
+		b, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := idx.FilterHeader(b.Hash); err == nil {
+			break
+		}
+		missing = append(missing, b)
+
+		if len(b.PrevHash) == 0 {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		err := bc.DB.Update(func(tx storage.Tx) error {
+			return connect(tx, missing[i])
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return idx, nil
+}
+
+func init() {
+	blk.RegisterConnectHandler(connectBlock)
+	blk.RegisterDisconnectHandler(disconnectBlock)
+}
+
+// connectBlock indexes the block in the transaction connecting it. Chains
+// without the index and blocks after a gap are left to NewIndex.
+func connectBlock(bc *blk.BlockChain, tx storage.Tx, b *blk.Block) error {
+	if tx.Bucket([]byte(headersBucket)) == nil {
+		return nil
+	}
+	err := connect(tx, b)
+	if err == ErrNotIndexed {
+		return nil
+	}
+
+	return err
+}
+
+// disconnectBlock removes the filter of the block in the transaction
+// disconnecting it
+func disconnectBlock(bc *blk.BlockChain, tx storage.Tx, b *blk.Block) error {
+	if tx.Bucket([]byte(headersBucket)) == nil {
+		return nil
+	}
+	if err := tx.Bucket([]byte(filtersBucket)).Delete(b.Hash); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(headersBucket)).Delete(b.Hash)
+}
+
+func connect(tx storage.Tx, b *blk.Block) error {
+	var prevHeader []byte
+	if len(b.PrevHash) > 0 {
+		prevHeader = tx.Bucket([]byte(headersBucket)).Get(b.PrevHash)
+		if prevHeader == nil {
+			return ErrNotIndexed
+		}
+	}
+
+	filter := BlockFilter(b)
+	header := FilterHeader(filter.Hash(), prevHeader)
+	if err := tx.Bucket([]byte(filtersBucket)).Put(b.Hash, filter.Bytes()); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(headersBucket)).Put(b.Hash, header)
+}
+
+func (idx *Index) get(bucket string, hash []byte) ([]byte, error) {
+	var data []byte
+
+	err := idx.bc.DB.View(func(tx storage.Tx) error {
+		v := tx.Bucket([]byte(bucket)).Get(hash)
+		if v == nil {
+			return ErrNotIndexed
+		}
+		data = append([]byte{}, v...)
+		return nil
+	})
+
+	return data, err
+}
+
+// Filter returns the serialized filter of a block
+func (idx *Index) Filter(blockHash []byte) ([]byte, error) {
+	return idx.get(filtersBucket, blockHash)
+}
+
+// FilterHeader returns the filter header of a block
+func (idx *Index) FilterHeader(blockHash []byte) ([]byte, error) {
+	return idx.get(headersBucket, blockHash)
+}
+
+// BlockRange returns the hashes of the blocks from startHeight to the block
+// stopHash, in height order
+func (idx *Index) BlockRange(startHeight int, stopHash []byte, max int) ([][]byte, error) {
+	// 被裁剪的块也有过滤器，只需要区块头
+	stop, err := idx.bc.GetHeader(stopHash)
+	if err != nil {
+		return nil, err
+	}
+	if startHeight < 0 || startHeight > stop.Height || stop.Height-startHeight >= max {
+		return nil, ErrBadRange
+	}
+
+	hashes := make([][]byte, stop.Height-startHeight+1)
+	for h := stop; ; {
+		hashes[h.Height-startHeight] = h.Hash
+		if h.Height == startHeight {
+			break
+		}
+		if h, err = idx.bc.GetHeader(h.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+
+	return hashes, nil
+}
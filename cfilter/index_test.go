@@ -0,0 +1,196 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cfilter_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"myBitCoin/cfilter"
+	"myBitCoin/chaintest"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+func TestGCS(t *testing.T) {
+	key := cfilter.Key([]byte("block hash of sixteen bytes"))
+	var items [][]byte
+	for i := 0; i < 100; i++ {
+		items = append(items, []byte(fmt.Sprintf("item %d", i)))
+	}
+	// 重复的项只算一次
+	f := cfilter.BuildGCS(key, append(items, items[0], items[1]))
+	if f.N() != 100 {
+		t.Fatalf("N = %d, want 100", f.N())
+	}
+
+	decoded, err := cfilter.FromBytes(f.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Hash(), f.Hash()) {
+		t.Fatal("the decoded filter has another hash")
+	}
+	for _, item := range items {
+		if !decoded.Match(key, item) {
+			t.Fatalf("%s does not match", item)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if decoded.Match(key, []byte(fmt.Sprintf("other %d", i))) {
+			falsePositives++
+		}
+	}
+	// 误判的概率是 1/M
+	if falsePositives > 2 {
+		t.Fatalf("%d false positives in 10000", falsePositives)
+	}
+	if !decoded.MatchAny(key, [][]byte{[]byte("other"), items[50]}) {
+		t.Fatal("MatchAny missed an item")
+	}
+	// 换一个块，同样的项哈希到别处
+	if other := cfilter.Key([]byte("another block hash")); decoded.MatchAny(other, items[:10]) {
+		t.Fatal("items match with the key of another block")
+	}
+
+	if cfilter.BuildGCS(key, nil).Match(key, items[0]) {
+		t.Fatal("the empty filter matches")
+	}
+	if _, err := cfilter.FromBytes(nil); err != cfilter.ErrMalformedFilter {
+		t.Fatalf("empty data: %v", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	to := chaintest.NewWallet(t)
+	tx := chaintest.NewTx(t, bc, w, string(to.GetAddress()), 3)
+	if _, err := bc.MineBlock([]*transaction.Transaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 建立索引之前的块由 NewIndex 补上
+	idx, err := cfilter.NewIndex(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := chaintest.MineBlocks(t, bc, string(w.GetAddress()), 2)
+
+	tip, err := bc.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prevHeader []byte
+	for h := 0; h <= tip; h++ {
+		hash, err := bc.HashAtHeight(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := idx.Filter(hash)
+		if err != nil {
+			t.Fatalf("filter of height %d: %v", h, err)
+		}
+		f, err := cfilter.FromBytes(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := idx.FilterHeader(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(header, cfilter.FilterHeader(f.Hash(), prevHeader)) {
+			t.Fatalf("filter header of height %d does not chain", h)
+		}
+		prevHeader = header
+
+		if h == 1 {
+			key := cfilter.Key(hash)
+			if !f.Match(key, wallet.HashPubKey(to.PublicKey)) {
+				t.Error("the filter does not match the receiver")
+			}
+			in := tx.Vin[0]
+			if !f.Match(key, cfilter.OutPointItem(in.TxID, in.Vout)) {
+				t.Error("the filter does not match the spent output")
+			}
+		}
+	}
+
+	hashes, err := idx.BlockRange(1, bc.Tip(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != tip || !bytes.Equal(hashes[len(hashes)-1], bc.Tip()) {
+		t.Fatalf("range of %d blocks", len(hashes))
+	}
+	for _, bad := range [][2]int{{-1, 10}, {tip + 1, 10}, {0, tip}} {
+		if _, err := idx.BlockRange(bad[0], bc.Tip(), bad[1]); err != cfilter.ErrBadRange {
+			t.Errorf("range from %d, max %d: %v", bad[0], bad[1], err)
+		}
+	}
+
+	// 断开的块的过滤器被删除
+	if _, err := bc.DisconnectBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Filter(blocks[1].Hash); err != cfilter.ErrNotIndexed {
+		t.Fatalf("filter of a disconnected block: %v", err)
+	}
+	if _, err := idx.Filter(blocks[0].Hash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 被替换的块的过滤器属于新的块
+func TestIndexAfterReorg(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	idx, err := cfilter.NewIndex(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := chaintest.MineBlocks(t, bc, string(w.GetAddress()), 1)[0]
+	if _, err := bc.DisconnectBlock(); err != nil {
+		t.Fatal(err)
+	}
+	other := chaintest.NewWallet(t)
+	b := chaintest.MineBlocks(t, bc, string(other.GetAddress()), 1)[0]
+
+	data, err := idx.Filter(b.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := cfilter.FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Match(cfilter.Key(b.Hash), wallet.HashPubKey(other.PublicKey)) {
+		t.Fatal("the filter of the new block does not match its coinbase")
+	}
+	if _, err := idx.Filter(old.Hash); err != cfilter.ErrNotIndexed {
+		t.Fatalf("filter of the replaced block: %v", err)
+	}
+}
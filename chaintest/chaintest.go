@@ -0,0 +1,150 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package chaintest builds regtest chains in memory for the tests of the
+// other packages
+package chaintest
+
+import (
+	"fmt"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/config"
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+	"myBitCoin/wallet"
+)
+
+// Setup switches the process to the regression test network with the chains
+// in memory. The network parameters, the checkpoints and the storage engine
+// are restored when t ends, so that tests don't depend on their order.
+func Setup(t testing.TB) {
+	t.Helper()
+
+	magic, bits := blk.NetworkMagic, blk.TargetBits()
+	checkpoints, engine := blk.Checkpoints, blk.DBEngine
+	t.Cleanup(func() {
+		blk.NetworkMagic = magic
+		blk.SetTargetBits(bits)
+		blk.Checkpoints = checkpoints
+		blk.DBEngine = engine
+	})
+
+	config.RegTest.Apply()
+	blk.DBEngine = storage.Memory
+}
+
+// NewWallet returns a new wallet
+func NewWallet(t testing.TB) *wallet.Wallet {
+	t.Helper()
+
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// NewChain calls Setup and creates a chain in a temporary directory, the
+// genesis reward is paid to the returned wallet. The UTXO set is indexed, it
+// is written back and the chain closed when t ends.
+func NewChain(t testing.TB) (*blk.BlockChain, *wallet.Wallet) {
+	t.Helper()
+
+	Setup(t)
+	w := NewWallet(t)
+	bc, err := blk.CreateBlockChain(string(w.GetAddress()), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxoSet := utxo.UTXOSet{bc}
+	if err := utxoSet.Reindex(); err != nil {
+		bc.DB.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := utxoSet.Close(); err != nil {
+			t.Error(err)
+		}
+		bc.DB.Close()
+	})
+
+	return bc, w
+}
+
+// MineBlocks mines n blocks paying the subsidy to address
+func MineBlocks(t testing.TB, bc *blk.BlockChain, address string, n int) []*blk.Block {
+	t.Helper()
+
+	var blocks []*blk.Block
+	for i := 0; i < n; i++ {
+		height, err := bc.GetBestHeight()
+		if err != nil {
+			t.Fatal(err)
+		}
+		coinbase, err := transaction.NewCoinbaseTx(address, fmt.Sprintf("block %d", height+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := bc.MineBlock([]*transaction.Transaction{coinbase})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, b)
+	}
+
+	return blocks
+}
+
+// NewTx returns a transaction of w paying amount to the address to, it is
+// signed but not added to the chain
+func NewTx(t testing.TB, bc *blk.BlockChain, w *wallet.Wallet, to string, amount int) *transaction.Transaction {
+	t.Helper()
+
+	tx, err := bc.NewUTXOTransaction(w, to, amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Sign(t, bc, tx, w)
+
+	return tx
+}
+
+// Sign sets the ID of tx, which may have been changed after NewTx, and signs
+// its inputs with the key of w
+func Sign(t testing.TB, bc *blk.BlockChain, tx *transaction.Transaction, w *wallet.Wallet) {
+	t.Helper()
+
+	for i := range tx.Vin {
+		tx.Vin[i].Signature = nil
+	}
+	if err := tx.SetID(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SignTransactions(tx, w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	blk "myBitCoin/block"
+	"myBitCoin/utxo"
+)
+
+func (cli *Client) exportChain(path, nodeID string) {
+	// 文件由 mybitcoind 写，相对路径要先转换
+	path, err := filepath.Abs(path)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var exported int
+	if node, dialErr := cli.dial(nodeID); dialErr == nil {
+		exported, err = node.ExportChain(path)
+		node.Close()
+	} else {
+		bc := openChain(nodeID)
+		exported, err = exportChainTo(bc, path)
+		bc.DB.Close()
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Exported %d blocks to %s\n", exported, path)
+}
+
+func exportChainTo(bc *blk.BlockChain, path string) (int, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n, err := bc.ExportChain(f)
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+
+	return n, f.Sync()
+}
+
+// importChain 每个块单独提交，中断后再次导入会跳过已有的块继续
+func (cli *Client) importChain(path, nodeID string) {
+	if node, err := cli.dial(nodeID); err == nil {
+		node.Close()
+		log.Panic("ERROR: mybitcoind is running, stop it before importing blocks")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+	r := blk.NewBootstrapReader(f)
+
+	var bc *blk.BlockChain
+	// found: 文件中已经读到了链尾的块
+	found := false
+	if blk.ChainExists(nodeID) {
+		bc = openChain(nodeID)
+	} else {
+		genesis, err := r.Next()
+		if err != nil {
+			log.Panic(err)
+		}
+		if bc, err = blk.CreateBlockChainWithGenesis(genesis, nodeID); err != nil {
+			log.Panic(err)
+		}
+		utxoSet := utxo.UTXOSet{bc}
+		if err := utxoSet.Reindex(); err != nil {
+			log.Panic(err)
+		}
+		found = true
+	}
+	defer bc.DB.Close()
+	utxoSet := utxo.UTXOSet{bc}
+	if err := utxoSet.Open(); err != nil {
+		log.Panic(err)
+	}
+	defer closeUTXOSet(utxoSet)
+
+	// 高度不超过链尾的块已经导入过，链尾的块必须和文件中的相同
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		log.Panic(err)
+	}
+	imported := 0
+	last := time.Now()
+	for {
+		b, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panicf("ERROR: reading the block after height %d: %v", height, err)
+		}
+
+		if b.Height < height {
+			continue
+		}
+		if b.Height == height {
+			if !bytes.Equal(b.Hash, bc.Tip()) {
+				log.Panicf("ERROR: block %x at height %d is not in the chain", b.Hash, b.Height)
+			}
+			found = true
+			if height > 0 {
+				fmt.Printf("Resuming after height %d\n", height)
+			}
+			continue
+		}
+
+		if err := bc.AcceptBlock(b); err != nil {
+			log.Panicf("ERROR: block %x at height %d: %v", b.Hash, b.Height, err)
+		}
+		height = b.Height
+		imported++
+
+		if time.Since(last) >= time.Second {
+			fmt.Printf("Imported %d blocks, height %d\n", imported, height)
+			last = time.Now()
+		}
+	}
+
+	if !found {
+		log.Panicf("ERROR: the chain at height %d is ahead of the file", height)
+	}
+	fmt.Printf("Imported %d blocks, height %d, tip %x\n", imported, height, bc.Tip())
+}
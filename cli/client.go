@@ -30,33 +30,93 @@
 package cli
 
 import (
+	"encoding/hex"
 	"flag"
 	"os"
 	"fmt"
 	"log"
 	"strconv"
+	"time"
 	blk "myBitCoin/block"
 	"myBitCoin/transaction"
 	"myBitCoin/wallet"
 	"myBitCoin/utxo"
+	"myBitCoin/storage"
+	"myBitCoin/config"
+	"myBitCoin/daemon"
+	"myBitCoin/explorer"
+	"myBitCoin/spv"
+	"net/http"
 )
 
 const usage = `
-Usage:
-  addblock -data BLOCK_DATA    add a block to the blockchain
-  printchain                   print all the blocks of the blockchain
+Usage: cli [-datadir DIR] [-conf FILE] [-network NETWORK] [-rpcuser USER -rpcpassword PASSWORD] COMMAND
+  DIR holds mybitcoin.conf and the chain and the wallets of the main network, ~/.mybitcoin by default,
+  or $GOPATH while it has the chain of an old node and ~/.mybitcoin doesn't exist,
+  testnet and regtest use a subdirectory, regtest mines at a very low difficulty.
+  The options, and -dbengine, -connect, -assumevalid and -checkpoint of the commands, can also be
+  set by MYBITCOIN_DATADIR and so on, or in mybitcoin.conf, a flag beats its environment variable,
+  which beats the file.
+
+Commands:
+  createblockchain -address ADDRESS [-dbengine ENGINE]    create a blockchain and send genesis block reward to ADDRESS
+                                       ENGINE is bolt (default) or leveldb
+  createwallet                         generate a new key-pair and save it into the wallet file
+  getbalance -address ADDRESS          get balance of ADDRESS
+  send -from FROM -to TO -amount AMOUNT    send AMOUNT of coins from FROM address to TO
+  printchain                           print all the blocks of the blockchain
+  explorer -listen ADDR                serve the block explorer on ADDR, e.g. :8080
+  gettxoutproof -txid TXID[,TXID] [-blockhash HASH]    print a proof that the transactions are in a block
+  verifytxoutproof -proof PROOF        print the transactions proven by PROOF
+  spv -connect ADDR [-cfilters|-bloom]    sync headers and proofs from a full node, print wallet balances
+                                       -cfilters scans with compact block filters instead of proofs
+                                       -bloom loads a bloom filter into the node and scans merkle blocks
+  sync -connect ADDR[,ADDR] [-assumevalid HASH] [-checkpoint HEIGHT:HASH[,HEIGHT:HASH]]    download the headers and then the blocks of the best chain of the peers
+                                       the signatures of the ancestors of HASH are not verified
+                                       forks below the checkpoints are refused
+  getblocktemplate -address ADDRESS    print a block paying ADDRESS for an external miner to solve
+  submitblock -merkleroot ROOT -timestamp TIME -nonce NONCE    submit a solved block template
+  poolmine -pool ADDR -worker NAME -password PASSWORD [-threads N]    mine for a Stratum pool, see mybitcoind -stratumworkers
+  dumptxoutset -file FILE              write a snapshot of the UTXO set at the tip to FILE
+  loadtxoutset -file FILE -connect ADDR[,ADDR] [-assumeutxo BASEHASH:CONTENTHASH]    create the chain from a snapshot and the headers of the peers
+                                       the snapshot must be known to the network or given with -assumeutxo
+  gettxoutsetinfo                      print the statistics and the MuHash3072 commitment of the UTXO set
+  gettxout -txid TXID -vout N          print an unspent output of the UTXO set
+  invalidateblock -hash HASH           disconnect the block HASH and the blocks after it, and refuse it
+  reconsiderblock -hash HASH           accept the block HASH again and connect it if it extends the tip
+  verifychain [-level 0..4] [-depth N]    check the N blocks below the tip, all of them when N is 0
+                                       0 proof of work, 1 merkle roots, 2 transactions, 3 undo data,
+                                       4 rebuild the UTXO set and compare it with the chainstate
+  exportchain -out FILE                write the blocks from the genesis block to the tip to FILE
+  importchain -in FILE [-dbengine ENGINE]    validate and connect the blocks of FILE written by exportchain,
+                                       creates the chain when there is none, run it again to resume
+
+getbalance, send, printchain, createwallet, gettxoutsetinfo, gettxout, invalidateblock, reconsiderblock, verifychain and exportchain are served by mybitcoind when it is running,
+getblocktemplate and submitblock need it, importchain needs it stopped.
 `
 
 type Client struct {
 	Bc *blk.BlockChain
+	// 连接 mybitcoind 的用户名和密码，来自 -rpcuser 和 -rpcpassword
+	cred daemon.Credentials
+}
+
+// dial 连接运行中的 mybitcoind，用户名或密码错误时退出，不会去打开被占用的数据库
+func (cli *Client) dial(nodeID string) (*daemon.Client, error) {
+	node, err := daemon.Dial(nodeID, cli.cred)
+	if err == daemon.ErrAuthFailed {
+		log.Panic("ERROR: mybitcoind refused the rpcuser and rpcpassword")
+	}
+
+	return node, err
 }
 
 func (cli *Client) printUsage() {
-	fmt.Println(usage)
+	fmt.Print(usage)
 }
 
-func (cli *Client) validateArgs() {
-	if len(os.Args) < 2 {
+func (cli *Client) validateArgs(args []string) {
+	if len(args) < 1 {
 		cli.printUsage()
 		os.Exit(1)
 	}
@@ -100,50 +160,194 @@ func (cli *Client) Run() {
 }*/
 
 func (cli *Client) Run() {
-	cli.validateArgs()
-
-	//nodeID := os.Getenv("NODE_ID")
-	nodeID := os.Getenv("GOPATH")
-	if nodeID == "" {
-		fmt.Printf("NODE_ID env. var is not set!")
-		os.Exit(1)
+	// 全局选项在命令之前，没有在命令行给出的从环境变量和配置文件中读
+	globalFlags := flag.NewFlagSet("cli", flag.ExitOnError)
+	globalFlags.Usage = cli.printUsage
+	config.AddFlags(globalFlags)
+	rpcUser := globalFlags.String("rpcuser", "", "User of the rpc of mybitcoind")
+	rpcPassword := globalFlags.String("rpcpassword", "", "Password of the rpc of mybitcoind")
+	globalFlags.Parse(os.Args[1:])
+	cli.validateArgs(globalFlags.Args())
+
+	cfg, err := config.Load(globalFlags)
+	if err != nil {
+		log.Panic(err)
 	}
+	cfg.Network.Apply()
+	cli.cred = daemon.Credentials{User: *rpcUser, Password: *rpcPassword}
+	nodeID := cfg.NodeDir
+	command, args := globalFlags.Arg(0), globalFlags.Args()[1:]
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	explorerCmd := flag.NewFlagSet("explorer", flag.ExitOnError)
+	getTxOutProofCmd := flag.NewFlagSet("gettxoutproof", flag.ExitOnError)
+	verifyTxOutProofCmd := flag.NewFlagSet("verifytxoutproof", flag.ExitOnError)
+	spvCmd := flag.NewFlagSet("spv", flag.ExitOnError)
+	syncCmd := flag.NewFlagSet("sync", flag.ExitOnError)
+	getBlockTemplateCmd := flag.NewFlagSet("getblocktemplate", flag.ExitOnError)
+	submitBlockCmd := flag.NewFlagSet("submitblock", flag.ExitOnError)
+	poolMineCmd := flag.NewFlagSet("poolmine", flag.ExitOnError)
+	dumpTxOutSetCmd := flag.NewFlagSet("dumptxoutset", flag.ExitOnError)
+	loadTxOutSetCmd := flag.NewFlagSet("loadtxoutset", flag.ExitOnError)
+	getTxOutSetInfoCmd := flag.NewFlagSet("gettxoutsetinfo", flag.ExitOnError)
+	getTxOutCmd := flag.NewFlagSet("gettxout", flag.ExitOnError)
+	invalidateBlockCmd := flag.NewFlagSet("invalidateblock", flag.ExitOnError)
+	reconsiderBlockCmd := flag.NewFlagSet("reconsiderblock", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainEngine := createBlockchainCmd.String("dbengine", storage.Bolt, "Storage engine of the chain: bolt or leveldb")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
-
-	switch os.Args[1] {
+	explorerListen := explorerCmd.String("listen", ":8080", "Address the explorer listens on")
+	getTxOutProofTxIDs := getTxOutProofCmd.String("txid", "", "Comma separated IDs of the transactions to prove")
+	getTxOutProofBlock := getTxOutProofCmd.String("blockhash", "", "Hash of the block containing the transactions")
+	verifyTxOutProofData := verifyTxOutProofCmd.String("proof", "", "Hex encoded proof printed by gettxoutproof")
+	spvConnect := spvCmd.String("connect", "", "Peer address of the full node, see mybitcoind -listen")
+	spvFilters := spvCmd.Bool("cfilters", false, "Find the wallet transactions with compact block filters")
+	spvBloom := spvCmd.Bool("bloom", false, "Find the wallet transactions with a bloom filter loaded into the node")
+	syncConnect := syncCmd.String("connect", "", "Comma separated peer addresses of full nodes, see mybitcoind -listen")
+	syncStallTimeout := syncCmd.Duration("stalltimeout", 30*time.Second, "Disconnect a peer not answering a request within this time")
+	syncAssumeValid := syncCmd.String("assumevalid", hex.EncodeToString(blk.AssumeValid), "Don't verify the signatures of the ancestors of this block, 0 verifies all of them")
+	syncCheckpoints := syncCmd.String("checkpoint", "", "Comma separated HEIGHT:HASH blocks of the chain of the network, forks below them are refused")
+	getBlockTemplateAddress := getBlockTemplateCmd.String("address", "", "The address to pay the subsidy and the fees to")
+	submitBlockMerkleRoot := submitBlockCmd.String("merkleroot", "", "Merkle root of the solved block template")
+	submitBlockTimeStamp := submitBlockCmd.Int64("timestamp", 0, "Timestamp of the solved block")
+	submitBlockNonce := submitBlockCmd.Int("nonce", 0, "Nonce of the solved block")
+	poolMinePool := poolMineCmd.String("pool", "", "Stratum address of the pool")
+	poolMineWorker := poolMineCmd.String("worker", "", "Name of the worker")
+	poolMinePassword := poolMineCmd.String("password", "", "Password of the worker")
+	poolMineThreads := poolMineCmd.Int("threads", 0, "Number of mining goroutines, one per cpu when 0")
+	dumpTxOutSetFile := dumpTxOutSetCmd.String("file", "", "Snapshot file to write")
+	loadTxOutSetFile := loadTxOutSetCmd.String("file", "", "Snapshot file written by dumptxoutset")
+	loadTxOutSetConnect := loadTxOutSetCmd.String("connect", "", "Comma separated peer addresses of full nodes, see mybitcoind -listen")
+	loadTxOutSetAssumeUTXO := loadTxOutSetCmd.String("assumeutxo", "", "Also trust the snapshot with this base block and content hash, printed by dumptxoutset")
+	getTxOutTxID := getTxOutCmd.String("txid", "", "ID of the transaction")
+	getTxOutVout := getTxOutCmd.Int("vout", -1, "Index of the output")
+	invalidateBlockHash := invalidateBlockCmd.String("hash", "", "Hash of the block")
+	reconsiderBlockHash := reconsiderBlockCmd.String("hash", "", "Hash of the block")
+	verifyChainLevel := verifyChainCmd.Int("level", blk.VerifyUndo, "How thorough the check is, 0 to 4")
+	verifyChainDepth := verifyChainCmd.Int("depth", 6, "Number of blocks to check, 0 checks all of them")
+	exportChainOut := exportChainCmd.String("out", "", "Bootstrap file to write")
+	importChainIn := importChainCmd.String("in", "", "Bootstrap file written by exportchain")
+	importChainEngine := importChainCmd.String("dbengine", storage.Bolt, "Storage engine of a new chain: bolt or leveldb")
+
+	switch command {
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(args)
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(args)
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(args)
 		if err != nil {
 			log.Panic(err)
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(args)
 		if err != nil {
 			log.Panic(err)
 		}
 	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "explorer":
+		err := explorerCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettxoutproof":
+		err := getTxOutProofCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifytxoutproof":
+		err := verifyTxOutProofCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "spv":
+		err := spvCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sync":
+		err := syncCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblocktemplate":
+		err := getBlockTemplateCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "submitblock":
+		err := submitBlockCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "poolmine":
+		err := poolMineCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumptxoutset":
+		err := dumpTxOutSetCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "loadtxoutset":
+		err := loadTxOutSetCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettxoutsetinfo":
+		err := getTxOutSetInfoCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettxout":
+		err := getTxOutCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "invalidateblock":
+		err := invalidateBlockCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reconsiderblock":
+		err := reconsiderBlockCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportchain":
+		err := exportChainCmd.Parse(args)
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importchain":
+		err := importChainCmd.Parse(args)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -165,6 +369,10 @@ func (cli *Client) Run() {
 			createBlockchainCmd.Usage()
 			os.Exit(1)
 		}
+		if err := cfg.SetFlags(createBlockchainCmd); err != nil {
+			log.Panic(err)
+		}
+		blk.DBEngine = *createBlockchainEngine
 		cli.createBlockchain(*createBlockchainAddress, nodeID)
 	}
 
@@ -184,6 +392,158 @@ func (cli *Client) Run() {
 	if createWalletCmd.Parsed() {
 		cli.createWallet(nodeID)
 	}
+
+	if explorerCmd.Parsed() {
+		cli.explorer(*explorerListen, nodeID)
+	}
+
+	if getTxOutProofCmd.Parsed() {
+		if *getTxOutProofTxIDs == "" {
+			getTxOutProofCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getTxOutProof(*getTxOutProofTxIDs, *getTxOutProofBlock, nodeID)
+	}
+
+	if verifyTxOutProofCmd.Parsed() {
+		if *verifyTxOutProofData == "" {
+			verifyTxOutProofCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyTxOutProof(*verifyTxOutProofData, nodeID)
+	}
+
+	if spvCmd.Parsed() {
+		if *spvConnect == "" || *spvFilters && *spvBloom {
+			spvCmd.Usage()
+			os.Exit(1)
+		}
+		mode := spv.ScanProofs
+		if *spvFilters {
+			mode = spv.ScanFilters
+		} else if *spvBloom {
+			mode = spv.ScanBloom
+		}
+		cli.spv(*spvConnect, mode, nodeID)
+	}
+
+	if syncCmd.Parsed() {
+		if err := cfg.SetFlags(syncCmd); err != nil {
+			log.Panic(err)
+		}
+		if *syncConnect == "" {
+			syncCmd.Usage()
+			os.Exit(1)
+		}
+		if err := blk.SetAssumeValid(*syncAssumeValid); err != nil {
+			log.Panic(err)
+		}
+		if err := blk.AddCheckpoints(*syncCheckpoints); err != nil {
+			log.Panic(err)
+		}
+		cli.sync(*syncConnect, *syncStallTimeout, nodeID)
+	}
+
+	if getBlockTemplateCmd.Parsed() {
+		if *getBlockTemplateAddress == "" {
+			getBlockTemplateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlockTemplate(*getBlockTemplateAddress, nodeID)
+	}
+
+	if submitBlockCmd.Parsed() {
+		if *submitBlockMerkleRoot == "" || *submitBlockTimeStamp == 0 {
+			submitBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.submitBlock(*submitBlockMerkleRoot, *submitBlockTimeStamp, *submitBlockNonce, nodeID)
+	}
+
+	if poolMineCmd.Parsed() {
+		if *poolMinePool == "" || *poolMineWorker == "" {
+			poolMineCmd.Usage()
+			os.Exit(1)
+		}
+		cli.poolMine(*poolMinePool, *poolMineWorker, *poolMinePassword, *poolMineThreads)
+	}
+
+	if dumpTxOutSetCmd.Parsed() {
+		if *dumpTxOutSetFile == "" {
+			dumpTxOutSetCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpTxOutSet(*dumpTxOutSetFile, nodeID)
+	}
+
+	if loadTxOutSetCmd.Parsed() {
+		if *loadTxOutSetFile == "" || *loadTxOutSetConnect == "" {
+			loadTxOutSetCmd.Usage()
+			os.Exit(1)
+		}
+		if *loadTxOutSetAssumeUTXO != "" {
+			if err := blk.AddAssumeUTXO(*loadTxOutSetAssumeUTXO); err != nil {
+				log.Panic(err)
+			}
+		}
+		cli.loadTxOutSet(*loadTxOutSetFile, *loadTxOutSetConnect, nodeID)
+	}
+
+	if getTxOutSetInfoCmd.Parsed() {
+		cli.getTxOutSetInfo(nodeID)
+	}
+
+	if getTxOutCmd.Parsed() {
+		if *getTxOutTxID == "" || *getTxOutVout < 0 {
+			getTxOutCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getTxOut(*getTxOutTxID, *getTxOutVout, nodeID)
+	}
+
+	if invalidateBlockCmd.Parsed() {
+		if *invalidateBlockHash == "" {
+			invalidateBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.invalidateBlock(*invalidateBlockHash, nodeID)
+	}
+
+	if reconsiderBlockCmd.Parsed() {
+		if *reconsiderBlockHash == "" {
+			reconsiderBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.reconsiderBlock(*reconsiderBlockHash, nodeID)
+	}
+
+	if verifyChainCmd.Parsed() {
+		if *verifyChainLevel < blk.VerifyHeaders || *verifyChainLevel > blk.VerifyUTXO || *verifyChainDepth < 0 {
+			verifyChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyChain(*verifyChainLevel, *verifyChainDepth, nodeID)
+	}
+
+	if exportChainCmd.Parsed() {
+		if *exportChainOut == "" {
+			exportChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportChain(*exportChainOut, nodeID)
+	}
+
+	if importChainCmd.Parsed() {
+		if *importChainIn == "" {
+			importChainCmd.Usage()
+			os.Exit(1)
+		}
+		if err := cfg.SetFlags(importChainCmd); err != nil {
+			log.Panic(err)
+		}
+		blk.DBEngine = *importChainEngine
+		cli.importChain(*importChainIn, nodeID)
+	}
 }
 
 func (cli *Client) addBlock(data string) {
@@ -192,19 +552,38 @@ func (cli *Client) addBlock(data string) {
 }
 
 func (cli *Client) printChain(nodeID string) {
-	bc := blk.NewBlockChain(nodeID)
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		blocks, err := node.GetBlocks()
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, block := range blocks {
+			printBlock(block)
+		}
+		if len(blocks) == 0 {
+			return
+		}
+		if last := blocks[len(blocks)-1]; len(last.PrevHash) != 0 {
+			fmt.Printf("Blocks below height %d are pruned\n", last.Height)
+		}
+		return
+	}
+
+	bc := openChain(nodeID)
 	defer bc.DB.Close()
 	bci := bc.Iterator()
 
 	for {
-		block := bci.Next()
-
-		fmt.Printf("Prev. hash: %x\n", block.PrevHash)
-		//fmt.Printf("Data: %s\n", block.Data)
-		fmt.Printf("Hash: %x\n", block.Hash)
-		pow := blk.NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
-		fmt.Println()
+		block, err := bci.Next()
+		if err != nil {
+			log.Panic(err)
+		}
+		if block.Pruned() {
+			fmt.Printf("Blocks below height %d are pruned\n", block.Height+1)
+			break
+		}
+		printBlock(block)
 
 		if len(block.PrevHash) == 0 {
 			break
@@ -212,21 +591,41 @@ func (cli *Client) printChain(nodeID string) {
 	}
 }
 
+func printBlock(block *blk.Block) {
+	fmt.Printf("Prev. hash: %x\n", block.PrevHash)
+	//fmt.Printf("Data: %s\n", block.Data)
+	fmt.Printf("Hash: %x\n", block.Hash)
+	pow := blk.NewProofOfWork(block)
+	fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+	fmt.Println()
+}
+
 func (cli *Client) getBalance(address, nodeID string) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("Error: Address is not valid !")
 	}
-	bc := blk.NewBlockChain(nodeID)
+
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		balance, err := node.GetBalance(address)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Balance of '%s': %d\n", address, balance)
+		return
+	}
+
+	bc := openChain(nodeID)
 	defer bc.DB.Close()
 
-	balance := 0
-	pubKeyHash := wallet.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1: len(pubKeyHash)-4]
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(address)
+	if err != nil {
+		log.Panic(err)
+	}
 	utxoSet := utxo.UTXOSet{bc}
-	UTXOs := utxoSet.FindUTXO(pubKeyHash)
-
-	for _, out := range UTXOs {
-		balance += out.Value
+	balance, err := utxoSet.GetBalance(pubKeyHash)
+	if err != nil {
+		log.Panic(err)
 	}
 
 	fmt.Printf("Balance of '%s': %d\n", address, balance)
@@ -240,40 +639,131 @@ func (cli *Client) Send(from, to, nodeID string, amount int) {
 		log.Panic("ERROR: Recipient address is not valid")
 	}
 
-	bc := blk.NewBlockChain(nodeID)
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		if err = node.Send(from, to, amount); err != nil {
+			log.Panic(err)
+		}
+		fmt.Println("Success!")
+		return
+	}
+
+	bc := openChain(nodeID)
 	utxoSet := utxo.UTXOSet{bc}
 	defer bc.DB.Close()
+	if err := utxoSet.Open(); err != nil {
+		log.Panic(err)
+	}
+	defer closeUTXOSet(utxoSet)
 
 	wallets, err := wallet.NewWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
 	wlt := wallets.GetWallet(from)
-	tx := bc.NewUTXOTransaction(wlt, to, amount)
-	utxoSet.BlockChain.SignTransactions(tx, wlt.PrivateKey)
+	if wlt == nil {
+		log.Panicf("ERROR: no wallet for address %s", from)
+	}
+	tx, err := bc.NewUTXOTransaction(wlt, to, amount)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := utxoSet.BlockChain.SignTransactions(tx, wlt.PrivateKey); err != nil {
+		log.Panic(err)
+	}
 
-	newBlock := bc.MineBlock([]*transaction.Transaction{tx})
-	utxoSet.Update(newBlock)
+	if _, err := bc.MineBlock([]*transaction.Transaction{tx}); err != nil {
+		log.Panic(err)
+	}
 	fmt.Println("Success!")
 }
 
 func (cli *Client) createWallet(nodeID string) {
-	wallets, _ := wallet.NewWallets(nodeID)
-	address := wallets.CreateWallet()
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		address, err := node.CreateWallet()
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Your new address: %s\n", address)
+		return
+	}
+
+	// 钱包文件读不出来时不能覆盖它
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	address, err := wallets.CreateWallet()
+	if err != nil {
+		log.Panic(err)
+	}
 	fmt.Println("nodeID: " + nodeID)
-	wallets.SaveToFile(nodeID)
+	if err := wallets.SaveToFile(nodeID); err != nil {
+		log.Panic(err)
+	}
 
 	fmt.Printf("Your new address: %s\n", address)
 }
 
+func (cli *Client) explorer(listen, nodeID string) {
+	if node, err := cli.dial(nodeID); err == nil {
+		node.Close()
+		fmt.Println("mybitcoind is running, start it with -explorer instead.")
+		os.Exit(1)
+	}
+
+	bc := openChain(nodeID)
+	defer bc.DB.Close()
+
+	e, err := explorer.New(bc)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Explorer listening on %s\n", listen)
+	log.Fatal(http.ListenAndServe(listen, e))
+}
+
 func (cli *Client) createBlockchain(address, nodeID string) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
-	bc := blk.CreateBlockChain(address, nodeID)
+	bc, err := blk.CreateBlockChain(address, nodeID)
+	if err == blk.ErrBlockchainExists {
+		fmt.Println("Blockchain already exists.")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
 	defer bc.DB.Close()
 	UTXOSet := utxo.UTXOSet{bc}
-	UTXOSet.Reindex()
+	if err := UTXOSet.Reindex(); err != nil {
+		log.Panic(err)
+	}
 
 	fmt.Println("Done!")
 }
+
+// openChain 打开本地的链，没有链时提示后退出
+func openChain(nodeID string) *blk.BlockChain {
+	bc, err := blk.NewBlockChain(nodeID)
+	if err == blk.ErrNoBlockchain {
+		fmt.Println("No existing blockchain found. Create one first.")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return bc
+}
+
+// closeUTXOSet writes the UTXO cache back before the chain is closed. When it
+// fails the blocks are connected again the next time the chain is opened.
+func closeUTXOSet(utxoSet utxo.UTXOSet) {
+	if err := utxoSet.Close(); err != nil {
+		log.Println(err)
+	}
+}
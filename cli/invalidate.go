@@ -0,0 +1,93 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"myBitCoin/utxo"
+)
+
+func (cli *Client) invalidateBlock(blockHash, nodeID string) {
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		log.Panic("ERROR: Block hash is not valid")
+	}
+
+	var n int
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		n, err = node.InvalidateBlock(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+	} else {
+		bc := openChain(nodeID)
+		defer bc.DB.Close()
+		utxoSet := utxo.UTXOSet{bc}
+		if err := utxoSet.Open(); err != nil {
+			log.Panic(err)
+		}
+		defer closeUTXOSet(utxoSet)
+
+		if n, err = bc.InvalidateBlock(hash); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	fmt.Printf("Disconnected %d blocks\n", n)
+}
+
+func (cli *Client) reconsiderBlock(blockHash, nodeID string) {
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		log.Panic("ERROR: Block hash is not valid")
+	}
+
+	var n int
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		n, err = node.ReconsiderBlock(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+	} else {
+		bc := openChain(nodeID)
+		defer bc.DB.Close()
+		utxoSet := utxo.UTXOSet{bc}
+		if err := utxoSet.Open(); err != nil {
+			log.Panic(err)
+		}
+		defer closeUTXOSet(utxoSet)
+
+		if n, err = bc.ReconsiderBlock(hash); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	fmt.Printf("Connected %d blocks\n", n)
+}
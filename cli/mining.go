@@ -0,0 +1,140 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+
+	blk "myBitCoin/block"
+	"myBitCoin/daemon"
+	"myBitCoin/stratum"
+)
+
+// blockTemplate 是打印给外部矿工的模板，哈希的计算方法见 mining.BlockTemplate
+type blockTemplate struct {
+	Height        int    `json:"height"`
+	PrevHash      string `json:"prevhash"`
+	MerkleRoot    string `json:"merkleroot"`
+	TimeStamp     int64  `json:"timestamp"`
+	Bits          int    `json:"bits"`
+	Target        string `json:"target"`
+	Transactions  int    `json:"transactions"`
+	Fees          int    `json:"fees"`
+	CoinbaseValue int    `json:"coinbasevalue"`
+}
+
+// 模板和提交都需要 mybitcoind 的交易池
+func (cli *Client) dialNode(nodeID string) *daemon.Client {
+	node, err := cli.dial(nodeID)
+	if err != nil {
+		log.Panic("mybitcoind is not running: ", err)
+	}
+
+	return node
+}
+
+func (cli *Client) getBlockTemplate(address, nodeID string) {
+	node := cli.dialNode(nodeID)
+	defer node.Close()
+
+	tmpl, err := node.GetBlockTemplate(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	out, err := json.MarshalIndent(&blockTemplate{
+		Height:        tmpl.Height,
+		PrevHash:      hex.EncodeToString(tmpl.Block.PrevHash),
+		MerkleRoot:    hex.EncodeToString(tmpl.MerkleRoot),
+		TimeStamp:     tmpl.Block.TimeStamp,
+		Bits:          tmpl.Bits,
+		Target:        fmt.Sprintf("%064x", tmpl.Target),
+		Transactions:  len(tmpl.Block.Transactions),
+		Fees:          tmpl.TotalFees,
+		CoinbaseValue: tmpl.CoinbaseValue,
+	}, "", "  ")
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(string(out))
+}
+
+func (cli *Client) submitBlock(merkleRoot string, timeStamp int64, nonce int, nodeID string) {
+	root, err := hex.DecodeString(merkleRoot)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	node := cli.dialNode(nodeID)
+	defer node.Close()
+
+	hash, err := node.SubmitHeader(&blk.BlockHeader{MerkleRoot: root, TimeStamp: timeStamp, Nonce: nonce})
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Accepted block %x\n", hash)
+}
+
+// poolMine 为矿池挖矿，直到 Ctrl-C
+func (cli *Client) poolMine(pool, worker, password string, threads int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	// share 的回调和算力报告在不同的 goroutine 里
+	var accepted, rejected int64
+	miner := &stratum.Miner{
+		Addr:     pool,
+		Worker:   worker,
+		Password: password,
+		Threads:  threads,
+		HashRate: func(hashesPerSec float64) {
+			fmt.Printf("%.0f hashes/s, shares accepted %d, rejected %d\n", hashesPerSec, atomic.LoadInt64(&accepted), atomic.LoadInt64(&rejected))
+		},
+		Share: func(jobID string, ok bool, err error) {
+			if ok {
+				atomic.AddInt64(&accepted, 1)
+				return
+			}
+			atomic.AddInt64(&rejected, 1)
+			fmt.Printf("Share of job %s rejected: %v\n", jobID, err)
+		},
+	}
+
+	if err := miner.Run(ctx); err != nil && err != context.Canceled {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	blk "myBitCoin/block"
+)
+
+func (cli *Client) getTxOutProof(txIDs, blockHash, nodeID string) {
+	var ids [][]byte
+	for _, id := range strings.Split(txIDs, ",") {
+		txID, err := hex.DecodeString(strings.TrimSpace(id))
+		if err != nil {
+			log.Panic("ERROR: Transaction ID is not valid")
+		}
+		ids = append(ids, txID)
+	}
+
+	var hash []byte
+	if blockHash != "" {
+		var err error
+		if hash, err = hex.DecodeString(blockHash); err != nil {
+			log.Panic("ERROR: Block hash is not valid")
+		}
+	}
+
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		proof, err := node.GetTxOutProof(ids, hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Println(hex.EncodeToString(proof))
+		return
+	}
+
+	bc := openChain(nodeID)
+	defer bc.DB.Close()
+
+	proof, err := bc.GetTxOutProof(ids, hash)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(hex.EncodeToString(proof.Serialize()))
+}
+
+func (cli *Client) verifyTxOutProof(data, nodeID string) {
+	raw, err := hex.DecodeString(data)
+	if err != nil {
+		log.Panic("ERROR: Proof is not valid hex")
+	}
+
+	var txIDs [][]byte
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		if txIDs, err = node.VerifyTxOutProof(raw); err != nil {
+			log.Panic(err)
+		}
+	} else {
+		proof, err := blk.DeserializeTxOutProof(raw)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		bc := openChain(nodeID)
+		defer bc.DB.Close()
+
+		txs, err := bc.VerifyTxOutProof(proof)
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, tx := range txs {
+			txIDs = append(txIDs, tx.ID)
+		}
+	}
+
+	for _, id := range txIDs {
+		fmt.Printf("%x\n", id)
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	blk "myBitCoin/block"
+	"myBitCoin/daemon"
+	"myBitCoin/netsync"
+	"myBitCoin/utxo"
+	"myBitCoin/wallet"
+)
+
+func printSnapshot(meta *blk.SnapshotMetadata) {
+	fmt.Printf("Base block: %x\n", meta.BaseHash)
+	fmt.Printf("Base height: %d\n", meta.BaseHeight)
+	fmt.Printf("Transactions: %d\n", meta.TxCount)
+	fmt.Printf("Content hash: %x\n", meta.ContentHash)
+}
+
+func (cli *Client) dumpTxOutSet(path, nodeID string) {
+	// 文件由 mybitcoind 写，相对路径要先转换
+	path, err := filepath.Abs(path)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		meta, err := node.DumpTxOutSet(path)
+		if err != nil {
+			log.Panic(err)
+		}
+		printSnapshot(meta)
+		return
+	}
+
+	bc := openChain(nodeID)
+	defer bc.DB.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	utxoSet := utxo.UTXOSet{bc}
+	meta, err := utxoSet.DumpSnapshot(f)
+	if err != nil {
+		log.Panic(err)
+	}
+	printSnapshot(meta)
+}
+
+func (cli *Client) loadTxOutSet(path, connect, nodeID string) {
+	if blk.ChainExists(nodeID) {
+		log.Panic("Blockchain already exists")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	cfg := netsync.Config{Peers: strings.Split(connect, ",")}
+	meta, err := netsync.LoadSnapshot(nodeID, bufio.NewReader(f), cfg)
+	if err != nil {
+		log.Panic(err)
+	}
+	printSnapshot(meta)
+
+	// 和生成快照的节点的 gettxoutsetinfo 比较
+	bc := openChain(nodeID)
+	utxoSet := utxo.UTXOSet{bc}
+	info, err := utxoSet.GetTxOutSetInfo()
+	bc.DB.Close()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("UTXO set hash: %x\n", info.Commitment)
+	fmt.Println("Start mybitcoind with -connect to sync the new blocks and validate the blocks below the snapshot")
+}
+
+func (cli *Client) getTxOutSetInfo(nodeID string) {
+	var info *utxo.TxOutSetInfo
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		if info, err = node.GetTxOutSetInfo(); err != nil {
+			log.Panic(err)
+		}
+	} else {
+		bc := openChain(nodeID)
+		defer bc.DB.Close()
+		utxoSet := utxo.UTXOSet{bc}
+		if info, err = utxoSet.GetTxOutSetInfo(); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	fmt.Printf("Best block: %x\n", info.BestBlock)
+	fmt.Printf("Height: %d\n", info.Height)
+	fmt.Printf("Transactions: %d\n", info.Transactions)
+	fmt.Printf("Outputs: %d\n", info.TxOuts)
+	fmt.Printf("Total amount: %d\n", info.TotalAmount)
+	fmt.Printf("Serialized size: %d\n", info.SerializedSize)
+	fmt.Printf("UTXO set hash: %x\n", info.Commitment)
+}
+
+func (cli *Client) getTxOut(txID string, vout int, nodeID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic("ERROR: Transaction ID is not valid")
+	}
+
+	var out *daemon.TxOut
+	if node, err := cli.dial(nodeID); err == nil {
+		defer node.Close()
+		if out, err = node.GetTxOut(id, vout); err != nil {
+			log.Panic(err)
+		}
+	} else {
+		bc := openChain(nodeID)
+		defer bc.DB.Close()
+		utxoSet := utxo.UTXOSet{bc}
+		out = &daemon.TxOut{}
+		if out.Coin, err = utxoSet.GetTxOut(id, vout); err != nil {
+			log.Panic(err)
+		}
+		if out.BestHeight, err = bc.GetBestHeight(); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	if out.Coin == nil {
+		fmt.Printf("Output %s:%d is spent or does not exist\n", txID, vout)
+		return
+	}
+	fmt.Printf("Value: %d\n", out.Coin.Value)
+	fmt.Printf("Address: %s\n", wallet.AddressFromPubKeyHash(out.Coin.PubKeyHash))
+	fmt.Printf("Height: %d\n", out.Coin.Height)
+	fmt.Printf("Confirmations: %d\n", out.BestHeight-out.Coin.Height+1)
+	fmt.Printf("Coinbase: %t\n", out.Coin.Coinbase)
+}
@@ -0,0 +1,74 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"myBitCoin/spv"
+	"myBitCoin/wallet"
+)
+
+// spv 以轻客户端方式同步，只保存区块头和钱包相关的交易
+func (cli *Client) spv(connect string, mode spv.ScanMode, nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+
+	client, err := spv.Open(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer client.Close()
+
+	addresses := wallets.GetAddresses()
+	sort.Strings(addresses)
+	var pubKeyHashes [][]byte
+	for _, address := range addresses {
+		pubKeyHashes = append(pubKeyHashes, wallet.HashPubKey(wallets.GetWallet(address).PublicKey))
+	}
+	client.Watch(pubKeyHashes)
+	client.SetScanMode(mode)
+
+	if err = client.Connect(connect); err != nil {
+		log.Panic(err)
+	}
+	if err = client.Sync(); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Synced headers to height %d\n", client.BestHeight())
+	for i, address := range addresses {
+		balance, err := client.GetBalance(pubKeyHashes[i])
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Balance of '%s': %d\n", address, balance)
+	}
+}
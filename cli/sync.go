@@ -0,0 +1,63 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"myBitCoin/netsync"
+)
+
+// sync 先同步区块头，再从所有节点并行下载区块，Ctrl-C 停止同步，已连接的块会保留
+func (cli *Client) sync(connect string, stallTimeout time.Duration, nodeID string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cfg := netsync.Config{
+		Peers:        strings.Split(connect, ","),
+		StallTimeout: stallTimeout,
+		Progress:     printSyncProgress,
+	}
+
+	err := netsync.SyncChain(ctx, nodeID, cfg)
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Sync interrupted")
+		return
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func printSyncProgress(p *netsync.Progress) {
+	fmt.Printf("Headers: %d, blocks: %d, peers: %d\n", p.HeaderHeight, p.BlockHeight, p.Peers)
+}
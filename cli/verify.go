@@ -0,0 +1,55 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"myBitCoin/utxo"
+)
+
+// verifyChain exits with status 1 when the chain is corrupt
+func (cli *Client) verifyChain(level, depth int, nodeID string) {
+	var checked int
+	var err error
+	if node, dialErr := cli.dial(nodeID); dialErr == nil {
+		checked, err = node.VerifyChain(level, depth)
+		node.Close()
+	} else {
+		// 不调用 Open，和链尾不一致的 UTXO 集合要报告出来而不是重建
+		bc := openChain(nodeID)
+		utxoSet := utxo.UTXOSet{bc}
+		checked, err = utxoSet.VerifyChain(level, depth)
+		bc.DB.Close()
+	}
+
+	fmt.Printf("Checked %d blocks at level %d\n", checked, level)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("No errors found")
+}
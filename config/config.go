@@ -0,0 +1,335 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	blk "myBitCoin/block"
+)
+
+const (
+	// FileName is the config file read from the data directory
+	FileName = "mybitcoin.conf"
+	// EnvPrefix prefixes the environment variable of every option, e.g. MYBITCOIN_DATADIR
+	EnvPrefix = "MYBITCOIN_"
+)
+
+// Options are the keys of the config file. Each one is also a flag of
+// mybitcoind; the cli reads the ones it has global flags for, and the ones
+// of its createblockchain, importchain and sync commands.
+var Options = []string{
+	"network", "rpcuser", "rpcpassword",
+	"listen", "connect", "explorer", "wslisten", "wsorigins", "stratum",
+	"stratumworkers", "payto", "sharediff", "threads",
+	"prune", "dbengine", "dbcache", "assumevalid", "checkpoint",
+}
+
+var ErrUnknownNetwork = errors.New("config: unknown network, use main, testnet or regtest")
+
+// Network separates the chains, wallets and bootstrap files of the networks
+type Network struct {
+	Name string
+	// 数据目录下的子目录，main 直接使用数据目录，旧节点放在 $GOPATH 中的链见 DefaultDataDir
+	SubDir string
+	Magic  [4]byte
+	// 挖矿难度，回归测试网络很低，出块几乎不用时间
+	TargetBits int
+	// 内置的检查点，只有主网有
+	Checkpoints []blk.Checkpoint
+}
+
+var (
+	MainNet = Network{Name: "main", Magic: [4]byte{0x6d, 0x79, 0x42, 0x43}, TargetBits: 24, Checkpoints: blk.Checkpoints}
+	TestNet = Network{Name: "testnet", SubDir: "testnet", Magic: [4]byte{0x6d, 0x79, 0x54, 0x4e}, TargetBits: 24}
+	RegTest = Network{Name: "regtest", SubDir: "regtest", Magic: [4]byte{0x6d, 0x79, 0x52, 0x54}, TargetBits: 8}
+)
+
+// LookupNetwork returns the network called name
+func LookupNetwork(name string) (*Network, error) {
+	for _, n := range []*Network{&MainNet, &TestNet, &RegTest} {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+
+	return nil, ErrUnknownNetwork
+}
+
+// Apply sets the parameters of the block package for the network, replacing
+// blk.Checkpoints by the built-in checkpoints of the network. It must run
+// before blk.AddCheckpoints, which adds the checkpoint option to them.
+func (n *Network) Apply() {
+	blk.NetworkMagic = n.Magic
+	blk.SetTargetBits(n.TargetBits)
+	// AddCheckpoints 会改写切片，不能和 n 共用
+	blk.Checkpoints = append([]blk.Checkpoint(nil), n.Checkpoints...)
+}
+
+// Config is the result of Load
+type Config struct {
+	DataDir string
+	Network *Network
+	// NodeDir is the directory of the chain and the wallet file of the
+	// network, the node ID of the other packages
+	NodeDir string
+
+	// 配置文件中这个网络的选项
+	path   string
+	values map[string]string
+}
+
+// DefaultDataDir is ~/.mybitcoin. Nodes before -datadir kept the main chain
+// and the wallets in $GOPATH, $GOPATH stays the default while it has a chain
+// and ~/.mybitcoin doesn't exist; move the blockchain and wallet_.dat files
+// of $GOPATH to ~/.mybitcoin to stop using it.
+func DefaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".mybitcoin"
+	}
+
+	dir := filepath.Join(home, ".mybitcoin")
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if old, ok := legacyDataDir(); ok {
+			return old
+		}
+	}
+	return dir
+}
+
+// legacyDataDir 返回旧节点存放链的 $GOPATH
+func legacyDataDir() (string, bool) {
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" || !blk.ChainExists(gopath) {
+		return "", false
+	}
+
+	return gopath, true
+}
+
+// AddFlags adds the -datadir, -conf and -network flags to fs
+func AddFlags(fs *flag.FlagSet) {
+	fs.String("datadir", DefaultDataDir(), "Directory of the chains and the wallets, and of "+FileName)
+	fs.String("conf", "", "Config file, "+FileName+" in the data directory by default")
+	fs.String("network", MainNet.Name, "Network of the node: main, testnet or regtest")
+}
+
+// Load sets the flags of fs which are not on the command line from the
+// environment, and then from the config file: a flag beats its
+// environment variable, which beats the file, which beats the default.
+// fs must have the flags of AddFlags and be parsed. The directory of the
+// network is created.
+func Load(fs *flag.FlagSet) (*Config, error) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	// 配置文件在数据目录中，这两个选项只能来自命令行或环境变量
+	for _, name := range []string{"datadir", "conf"} {
+		if err := setFromEnv(fs, name, set); err != nil {
+			return nil, err
+		}
+	}
+
+	dataDir := fs.Lookup("datadir").Value.String()
+	if old, ok := legacyDataDir(); ok && !set["datadir"] && dataDir == old {
+		log.Printf("using the data directory %s of the old node in $GOPATH, move its blockchain and wallet_.dat files to ~/.mybitcoin or set -datadir", old)
+	}
+	path := fs.Lookup("conf").Value.String()
+	if path == "" {
+		path = filepath.Join(dataDir, FileName)
+	}
+	file, err := ReadFile(path)
+	if os.IsNotExist(err) && !set["conf"] {
+		file = &File{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	// 先确定网络，再按网络读配置文件中对应的段
+	if err := setFromEnv(fs, "network", set); err != nil {
+		return nil, err
+	}
+	if v, ok := file.Global["network"]; ok && !set["network"] {
+		if err := fs.Set("network", v); err != nil {
+			return nil, err
+		}
+		set["network"] = true
+	}
+	network, err := LookupNetwork(fs.Lookup("network").Value.String())
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		DataDir: dataDir,
+		Network: network,
+		NodeDir: filepath.Join(dataDir, network.SubDir),
+		path:    path,
+		values:  file.Values(network.Name),
+	}
+	if err := cfg.SetFlags(fs); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.NodeDir, 0700); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// SetFlags sets the flags of fs which are options and not on the command
+// line from the environment and then from the config file, as Load does. fs
+// must be parsed, the cli calls it with the flags of a command.
+func (c *Config) SetFlags(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var bad error
+	fs.VisitAll(func(f *flag.Flag) {
+		if bad != nil || set[f.Name] || !known(f.Name) {
+			return
+		}
+		if bad = setFromEnv(fs, f.Name, set); bad != nil || set[f.Name] {
+			return
+		}
+		if v, ok := c.values[f.Name]; ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				bad = fmt.Errorf("config: %s in %s: %v", f.Name, c.path, err)
+			}
+		}
+	})
+
+	return bad
+}
+
+func setFromEnv(fs *flag.FlagSet, name string, set map[string]bool) error {
+	if set[name] {
+		return nil
+	}
+	v, ok := os.LookupEnv(EnvPrefix + strings.ToUpper(name))
+	if !ok {
+		return nil
+	}
+	if err := fs.Set(name, v); err != nil {
+		return fmt.Errorf("config: %s%s: %v", EnvPrefix, strings.ToUpper(name), err)
+	}
+	set[name] = true
+
+	return nil
+}
+
+// File is a parsed config file. The options before the first section apply
+// to every network, the ones in a [main], [testnet] or [regtest] section
+// only to that network.
+type File struct {
+	Global   map[string]string
+	Networks map[string]map[string]string
+}
+
+// Values returns the options of the file for the network
+func (f *File) Values(network string) map[string]string {
+	values := make(map[string]string)
+	for k, v := range f.Global {
+		values[k] = v
+	}
+	for k, v := range f.Networks[network] {
+		values[k] = v
+	}
+
+	return values
+}
+
+// ReadFile parses an INI config file of key = value lines. Empty lines and
+// lines starting with # or ; are skipped, unknown keys are an error.
+func ReadFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file := &File{
+		Global:   make(map[string]string),
+		Networks: make(map[string]map[string]string),
+	}
+	section := file.Global
+	sectionName := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			sectionName = strings.TrimSpace(line[1 : len(line)-1])
+			if _, err := LookupNetwork(sectionName); err != nil {
+				return nil, fmt.Errorf("config: %s:%d: unknown network [%s]", path, n, sectionName)
+			}
+			if file.Networks[sectionName] == nil {
+				file.Networks[sectionName] = make(map[string]string)
+			}
+			section = file.Networks[sectionName]
+			continue
+		}
+
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("config: %s:%d: expected key = value", path, n)
+		}
+		key := strings.TrimSpace(line[:i])
+		value := strings.Trim(strings.TrimSpace(line[i+1:]), `"`)
+		if !known(key) {
+			return nil, fmt.Errorf("config: %s:%d: unknown option %s", path, n, key)
+		}
+		if key == "network" && sectionName != "" {
+			return nil, fmt.Errorf("config: %s:%d: network can't be set in a network section", path, n)
+		}
+		section[key] = value
+	}
+
+	return file, scanner.Err()
+}
+
+func known(key string) bool {
+	for _, o := range Options {
+		if o == key {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,230 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package config_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/config"
+)
+
+func writeFile(t *testing.T, dir, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, config.FileName)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadFile(t *testing.T) {
+	path := writeFile(t, t.TempDir(), `
+# comment
+; comment
+network = regtest
+connect = "a:1"
+prune=5
+
+[regtest]
+connect = b:2
+[main]
+prune = 7
+`)
+	file, err := config.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Global["network"] != "regtest" {
+		t.Errorf("network %q", file.Global["network"])
+	}
+
+	// 网络的段覆盖全局的选项
+	for network, want := range map[string]map[string]string{
+		"regtest": {"network": "regtest", "connect": "b:2", "prune": "5"},
+		"main":    {"network": "regtest", "connect": "a:1", "prune": "7"},
+		"testnet": {"network": "regtest", "connect": "a:1", "prune": "5"},
+	} {
+		values := file.Values(network)
+		if len(values) != len(want) {
+			t.Errorf("%s: %v, want %v", network, values, want)
+		}
+		for k, v := range want {
+			if values[k] != v {
+				t.Errorf("%s: %s = %q, want %q", network, k, values[k], v)
+			}
+		}
+	}
+}
+
+func TestReadFileErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown option":     "color = red\n",
+		"unknown network":    "[mars]\nprune = 1\n",
+		"network in section": "[regtest]\nnetwork = main\n",
+		"no value":           "prune\n",
+	} {
+		path := writeFile(t, t.TempDir(), content)
+		if _, err := config.ReadFile(path); err == nil || !strings.Contains(err.Error(), path+":") {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// newFlags returns the flags of mybitcoind used by the tests
+func newFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	config.AddFlags(fs)
+	fs.String("rpcuser", "", "")
+	fs.String("connect", "", "")
+	fs.Int64("prune", 0, "")
+	fs.Int("dbcache", 32, "")
+	fs.String("explorer", "", "")
+	return fs
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, `
+network = regtest
+rpcuser = file
+prune = 5
+dbcache = 9
+[regtest]
+connect = regtest:1
+[main]
+explorer = :8080
+`)
+	t.Setenv(config.EnvPrefix+"RPCUSER", "env")
+	t.Setenv(config.EnvPrefix+"PRUNE", "6")
+	t.Setenv(config.EnvPrefix+"DATADIR", dir)
+
+	fs := newFlags()
+	if err := fs.Parse([]string{"-rpcuser", "flag"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DataDir != dir || cfg.Network != &config.RegTest || cfg.NodeDir != filepath.Join(dir, "regtest") {
+		t.Fatalf("data directory %s, network %s, node directory %s", cfg.DataDir, cfg.Network.Name, cfg.NodeDir)
+	}
+	if _, err := os.Stat(cfg.NodeDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// 命令行 > 环境变量 > 配置文件 > 默认值
+	for name, want := range map[string]string{
+		"rpcuser":  "flag",
+		"prune":    "6",
+		"dbcache":  "9",
+		"connect":  "regtest:1",
+		"explorer": "",
+	} {
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// 命令的选项也一样
+	cmd := flag.NewFlagSet("sync", flag.ContinueOnError)
+	connect := cmd.String("connect", "", "")
+	prune := cmd.Int64("prune", 0, "")
+	rpcuser := cmd.String("rpcuser", "", "")
+	unknown := cmd.String("address", "default", "")
+	if err := cmd.Parse([]string{"-connect", "cmd:1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.SetFlags(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if *connect != "cmd:1" || *prune != 6 || *rpcuser != "env" || *unknown != "default" {
+		t.Errorf("command flags connect %q, prune %d, rpcuser %q, address %q", *connect, *prune, *rpcuser, *unknown)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	fs := newFlags()
+	fs.Parse([]string{"-datadir", dir, "-conf", filepath.Join(dir, "missing.conf")})
+	if _, err := config.Load(fs); !os.IsNotExist(err) {
+		t.Errorf("missing -conf file: %v", err)
+	}
+
+	fs = newFlags()
+	fs.Parse([]string{"-datadir", dir, "-network", "mars"})
+	if _, err := config.Load(fs); err != config.ErrUnknownNetwork {
+		t.Errorf("unknown network: %v", err)
+	}
+
+	writeFile(t, dir, "prune = lots\n")
+	fs = newFlags()
+	fs.Parse([]string{"-datadir", dir})
+	if _, err := config.Load(fs); err == nil || !strings.Contains(err.Error(), "prune") {
+		t.Errorf("bad value in the file: %v", err)
+	}
+}
+
+func TestApplyBeforeAddCheckpoints(t *testing.T) {
+	// 测试结束时恢复网络参数和检查点
+	chaintest.Setup(t)
+
+	config.RegTest.Apply()
+	if blk.NetworkMagic != config.RegTest.Magic || blk.TargetBits() != config.RegTest.TargetBits {
+		t.Fatal("the regtest parameters are not applied")
+	}
+	if err := blk.AddCheckpoints("5:ab"); err != nil {
+		t.Fatal(err)
+	}
+	if blk.LastCheckpoint() != 5 {
+		t.Fatalf("last checkpoint %d, want 5", blk.LastCheckpoint())
+	}
+
+	// 之后再 Apply 就回到网络内置的检查点
+	config.TestNet.Apply()
+	if blk.LastCheckpoint() != -1 {
+		t.Fatalf("last checkpoint %d after Apply, want none", blk.LastCheckpoint())
+	}
+
+	builtIn := len(config.MainNet.Checkpoints)
+	config.MainNet.Apply()
+	if len(blk.Checkpoints) != builtIn {
+		t.Fatalf("%d checkpoints on the main network, want %d", len(blk.Checkpoints), builtIn)
+	}
+	if err := blk.AddCheckpoints("5:ab,7:cd"); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.MainNet.Checkpoints) != builtIn {
+		t.Fatal("AddCheckpoints changed the built-in checkpoints")
+	}
+}
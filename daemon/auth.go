@@ -0,0 +1,95 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package daemon
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	authOK     = "ok\n"
+	authDenied = "denied\n"
+
+	authTimeout = 10 * time.Second
+)
+
+var ErrAuthFailed = errors.New("daemon: rpc user or password is wrong")
+
+// Credentials of the local socket, see mybitcoind -rpcuser and -rpcpassword.
+// The socket accepts any client when they are empty.
+type Credentials struct {
+	User     string
+	Password string
+}
+
+func (c Credentials) line() string {
+	return c.User + ":" + c.Password + "\n"
+}
+
+// authenticate 读客户端发来的第一行，客户端收到回复之前不会再发送，读缓冲中不会有 rpc 的数据
+func (s *Server) authenticate(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if s.cred.Password != "" && subtle.ConstantTimeCompare([]byte(line), []byte(s.cred.line())) != 1 {
+		conn.Write([]byte(authDenied))
+		return ErrAuthFailed
+	}
+	_, err = conn.Write([]byte(authOK))
+
+	return err
+}
+
+// login 是 authenticate 的客户端
+func login(conn net.Conn, cred Credentials) error {
+	if strings.ContainsAny(cred.User+cred.Password, "\n") {
+		return ErrAuthFailed
+	}
+
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write([]byte(cred.line())); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if reply != authOK {
+		return ErrAuthFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+
+	"myBitCoin/block"
+	"myBitCoin/mining"
+	"myBitCoin/utxo"
+)
+
+// Client talks to a running mybitcoind
+type Client struct {
+	rpc *rpc.Client
+}
+
+// Dial connects to the mybitcoind of nodeID and logs in with cred. It fails
+// when the node is not running, or with ErrAuthFailed when cred is wrong.
+func Dial(nodeID string, cred Credentials) (*Client, error) {
+	conn, err := net.Dial("unix", fmt.Sprintf(sockFile, nodeID))
+	if err != nil {
+		return nil, err
+	}
+	if err := login(conn, cred); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Client{rpc.NewClient(conn)}, nil
+}
+
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+func (c *Client) GetBalance(address string) (int, error) {
+	var balance int
+	err := c.rpc.Call(serviceName+".GetBalance", &GetBalanceArgs{address}, &balance)
+
+	return balance, err
+}
+
+func (c *Client) Send(from, to string, amount int) error {
+	var ok bool
+	return c.rpc.Call(serviceName+".Send", &SendArgs{from, to, amount}, &ok)
+}
+
+func (c *Client) GetBlocks() ([]*block.Block, error) {
+	var blocks []*block.Block
+	err := c.rpc.Call(serviceName+".GetBlocks", struct{}{}, &blocks)
+
+	return blocks, err
+}
+
+func (c *Client) CreateWallet() (string, error) {
+	var address string
+	err := c.rpc.Call(serviceName+".CreateWallet", struct{}{}, &address)
+
+	return address, err
+}
+
+func (c *Client) GetTxOutProof(txIDs [][]byte, blockHash []byte) ([]byte, error) {
+	var proof []byte
+	err := c.rpc.Call(serviceName+".GetTxOutProof", &GetTxOutProofArgs{txIDs, blockHash}, &proof)
+
+	return proof, err
+}
+
+func (c *Client) VerifyTxOutProof(proof []byte) ([][]byte, error) {
+	var txIDs [][]byte
+	err := c.rpc.Call(serviceName+".VerifyTxOutProof", proof, &txIDs)
+
+	return txIDs, err
+}
+
+func (c *Client) GetBlockTemplate(payToAddress string) (*mining.BlockTemplate, error) {
+	var tmpl mining.BlockTemplate
+	err := c.rpc.Call(serviceName+".GetBlockTemplate", &GetBlockTemplateArgs{payToAddress}, &tmpl)
+
+	return &tmpl, err
+}
+
+// SubmitHeader submits the solved header of a block template
+func (c *Client) SubmitHeader(header *block.BlockHeader) ([]byte, error) {
+	var hash []byte
+	err := c.rpc.Call(serviceName+".SubmitBlock", &SubmitBlockArgs{Header: header}, &hash)
+
+	return hash, err
+}
+
+func (c *Client) SubmitBlock(b *block.Block) ([]byte, error) {
+	var hash []byte
+	err := c.rpc.Call(serviceName+".SubmitBlock", &SubmitBlockArgs{Block: b}, &hash)
+
+	return hash, err
+}
+
+// DumpTxOutSet makes mybitcoind write a snapshot of its UTXO set to path
+func (c *Client) DumpTxOutSet(path string) (*block.SnapshotMetadata, error) {
+	var meta block.SnapshotMetadata
+	err := c.rpc.Call(serviceName+".DumpTxOutSet", &DumpTxOutSetArgs{path}, &meta)
+
+	return &meta, err
+}
+
+func (c *Client) GetTxOutSetInfo() (*utxo.TxOutSetInfo, error) {
+	var info utxo.TxOutSetInfo
+	err := c.rpc.Call(serviceName+".GetTxOutSetInfo", struct{}{}, &info)
+
+	return &info, err
+}
+
+func (c *Client) GetTxOut(txID []byte, vout int) (*TxOut, error) {
+	var out TxOut
+	err := c.rpc.Call(serviceName+".GetTxOut", &GetTxOutArgs{txID, vout}, &out)
+
+	return &out, err
+}
+
+func (c *Client) InvalidateBlock(hash []byte) (int, error) {
+	var disconnected int
+	err := c.rpc.Call(serviceName+".InvalidateBlock", &BlockHashArgs{hash}, &disconnected)
+
+	return disconnected, err
+}
+
+func (c *Client) ReconsiderBlock(hash []byte) (int, error) {
+	var connected int
+	err := c.rpc.Call(serviceName+".ReconsiderBlock", &BlockHashArgs{hash}, &connected)
+
+	return connected, err
+}
+
+func (c *Client) VerifyChain(level, depth int) (int, error) {
+	var checked int
+	err := c.rpc.Call(serviceName+".VerifyChain", &VerifyChainArgs{level, depth}, &checked)
+
+	return checked, err
+}
+
+// ExportChain makes mybitcoind write the blocks of its chain to path
+func (c *Client) ExportChain(path string) (int, error) {
+	var exported int
+	err := c.rpc.Call(serviceName+".ExportChain", &ExportChainArgs{path}, &exported)
+
+	return exported, err
+}
@@ -0,0 +1,100 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package daemon
+
+import (
+	"encoding/hex"
+	"errors"
+	"log"
+
+	blk "myBitCoin/block"
+	"myBitCoin/mining"
+)
+
+// 同一个链尾上最多保留的模板数
+const maxTemplates = 64
+
+var ErrStaleTemplate = errors.New("block template is unknown or stale")
+
+func (s *Server) handleChainNotification(n *blk.Notification) {
+	if n.Type != blk.NTBlockConnected && n.Type != blk.NTBlockDisconnected {
+		return
+	}
+
+	s.templatesMu.Lock()
+	s.templates = make(map[string]*mining.BlockTemplate)
+	s.templatesMu.Unlock()
+}
+
+// getBlockTemplate builds a template and remembers it for submitBlock
+func (s *Server) getBlockTemplate(payToAddress string) (*mining.BlockTemplate, error) {
+	tmpl, err := s.tmplGen.NewBlockTemplate(payToAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	s.templatesMu.Lock()
+	if len(s.templates) >= maxTemplates {
+		for k := range s.templates {
+			delete(s.templates, k)
+			break
+		}
+	}
+	s.templates[hex.EncodeToString(tmpl.MerkleRoot)] = tmpl
+	s.templatesMu.Unlock()
+
+	return tmpl, nil
+}
+
+// submitBlock completes the template of header.MerkleRoot with the nonce and
+// the timestamp of header, and connects the block to the chain
+func (s *Server) submitBlock(header *blk.BlockHeader) (*blk.Block, error) {
+	s.templatesMu.Lock()
+	tmpl, ok := s.templates[hex.EncodeToString(header.MerkleRoot)]
+	s.templatesMu.Unlock()
+	if !ok {
+		return nil, ErrStaleTemplate
+	}
+
+	b := *tmpl.Block
+	b.TimeStamp = header.TimeStamp
+	b.Nonce = header.Nonce
+	b.Hash = blk.NewProofOfWork(&b).CalcHash()
+
+	return &b, s.ProcessBlock(&b)
+}
+
+// ProcessBlock connects a block solved outside of the node
+func (s *Server) ProcessBlock(b *blk.Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.bc.AcceptBlock(b); err != nil {
+		return err
+	}
+	log.Printf("accepted block %x at height %d", b.Hash, b.Height)
+
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package daemon_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/daemon"
+	"myBitCoin/transaction"
+)
+
+func TestSubmitHeader(t *testing.T) {
+	dir, w := newServer(t, daemon.Credentials{})
+	c, err := daemon.Dial(dir, daemon.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	tmpl, err := c.GetBlockTemplate(string(w.GetAddress()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.Height != 1 || tmpl.CoinbaseValue != transaction.Subsidy {
+		t.Fatalf("template at height %d paying %d", tmpl.Height, tmpl.CoinbaseValue)
+	}
+
+	// 解错的头被拒绝，模板还可以再用
+	header := tmpl.Block.Header()
+	for header.Nonce = 0; blk.NewHeaderProofOfWork(header).Validate(); header.Nonce++ {
+	}
+	if _, err := c.SubmitHeader(header); err == nil {
+		t.Fatal("a header with a wrong nonce was accepted")
+	}
+
+	var hashes uint64
+	nonce, hash, ok := (&blk.Miner{}).SolveHeader(context.Background(), header, blk.Target(), &hashes)
+	if !ok {
+		t.Fatal("the header was not solved")
+	}
+	header.Nonce = nonce
+	got, err := c.SubmitHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, hash) {
+		t.Fatalf("submitted block %x, want %x", got, hash)
+	}
+
+	blocks, err := c.GetBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || !bytes.Equal(blocks[0].Hash, hash) {
+		t.Fatalf("%d blocks", len(blocks))
+	}
+	if balance, err := c.GetBalance(string(w.GetAddress())); err != nil || balance != 2*transaction.Subsidy {
+		t.Fatalf("balance %d, %v", balance, err)
+	}
+
+	// 连上新块之后旧模板作废
+	if _, err := c.SubmitHeader(header); err == nil || err.Error() != daemon.ErrStaleTemplate.Error() {
+		t.Fatalf("stale template: %v", err)
+	}
+}
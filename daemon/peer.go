@@ -0,0 +1,347 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package daemon
+
+import (
+	"errors"
+	"sync"
+
+	"myBitCoin/block"
+	"myBitCoin/bloom"
+	"myBitCoin/cfilter"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+const (
+	peerServiceName = "Peer"
+
+	maxMerkleBlocksPerMsg = 500
+	// MaxAddressProofBlocks is the number of blocks from StartHeight scanned
+	// by a GetAddressProofs call
+	MaxAddressProofBlocks = 2000
+)
+
+var ErrNoFilter = errors.New("no bloom filter is loaded")
+
+// ServiceFlag tells the peers what a node serves, the bits are those of bitcoin
+type ServiceFlag uint64
+
+const (
+	// SFNodeNetwork serves all the blocks
+	SFNodeNetwork ServiceFlag = 1 << 0
+	SFNodeBloom   ServiceFlag = 1 << 2
+	SFNodeCF      ServiceFlag = 1 << 6
+	// SFNodeNetworkLimited serves only the blocks from PruneHeight, at least
+	// the last block.MinBlocksToKeep blocks
+	SFNodeNetworkLimited ServiceFlag = 1 << 10
+)
+
+// NodeVersion is what a node tells about itself
+type NodeVersion struct {
+	Services    ServiceFlag
+	BestHeight  int
+	PruneHeight int
+}
+
+type GetHeadersArgs struct {
+	Locator  [][]byte
+	HashStop []byte
+}
+
+type GetAddressProofsArgs struct {
+	PubKeyHashes [][]byte
+	StartHeight  int
+}
+
+type GetCFiltersArgs struct {
+	StartHeight int
+	StopHash    []byte
+}
+
+// CFilter is the compact filter of a block
+type CFilter struct {
+	BlockHash []byte
+	Filter    []byte
+}
+
+// CFHeaders lets the client rebuild the filter headers from StartHeight to StopHash
+type CFHeaders struct {
+	StopHash         []byte
+	PrevFilterHeader []byte
+	FilterHashes     [][]byte
+}
+
+type GetMerkleBlocksArgs struct {
+	StartHeight int
+}
+
+// Peer is the read only rpc service served to other nodes and light clients,
+// there is one Peer per connection
+type Peer struct {
+	s *Server
+
+	mu     sync.Mutex
+	filter *bloom.Filter
+}
+
+// Version returns the services and the heights of the node, a pruned node
+// is limited to the blocks from its PruneHeight
+func (p *Peer) Version(_ struct{}, v *NodeVersion) error {
+	bestHeight, err := p.s.bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	v.Services = SFNodeBloom | SFNodeCF
+	v.BestHeight = bestHeight
+	v.PruneHeight = p.s.bc.PruneHeight()
+	if v.PruneHeight > 0 {
+		v.Services |= SFNodeNetworkLimited
+	} else {
+		v.Services |= SFNodeNetwork
+	}
+
+	return nil
+}
+
+// GetHeaders returns the headers after the fork point described by the locator
+func (p *Peer) GetHeaders(args *GetHeadersArgs, headers *[]*block.BlockHeader) error {
+	var err error
+	*headers, err = p.s.bc.LocateHeaders(args.Locator, args.HashStop)
+	return err
+}
+
+// GetAddressProofs returns, in height order, a proof for every block with
+// transactions paying to or spending from the public key hashes, among the
+// MaxAddressProofBlocks blocks from StartHeight
+func (p *Peer) GetAddressProofs(args *GetAddressProofsArgs, proofs *[]*block.TxOutProof) error {
+	watched := make(map[string]bool)
+	for _, pubKeyHash := range args.PubKeyHashes {
+		watched[string(pubKeyHash)] = true
+	}
+
+	return p.eachBlock(args.StartHeight, MaxAddressProofBlocks, func(b *block.Block) error {
+		var txIDs [][]byte
+		for _, tx := range b.Transactions {
+			if relevant(tx, watched) {
+				txIDs = append(txIDs, tx.ID)
+			}
+		}
+		if len(txIDs) == 0 {
+			return nil
+		}
+
+		proof, err := b.TxOutProof(txIDs)
+		if err != nil {
+			return err
+		}
+		*proofs = append(*proofs, proof)
+		return nil
+	})
+}
+
+// eachBlock calls fn with at most max blocks of the main chain from
+// startHeight, in height order, until the tip
+func (p *Peer) eachBlock(startHeight, max int, fn func(b *block.Block) error) error {
+	for height := startHeight; height < startHeight+max; height++ {
+		hash, err := p.s.bc.HashAtHeight(height)
+		if err == block.ErrBlockNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		b, err := p.s.bc.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func relevant(tx *transaction.Transaction, watched map[string]bool) bool {
+	for _, out := range tx.Vout {
+		if watched[string(out.PubKeyHash)] {
+			return true
+		}
+	}
+
+	if tx.IsCoinbase() {
+		return false
+	}
+	for _, in := range tx.Vin {
+		if watched[string(wallet.HashPubKey(in.PubKey))] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetBlock returns a full block, light clients use it after a filter matched.
+// A pruned node answers ErrBlockPruned for the blocks below its PruneHeight.
+func (p *Peer) GetBlock(hash []byte, b *block.Block) error {
+	found, err := p.s.bc.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+
+	*b = *found
+	return nil
+}
+
+// GetCFilters returns the compact filters of the blocks from StartHeight to StopHash
+func (p *Peer) GetCFilters(args *GetCFiltersArgs, filters *[]CFilter) error {
+	hashes, err := p.s.cfIndex.BlockRange(args.StartHeight, args.StopHash, cfilter.MaxFiltersPerMsg)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		filter, err := p.s.cfIndex.Filter(hash)
+		if err != nil {
+			return err
+		}
+		*filters = append(*filters, CFilter{hash, filter})
+	}
+
+	return nil
+}
+
+// GetCFHeaders returns the filter hashes of the blocks from StartHeight to StopHash
+// and the filter header of the block before StartHeight
+func (p *Peer) GetCFHeaders(args *GetCFiltersArgs, headers *CFHeaders) error {
+	hashes, err := p.s.cfIndex.BlockRange(args.StartHeight, args.StopHash, cfilter.MaxHeadersPerMsg)
+	if err != nil {
+		return err
+	}
+
+	headers.StopHash = args.StopHash
+	for i, hash := range hashes {
+		data, err := p.s.cfIndex.Filter(hash)
+		if err != nil {
+			return err
+		}
+		filter, err := cfilter.FromBytes(data)
+		if err != nil {
+			return err
+		}
+		headers.FilterHashes = append(headers.FilterHashes, filter.Hash())
+
+		if i == 0 && args.StartHeight > 0 {
+			h, err := p.s.bc.GetHeader(hash)
+			if err != nil {
+				return err
+			}
+			if headers.PrevFilterHeader, err = p.s.cfIndex.FilterHeader(h.PrevHash); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// FilterLoad sets the bloom filter of the connection
+func (p *Peer) FilterLoad(msg *bloom.FilterLoad, ok *bool) error {
+	filter, err := bloom.LoadFilter(msg)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.filter = filter
+	p.mu.Unlock()
+
+	*ok = true
+	return nil
+}
+
+// FilterAdd adds data to the loaded bloom filter
+func (p *Peer) FilterAdd(data []byte, ok *bool) error {
+	if len(data) > bloom.MaxFilterAddSize {
+		return bloom.ErrDataTooLarge
+	}
+
+	filter := p.loadedFilter()
+	if filter == nil {
+		return ErrNoFilter
+	}
+	filter.Add(data)
+
+	*ok = true
+	return nil
+}
+
+func (p *Peer) FilterClear(_ struct{}, ok *bool) error {
+	p.mu.Lock()
+	p.filter = nil
+	p.mu.Unlock()
+
+	*ok = true
+	return nil
+}
+
+func (p *Peer) loadedFilter() *bloom.Filter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.filter
+}
+
+// GetMerkleBlocks returns, in height order, the merkle blocks of at most
+// maxMerkleBlocksPerMsg blocks from StartHeight, filtered by the loaded filter
+func (p *Peer) GetMerkleBlocks(args *GetMerkleBlocksArgs, merkleBlocks *[]*bloom.MerkleBlock) error {
+	filter := p.loadedFilter()
+	if filter == nil {
+		return ErrNoFilter
+	}
+
+	// 过滤器会随匹配的输出更新，必须按高度从低到高处理
+	return p.eachBlock(args.StartHeight, maxMerkleBlocksPerMsg, func(b *block.Block) error {
+		*merkleBlocks = append(*merkleBlocks, bloom.NewMerkleBlock(b, filter))
+		return nil
+	})
+}
+
+// GetMempool returns the transactions of the mempool matching the loaded filter
+func (p *Peer) GetMempool(_ struct{}, txs *[]*transaction.Transaction) error {
+	filter := p.loadedFilter()
+	if filter == nil {
+		return ErrNoFilter
+	}
+
+	for _, tx := range p.s.txPool.Transactions() {
+		if filter.MatchTxAndUpdate(tx) {
+			*txs = append(*txs, tx)
+		}
+	}
+
+	return nil
+}
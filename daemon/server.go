@@ -0,0 +1,370 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+
+	blk "myBitCoin/block"
+	"myBitCoin/cfilter"
+	"myBitCoin/mempool"
+	"myBitCoin/mining"
+	"myBitCoin/notify"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+	"myBitCoin/wallet"
+)
+
+// mybitcoind 在数据目录下监听的本地 socket
+const sockFile = "%s/mybitcoind.sock"
+
+// Server owns the BlockChain of a node and serves the cli commands over a
+// local unix socket, so that several commands can run while the node is up.
+type Server struct {
+	nodeID   string
+	bc       *blk.BlockChain
+	txPool   *mempool.TxPool
+	notifier *notify.Notifier
+	cfIndex  *cfilter.Index
+	listener net.Listener
+	cred     Credentials
+	// 对其他节点和轻客户端开放的 tcp 端口，只提供 Peer 服务
+	peerListener net.Listener
+
+	// send 会挖出新块，同一时间只允许一个写操作
+	mu      sync.Mutex
+	wallets *wallet.Wallets
+
+	tmplGen *mining.BlkTmplGenerator
+	// 发给外部矿工的模板，按 merkle root 查找，链尾变化时清空
+	templatesMu sync.Mutex
+	templates   map[string]*mining.BlockTemplate
+}
+
+func NewServer(nodeID string) (*Server, error) {
+	bc, err := blk.NewBlockChain(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	utxoSet := utxo.UTXOSet{bc}
+	if err := utxoSet.Open(); err != nil {
+		bc.DB.Close()
+		return nil, err
+	}
+
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		bc.DB.Close()
+		return nil, err
+	}
+
+	cfIndex, err := cfilter.NewIndex(bc)
+	if err != nil {
+		bc.DB.Close()
+		return nil, err
+	}
+
+	txPool := mempool.New(bc)
+	notifier := notify.NewNotifier()
+	bc.Subscribe(notifier.HandleChainNotification)
+	txPool.Subscribe(notifier.HandleTxAccepted)
+
+	s := &Server{
+		nodeID:    nodeID,
+		bc:        bc,
+		txPool:    txPool,
+		notifier:  notifier,
+		cfIndex:   cfIndex,
+		wallets:   wallets,
+		tmplGen:   mining.NewBlkTmplGenerator(bc, txPool),
+		templates: make(map[string]*mining.BlockTemplate),
+	}
+	bc.Subscribe(s.handleChainNotification)
+
+	return s, nil
+}
+
+// BlockChain returns the chain owned by the server
+func (s *Server) BlockChain() *blk.BlockChain {
+	return s.bc
+}
+
+// TxPool returns the mempool of the node
+func (s *Server) TxPool() *mempool.TxPool {
+	return s.txPool
+}
+
+// TemplateGenerator returns the generator of the block templates of the node
+func (s *Server) TemplateGenerator() *mining.BlkTmplGenerator {
+	return s.tmplGen
+}
+
+// Notifier returns the notifier publishing the chain and mempool events
+func (s *Server) Notifier() *notify.Notifier {
+	return s.notifier
+}
+
+// Wallets returns the wallets of the node, new ones are added with CreateWallet
+func (s *Server) Wallets() *wallet.Wallets {
+	return s.wallets
+}
+
+// Listen opens the local socket, removing a stale one left by a crashed node.
+// The clients must log in with cred when its password is not empty.
+func (s *Server) Listen(cred Credentials) error {
+	path := fmt.Sprintf(sockFile, s.nodeID)
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return errors.New("mybitcoind is already running")
+	}
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	s.cred = cred
+
+	return nil
+}
+
+// ListenPeers opens the tcp port serving other nodes and light clients
+func (s *Server) ListenPeers(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.peerListener = l
+
+	return nil
+}
+
+// PeerAddr returns the address of the peer port, nil when ListenPeers was
+// not called
+func (s *Server) PeerAddr() net.Addr {
+	if s.peerListener == nil {
+		return nil
+	}
+
+	return s.peerListener.Addr()
+}
+
+// Serve accepts connections until Close is called. It returns at once when
+// neither Listen nor ListenPeers was called.
+func (s *Server) Serve() error {
+	if s.listener == nil {
+		if s.peerListener == nil {
+			return nil
+		}
+		return s.accept(s.peerListener, false)
+	}
+	if s.peerListener != nil {
+		go s.accept(s.peerListener, false)
+	}
+
+	return s.accept(s.listener, true)
+}
+
+func (s *Server) accept(l net.Listener, local bool) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn, local)
+	}
+}
+
+// serveConn 为每个连接注册一个新的 Peer，peer 加载的 bloom 过滤器只属于这个连接
+func (s *Server) serveConn(conn net.Conn, local bool) {
+	server := rpc.NewServer()
+	if local {
+		if err := s.authenticate(conn); err != nil {
+			conn.Close()
+			return
+		}
+		if err := server.RegisterName(serviceName, &Node{s}); err != nil {
+			log.Print(err)
+			conn.Close()
+			return
+		}
+	}
+	if err := server.RegisterName(peerServiceName, &Peer{s: s}); err != nil {
+		log.Print(err)
+		conn.Close()
+		return
+	}
+	server.ServeConn(conn)
+}
+
+func (s *Server) Close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.peerListener != nil {
+		s.peerListener.Close()
+	}
+
+	// 等正在接入的块写完，再把 UTXO 缓存写回
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	utxoSet := utxo.UTXOSet{s.bc}
+	if err := utxoSet.Close(); err != nil {
+		log.Print(err)
+	}
+	s.bc.DB.Close()
+}
+
+// Send pays amount from a wallet of the node to the address to, and mines a
+// block with the transaction
+func (s *Server) Send(from, to string, amount int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wlt := s.wallets.GetWallet(from)
+	if wlt == nil {
+		return fmt.Errorf("no wallet for address %s", from)
+	}
+
+	pubKeyHash := wallet.HashPubKey(wlt.PublicKey)
+	utxoSet := utxo.UTXOSet{s.bc}
+	balance, err := utxoSet.GetBalance(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	if balance < amount {
+		return blk.ErrInsufficientFunds
+	}
+
+	tx, err := s.bc.NewUTXOTransaction(wlt, to, amount)
+	if err != nil {
+		return err
+	}
+	if err := s.bc.SignTransactions(tx, wlt.PrivateKey); err != nil {
+		return err
+	}
+	if err := s.txPool.ProcessTransaction(tx); err != nil {
+		return err
+	}
+
+	newBlock, err := s.bc.MineBlockContext(context.Background(), []*transaction.Transaction{tx})
+	if err != nil {
+		// 没挖进块的交易留在池里会挡住之后用同样输出的交易
+		s.txPool.RemoveTransaction(tx)
+		return err
+	}
+	log.Printf("mined block %x at height %d", newBlock.Hash, newBlock.Height)
+
+	return nil
+}
+
+// CreateWallet adds a new wallet to the wallet file of the node and returns its address
+func (s *Server) CreateWallet() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	address, err := s.wallets.CreateWallet()
+	if err != nil {
+		return "", err
+	}
+	if err := s.wallets.SaveToFile(s.nodeID); err != nil {
+		return "", err
+	}
+
+	return address, nil
+}
+
+// dumpTxOutSet 持有写锁，快照期间不会有新块接上
+func (s *Server) dumpTxOutSet(path string) (*blk.SnapshotMetadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	utxoSet := utxo.UTXOSet{s.bc}
+	meta, err := utxoSet.DumpSnapshot(f)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return meta, f.Sync()
+}
+
+// exportChain 持有写锁，导出期间不会有新块接上
+func (s *Server) exportChain(path string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n, err := s.bc.ExportChain(f)
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+
+	return n, f.Sync()
+}
+
+// invalidateBlock 和 reconsiderBlock 持有写锁，和挖矿、同步的块依次连接
+func (s *Server) invalidateBlock(hash []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.bc.InvalidateBlock(hash)
+}
+
+func (s *Server) reconsiderBlock(hash []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.bc.ReconsiderBlock(hash)
+}
+
+// verifyChain 持有写锁，检查期间链尾不变
+func (s *Server) verifyChain(level, depth int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	utxoSet := utxo.UTXOSet{s.bc}
+	return utxoSet.VerifyChain(level, depth)
+}
@@ -0,0 +1,184 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package daemon_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/daemon"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+	"myBitCoin/wallet"
+)
+
+// newChain creates a regtest chain paying the genesis reward to the returned
+// wallet
+func newChain(t *testing.T) (string, *wallet.Wallet) {
+	t.Helper()
+
+	chaintest.Setup(t)
+	w := chaintest.NewWallet(t)
+	dir := t.TempDir()
+	bc, err := blk.CreateBlockChain(string(w.GetAddress()), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxoSet := utxo.UTXOSet{bc}
+	err = utxoSet.Reindex()
+	bc.DB.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, w
+}
+
+// serve runs a server of the chain in dir on the local socket with cred
+func serve(t *testing.T, dir string, cred daemon.Credentials) *daemon.Server {
+	t.Helper()
+
+	s, err := daemon.NewServer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Listen(cred); err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	go s.Serve()
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// newServer serves a new chain, the wallet holding the genesis reward is in
+// the node
+func newServer(t *testing.T, cred daemon.Credentials) (string, *wallet.Wallet) {
+	t.Helper()
+
+	dir, w := newChain(t)
+	s := serve(t, dir, cred)
+	s.Wallets().Wallets[string(w.GetAddress())] = w
+
+	return dir, w
+}
+
+func TestLogin(t *testing.T) {
+	cred := daemon.Credentials{User: "alice", Password: "secret"}
+	dir, w := newServer(t, cred)
+
+	for _, wrong := range []daemon.Credentials{
+		{},
+		{User: "alice", Password: "wrong"},
+		{User: "bob", Password: "secret"},
+		{User: "alice", Password: "secret\nalice:secret"},
+	} {
+		if c, err := daemon.Dial(dir, wrong); err != daemon.ErrAuthFailed {
+			if c != nil {
+				c.Close()
+			}
+			t.Errorf("login as %q: %v", wrong.User+":"+wrong.Password, err)
+		}
+	}
+
+	c, err := daemon.Dial(dir, cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if balance, err := c.GetBalance(string(w.GetAddress())); err != nil || balance != transaction.Subsidy {
+		t.Fatalf("balance %d, %v", balance, err)
+	}
+}
+
+func TestNoPassword(t *testing.T) {
+	dir, _ := newServer(t, daemon.Credentials{})
+
+	// 没有设置密码时接受任何客户端
+	for _, cred := range []daemon.Credentials{{}, {User: "anyone", Password: "anything"}} {
+		c, err := daemon.Dial(dir, cred)
+		if err != nil {
+			t.Fatalf("login as %q: %v", cred.User, err)
+		}
+		c.Close()
+	}
+}
+
+func TestSendAndGetBlocks(t *testing.T) {
+	dir, w := newServer(t, daemon.Credentials{})
+	c, err := daemon.Dial(dir, daemon.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	from := string(w.GetAddress())
+	to := string(chaintest.NewWallet(t).GetAddress())
+	if err := c.Send(from, to, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(to, from, 1); err == nil {
+		t.Fatal("sent from an address without a wallet in the node")
+	}
+	if err := c.Send(from, to, 100); err == nil {
+		t.Fatal("sent more than the balance")
+	}
+
+	for address, want := range map[string]int{from: transaction.Subsidy - 3, to: 3} {
+		if balance, err := c.GetBalance(address); err != nil || balance != want {
+			t.Errorf("balance of %s: %d, %v, want %d", address, balance, err, want)
+		}
+	}
+	blocks, err := c.GetBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Height != 1 || len(blocks[1].PrevHash) != 0 {
+		t.Fatalf("got %d blocks", len(blocks))
+	}
+}
+
+func TestListen(t *testing.T) {
+	dir, _ := newChain(t)
+	// 崩溃的节点留下的 socket 文件被删除
+	if err := os.WriteFile(filepath.Join(dir, "mybitcoind.sock"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := serve(t, dir, daemon.Credentials{})
+	c, err := daemon.Dial(dir, daemon.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// 已经有节点在这个数据目录上监听
+	if err := s.Listen(daemon.Credentials{}); err == nil {
+		t.Fatal("listened on the socket of a running node")
+	}
+}
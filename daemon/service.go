@@ -0,0 +1,278 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package daemon
+
+import (
+	"errors"
+
+	"myBitCoin/block"
+	"myBitCoin/mining"
+	"myBitCoin/utxo"
+	"myBitCoin/wallet"
+)
+
+const serviceName = "Node"
+
+type GetBalanceArgs struct {
+	Address string
+}
+
+type SendArgs struct {
+	From   string
+	To     string
+	Amount int
+}
+
+type GetTxOutProofArgs struct {
+	TxIDs     [][]byte
+	BlockHash []byte
+}
+
+type GetBlockTemplateArgs struct {
+	PayToAddress string
+}
+
+// SubmitBlockArgs carries either a block solved by the caller, or the header
+// of a solved block template
+type SubmitBlockArgs struct {
+	Block  *block.Block
+	Header *block.BlockHeader
+}
+
+type DumpTxOutSetArgs struct {
+	// Path of the snapshot file, written by mybitcoind
+	Path string
+}
+
+type ExportChainArgs struct {
+	// Path of the bootstrap file, written by mybitcoind
+	Path string
+}
+
+type BlockHashArgs struct {
+	Hash []byte
+}
+
+type VerifyChainArgs struct {
+	Level int
+	Depth int
+}
+
+type GetTxOutArgs struct {
+	TxID []byte
+	Vout int
+}
+
+// TxOut is the reply of GetTxOut, Coin is nil when the output is spent or
+// doesn't exist
+type TxOut struct {
+	Coin       *utxo.Coin
+	BestHeight int
+}
+
+// Node is the rpc service exported by mybitcoind
+type Node struct {
+	s *Server
+}
+
+func (n *Node) GetBalance(args *GetBalanceArgs, balance *int) error {
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(args.Address)
+	if err != nil {
+		return err
+	}
+	utxoSet := utxo.UTXOSet{n.s.bc}
+
+	*balance, err = utxoSet.GetBalance(pubKeyHash)
+	return err
+}
+
+func (n *Node) Send(args *SendArgs, ok *bool) error {
+	err := n.s.Send(args.From, args.To, args.Amount)
+	*ok = err == nil
+
+	return err
+}
+
+// GetBlocks returns all the blocks from the tip back to the genesis block, or
+// back to the last block which is not pruned
+func (n *Node) GetBlocks(_ struct{}, blocks *[]*block.Block) error {
+	bci := n.s.bc.Iterator()
+
+	for {
+		b, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		if b.Pruned() {
+			break
+		}
+		*blocks = append(*blocks, b)
+
+		if len(b.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (n *Node) CreateWallet(_ struct{}, address *string) error {
+	var err error
+	*address, err = n.s.CreateWallet()
+	return err
+}
+
+// GetTxOutProof returns the serialized proof that the transactions are in a block
+func (n *Node) GetTxOutProof(args *GetTxOutProofArgs, proof *[]byte) error {
+	p, err := n.s.bc.GetTxOutProof(args.TxIDs, args.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	*proof = p.Serialize()
+	return nil
+}
+
+// VerifyTxOutProof returns the IDs of the transactions proven by a serialized proof
+func (n *Node) VerifyTxOutProof(proof []byte, txIDs *[][]byte) error {
+	p, err := block.DeserializeTxOutProof(proof)
+	if err != nil {
+		return err
+	}
+
+	txs, err := n.s.bc.VerifyTxOutProof(p)
+	if err != nil {
+		return err
+	}
+	for _, tx := range txs {
+		*txIDs = append(*txIDs, tx.ID)
+	}
+
+	return nil
+}
+
+// GetBlockTemplate returns a block for an external miner to solve
+func (n *Node) GetBlockTemplate(args *GetBlockTemplateArgs, tmpl *mining.BlockTemplate) error {
+	t, err := n.s.getBlockTemplate(args.PayToAddress)
+	if err != nil {
+		return err
+	}
+
+	*tmpl = *t
+	return nil
+}
+
+// SubmitBlock connects a solved block to the chain and returns its hash
+func (n *Node) SubmitBlock(args *SubmitBlockArgs, hash *[]byte) error {
+	if args.Block != nil {
+		if err := n.s.ProcessBlock(args.Block); err != nil {
+			return err
+		}
+		*hash = args.Block.Hash
+		return nil
+	}
+	if args.Header == nil {
+		return errors.New("nothing to submit")
+	}
+
+	b, err := n.s.submitBlock(args.Header)
+	if err != nil {
+		return err
+	}
+
+	*hash = b.Hash
+	return nil
+}
+
+// DumpTxOutSet writes a snapshot of the UTXO set at the tip to args.Path
+func (n *Node) DumpTxOutSet(args *DumpTxOutSetArgs, meta *block.SnapshotMetadata) error {
+	m, err := n.s.dumpTxOutSet(args.Path)
+	if err != nil {
+		return err
+	}
+
+	*meta = *m
+	return nil
+}
+
+// GetTxOutSetInfo returns the statistics and the commitment hash of the UTXO set
+func (n *Node) GetTxOutSetInfo(_ struct{}, info *utxo.TxOutSetInfo) error {
+	utxoSet := utxo.UTXOSet{n.s.bc}
+	i, err := utxoSet.GetTxOutSetInfo()
+	if err != nil {
+		return err
+	}
+
+	*info = *i
+	return nil
+}
+
+// GetTxOut returns an output of the UTXO set
+func (n *Node) GetTxOut(args *GetTxOutArgs, out *TxOut) error {
+	utxoSet := utxo.UTXOSet{n.s.bc}
+	var err error
+	if out.Coin, err = utxoSet.GetTxOut(args.TxID, args.Vout); err != nil {
+		return err
+	}
+	out.BestHeight, err = n.s.bc.GetBestHeight()
+
+	return err
+}
+
+// InvalidateBlock disconnects a block and the blocks after it, and refuses it
+// until ReconsiderBlock. It returns the number of blocks disconnected.
+func (n *Node) InvalidateBlock(args *BlockHashArgs, disconnected *int) error {
+	var err error
+	*disconnected, err = n.s.invalidateBlock(args.Hash)
+
+	return err
+}
+
+// ReconsiderBlock connects again a block marked by InvalidateBlock and
+// returns the number of blocks connected
+func (n *Node) ReconsiderBlock(args *BlockHashArgs, connected *int) error {
+	var err error
+	*connected, err = n.s.reconsiderBlock(args.Hash)
+
+	return err
+}
+
+// VerifyChain checks the blocks below the tip and the UTXO set, it returns
+// the number of blocks checked or the first corruption found
+func (n *Node) VerifyChain(args *VerifyChainArgs, checked *int) error {
+	var err error
+	*checked, err = n.s.verifyChain(args.Level, args.Depth)
+
+	return err
+}
+
+// ExportChain writes the blocks of the chain to args.Path in height order, it
+// returns the number of blocks written
+func (n *Node) ExportChain(args *ExportChainArgs, exported *int) error {
+	var err error
+	*exported, err = n.s.exportChain(args.Path)
+
+	return err
+}
@@ -0,0 +1,324 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package explorer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	blk "myBitCoin/block"
+	"myBitCoin/txindex"
+	"myBitCoin/utxo"
+	"myBitCoin/wallet"
+)
+
+const (
+	recentBlocks = 20
+	maxBlocks    = 100
+	// 地址页每页的交易数
+	addressPageSize = 25
+)
+
+var errNotFound = errors.New("not found")
+
+// Explorer serves the html pages and the /api json endpoints of the block explorer
+type Explorer struct {
+	bc  *blk.BlockChain
+	idx *txindex.Index
+	mux *http.ServeMux
+}
+
+// New opens the transaction index of the chain, the blocks missing from it
+// are indexed first
+func New(bc *blk.BlockChain) (*Explorer, error) {
+	idx, err := txindex.NewIndex(bc)
+	if err != nil {
+		return nil, err
+	}
+	e := &Explorer{bc: bc, idx: idx, mux: http.NewServeMux()}
+
+	e.mux.HandleFunc("/", e.index)
+	e.mux.HandleFunc("/block/", e.page("/block/", "block", e.block))
+	e.mux.HandleFunc("/tx/", e.page("/tx/", "tx", e.tx))
+	e.mux.HandleFunc("/address/", e.page("/address/", "address", e.address))
+	e.mux.HandleFunc("/search", e.search)
+
+	e.mux.HandleFunc("/api/blocks", e.apiBlocks)
+	e.mux.HandleFunc("/api/block/", e.api("/api/block/", e.block))
+	e.mux.HandleFunc("/api/tx/", e.api("/api/tx/", e.tx))
+	e.mux.HandleFunc("/api/address/", e.api("/api/address/", e.address))
+	e.mux.HandleFunc("/api/search", e.apiSearch)
+
+	return e, nil
+}
+
+func (e *Explorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mux.ServeHTTP(w, r)
+}
+
+type lookup func(id string, query url.Values) (interface{}, error)
+
+func (e *Explorer) page(prefix, name string, find lookup) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		view, err := find(strings.TrimPrefix(r.URL.Path, prefix), r.URL.Query())
+		if err != nil {
+			renderError(w, err)
+			return
+		}
+		render(w, name, view)
+	}
+}
+
+func (e *Explorer) api(prefix string, find lookup) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		view, err := find(strings.TrimPrefix(r.URL.Path, prefix), r.URL.Query())
+		if err != nil {
+			writeJSON(w, statusOf(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, view)
+	}
+}
+
+func (e *Explorer) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		renderError(w, errNotFound)
+		return
+	}
+	blocks, err := e.recent(recentBlocks)
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+	render(w, "index", blocks)
+}
+
+func (e *Explorer) apiBlocks(w http.ResponseWriter, r *http.Request) {
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count <= 0 {
+		count = recentBlocks
+	}
+	if count > maxBlocks {
+		count = maxBlocks
+	}
+
+	blocks, err := e.recent(count)
+	if err != nil {
+		writeJSON(w, statusOf(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, blocks)
+}
+
+func (e *Explorer) search(w http.ResponseWriter, r *http.Request) {
+	kind, id := e.resolve(strings.TrimSpace(r.URL.Query().Get("q")))
+	if kind == "" {
+		renderError(w, errNotFound)
+		return
+	}
+	http.Redirect(w, r, "/"+kind+"/"+id, http.StatusFound)
+}
+
+func (e *Explorer) apiSearch(w http.ResponseWriter, r *http.Request) {
+	kind, id := e.resolve(strings.TrimSpace(r.URL.Query().Get("q")))
+	if kind == "" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errNotFound.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"type": kind, "id": id})
+}
+
+// resolve tells whether q is a block hash, a transaction id or an address
+func (e *Explorer) resolve(q string) (string, string) {
+	if q == "" {
+		return "", ""
+	}
+	if _, err := e.block(q, nil); err == nil {
+		return "block", q
+	}
+	if _, err := e.tx(q, nil); err == nil {
+		return "tx", q
+	}
+	if _, ok := decodeAddress(q); ok {
+		return "address", q
+	}
+
+	return "", ""
+}
+
+func (e *Explorer) recent(count int) ([]blockView, error) {
+	var blocks []blockView
+	bci := e.bc.Iterator()
+
+	for len(blocks) < count {
+		b, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, newBlockView(b, false))
+
+		if len(b.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return blocks, nil
+}
+
+func (e *Explorer) block(id string, _ url.Values) (interface{}, error) {
+	hash, err := hex.DecodeString(id)
+	if err != nil || len(hash) == 0 {
+		return nil, errNotFound
+	}
+
+	b, err := e.bc.GetBlock(hash)
+	if err != nil {
+		return nil, errNotFound
+	}
+
+	return newBlockView(b, true), nil
+}
+
+func (e *Explorer) tx(id string, _ url.Values) (interface{}, error) {
+	txID, err := hex.DecodeString(id)
+	if err != nil || len(txID) == 0 {
+		return nil, errNotFound
+	}
+
+	tx, b, err := e.idx.Transaction(txID)
+	if err == txindex.ErrNotIndexed {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newTxView(tx, b), nil
+}
+
+// address shows the balance of an address and a page of its transactions,
+// the newest first. The page is given by the page query parameter.
+func (e *Explorer) address(address string, query url.Values) (interface{}, error) {
+	pubKeyHash, ok := decodeAddress(address)
+	if !ok {
+		return nil, errNotFound
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 0 {
+		page = 0
+	}
+
+	utxoSet := utxo.UTXOSet{e.bc}
+	balance, err := utxoSet.GetBalance(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	ids, more, err := e.idx.AddressTransactions(pubKeyHash, page*addressPageSize, addressPageSize)
+	if err != nil {
+		return nil, err
+	}
+	view := addressView{
+		Address:      address,
+		Balance:      balance,
+		Page:         page,
+		More:         more,
+		Transactions: []txView{},
+	}
+
+	for _, id := range ids {
+		tx, b, err := e.idx.Transaction(id)
+		// 块被裁剪后交易就没有了
+		if err == txindex.ErrNotIndexed {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		view.Transactions = append(view.Transactions, newTxView(tx, b))
+	}
+
+	return view, nil
+}
+
+// decodeAddress returns the public key hash of a well formed address
+func decodeAddress(address string) ([]byte, bool) {
+	if address == "" || strings.Trim(address, "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz") != "" {
+		return nil, false
+	}
+
+	decoded := wallet.Base58Decode([]byte(address))
+	if len(decoded) != 25 || !wallet.ValidateAddress(address) {
+		return nil, false
+	}
+
+	return decoded[1 : len(decoded)-4], true
+}
+
+func statusOf(err error) int {
+	if err == errNotFound {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func render(w http.ResponseWriter, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+func renderError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusOf(err))
+	templates.ExecuteTemplate(w, "error", err.Error())
+}
+
+var templates = template.Must(template.New("explorer").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+	"dec": func(i int) int { return i - 1 },
+}).Parse(layout))
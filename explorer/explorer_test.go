@@ -0,0 +1,251 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package explorer_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/explorer"
+	"myBitCoin/transaction"
+)
+
+type fixture struct {
+	srv   *httptest.Server
+	bc    *blk.BlockChain
+	from  string
+	to    string
+	tx    *transaction.Transaction
+	block *blk.Block
+}
+
+// newFixture serves the explorer of a chain with a block paying 3 to another
+// address after the genesis block
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	bc, w := chaintest.NewChain(t)
+	f := &fixture{
+		bc:   bc,
+		from: string(w.GetAddress()),
+		to:   string(chaintest.NewWallet(t).GetAddress()),
+	}
+	f.tx = chaintest.NewTx(t, bc, w, f.to, 3)
+	b, err := bc.MineBlock([]*transaction.Transaction{f.tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.block = b
+
+	e, err := explorer.New(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.srv = httptest.NewServer(e)
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+// get returns the status and the body of path, redirects are not followed
+func (f *fixture) get(t *testing.T, path string) (int, string, http.Header) {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(f.srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(body), resp.Header
+}
+
+// getJSON decodes the json of path into v
+func (f *fixture) getJSON(t *testing.T, path string, v interface{}) int {
+	t.Helper()
+
+	status, body, header := f.get(t, path)
+	if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s: content type %q", path, ct)
+	}
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return status
+}
+
+type txJSON struct {
+	ID       string `json:"id"`
+	Block    string `json:"block"`
+	Coinbase bool   `json:"coinbase"`
+	Outputs  []struct {
+		Value   int    `json:"value"`
+		Address string `json:"address"`
+	} `json:"outputs"`
+}
+
+type blockJSON struct {
+	Hash         string   `json:"hash"`
+	Height       int      `json:"height"`
+	PoW          bool     `json:"pow"`
+	TxCount      int      `json:"tx_count"`
+	Transactions []txJSON `json:"transactions"`
+}
+
+func TestAPI(t *testing.T) {
+	f := newFixture(t)
+	hash, txID := hex.EncodeToString(f.block.Hash), hex.EncodeToString(f.tx.ID)
+
+	var blocks []blockJSON
+	if status := f.getJSON(t, "/api/blocks?count=5", &blocks); status != http.StatusOK {
+		t.Fatalf("blocks: status %d", status)
+	}
+	// 新的块在前
+	if len(blocks) != 2 || blocks[0].Hash != hash || blocks[1].Height != 0 {
+		t.Fatalf("blocks %+v", blocks)
+	}
+
+	var b blockJSON
+	if status := f.getJSON(t, "/api/block/"+hash, &b); status != http.StatusOK {
+		t.Fatalf("block: status %d", status)
+	}
+	if b.Height != 1 || !b.PoW || b.TxCount != 1 || len(b.Transactions) != 1 || b.Transactions[0].ID != txID {
+		t.Fatalf("block %+v", b)
+	}
+
+	var tx txJSON
+	if status := f.getJSON(t, "/api/tx/"+txID, &tx); status != http.StatusOK {
+		t.Fatalf("tx: status %d", status)
+	}
+	if tx.Block != hash || tx.Coinbase || len(tx.Outputs) == 0 || tx.Outputs[0].Value != 3 || tx.Outputs[0].Address != f.to {
+		t.Fatalf("tx %+v", tx)
+	}
+
+	var address struct {
+		Balance      int      `json:"balance"`
+		Transactions []txJSON `json:"transactions"`
+	}
+	if status := f.getJSON(t, "/api/address/"+f.to, &address); status != http.StatusOK {
+		t.Fatalf("address: status %d", status)
+	}
+	if address.Balance != 3 || len(address.Transactions) != 1 || address.Transactions[0].ID != txID {
+		t.Fatalf("address %+v", address)
+	}
+
+	var notFound map[string]string
+	for _, path := range []string{"/api/block/zz", "/api/block/00", "/api/tx/" + hash, "/api/address/" + f.to + "x"} {
+		if status := f.getJSON(t, path, &notFound); status != http.StatusNotFound || notFound["error"] == "" {
+			t.Errorf("%s: status %d, %v", path, status, notFound)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	f := newFixture(t)
+	hash, txID := hex.EncodeToString(f.block.Hash), hex.EncodeToString(f.tx.ID)
+
+	for q, want := range map[string]string{hash: "block", txID: "tx", f.to: "address"} {
+		var result map[string]string
+		if status := f.getJSON(t, "/api/search?q="+q, &result); status != http.StatusOK || result["type"] != want || result["id"] != q {
+			t.Errorf("search %s: status %d, %v, want %s", q, status, result, want)
+		}
+
+		status, _, header := f.get(t, "/search?q="+q)
+		if status != http.StatusFound || header.Get("Location") != "/"+want+"/"+q {
+			t.Errorf("search page %s: status %d, location %q", q, status, header.Get("Location"))
+		}
+	}
+
+	var result map[string]string
+	if status := f.getJSON(t, "/api/search?q=nothing", &result); status != http.StatusNotFound {
+		t.Errorf("search of nothing: status %d", status)
+	}
+}
+
+func TestPages(t *testing.T) {
+	f := newFixture(t)
+	hash, txID := hex.EncodeToString(f.block.Hash), hex.EncodeToString(f.tx.ID)
+
+	for path, want := range map[string]string{
+		"/":                  hash,
+		"/block/" + hash:     txID,
+		"/tx/" + txID:        f.to,
+		"/address/" + f.to:   txID,
+		"/address/" + f.from: f.from,
+	} {
+		status, body, header := f.get(t, path)
+		if status != http.StatusOK || !strings.HasPrefix(header.Get("Content-Type"), "text/html") {
+			t.Errorf("%s: status %d, content type %q", path, status, header.Get("Content-Type"))
+		}
+		if !strings.Contains(body, want) {
+			t.Errorf("%s does not show %s", path, want)
+		}
+	}
+
+	for _, path := range []string{"/nothing", "/block/zz", "/tx/" + hash} {
+		if status, _, _ := f.get(t, path); status != http.StatusNotFound {
+			t.Errorf("%s: status %d", path, status)
+		}
+	}
+}
+
+func TestAddressPages(t *testing.T) {
+	f := newFixture(t)
+	// 创世块和这些块的 coinbase，加上付给 to 的交易，一共 27 笔
+	chaintest.MineBlocks(t, f.bc, f.from, 25)
+
+	type page struct {
+		Page         int      `json:"page"`
+		More         bool     `json:"more"`
+		Transactions []txJSON `json:"transactions"`
+	}
+	var first, second page
+	f.getJSON(t, "/api/address/"+f.from, &first)
+	f.getJSON(t, "/api/address/"+f.from+"?page=1", &second)
+	if len(first.Transactions) != 25 || !first.More {
+		t.Fatalf("first page: %d transactions, more %v", len(first.Transactions), first.More)
+	}
+	if second.Page != 1 || len(second.Transactions) != 2 || second.More {
+		t.Fatalf("second page: %d transactions, more %v", len(second.Transactions), second.More)
+	}
+	// 新的交易在前，最后一笔是创世块的 coinbase
+	if last := second.Transactions[1]; !last.Coinbase {
+		t.Fatalf("the last transaction is %s", last.ID)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package explorer
+
+const layout = `
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>myBitCoin explorer</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: left; font-family: monospace; }
+.box { border: 1px solid #ccc; padding: 8px; margin: 8px 0; }
+</style>
+</head>
+<body>
+<h1><a href="/">myBitCoin explorer</a></h1>
+<form action="/search" method="get">
+<input type="text" name="q" size="70" placeholder="block hash, transaction id or address">
+<input type="submit" value="Search">
+</form>
+{{end}}
+
+{{define "footer"}}
+</body>
+</html>
+{{end}}
+
+{{define "tx_body"}}
+<div class="box">
+<p>Transaction <a href="/tx/{{.ID}}">{{.ID}}</a>{{if .Coinbase}} (coinbase){{end}}</p>
+<table>
+<tr><th>Inputs</th><th>Outputs</th></tr>
+<tr>
+<td>
+{{range .Inputs}}
+{{if .Data}}{{.Data}}{{else}}<a href="/address/{{.Address}}">{{.Address}}</a> &lt;- <a href="/tx/{{.TxID}}">{{.TxID}}</a>:{{.Vout}}{{end}}<br>
+{{end}}
+</td>
+<td>
+{{range .Outputs}}
+{{.Index}}: <a href="/address/{{.Address}}">{{.Address}}</a> {{.Value}}<br>
+{{end}}
+</td>
+</tr>
+</table>
+</div>
+{{end}}
+
+{{define "index"}}{{template "header"}}
+<h2>Recent blocks</h2>
+<table>
+<tr><th>Height</th><th>Hash</th><th>Time</th><th>Transactions</th><th>PoW</th></tr>
+{{range .}}
+<tr><td>{{.Height}}</td><td><a href="/block/{{.Hash}}">{{.Hash}}</a></td><td>{{.Time}}</td><td>{{.TxCount}}</td><td>{{.PoW}}</td></tr>
+{{end}}
+</table>
+{{template "footer"}}{{end}}
+
+{{define "block"}}{{template "header"}}
+<h2>Block {{.Height}}</h2>
+<table>
+<tr><th>Hash</th><td>{{.Hash}}</td></tr>
+<tr><th>Previous block</th><td>{{if .PrevHash}}<a href="/block/{{.PrevHash}}">{{.PrevHash}}</a>{{end}}</td></tr>
+<tr><th>Time</th><td>{{.Time}}</td></tr>
+<tr><th>Nonce</th><td>{{.Nonce}}</td></tr>
+<tr><th>PoW</th><td>{{.PoW}}</td></tr>
+<tr><th>Transactions</th><td>{{.TxCount}}</td></tr>
+</table>
+{{range .Transactions}}{{template "tx_body" .}}{{end}}
+{{template "footer"}}{{end}}
+
+{{define "tx"}}{{template "header"}}
+<h2>Transaction</h2>
+<p>Included in block <a href="/block/{{.Block}}">{{.Block}}</a></p>
+{{template "tx_body" .}}
+{{template "footer"}}{{end}}
+
+{{define "address"}}{{template "header"}}
+<h2>Address {{.Address}}</h2>
+<p>Balance: {{.Balance}}</p>
+<h3>Transactions</h3>
+{{range .Transactions}}{{template "tx_body" .}}{{else}}<p>No transactions.</p>{{end}}
+<p>{{if .Page}}<a href="/address/{{.Address}}?page={{dec .Page}}">Newer</a>{{end}}
+{{if .More}}<a href="/address/{{.Address}}?page={{inc .Page}}">Older</a>{{end}}</p>
+{{template "footer"}}{{end}}
+
+{{define "error"}}{{template "header"}}
+<p>{{.}}</p>
+{{template "footer"}}{{end}}
+`
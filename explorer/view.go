@@ -0,0 +1,127 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package explorer
+
+import (
+	"encoding/hex"
+	"time"
+
+	blk "myBitCoin/block"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+type blockView struct {
+	Hash         string   `json:"hash"`
+	PrevHash     string   `json:"prev_hash"`
+	Height       int      `json:"height"`
+	Time         string   `json:"time"`
+	Nonce        int      `json:"nonce"`
+	PoW          bool     `json:"pow"`
+	TxCount      int      `json:"tx_count"`
+	Transactions []txView `json:"transactions,omitempty"`
+}
+
+type txView struct {
+	ID       string       `json:"id"`
+	Block    string       `json:"block,omitempty"`
+	Coinbase bool         `json:"coinbase"`
+	Inputs   []inputView  `json:"inputs"`
+	Outputs  []outputView `json:"outputs"`
+}
+
+type inputView struct {
+	TxID    string `json:"txid,omitempty"`
+	Vout    int    `json:"vout"`
+	Address string `json:"address,omitempty"`
+	Data    string `json:"data,omitempty"`
+}
+
+type outputView struct {
+	Index   int    `json:"index"`
+	Value   int    `json:"value"`
+	Address string `json:"address"`
+}
+
+type addressView struct {
+	Address      string   `json:"address"`
+	Balance      int      `json:"balance"`
+	Page         int      `json:"page"`
+	More         bool     `json:"more"`
+	Transactions []txView `json:"transactions"`
+}
+
+func newBlockView(b *blk.Block, withTxs bool) blockView {
+	view := blockView{
+		Hash:     hex.EncodeToString(b.Hash),
+		PrevHash: hex.EncodeToString(b.PrevHash),
+		Height:   b.Height,
+		Time:     time.Unix(b.TimeStamp, 0).Format(time.RFC3339),
+		Nonce:    b.Nonce,
+		PoW:      blk.NewProofOfWork(b).Validate(),
+		TxCount:  len(b.Transactions),
+	}
+
+	if withTxs {
+		for _, tx := range b.Transactions {
+			view.Transactions = append(view.Transactions, newTxView(tx, nil))
+		}
+	}
+
+	return view
+}
+
+func newTxView(tx *transaction.Transaction, b *blk.Block) txView {
+	view := txView{
+		ID:       hex.EncodeToString(tx.ID),
+		Coinbase: tx.IsCoinbase(),
+	}
+	if b != nil {
+		view.Block = hex.EncodeToString(b.Hash)
+	}
+
+	for _, in := range tx.Vin {
+		if view.Coinbase {
+			view.Inputs = append(view.Inputs, inputView{Vout: in.Vout, Data: string(in.PubKey)})
+			continue
+		}
+
+		view.Inputs = append(view.Inputs, inputView{
+			TxID:    hex.EncodeToString(in.TxID),
+			Vout:    in.Vout,
+			Address: string(wallet.AddressFromPubKeyHash(wallet.HashPubKey(in.PubKey))),
+		})
+	}
+
+	for i, out := range tx.Vout {
+		view.Outputs = append(view.Outputs, outputView{
+			Index:   i,
+			Value:   out.Value,
+			Address: string(wallet.AddressFromPubKeyHash(out.PubKeyHash)),
+		})
+	}
+
+	return view
+}
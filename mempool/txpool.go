@@ -0,0 +1,271 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package mempool
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	blk "myBitCoin/block"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+)
+
+var (
+	ErrCoinbase       = errors.New("coinbase transaction can not be relayed")
+	ErrAlreadyHave    = errors.New("transaction already in the pool")
+	ErrDoubleSpend    = errors.New("output already spent by a transaction in the pool")
+	ErrBadSignature   = transaction.ErrBadSignature
+	ErrBadValue       = transaction.ErrBadValue
+	ErrNegativeFee    = transaction.ErrNegativeFee
+	ErrDuplicateInput = transaction.ErrDuplicateInput
+	ErrWrongKey       = transaction.ErrWrongKey
+	ErrMissingInput   = errors.New("input is spent or does not exist")
+)
+
+// TxDesc is a transaction of the pool with the data needed to mine it
+type TxDesc struct {
+	Tx    *transaction.Transaction
+	Fee   int
+	Size  int
+	Added time.Time
+}
+
+// TxPool holds the transactions accepted by the node which are not mined yet
+type TxPool struct {
+	bc *blk.BlockChain
+
+	mu   sync.RWMutex
+	pool map[string]*TxDesc
+	// "txid:vout" of the outputs spent by the pool -> spending txid
+	spent map[string]string
+
+	callbacksLock sync.RWMutex
+	callbacks     []func(*transaction.Transaction)
+}
+
+func New(bc *blk.BlockChain) *TxPool {
+	p := &TxPool{
+		bc:    bc,
+		pool:  make(map[string]*TxDesc),
+		spent: make(map[string]string),
+	}
+	bc.Subscribe(p.handleChainNotification)
+
+	return p
+}
+
+func outpoint(txID []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txID, vout)
+}
+
+// Subscribe registers a callback called for every accepted transaction
+func (p *TxPool) Subscribe(callback func(*transaction.Transaction)) {
+	p.callbacksLock.Lock()
+	p.callbacks = append(p.callbacks, callback)
+	p.callbacksLock.Unlock()
+}
+
+// ProcessTransaction verifies tx and adds it to the pool
+func (p *TxPool) ProcessTransaction(tx *transaction.Transaction) error {
+	if tx.IsCoinbase() {
+		return ErrCoinbase
+	}
+
+	p.mu.Lock()
+	err := p.maybeAccept(tx)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	p.callbacksLock.RLock()
+	for _, callback := range p.callbacks {
+		callback(tx)
+	}
+	p.callbacksLock.RUnlock()
+
+	return nil
+}
+
+func (p *TxPool) maybeAccept(tx *transaction.Transaction) error {
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := p.pool[id]; ok {
+		return ErrAlreadyHave
+	}
+
+	for _, in := range tx.Vin {
+		if _, ok := p.spent[outpoint(in.TxID, in.Vout)]; ok {
+			return ErrDoubleSpend
+		}
+	}
+
+	fee, prevTxs, err := tx.CheckInputs(p.prevOut)
+	if err != nil {
+		return err
+	}
+	if err := tx.Verify(prevTxs); err != nil {
+		return err
+	}
+
+	p.pool[id] = &TxDesc{
+		Tx:    tx,
+		Fee:   fee,
+		Size:  len(tx.Serialize()),
+		Added: time.Now(),
+	}
+	for _, in := range tx.Vin {
+		p.spent[outpoint(in.TxID, in.Vout)] = id
+	}
+
+	return nil
+}
+
+// prevOut returns the output spent by in, from a transaction of the pool or
+// from the UTXO set
+func (p *TxPool) prevOut(in *transaction.TxInput) (*transaction.TxOutput, error) {
+	if prev, ok := p.pool[hex.EncodeToString(in.TxID)]; ok {
+		if in.Vout >= len(prev.Tx.Vout) {
+			return nil, fmt.Errorf("%w: %x:%d", ErrMissingInput, in.TxID, in.Vout)
+		}
+		return &prev.Tx.Vout[in.Vout], nil
+	}
+
+	// 链上的输入必须还在 UTXO 集里，已经被块花费的输出不能再花
+	coin, err := utxo.UTXOSet{p.bc}.GetTxOut(in.TxID, in.Vout)
+	if err != nil {
+		return nil, err
+	}
+	if coin == nil {
+		return nil, fmt.Errorf("%w: %x:%d", ErrMissingInput, in.TxID, in.Vout)
+	}
+
+	return &coin.TxOutput, nil
+}
+
+// RemoveTransaction removes tx and the transactions spending its outputs from
+// the pool, e.g. when the block mined with it could not be connected
+func (p *TxPool) RemoveTransaction(tx *transaction.Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.pool[hex.EncodeToString(tx.ID)]; ok {
+		p.removeWithDescendants(tx)
+	}
+}
+
+func (p *TxPool) removeTransaction(tx *transaction.Transaction) {
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := p.pool[id]; ok {
+		delete(p.pool, id)
+		for _, in := range tx.Vin {
+			delete(p.spent, outpoint(in.TxID, in.Vout))
+		}
+	}
+
+	// 块中的交易可能花费了池中其他交易已花费的输出
+	for _, in := range tx.Vin {
+		if conflict, ok := p.spent[outpoint(in.TxID, in.Vout)]; ok {
+			p.removeWithDescendants(p.pool[conflict].Tx)
+		}
+	}
+}
+
+// removeWithDescendants removes tx and the transactions of the pool spending
+// its outputs, which can not be mined any more
+func (p *TxPool) removeWithDescendants(tx *transaction.Transaction) {
+	for i := range tx.Vout {
+		if spender, ok := p.spent[outpoint(tx.ID, i)]; ok {
+			p.removeWithDescendants(p.pool[spender].Tx)
+		}
+	}
+
+	p.removeTransaction(tx)
+}
+
+func (p *TxPool) handleChainNotification(n *blk.Notification) {
+	b := n.Data.(*blk.Block)
+
+	switch n.Type {
+	case blk.NTBlockConnected:
+		p.mu.Lock()
+		for _, tx := range b.Transactions {
+			p.removeTransaction(tx)
+		}
+		p.mu.Unlock()
+
+	case blk.NTBlockDisconnected:
+		for _, tx := range b.Transactions {
+			if !tx.IsCoinbase() {
+				p.ProcessTransaction(tx)
+			}
+		}
+	}
+}
+
+// Transactions returns all the transactions in the pool
+func (p *TxPool) Transactions() []*transaction.Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	txs := make([]*transaction.Transaction, 0, len(p.pool))
+	for _, desc := range p.pool {
+		txs = append(txs, desc.Tx)
+	}
+
+	return txs
+}
+
+// TxDescs returns the descriptors of all the transactions in the pool
+func (p *TxPool) TxDescs() []*TxDesc {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	descs := make([]*TxDesc, 0, len(p.pool))
+	for _, desc := range p.pool {
+		descs = append(descs, desc)
+	}
+
+	return descs
+}
+
+// HaveTransaction tells whether the transaction is in the pool
+func (p *TxPool) HaveTransaction(id []byte) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.pool[hex.EncodeToString(id)]
+	return ok
+}
+
+func (p *TxPool) Count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.pool)
+}
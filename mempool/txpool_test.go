@@ -0,0 +1,221 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package mempool_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/mempool"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+// newPool returns the pool of a new chain, the genesis reward is paid to the
+// returned wallet
+func newPool(t *testing.T) (*mempool.TxPool, *blk.BlockChain, *wallet.Wallet) {
+	t.Helper()
+
+	bc, w := chaintest.NewChain(t)
+	return mempool.New(bc), bc, w
+}
+
+func address(w *wallet.Wallet) string {
+	return string(w.GetAddress())
+}
+
+func TestAcceptAndMine(t *testing.T) {
+	pool, bc, w := newPool(t)
+	to := address(chaintest.NewWallet(t))
+
+	var accepted []*transaction.Transaction
+	pool.Subscribe(func(tx *transaction.Transaction) {
+		accepted = append(accepted, tx)
+	})
+
+	tx := chaintest.NewTx(t, bc, w, to, 3)
+	if err := pool.ProcessTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.ProcessTransaction(tx); err != mempool.ErrAlreadyHave {
+		t.Fatalf("got %v, want %v", err, mempool.ErrAlreadyHave)
+	}
+	if len(accepted) != 1 || !pool.HaveTransaction(tx.ID) {
+		t.Fatalf("%d transactions accepted, want 1", len(accepted))
+	}
+
+	if _, err := bc.MineBlock(pool.Transactions()); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Count() != 0 {
+		t.Errorf("the mined transaction is still in the pool")
+	}
+}
+
+func TestRejectSpentOnChain(t *testing.T) {
+	pool, bc, w := newPool(t)
+	to := address(chaintest.NewWallet(t))
+
+	// 两笔交易花费同一个输出，其中一笔已经挖进块
+	tx := chaintest.NewTx(t, bc, w, to, 1)
+	if _, err := bc.MineBlock([]*transaction.Transaction{chaintest.NewTx(t, bc, w, to, 2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := pool.ProcessTransaction(tx)
+	if !errors.Is(err, mempool.ErrMissingInput) {
+		t.Fatalf("got %v, want %v", err, mempool.ErrMissingInput)
+	}
+	if pool.Count() != 0 {
+		t.Errorf("the pool holds %d transactions", pool.Count())
+	}
+}
+
+func TestEvictConflictOnConnect(t *testing.T) {
+	pool, bc, w := newPool(t)
+	to := address(chaintest.NewWallet(t))
+
+	tx := chaintest.NewTx(t, bc, w, to, 1)
+	if err := pool.ProcessTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.ProcessTransaction(chaintest.NewTx(t, bc, w, to, 2)); err != mempool.ErrDoubleSpend {
+		t.Fatalf("got %v, want %v", err, mempool.ErrDoubleSpend)
+	}
+
+	// 块里的交易和池中的交易冲突
+	if _, err := bc.MineBlock([]*transaction.Transaction{chaintest.NewTx(t, bc, w, to, 2)}); err != nil {
+		t.Fatal(err)
+	}
+	if pool.HaveTransaction(tx.ID) {
+		t.Errorf("the conflicting transaction is still in the pool")
+	}
+}
+
+func TestRemoveWithDescendants(t *testing.T) {
+	pool, bc, w := newPool(t)
+	w2 := chaintest.NewWallet(t)
+
+	parent := chaintest.NewTx(t, bc, w, address(w2), transaction.Subsidy)
+	if err := pool.ProcessTransaction(parent); err != nil {
+		t.Fatal(err)
+	}
+	// 花费池中交易的输出，签名用的前序交易不在链上
+	out, err := transaction.NewTxOut(transaction.Subsidy, address(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := &transaction.Transaction{
+		Vin:  []transaction.TxInput{{TxID: parent.ID, Vout: 0, PubKey: w2.PublicKey}},
+		Vout: []transaction.TxOutput{out},
+	}
+	if err := child.SetID(); err != nil {
+		t.Fatal(err)
+	}
+	prevTxs := map[string]transaction.Transaction{hex.EncodeToString(parent.ID): *parent}
+	if err := child.Sign(w2.PrivateKey, prevTxs); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.ProcessTransaction(child); err != nil {
+		t.Fatal(err)
+	}
+
+	pool.RemoveTransaction(parent)
+	if pool.Count() != 0 {
+		t.Errorf("the pool holds %d transactions, the child must go with its parent", pool.Count())
+	}
+}
+
+func TestRejectBadTransactions(t *testing.T) {
+	thief := chaintest.NewWallet(t)
+	outputs := func(t *testing.T, values ...int) []transaction.TxOutput {
+		var outs []transaction.TxOutput
+		for _, v := range values {
+			out, err := transaction.NewTxOut(v, address(thief))
+			if err != nil {
+				t.Fatal(err)
+			}
+			outs = append(outs, out)
+		}
+		return outs
+	}
+
+	// 每个用例都改写一笔花费创世奖励的交易
+	tests := []struct {
+		name   string
+		change func(t *testing.T, tx *transaction.Transaction) *wallet.Wallet
+		want   error
+	}{
+		{"zero output", func(t *testing.T, tx *transaction.Transaction) *wallet.Wallet {
+			tx.Vout = outputs(t, transaction.Subsidy, 0)
+			return nil
+		}, mempool.ErrBadValue},
+		{"negative output", func(t *testing.T, tx *transaction.Transaction) *wallet.Wallet {
+			tx.Vout = outputs(t, -1000, 1000+transaction.Subsidy)
+			return nil
+		}, mempool.ErrBadValue},
+		{"overflow", func(t *testing.T, tx *transaction.Transaction) *wallet.Wallet {
+			tx.Vout = outputs(t, math.MaxInt, 2)
+			return nil
+		}, mempool.ErrBadValue},
+		{"more than inputs", func(t *testing.T, tx *transaction.Transaction) *wallet.Wallet {
+			tx.Vout = outputs(t, transaction.Subsidy+1)
+			return nil
+		}, mempool.ErrNegativeFee},
+		{"duplicate input", func(t *testing.T, tx *transaction.Transaction) *wallet.Wallet {
+			tx.Vin = append(tx.Vin, tx.Vin[0])
+			tx.Vout = outputs(t, 2*transaction.Subsidy)
+			return nil
+		}, mempool.ErrDuplicateInput},
+		{"key of another wallet", func(t *testing.T, tx *transaction.Transaction) *wallet.Wallet {
+			// 用自己的密钥签名，签名本身是有效的
+			tx.Vin[0].PubKey = thief.PublicKey
+			tx.Vout = outputs(t, transaction.Subsidy)
+			return thief
+		}, mempool.ErrWrongKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, bc, w := newPool(t)
+			tx := chaintest.NewTx(t, bc, w, address(thief), transaction.Subsidy)
+			signer := tt.change(t, tx)
+			if signer == nil {
+				signer = w
+			}
+			chaintest.Sign(t, bc, tx, signer)
+
+			if err := pool.ProcessTransaction(tx); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if pool.Count() != 0 {
+				t.Errorf("the pool holds %d transactions", pool.Count())
+			}
+		})
+	}
+}
@@ -29,7 +29,16 @@
 
 package merkle
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+var (
+	ErrIndexOutOfRange = errors.New("merkle: leaf index out of range")
+	ErrNoLeaves        = errors.New("merkle: tree without leaves")
+)
 
 type MerkleNode struct {
 	Right *MerkleNode
@@ -39,6 +48,13 @@ type MerkleNode struct {
 
 type MerkleTree struct {
 	RootNode *MerkleNode
+	leaves   int
+}
+
+// Proof holds the sibling hashes from the leaf level up to the root
+type Proof struct {
+	Index    int
+	Siblings [][]byte
 }
 
 func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
@@ -58,19 +74,25 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	return node
 }
 
-func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
-
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
+// NewMerkleTree builds the tree of the leaves data, there must be at least one
+func NewMerkleTree(data [][]byte) (*MerkleTree, error) {
+	if len(data) == 0 {
+		return nil, ErrNoLeaves
 	}
 
+	var nodes []MerkleNode
+
 	for _, datum := range data {
 		node := NewMerkleNode(nil, nil, datum)
 		nodes = append(nodes, *node)
 	}
 
-	for i := 0; i < len(data)/2; i++ {
+	// 每一层节点数为奇数时复制最后一个节点，直到只剩根节点
+	for {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
 		var newLevel []MerkleNode
 
 		for j := 0; j < len(nodes); j += 2 {
@@ -79,9 +101,66 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		}
 
 		nodes = newLevel
+		if len(nodes) <= 1 {
+			break
+		}
 	}
 
-	mTree := MerkleTree{&nodes[0]}
+	mTree := MerkleTree{&nodes[0], len(data)}
+
+	return &mTree, nil
+}
+
+// Proof returns the path proving that the leaf at index is in the tree
+func (t *MerkleTree) Proof(index int) (*Proof, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, ErrIndexOutOfRange
+	}
+
+	depth := 0
+	for node := t.RootNode; node.Left != nil; node = node.Left {
+		depth++
+	}
+
+	// 从根节点往下走，记录每一层的兄弟节点
+	siblings := make([][]byte, depth)
+	node := t.RootNode
+	for level := depth - 1; level >= 0; level-- {
+		if (index>>uint(level))&1 == 0 {
+			siblings[level] = node.Right.Data
+			node = node.Left
+		} else {
+			siblings[level] = node.Left.Data
+			node = node.Right
+		}
+	}
+
+	return &Proof{index, siblings}, nil
+}
+
+// VerifyProof checks that leaf is the data at proof.Index of the tree with root
+func VerifyProof(root, leaf []byte, proof *Proof) bool {
+	hash := sha256.Sum256(leaf)
+	current := hash[:]
+	index := proof.Index
+
+	for _, sibling := range proof.Siblings {
+		if index&1 == 0 {
+			current = hashPair(current, sibling)
+		} else {
+			current = hashPair(sibling, current)
+		}
+		index >>= 1
+	}
+
+	return index == 0 && bytes.Equal(current, root)
+}
+
+func hashPair(left, right []byte) []byte {
+	data := make([]byte, 0, len(left)+len(right))
+	data = append(data, left...)
+	data = append(data, right...)
+	hash := sha256.Sum256(data)
 
-	return &mTree
+	return hash[:]
 }
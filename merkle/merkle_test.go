@@ -0,0 +1,134 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func leaves(n int) [][]byte {
+	var data [][]byte
+	for i := 0; i < n; i++ {
+		data = append(data, []byte(fmt.Sprintf("tx%d", i)))
+	}
+	return data
+}
+
+func TestEmptyTree(t *testing.T) {
+	if _, err := NewMerkleTree(nil); err != ErrNoLeaves {
+		t.Fatalf("got %v, want %v", err, ErrNoLeaves)
+	}
+}
+
+func TestOneLeaf(t *testing.T) {
+	tree, err := NewMerkleTree(leaves(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 唯一的叶子和自己哈希
+	leaf := LeafHash(leaves(1)[0])
+	if want := hashPair(leaf, leaf); !bytes.Equal(tree.RootNode.Data, want) {
+		t.Errorf("root %x, want %x", tree.RootNode.Data, want)
+	}
+
+	proof, err := tree.Proof(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyProof(tree.RootNode.Data, leaves(1)[0], proof) {
+		t.Error("proof of the leaf does not verify")
+	}
+	if _, err := tree.Proof(1); err != ErrIndexOutOfRange {
+		t.Errorf("got %v, want %v", err, ErrIndexOutOfRange)
+	}
+}
+
+func TestOddLeaves(t *testing.T) {
+	data := leaves(3)
+	tree, err := NewMerkleTree(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第三个叶子被复制补齐
+	h0, h1, h2 := LeafHash(data[0]), LeafHash(data[1]), LeafHash(data[2])
+	want := hashPair(hashPair(h0, h1), hashPair(h2, h2))
+	if !bytes.Equal(tree.RootNode.Data, want) {
+		t.Errorf("root %x, want %x", tree.RootNode.Data, want)
+	}
+}
+
+func TestProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		data := leaves(n)
+		tree, err := NewMerkleTree(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range data {
+			proof, err := tree.Proof(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyProof(tree.RootNode.Data, data[i], proof) {
+				t.Errorf("%d leaves: proof of leaf %d does not verify", n, i)
+			}
+			if VerifyProof(tree.RootNode.Data, []byte("other"), proof) {
+				t.Errorf("%d leaves: proof of leaf %d verifies another leaf", n, i)
+			}
+		}
+	}
+}
+
+func TestPartialTreeRoot(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		data := leaves(n)
+		tree, err := NewMerkleTree(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var hashes [][]byte
+		matches := make([]bool, n)
+		for i, datum := range data {
+			hashes = append(hashes, LeafHash(datum))
+			matches[i] = i%2 == 1
+		}
+		root, matched, _, err := NewPartialMerkleTree(hashes, matches).ExtractMatches()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(root, tree.RootNode.Data) {
+			t.Errorf("%d leaves: partial root %x, want %x", n, root, tree.RootNode.Data)
+		}
+		if len(matched) != n/2 {
+			t.Errorf("%d leaves: %d matches, want %d", n, len(matched), n/2)
+		}
+	}
+}
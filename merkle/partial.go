@@ -0,0 +1,190 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+var ErrBadPartialTree = errors.New("merkle: malformed partial merkle tree")
+
+// PartialMerkleTree is a compact proof that some leaves are in a tree. It keeps
+// only the hashes needed to rebuild the root, and one flag bit per visited node
+// telling whether the subtree under it contains a matched leaf.
+type PartialMerkleTree struct {
+	Total  int
+	Hashes [][]byte
+	Flags  []byte
+}
+
+type partialBuilder struct {
+	leaves  [][]byte
+	matches []bool
+	tree    *PartialMerkleTree
+	bits    int
+}
+
+// LeafHash hashes a datum the same way the leaves of MerkleTree are hashed
+func LeafHash(datum []byte) []byte {
+	hash := sha256.Sum256(datum)
+	return hash[:]
+}
+
+// NewPartialMerkleTree builds the proof for the leaves whose matches entry is true.
+// leafHashes are the hashed leaves, see LeafHash.
+func NewPartialMerkleTree(leafHashes [][]byte, matches []bool) *PartialMerkleTree {
+	b := &partialBuilder{
+		leaves:  leafHashes,
+		matches: matches,
+		tree:    &PartialMerkleTree{Total: len(leafHashes)},
+	}
+	b.traverse(treeHeight(len(leafHashes)), 0)
+
+	return b.tree
+}
+
+// treeHeight 和 NewMerkleTree 一致：只有一个叶子时也会复制出一层
+func treeHeight(total int) int {
+	height := 1
+	for treeWidth(total, height) > 1 {
+		height++
+	}
+
+	return height
+}
+
+func treeWidth(total, height int) int {
+	return (total + (1 << uint(height)) - 1) >> uint(height)
+}
+
+func (b *partialBuilder) hash(height, pos int) []byte {
+	if height == 0 {
+		return b.leaves[pos]
+	}
+
+	left := b.hash(height-1, pos*2)
+	right := left
+	if pos*2+1 < treeWidth(len(b.leaves), height-1) {
+		right = b.hash(height-1, pos*2+1)
+	}
+
+	return hashPair(left, right)
+}
+
+func (b *partialBuilder) traverse(height, pos int) {
+	match := false
+	for p := pos << uint(height); p < (pos+1)<<uint(height) && p < len(b.leaves); p++ {
+		match = match || b.matches[p]
+	}
+	b.addBit(match)
+
+	if height == 0 || !match {
+		b.tree.Hashes = append(b.tree.Hashes, b.hash(height, pos))
+		return
+	}
+
+	b.traverse(height-1, pos*2)
+	if pos*2+1 < treeWidth(len(b.leaves), height-1) {
+		b.traverse(height-1, pos*2+1)
+	}
+}
+
+func (b *partialBuilder) addBit(bit bool) {
+	if b.bits%8 == 0 {
+		b.tree.Flags = append(b.tree.Flags, 0)
+	}
+	if bit {
+		b.tree.Flags[b.bits/8] |= 1 << uint(b.bits%8)
+	}
+	b.bits++
+}
+
+type partialExtractor struct {
+	tree      *PartialMerkleTree
+	bitsUsed  int
+	hashUsed  int
+	matched   [][]byte
+	indexes   []int
+	malformed bool
+}
+
+// ExtractMatches rebuilds the root and returns it with the matched leaf hashes
+// and their positions in the tree.
+func (t *PartialMerkleTree) ExtractMatches() ([]byte, [][]byte, []int, error) {
+	if t.Total <= 0 || len(t.Hashes) > t.Total || len(t.Flags)*8 < len(t.Hashes) {
+		return nil, nil, nil, ErrBadPartialTree
+	}
+
+	e := &partialExtractor{tree: t}
+	root := e.traverse(treeHeight(t.Total), 0)
+
+	// 所有的哈希和标志位都必须被用到
+	if e.malformed || e.hashUsed != len(t.Hashes) || (e.bitsUsed+7)/8 != len(t.Flags) {
+		return nil, nil, nil, ErrBadPartialTree
+	}
+
+	return root, e.matched, e.indexes, nil
+}
+
+func (e *partialExtractor) traverse(height, pos int) []byte {
+	if e.bitsUsed >= len(e.tree.Flags)*8 {
+		e.malformed = true
+		return nil
+	}
+	match := e.tree.Flags[e.bitsUsed/8]&(1<<uint(e.bitsUsed%8)) != 0
+	e.bitsUsed++
+
+	if height == 0 || !match {
+		if e.hashUsed >= len(e.tree.Hashes) {
+			e.malformed = true
+			return nil
+		}
+		hash := e.tree.Hashes[e.hashUsed]
+		e.hashUsed++
+
+		if height == 0 && match {
+			e.matched = append(e.matched, hash)
+			e.indexes = append(e.indexes, pos)
+		}
+		return hash
+	}
+
+	left := e.traverse(height-1, pos*2)
+	right := left
+	if pos*2+1 < treeWidth(e.tree.Total, height-1) {
+		right = e.traverse(height-1, pos*2+1)
+		// 两个不同的子树哈希相同说明在伪造重复的叶子
+		if bytes.Equal(left, right) {
+			e.malformed = true
+		}
+	}
+	if e.malformed {
+		return nil
+	}
+
+	return hashPair(left, right)
+}
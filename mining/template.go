@@ -0,0 +1,218 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package mining
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	blk "myBitCoin/block"
+	"myBitCoin/mempool"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+const (
+	// MaxBlockSize limits the serialized size of the transactions of a block template
+	MaxBlockSize = 1000000
+
+	// 给 coinbase 预留的大小
+	coinbaseReserve = 1000
+)
+
+var ErrBadAddress = errors.New("mining: address is not valid")
+
+// BlockTemplate is a block ready to be solved: only the nonce, and the
+// timestamp if the nonce space is exhausted, remain to be set. The hash is
+// sha256(PrevHash || MerkleRoot || hex(TimeStamp) || hex(Bits) || hex(Nonce)),
+// with the integers in lower case hexadecimal, and must be below Target.
+type BlockTemplate struct {
+	Block      *blk.Block
+	Height     int
+	MerkleRoot []byte
+	Target     []byte
+	Bits       int
+	// Fees of the transactions of Block, 0 for the coinbase
+	Fees          []int
+	TotalFees     int
+	CoinbaseValue int
+}
+
+// BlkTmplGenerator builds block templates on the tip of the chain with the
+// transactions of the mempool
+type BlkTmplGenerator struct {
+	bc     *blk.BlockChain
+	txPool *mempool.TxPool
+}
+
+func NewBlkTmplGenerator(bc *blk.BlockChain, txPool *mempool.TxPool) *BlkTmplGenerator {
+	return &BlkTmplGenerator{bc: bc, txPool: txPool}
+}
+
+// NewBlockTemplate selects the transactions of the mempool by fee rate and
+// builds a block paying the subsidy and the fees to payToAddress
+func (g *BlkTmplGenerator) NewBlockTemplate(payToAddress string) (*BlockTemplate, error) {
+	if !wallet.ValidateAddress(payToAddress) {
+		return nil, ErrBadAddress
+	}
+
+	tip, err := g.bc.GetBlock(g.bc.Tip())
+	if err != nil {
+		return nil, err
+	}
+	height := tip.Height + 1
+
+	descs := selectTransactions(g.txPool.TxDescs(), MaxBlockSize-coinbaseReserve)
+	totalFees := 0
+	for _, desc := range descs {
+		totalFees += desc.Fee
+	}
+
+	coinbase, err := newCoinbase(payToAddress, height, transaction.Subsidy+totalFees)
+	if err != nil {
+		return nil, err
+	}
+	txs := []*transaction.Transaction{coinbase}
+	fees := []int{0}
+	for _, desc := range descs {
+		txs = append(txs, desc.Tx)
+		fees = append(fees, desc.Fee)
+	}
+
+	b := &blk.Block{
+		TimeStamp:    time.Now().Unix(),
+		PrevHash:     tip.Hash,
+		Transactions: txs,
+		Height:       height,
+	}
+
+	return &BlockTemplate{
+		Block:         b,
+		Height:        height,
+		MerkleRoot:    b.HashTransactions(),
+		Target:        blk.Target().Bytes(),
+		Bits:          blk.TargetBits(),
+		Fees:          fees,
+		TotalFees:     totalFees,
+		CoinbaseValue: transaction.Subsidy + totalFees,
+	}, nil
+}
+
+// newCoinbase 把高度写进 coinbase，不同块的 coinbase ID 不会相同
+func newCoinbase(to string, height, value int) (*transaction.Transaction, error) {
+	out, err := transaction.NewTxOut(value, to)
+	if err != nil {
+		return nil, err
+	}
+	data := []byte(fmt.Sprintf("height %d", height))
+	txIn := transaction.TxInput{TxID: []byte{}, Vout: -1, PubKey: data}
+	tx := &transaction.Transaction{
+		Vin:  []transaction.TxInput{txIn},
+		Vout: []transaction.TxOutput{out},
+	}
+	if err := tx.SetID(); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// selectTransactions picks transactions by the fee rate of their package, the
+// transaction with its ancestors still in the mempool, so a child paying a high
+// fee gets its parents mined. The result is in a valid order for a block.
+func selectTransactions(descs []*mempool.TxDesc, maxSize int) []*mempool.TxDesc {
+	byID := make(map[string]*mempool.TxDesc)
+	for _, desc := range descs {
+		byID[hex.EncodeToString(desc.Tx.ID)] = desc
+	}
+	// 手续费率相同时先进池的优先，结果不依赖 map 的顺序
+	sort.Slice(descs, func(i, j int) bool {
+		if !descs[i].Added.Equal(descs[j].Added) {
+			return descs[i].Added.Before(descs[j].Added)
+		}
+		return hex.EncodeToString(descs[i].Tx.ID) < hex.EncodeToString(descs[j].Tx.ID)
+	})
+
+	selected := make(map[string]bool)
+	var result []*mempool.TxDesc
+	size := 0
+
+	for {
+		var best []*mempool.TxDesc
+		var bestFee, bestSize int
+
+		for _, desc := range descs {
+			if selected[hex.EncodeToString(desc.Tx.ID)] {
+				continue
+			}
+
+			pkg := ancestorPackage(desc, byID, selected, make(map[string]bool))
+			fee, pkgSize := 0, 0
+			for _, d := range pkg {
+				fee += d.Fee
+				pkgSize += d.Size
+			}
+			if size+pkgSize > maxSize {
+				continue
+			}
+			// fee/size > bestFee/bestSize
+			if best == nil || fee*bestSize > bestFee*pkgSize {
+				best, bestFee, bestSize = pkg, fee, pkgSize
+			}
+		}
+		if best == nil {
+			break
+		}
+
+		for _, d := range best {
+			selected[hex.EncodeToString(d.Tx.ID)] = true
+			result = append(result, d)
+		}
+		size += bestSize
+	}
+
+	return result
+}
+
+// ancestorPackage returns desc after its ancestors which are not selected
+// yet, parents first
+func ancestorPackage(desc *mempool.TxDesc, byID map[string]*mempool.TxDesc, selected, visited map[string]bool) []*mempool.TxDesc {
+	var pkg []*mempool.TxDesc
+
+	for _, in := range desc.Tx.Vin {
+		id := hex.EncodeToString(in.TxID)
+		parent, ok := byID[id]
+		if !ok || selected[id] || visited[id] {
+			continue
+		}
+		visited[id] = true
+		pkg = append(pkg, ancestorPackage(parent, byID, selected, visited)...)
+	}
+
+	return append(pkg, desc)
+}
@@ -0,0 +1,127 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package mining_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/mempool"
+	"myBitCoin/mining"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+// spend pays output vout of prev, which belongs to w, back to w less fee
+func spend(t *testing.T, w *wallet.Wallet, prev *transaction.Transaction, vout, fee int) *transaction.Transaction {
+	t.Helper()
+
+	out, err := transaction.NewTxOut(prev.Vout[vout].Value-fee, string(w.GetAddress()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &transaction.Transaction{
+		Vin:  []transaction.TxInput{{TxID: prev.ID, Vout: vout, PubKey: w.PublicKey}},
+		Vout: []transaction.TxOutput{out},
+	}
+	if err := tx.SetID(); err != nil {
+		t.Fatal(err)
+	}
+	prevTxs := map[string]transaction.Transaction{hex.EncodeToString(prev.ID): *prev}
+	if err := tx.Sign(w.PrivateKey, prevTxs); err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+func TestFeeSelection(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	blocks := chaintest.MineBlocks(t, bc, string(w.GetAddress()), 3)
+	pool := mempool.New(bc)
+
+	// 父交易手续费低，但和子交易一起的费率比 a 高
+	a := spend(t, w, blocks[0].Transactions[0], 0, 3)
+	parent := spend(t, w, blocks[1].Transactions[0], 0, 1)
+	child := spend(t, w, parent, 0, 8)
+	b := spend(t, w, blocks[2].Transactions[0], 0, 2)
+	for _, tx := range []*transaction.Transaction{b, parent, child, a} {
+		if err := pool.ProcessTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gen := mining.NewBlkTmplGenerator(bc, pool)
+	tmpl, err := gen.NewBlockTemplate(string(w.GetAddress()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*transaction.Transaction{parent, child, a, b}
+	txs := tmpl.Block.Transactions
+	if len(txs) != len(want)+1 || !txs[0].IsCoinbase() {
+		t.Fatalf("%d transactions in the template", len(txs))
+	}
+	for i, tx := range want {
+		if !bytes.Equal(txs[i+1].ID, tx.ID) {
+			t.Fatalf("transaction %d is %x, want %x", i+1, txs[i+1].ID, tx.ID)
+		}
+	}
+	if fees := tmpl.Fees; len(fees) != 5 || fees[0] != 0 || fees[1] != 1 || fees[2] != 8 || fees[3] != 3 || fees[4] != 2 {
+		t.Fatalf("fees %v", fees)
+	}
+	if tmpl.TotalFees != 14 || tmpl.CoinbaseValue != transaction.Subsidy+14 || txs[0].Vout[0].Value != tmpl.CoinbaseValue {
+		t.Fatalf("fees %d, coinbase value %d", tmpl.TotalFees, tmpl.CoinbaseValue)
+	}
+	if tmpl.Height != 4 || !bytes.Equal(tmpl.Block.PrevHash, bc.Tip()) {
+		t.Fatalf("template at height %d", tmpl.Height)
+	}
+	if !bytes.Equal(tmpl.MerkleRoot, tmpl.Block.HashTransactions()) || tmpl.Target == nil {
+		t.Fatal("the merkle root or the target is wrong")
+	}
+
+	// 解出来的模板是有效的块
+	if err := (&blk.Miner{}).Mine(context.Background(), tmpl.Block); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AcceptBlock(tmpl.Block); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Count() != 0 {
+		t.Fatalf("%d transactions left in the pool", pool.Count())
+	}
+}
+
+func TestTemplateBadAddress(t *testing.T) {
+	bc, _ := chaintest.NewChain(t)
+	gen := mining.NewBlkTmplGenerator(bc, mempool.New(bc))
+	if _, err := gen.NewBlockTemplate("not an address"); err != mining.ErrBadAddress {
+		t.Fatalf("got %v, want %v", err, mining.ErrBadAddress)
+	}
+}
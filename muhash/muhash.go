@@ -0,0 +1,152 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package muhash implements MuHash3072, a hash of a set of elements which can
+// be updated when an element is added or removed, without the other elements.
+// Each element is mapped to a number modulo the prime 2^3072 - 1103717, the
+// set is the product of the numbers of its elements.
+package muhash
+
+import (
+	"crypto/sha256"
+	"errors"
+	"log"
+	"math/big"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// ElementSize is the size of the numbers, and of a serialized MuHash
+const ElementSize = 384
+
+var ErrBadState = errors.New("muhash: bad serialized state")
+
+var prime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 3072), big.NewInt(1103717))
+
+// MuHash is the hash of a set. The removed elements are multiplied into a
+// denominator, so that only Finalize and Serialize compute an inverse.
+type MuHash struct {
+	numerator   *big.Int
+	denominator *big.Int
+}
+
+// New returns the MuHash of the empty set
+func New() *MuHash {
+	return &MuHash{big.NewInt(1), big.NewInt(1)}
+}
+
+// Deserialize restores a MuHash written by Serialize
+func Deserialize(data []byte) (*MuHash, error) {
+	if len(data) != ElementSize {
+		return nil, ErrBadState
+	}
+
+	n := fromLittleEndian(data)
+	if n.Sign() == 0 || n.Cmp(prime) >= 0 {
+		return nil, ErrBadState
+	}
+
+	return &MuHash{n, big.NewInt(1)}, nil
+}
+
+// toNumber 用 sha256(data) 作 ChaCha20 的密钥，取 384 字节密钥流作为小端整数
+func toNumber(data []byte) *big.Int {
+	key := sha256.Sum256(data)
+	c, err := chacha20.NewUnauthenticatedCipher(key[:], make([]byte, chacha20.NonceSize))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var stream [ElementSize]byte
+	c.XORKeyStream(stream[:], stream[:])
+
+	n := fromLittleEndian(stream[:])
+	return n.Mod(n, prime)
+}
+
+func fromLittleEndian(data []byte) *big.Int {
+	be := make([]byte, len(data))
+	for i, b := range data {
+		be[len(data)-1-i] = b
+	}
+
+	return new(big.Int).SetBytes(be)
+}
+
+func toLittleEndian(n *big.Int) []byte {
+	be := n.FillBytes(make([]byte, ElementSize))
+	le := make([]byte, ElementSize)
+	for i, b := range be {
+		le[ElementSize-1-i] = b
+	}
+
+	return le
+}
+
+// Insert adds an element to the set
+func (m *MuHash) Insert(data []byte) {
+	m.numerator.Mul(m.numerator, toNumber(data))
+	m.numerator.Mod(m.numerator, prime)
+}
+
+// Remove removes an element from the set. Removing an element which was not
+// inserted is not detected, the hash is then the one of no set.
+func (m *MuHash) Remove(data []byte) {
+	m.denominator.Mul(m.denominator, toNumber(data))
+	m.denominator.Mod(m.denominator, prime)
+}
+
+// Combine adds the elements of other to the set
+func (m *MuHash) Combine(other *MuHash) {
+	m.numerator.Mul(m.numerator, other.numerator)
+	m.numerator.Mod(m.numerator, prime)
+	m.denominator.Mul(m.denominator, other.denominator)
+	m.denominator.Mod(m.denominator, prime)
+}
+
+// normalize 把分母除掉，之后分母为 1
+func (m *MuHash) normalize() {
+	if m.denominator.Cmp(big.NewInt(1)) == 0 {
+		return
+	}
+
+	inv := new(big.Int).ModInverse(m.denominator, prime)
+	m.numerator.Mul(m.numerator, inv)
+	m.numerator.Mod(m.numerator, prime)
+	m.denominator.SetInt64(1)
+}
+
+// Serialize returns the state of the hash, which can still be updated after
+// Deserialize
+func (m *MuHash) Serialize() []byte {
+	m.normalize()
+	return toLittleEndian(m.numerator)
+}
+
+// Finalize returns the 32 byte hash of the set. The same set gives the same
+// hash whatever the order of Insert and Remove.
+func (m *MuHash) Finalize() []byte {
+	hash := sha256.Sum256(m.Serialize())
+	return hash[:]
+}
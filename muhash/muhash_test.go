@@ -0,0 +1,132 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package muhash_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"myBitCoin/muhash"
+)
+
+// element 和 Bitcoin Core 测试中的 FromInt 一样：第一个字节是 i 的 32 字节
+func element(i byte) []byte {
+	data := make([]byte, 32)
+	data[0] = i
+	return data
+}
+
+// Bitcoin Core 的测试向量，它按小端显示 uint256
+func TestVector(t *testing.T) {
+	m := muhash.New()
+	m.Insert(element(0))
+	m.Insert(element(1))
+	m.Remove(element(2))
+
+	hash := m.Finalize()
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	if got := hex.EncodeToString(hash); got != "10d312b100cbd32ada024a6646e40d3482fcff103668d2625f10002a607d5863" {
+		t.Fatalf("hash %s", got)
+	}
+}
+
+func TestOrderIndependence(t *testing.T) {
+	var elements [][]byte
+	for i := 0; i < 20; i++ {
+		elements = append(elements, []byte(fmt.Sprintf("output %d", i)))
+	}
+
+	want := muhash.New()
+	for _, e := range elements {
+		want.Insert(e)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 5; round++ {
+		r.Shuffle(len(elements), func(i, j int) { elements[i], elements[j] = elements[j], elements[i] })
+
+		// 多加一个元素再去掉，和顺序一样不影响结果
+		m := muhash.New()
+		m.Insert([]byte("spent"))
+		for _, e := range elements {
+			m.Insert(e)
+		}
+		m.Remove([]byte("spent"))
+		if !bytes.Equal(m.Finalize(), want.Finalize()) {
+			t.Fatalf("round %d: the hash depends on the order", round)
+		}
+	}
+
+	other := muhash.New()
+	for _, e := range elements[1:] {
+		other.Insert(e)
+	}
+	if bytes.Equal(other.Finalize(), want.Finalize()) {
+		t.Fatal("a smaller set has the same hash")
+	}
+}
+
+func TestCombineAndSerialize(t *testing.T) {
+	a, b, all := muhash.New(), muhash.New(), muhash.New()
+	for i := byte(0); i < 10; i++ {
+		all.Insert(element(i))
+		if i%2 == 0 {
+			a.Insert(element(i))
+		} else {
+			b.Insert(element(i))
+		}
+	}
+	b.Insert(element(100))
+	b.Remove(element(100))
+	a.Combine(b)
+	if !bytes.Equal(a.Finalize(), all.Finalize()) {
+		t.Fatal("the combined hash differs")
+	}
+
+	// 反序列化之后还能继续更新
+	m, err := muhash.Deserialize(all.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Remove(element(9))
+	all.Remove(element(9))
+	if !bytes.Equal(m.Finalize(), all.Finalize()) {
+		t.Fatal("the deserialized hash differs")
+	}
+
+	if bytes.Equal(muhash.New().Finalize(), m.Finalize()) {
+		t.Fatal("the empty set has the hash of a set")
+	}
+	for _, data := range [][]byte{nil, make([]byte, muhash.ElementSize), bytes.Repeat([]byte{0xff}, muhash.ElementSize)} {
+		if _, err := muhash.Deserialize(data); err != muhash.ErrBadState {
+			t.Errorf("state of %d bytes: %v", len(data), err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	blk "myBitCoin/block"
+	"myBitCoin/config"
+	"myBitCoin/explorer"
+	"myBitCoin/netsync"
+	"myBitCoin/node"
+	"myBitCoin/storage"
+	"myBitCoin/stratum"
+	"myBitCoin/utxo"
+)
+
+func main() {
+	explorerListen := flag.String("explorer", "", "Serve the block explorer on this address, e.g. :8080")
+	listen := flag.String("listen", "", "Serve other nodes and light clients on this tcp address, e.g. :9333")
+	wsListen := flag.String("wslisten", "", "Serve websocket notifications on ws://ADDR/ws")
+	wsOrigins := flag.String("wsorigins", "", "Comma separated origins of the web pages allowed to use the websocket besides its own, * allows all")
+	connect := flag.String("connect", "", "Sync from these comma separated peer addresses before serving")
+	threads := flag.Int("threads", 0, "Number of mining goroutines, one per cpu when 0")
+	stratumListen := flag.String("stratum", "", "Serve Stratum pool miners on this tcp address, e.g. :3333")
+	payTo := flag.String("payto", "", "Address receiving the rewards of the blocks found by the pool")
+	stratumWorkers := flag.String("stratumworkers", "", "Comma separated NAME:PASSWORD of the pool miners allowed to mine")
+	shareDifficulty := flag.Float64("sharediff", 1, "Difficulty of the shares of the pool miners")
+	prune := flag.Int64("prune", 0, "Delete the oldest block bodies to keep them below this many MB, 0 keeps all blocks")
+	dbEngine := flag.String("dbengine", storage.Bolt, "Storage engine of a chain created by -connect: bolt, leveldb or memory")
+	dbCache := flag.Int("dbcache", utxo.DefaultCacheSize>>20, "Size of the UTXO cache in MB")
+	assumeValid := flag.String("assumevalid", hex.EncodeToString(blk.AssumeValid), "Don't verify the signatures of the ancestors of this block while syncing, 0 verifies all of them")
+	checkpoints := flag.String("checkpoint", "", "Comma separated HEIGHT:HASH blocks of the chain of the network, forks below them are refused")
+	rpcUser := flag.String("rpcuser", "", "User the cli logs in as, see -rpcpassword")
+	rpcPassword := flag.String("rpcpassword", "", "Password of the cli, any local user can use the node when it is empty")
+	config.AddFlags(flag.CommandLine)
+	flag.Parse()
+
+	// 命令行 > 环境变量 > 配置文件 > 默认值
+	cfg, err := config.Load(flag.CommandLine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Network.Apply()
+	nodeID := cfg.NodeDir
+
+	blk.DefaultMiner.Threads = *threads
+	utxo.DefaultCacheSize = *dbCache << 20
+	blk.DBEngine = *dbEngine
+	if err := blk.SetAssumeValid(*assumeValid); err != nil {
+		log.Fatal(err)
+	}
+	if err := blk.AddCheckpoints(*checkpoints); err != nil {
+		log.Fatal(err)
+	}
+	blk.DefaultMiner.HashRate = func(hashesPerSec float64) {
+		log.Printf("mining at %.0f hashes/s", hashesPerSec)
+	}
+
+	opts := []node.Option{
+		node.WithDataDir(nodeID),
+		node.WithRPC(),
+		node.WithRPCAuth(*rpcUser, *rpcPassword),
+		node.WithPruneTarget(*prune << 20),
+		node.WithSyncProgress(func(p *netsync.Progress) {
+			log.Printf("sync: headers %d, blocks %d, peers %d", p.HeaderHeight, p.BlockHeight, p.Peers)
+		}),
+	}
+	if *connect != "" {
+		opts = append(opts, node.WithPeers(strings.Split(*connect, ",")...))
+	}
+	if *listen != "" {
+		opts = append(opts, node.WithListen(*listen))
+	}
+	n, err := node.New(opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// 后台服务出错时取消 ctx，节点停止后再报告错误退出
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if err := n.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	// 启动之后退出前先停止节点，把 UTXO 缓存写回
+	fatal := func(err error) {
+		n.Stop()
+		log.Fatal(err)
+	}
+	bc, err := n.Chain()
+	if err != nil {
+		fatal(err)
+	}
+
+	if *explorerListen != "" {
+		e, err := explorer.New(bc)
+		if err != nil {
+			fatal(err)
+		}
+		go func() {
+			cancel(http.ListenAndServe(*explorerListen, e))
+		}()
+	}
+
+	if *wsListen != "" {
+		notifier, err := n.Notifier()
+		if err != nil {
+			fatal(err)
+		}
+		if *wsOrigins != "" {
+			notifier.SetAllowedOrigins(strings.Split(*wsOrigins, ","))
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/ws", notifier)
+		go func() {
+			cancel(http.ListenAndServe(*wsListen, mux))
+		}()
+	}
+
+	if *stratumListen != "" {
+		workers := make(map[string]string)
+		for _, w := range strings.Split(*stratumWorkers, ",") {
+			if name, password, ok := strings.Cut(w, ":"); ok && name != "" {
+				workers[name] = password
+			}
+		}
+		generator, err := n.TemplateGenerator()
+		if err != nil {
+			fatal(err)
+		}
+		pool, err := stratum.NewServer(stratum.Config{
+			Addr:            *stratumListen,
+			PayToAddress:    *payTo,
+			ShareDifficulty: *shareDifficulty,
+			Workers:         workers,
+			Generator:       generator,
+			Chain:           bc,
+			SubmitBlock:     n.Server().ProcessBlock,
+		})
+		if err == nil {
+			err = pool.Listen()
+		}
+		if err != nil {
+			fatal(err)
+		}
+		go pool.Serve()
+		defer pool.Close()
+	}
+
+	fmt.Printf("mybitcoind started, network: %s, node: %s\n", cfg.Network.Name, nodeID)
+	// Stop 把 UTXO 缓存写回后才关闭 Done
+	<-n.Done()
+	if err := context.Cause(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+	fmt.Println("mybitcoind stopped")
+}
@@ -0,0 +1,282 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package netsync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+
+	blk "myBitCoin/block"
+	"myBitCoin/storage"
+)
+
+const (
+	headersBucket = "syncheaders" // hash -> header, "l" -> hash of the best header
+	heightsBucket = "syncheights" // height -> hash of the header on the best header chain
+)
+
+var (
+	ErrUnknownHeader = errors.New("netsync: headers do not connect to a known header")
+	ErrDeepFork      = errors.New("netsync: headers fork below the chain tip")
+
+	// errNotBetter 对方的头链不比已知的长
+	errNotBetter = errors.New("netsync: headers are not on a longer chain")
+)
+
+// HeaderStore keeps the validated headers of the best known chain in the
+// chain database. Block bodies are only downloaded for headers in the store.
+type HeaderStore struct {
+	db storage.DB
+}
+
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+// NewHeaderStore opens the store and adds the headers of the blocks of bc it
+// does not have yet
+func NewHeaderStore(bc *blk.BlockChain) (*HeaderStore, error) {
+	s := &HeaderStore{db: bc.DB}
+
+	err := s.db.Update(func(tx storage.Tx) error {
+		for _, name := range []string{headersBucket, heightsBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, s.catchUp(bc)
+}
+
+// catchUp 把本地挖出的块加进来，链上的块总是以链为准
+func (s *HeaderStore) catchUp(bc *blk.BlockChain) error {
+	var missing []*blk.BlockHeader
+
+	bci := bc.Iterator()
+	for {
+		b, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		if known := s.HeaderAt(b.Height); known != nil && bytes.Equal(known.Hash, b.Hash) {
+			break
+		}
+		missing = append(missing, b.Header())
+
+		if len(b.PrevHash) == 0 {
+			break
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return s.db.Update(func(tx storage.Tx) error {
+		headersB := tx.Bucket([]byte(headersBucket))
+		heightsB := tx.Bucket([]byte(heightsBucket))
+
+		for _, h := range missing {
+			if err := headersB.Put(h.Hash, h.Serialize()); err != nil {
+				return err
+			}
+			if err := heightsB.Put(heightKey(h.Height), h.Hash); err != nil {
+				return err
+			}
+		}
+
+		tip := missing[0]
+		if err := truncate(heightsB, tip.Height); err != nil {
+			return err
+		}
+		return headersB.Put([]byte("l"), tip.Hash)
+	})
+}
+
+// truncate removes the heights above height from the best header chain
+func truncate(heightsB storage.Bucket, height int) error {
+	var stale [][]byte
+	c := heightsB.Cursor()
+	for k, _ := c.Seek(heightKey(height + 1)); k != nil; k, _ = c.Next() {
+		stale = append(stale, append([]byte{}, k...))
+	}
+
+	for _, k := range stale {
+		if err := heightsB.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func header(headersB storage.Bucket, hash []byte) *blk.BlockHeader {
+	data := headersB.Get(hash)
+	if data == nil {
+		return nil
+	}
+
+	// 解不开的头当作没有，再从节点下载一次
+	h, err := blk.DeserializeHeader(data)
+	if err != nil {
+		log.Printf("netsync: header %x: %v", hash, err)
+	}
+
+	return h
+}
+
+// Tip returns the last header of the best header chain
+func (s *HeaderStore) Tip() *blk.BlockHeader {
+	var tip *blk.BlockHeader
+
+	s.db.View(func(tx storage.Tx) error {
+		headersB := tx.Bucket([]byte(headersBucket))
+		tip = header(headersB, headersB.Get([]byte("l")))
+		return nil
+	})
+
+	return tip
+}
+
+// HeaderAt returns the header at height on the best header chain, nil when
+// the chain is shorter
+func (s *HeaderStore) HeaderAt(height int) *blk.BlockHeader {
+	var h *blk.BlockHeader
+
+	s.db.View(func(tx storage.Tx) error {
+		hash := tx.Bucket([]byte(heightsBucket)).Get(heightKey(height))
+		if hash != nil {
+			h = header(tx.Bucket([]byte(headersBucket)), hash)
+		}
+		return nil
+	})
+
+	return h
+}
+
+// AssumeValidHeight returns the height of blk.AssumeValid when it is on the
+// best header chain, or -1. The blocks up to this height are its ancestors.
+func (s *HeaderStore) AssumeValidHeight() int {
+	if len(blk.AssumeValid) == 0 {
+		return -1
+	}
+
+	height := -1
+	s.db.View(func(tx storage.Tx) error {
+		h := header(tx.Bucket([]byte(headersBucket)), blk.AssumeValid)
+		if h == nil {
+			return nil
+		}
+		// 不在最长的头链上的块不能假定有效
+		if bytes.Equal(tx.Bucket([]byte(heightsBucket)).Get(heightKey(h.Height)), h.Hash) {
+			height = h.Height
+		}
+		return nil
+	})
+
+	return height
+}
+
+// Locator returns the block locator of the best header chain
+func (s *HeaderStore) Locator() [][]byte {
+	var locator [][]byte
+
+	s.db.View(func(tx storage.Tx) error {
+		heightsB := tx.Bucket([]byte(heightsBucket))
+		tip := header(tx.Bucket([]byte(headersBucket)), tx.Bucket([]byte(headersBucket)).Get([]byte("l")))
+
+		locator = blk.BlockLocator(tip.Height, func(height int) []byte {
+			return append([]byte{}, heightsB.Get(heightKey(height))...)
+		})
+		return nil
+	})
+
+	return locator
+}
+
+// Connect validates headers, in height order, and adds them to the store.
+// They must connect to a known header, and when they fork from the best header
+// chain they must make a longer chain forking above chainHeight, the height
+// of the last downloaded block, and above the last checkpoint.
+func (s *HeaderStore) Connect(headers []*blk.BlockHeader, chainHeight int) error {
+	return s.db.Update(func(tx storage.Tx) error {
+		headersB := tx.Bucket([]byte(headersBucket))
+		heightsB := tx.Bucket([]byte(heightsBucket))
+
+		prev := header(headersB, headers[0].PrevHash)
+		if prev == nil {
+			return ErrUnknownHeader
+		}
+		for _, h := range headers {
+			if err := h.CheckProofOfWork(); err != nil {
+				return err
+			}
+			if err := h.CheckConnects(prev); err != nil {
+				return err
+			}
+			if err := h.CheckCheckpoint(); err != nil {
+				return err
+			}
+			prev = h
+		}
+
+		tip := header(headersB, headersB.Get([]byte("l")))
+		last := headers[len(headers)-1]
+		if !bytes.Equal(headers[0].PrevHash, tip.Hash) {
+			if headers[0].Height <= blk.LastCheckpoint() {
+				return blk.ErrForkBelowCheckpoint
+			}
+			if last.Height <= tip.Height {
+				return errNotBetter
+			}
+			// 暂不支持回滚已经下载的块
+			if headers[0].Height <= chainHeight {
+				return ErrDeepFork
+			}
+			if err := truncate(heightsB, headers[0].Height-1); err != nil {
+				return err
+			}
+		}
+
+		for _, h := range headers {
+			if err := headersB.Put(h.Hash, h.Serialize()); err != nil {
+				return err
+			}
+			if err := heightsB.Put(heightKey(h.Height), h.Hash); err != nil {
+				return err
+			}
+		}
+
+		return headersB.Put([]byte("l"), last.Hash)
+	})
+}
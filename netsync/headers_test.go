@@ -0,0 +1,134 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package netsync_test
+
+import (
+	"fmt"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/netsync"
+	"myBitCoin/transaction"
+)
+
+// newChain returns a regtest chain with blocks up to height
+func newChain(t *testing.T, height int) (*blk.BlockChain, string) {
+	t.Helper()
+
+	bc, w := chaintest.NewChain(t)
+	address := string(w.GetAddress())
+	chaintest.MineBlocks(t, bc, address, height)
+
+	return bc, address
+}
+
+// fork mines the headers of a chain forking after the block at height and
+// ending at tip
+func fork(t *testing.T, bc *blk.BlockChain, address string, height, tip int) []*blk.BlockHeader {
+	t.Helper()
+
+	prev, err := bc.HashAtHeight(height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var headers []*blk.BlockHeader
+	for h := height + 1; h <= tip; h++ {
+		coinbase, err := transaction.NewCoinbaseTx(address, fmt.Sprintf("fork %d", h))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := blk.NewBlock([]*transaction.Transaction{coinbase}, prev, h)
+		headers = append(headers, b.Header())
+		prev = b.Hash
+	}
+
+	return headers
+}
+
+func TestForkBelowCheckpoint(t *testing.T) {
+	bc, address := newChain(t, 4)
+	store, err := netsync.NewHeaderStore(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := fork(t, bc, address, 1, 6)
+
+	checkpoint, err := bc.HashAtHeight(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { blk.Checkpoints = nil })
+	if err := blk.AddCheckpoints(fmt.Sprintf("3:%x", checkpoint)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 分叉的第一批头还没到检查点，整条分叉在检查点的高度上对不上
+	if err := store.Connect(headers[:1], 1); err != blk.ErrForkBelowCheckpoint {
+		t.Fatalf("fork below the checkpoint: %v", err)
+	}
+	if err := store.Connect(headers, 1); err != blk.ErrCheckpointMismatch {
+		t.Fatalf("longer fork below the checkpoint: %v", err)
+	}
+	if tip := store.Tip(); tip.Height != 4 {
+		t.Fatalf("header tip moved to height %d", tip.Height)
+	}
+
+	// 分叉点在检查点之上时接受
+	blk.Checkpoints = nil
+	if err := blk.AddCheckpoints(fmt.Sprintf("1:%x", headers[0].PrevHash)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Connect(headers, 1); err != nil {
+		t.Fatalf("fork above the checkpoint: %v", err)
+	}
+	if tip := store.Tip(); tip.Height != 6 {
+		t.Fatalf("header tip is at height %d, want 6", tip.Height)
+	}
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	bc, address := newChain(t, 2)
+	store, err := netsync.NewHeaderStore(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := fork(t, bc, address, 2, 4)
+
+	t.Cleanup(func() { blk.Checkpoints = nil })
+	if err := blk.AddCheckpoints(fmt.Sprintf("4:%x,3:%x", []byte("other block"), headers[0].Hash)); err != nil {
+		t.Fatal(err)
+	}
+	if len(blk.Checkpoints) != 2 || blk.Checkpoints[0].Height != 3 {
+		t.Fatalf("checkpoints are not in height order: %v", blk.Checkpoints)
+	}
+
+	if err := store.Connect(headers, 2); err != blk.ErrCheckpointMismatch {
+		t.Fatalf("header not matching the checkpoint: %v", err)
+	}
+	if err := blk.AddCheckpoints("3:zz"); err == nil {
+		t.Fatal("bad checkpoint accepted")
+	}
+}
@@ -0,0 +1,465 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package netsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"time"
+
+	blk "myBitCoin/block"
+	"myBitCoin/daemon"
+	"myBitCoin/utxo"
+)
+
+const (
+	defaultStallTimeout = 30 * time.Second
+
+	// 已下载但还没接到链上的块最多这么多个
+	maxBlocksInFlight = 128
+
+	progressInterval = time.Second
+)
+
+var (
+	ErrNoPeers  = errors.New("netsync: no peer is connected")
+	ErrStalled  = errors.New("netsync: peer stalled")
+	ErrBadBlock = errors.New("netsync: block does not match its header")
+	// ErrPrunedPeers is returned when the blocks to download are pruned by all the peers
+	ErrPrunedPeers = errors.New("netsync: the blocks are pruned by every peer")
+)
+
+type Config struct {
+	// Peers are the tcp addresses of the full nodes, see mybitcoind -listen
+	Peers []string
+	// StallTimeout is how long a peer may take to answer a request before it
+	// is disconnected and its blocks are requested from the other peers
+	StallTimeout time.Duration
+	// Progress is called at most once per progressInterval while syncing
+	Progress func(*Progress)
+	// PruneTarget enables pruning of the synced chain, see BlockChain.SetPruneTarget
+	PruneTarget int64
+}
+
+// Progress reports how far the sync is
+type Progress struct {
+	HeaderHeight int
+	BlockHeight  int
+	Peers        int
+}
+
+type peer struct {
+	addr    string
+	client  *rpc.Client
+	version daemon.NodeVersion
+}
+
+// canServe 裁剪过的节点只能提供 PruneHeight 以上的块
+func (p *peer) canServe(height int) bool {
+	return p.version.Services&daemon.SFNodeNetwork != 0 || height >= p.version.PruneHeight
+}
+
+// SyncManager downloads the headers of the best chain of its peers first, and
+// then the block bodies from all peers in parallel
+type SyncManager struct {
+	cfg        Config
+	peers      []*peer
+	lastReport time.Time
+}
+
+func New(cfg Config) *SyncManager {
+	if cfg.StallTimeout <= 0 {
+		cfg.StallTimeout = defaultStallTimeout
+	}
+
+	return &SyncManager{cfg: cfg}
+}
+
+// Connect dials the peers, the ones that can not be reached are skipped
+func (m *SyncManager) Connect(ctx context.Context) error {
+	var dialer net.Dialer
+	for _, addr := range m.cfg.Peers {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("netsync: %s: %v", addr, err)
+			continue
+		}
+
+		client := rpc.NewClient(conn)
+		p := &peer{addr: addr, client: client}
+		if err := p.call(ctx, "Peer.Version", struct{}{}, &p.version, m.cfg.StallTimeout); err != nil {
+			client.Close()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("netsync: %s: %v", addr, err)
+			continue
+		}
+		m.peers = append(m.peers, p)
+	}
+
+	if len(m.peers) == 0 {
+		return ErrNoPeers
+	}
+	return nil
+}
+
+func (m *SyncManager) Close() {
+	for _, p := range m.peers {
+		p.client.Close()
+	}
+	m.peers = nil
+}
+
+func (m *SyncManager) dropPeer(p *peer, err error) {
+	log.Printf("netsync: disconnecting %s: %v", p.addr, err)
+	p.client.Close()
+
+	for i := range m.peers {
+		if m.peers[i] == p {
+			m.peers = append(m.peers[:i], m.peers[i+1:]...)
+			break
+		}
+	}
+}
+
+// call 超时的请求当作对方卡住了，ctx 取消时马上返回
+func (p *peer) call(ctx context.Context, method string, args, reply interface{}, timeout time.Duration) error {
+	call := p.client.Go(method, args, reply, make(chan *rpc.Call, 1))
+
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(timeout):
+		return ErrStalled
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *SyncManager) report(store *HeaderStore, blockHeight int, force bool) {
+	if m.cfg.Progress == nil || !force && time.Since(m.lastReport) < progressInterval {
+		return
+	}
+	m.lastReport = time.Now()
+
+	m.cfg.Progress(&Progress{
+		HeaderHeight: store.Tip().Height,
+		BlockHeight:  blockHeight,
+		Peers:        len(m.peers),
+	})
+}
+
+// OpenChain opens the chain of nodeID. A new node downloads the genesis block
+// from its peers.
+func (m *SyncManager) OpenChain(ctx context.Context, nodeID string) (*blk.BlockChain, error) {
+	if blk.ChainExists(nodeID) {
+		return blk.NewBlockChain(nodeID)
+	}
+
+	for _, p := range append([]*peer{}, m.peers...) {
+		if !p.canServe(0) {
+			continue
+		}
+		genesis, err := m.getGenesis(ctx, p)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			m.dropPeer(p, err)
+			continue
+		}
+
+		bc, err := blk.CreateBlockChainWithGenesis(genesis, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		utxoSet := utxo.UTXOSet{bc}
+		if err := utxoSet.Reindex(); err != nil {
+			bc.DB.Close()
+			return nil, err
+		}
+
+		return bc, nil
+	}
+
+	if len(m.peers) == 0 {
+		return nil, ErrNoPeers
+	}
+	return nil, ErrPrunedPeers
+}
+
+func (m *SyncManager) getGenesis(ctx context.Context, p *peer) (*blk.Block, error) {
+	// 空的 locator 从创世块开始返回
+	var headers []*blk.BlockHeader
+	if err := p.call(ctx, "Peer.GetHeaders", &daemon.GetHeadersArgs{}, &headers, m.cfg.StallTimeout); err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 {
+		return nil, ErrUnknownHeader
+	}
+
+	var genesis blk.Block
+	if err := p.call(ctx, "Peer.GetBlock", headers[0].Hash, &genesis, m.cfg.StallTimeout); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(genesis.Hash, headers[0].Hash) {
+		return nil, ErrBadBlock
+	}
+
+	return &genesis, nil
+}
+
+// Sync downloads the headers and then the blocks missing from bc, it stops
+// with the error of ctx when ctx is cancelled. The blocks connected before
+// are kept.
+func (m *SyncManager) Sync(ctx context.Context, bc *blk.BlockChain) error {
+	store, err := NewHeaderStore(bc)
+	if err != nil {
+		return err
+	}
+
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	if err := m.syncHeaders(ctx, store, height); err != nil {
+		return err
+	}
+	if err := m.downloadBlocks(ctx, bc, store); err != nil {
+		return err
+	}
+
+	if height, err = bc.GetBestHeight(); err != nil {
+		return err
+	}
+	m.report(store, height, true)
+	return nil
+}
+
+// syncHeaders asks every peer in turn for the headers after our locator, so
+// the store ends with the longest header chain of all of them
+func (m *SyncManager) syncHeaders(ctx context.Context, store *HeaderStore, chainHeight int) error {
+	for _, p := range append([]*peer{}, m.peers...) {
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var headers []*blk.BlockHeader
+			args := &daemon.GetHeadersArgs{Locator: store.Locator()}
+			if err := p.call(ctx, "Peer.GetHeaders", args, &headers, m.cfg.StallTimeout); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				m.dropPeer(p, err)
+				break
+			}
+			if len(headers) == 0 {
+				break
+			}
+
+			err := store.Connect(headers, chainHeight)
+			if err == errNotBetter {
+				break
+			}
+			if err != nil {
+				m.dropPeer(p, err)
+				break
+			}
+			m.report(store, chainHeight, false)
+		}
+	}
+
+	if len(m.peers) == 0 {
+		return ErrNoPeers
+	}
+	return nil
+}
+
+type blockResult struct {
+	height int
+	block  *blk.Block
+	peer   *peer
+	err    error
+}
+
+// downloadBlocks requests the blocks of the best header chain from all peers
+// at once, and connects them to bc in height order. The signatures of the
+// ancestors of blk.AssumeValid are not verified.
+func (m *SyncManager) downloadBlocks(ctx context.Context, bc *blk.BlockChain, store *HeaderStore) error {
+	connected, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	stop := store.Tip().Height
+	if connected >= stop {
+		return nil
+	}
+	assumeValid := store.AssumeValidHeight()
+
+	jobs := make(chan int)
+	results := make(chan *blockResult)
+	done := make(chan struct{})
+	defer close(done)
+
+	// 只从还有这些块的节点下载
+	live := 0
+	for _, p := range m.peers {
+		if p.canServe(connected + 1) {
+			go m.downloader(ctx, p, store, jobs, results, done)
+			live++
+		}
+	}
+	if live == 0 {
+		return ErrPrunedPeers
+	}
+
+	var queue []int
+	next := connected + 1
+	pending := make(map[int]*blk.Block)
+
+	for connected < stop {
+		for ; next <= stop && next <= connected+maxBlocksInFlight; next++ {
+			queue = append(queue, next)
+		}
+
+		// 队列为空时 nil channel 让这个分支不会被选中
+		var jobCh chan int
+		var job int
+		if len(queue) > 0 {
+			jobCh, job = jobs, queue[0]
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case jobCh <- job:
+			queue = queue[1:]
+
+		case r := <-results:
+			if r.err != nil {
+				// 交给其他节点重新下载，低的高度先下
+				queue = append([]int{r.height}, queue...)
+				m.dropPeer(r.peer, r.err)
+				if live--; live == 0 {
+					return ErrNoPeers
+				}
+				continue
+			}
+
+			pending[r.height] = r.block
+			for b, ok := pending[connected+1]; ok; b, ok = pending[connected+1] {
+				delete(pending, connected+1)
+				accept := bc.AcceptBlock
+				if b.Height <= assumeValid {
+					accept = bc.AcceptAssumedValidBlock
+				}
+				if err := accept(b); err != nil {
+					return err
+				}
+				connected++
+			}
+			m.report(store, connected, false)
+		}
+	}
+
+	return nil
+}
+
+// downloader fetches the blocks at the heights it receives from p until p
+// fails, the failed height is sent back with the error
+func (m *SyncManager) downloader(ctx context.Context, p *peer, store *HeaderStore, jobs <-chan int, results chan<- *blockResult, done <-chan struct{}) {
+	for {
+		var height int
+		select {
+		case height = <-jobs:
+		case <-done:
+			return
+		}
+
+		r := &blockResult{height: height, peer: p}
+		header := store.HeaderAt(height)
+		var b blk.Block
+		if r.err = p.call(ctx, "Peer.GetBlock", header.Hash, &b, m.cfg.StallTimeout); r.err == nil {
+			if len(b.Transactions) == 0 {
+				r.err = fmt.Errorf("%w: no transactions", ErrBadBlock)
+			} else if bytes.Equal(b.Hash, header.Hash) && bytes.Equal(b.HashTransactions(), header.MerkleRoot) {
+				r.block = &b
+			} else {
+				r.err = ErrBadBlock
+			}
+		}
+
+		select {
+		case results <- r:
+		case <-done:
+			return
+		}
+		if r.err != nil {
+			return
+		}
+	}
+}
+
+// SyncChain connects to the peers of cfg and syncs the chain of nodeID from
+// them, creating the chain when the node has none. Cancelling ctx stops the
+// sync, the UTXO cache is written back before it returns.
+func SyncChain(ctx context.Context, nodeID string, cfg Config) error {
+	m := New(cfg)
+	if err := m.Connect(ctx); err != nil {
+		return err
+	}
+	defer m.Close()
+
+	bc, err := m.OpenChain(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+	utxoSet := utxo.UTXOSet{bc}
+	if err := utxoSet.Open(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := utxoSet.Close(); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	if err := bc.SetPruneTarget(cfg.PruneTarget); err != nil {
+		return err
+	}
+
+	return m.Sync(ctx, bc)
+}
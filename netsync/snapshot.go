@@ -0,0 +1,219 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package netsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	blk "myBitCoin/block"
+	"myBitCoin/daemon"
+	"myBitCoin/utxo"
+)
+
+// LoadSnapshot creates the chain of nodeID from the snapshot read from r:
+// the headers up to the base block of the snapshot and the base block are
+// downloaded from the peers of cfg, the UTXO set is the one of the snapshot.
+// The node can then sync the blocks after the base block, while the blocks
+// below it are validated by ValidateSnapshot. The snapshot must be one of
+// blk.AssumeUTXOs.
+func LoadSnapshot(nodeID string, r io.Reader, cfg Config) (*blk.SnapshotMetadata, error) {
+	snap, err := blk.NewSnapshotReader(r)
+	if err != nil {
+		return nil, err
+	}
+	meta := &snap.Metadata
+	// 先检查快照在已知的列表中，再去下载区块头
+	if err := meta.CheckAssumeUTXO(); err != nil {
+		return nil, err
+	}
+
+	m := New(cfg)
+	if err := m.Connect(context.Background()); err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	for _, p := range append([]*peer{}, m.peers...) {
+		if !p.canServe(meta.BaseHeight) {
+			continue
+		}
+		headers, base, err := m.getSnapshotBase(p, meta)
+		if err != nil {
+			m.dropPeer(p, err)
+			continue
+		}
+
+		bc, err := blk.CreateBlockChainFromSnapshot(nodeID, snap, headers, base)
+		if err != nil {
+			return nil, err
+		}
+		defer bc.DB.Close()
+
+		utxoSet := utxo.UTXOSet{bc}
+		if err := utxoSet.Reindex(); err != nil {
+			return nil, err
+		}
+
+		return meta, nil
+	}
+
+	if len(m.peers) == 0 {
+		return nil, ErrNoPeers
+	}
+	return nil, ErrPrunedPeers
+}
+
+// getSnapshotBase downloads the headers from the genesis block to the base
+// block of the snapshot, and the base block
+func (m *SyncManager) getSnapshotBase(p *peer, meta *blk.SnapshotMetadata) ([]*blk.BlockHeader, *blk.Block, error) {
+	var headers []*blk.BlockHeader
+	for len(headers) <= meta.BaseHeight {
+		args := &daemon.GetHeadersArgs{HashStop: meta.BaseHash}
+		if len(headers) > 0 {
+			args.Locator = [][]byte{headers[len(headers)-1].Hash}
+		}
+
+		var batch []*blk.BlockHeader
+		if err := p.call(context.Background(), "Peer.GetHeaders", args, &batch, m.cfg.StallTimeout); err != nil {
+			return nil, nil, err
+		}
+		if len(batch) == 0 {
+			return nil, nil, ErrUnknownHeader
+		}
+		headers = append(headers, batch...)
+	}
+	if len(headers) != meta.BaseHeight+1 {
+		return nil, nil, blk.ErrSnapshotMismatch
+	}
+
+	var base blk.Block
+	if err := p.call(context.Background(), "Peer.GetBlock", meta.BaseHash, &base, m.cfg.StallTimeout); err != nil {
+		return nil, nil, err
+	}
+
+	return headers, &base, nil
+}
+
+// ValidateSnapshot downloads the blocks below the snapshot bc was loaded
+// from, checks them and checks that they lead to the UTXO set of the
+// snapshot. It does nothing when bc was not loaded from a snapshot. When
+// they do not, the chain is marked invalid and blk.ErrSnapshotInvalid is
+// returned.
+func ValidateSnapshot(bc *blk.BlockChain, cfg Config) error {
+	meta := bc.Snapshot()
+	if meta == nil {
+		return nil
+	}
+
+	// 从基准块往回取区块头
+	headers := make([]*blk.BlockHeader, meta.BaseHeight+1)
+	for hash := meta.BaseHash; len(hash) > 0; {
+		h, err := bc.GetHeader(hash)
+		if err != nil {
+			return err
+		}
+		headers[h.Height] = h
+		hash = h.PrevHash
+	}
+
+	m := New(cfg)
+	if err := m.Connect(context.Background()); err != nil {
+		return err
+	}
+	defer m.Close()
+
+	var full []*peer
+	for _, p := range m.peers {
+		if p.canServe(0) {
+			full = append(full, p)
+		}
+	}
+	if len(full) == 0 {
+		return ErrPrunedPeers
+	}
+
+	replay := blk.NewSnapshotReplay(meta)
+	for _, header := range headers {
+		b, err := m.downloadBlock(&full, header)
+		if err != nil {
+			return err
+		}
+		if err := replay.ConnectBlock(b); err != nil {
+			return invalidSnapshot(bc, fmt.Errorf("block %x: %v", header.Hash, err))
+		}
+		m.reportSnapshot(meta, header.Height, len(full))
+	}
+
+	if err := replay.Verify(); err != nil {
+		return invalidSnapshot(bc, err)
+	}
+	log.Printf("netsync: snapshot at height %d is valid", meta.BaseHeight)
+
+	return bc.SetSnapshotValidated()
+}
+
+// invalidSnapshot 标记链状态无效，返回的错误包含 blk.ErrSnapshotInvalid
+func invalidSnapshot(bc *blk.BlockChain, err error) error {
+	if markErr := bc.SetSnapshotInvalid(); markErr != nil {
+		log.Printf("netsync: %v", markErr)
+	}
+
+	return fmt.Errorf("%w: %v", blk.ErrSnapshotInvalid, err)
+}
+
+// downloadBlock 依次向各个节点请求一个块，失败的节点被断开
+func (m *SyncManager) downloadBlock(peers *[]*peer, header *blk.BlockHeader) (*blk.Block, error) {
+	for len(*peers) > 0 {
+		p := (*peers)[0]
+
+		var b blk.Block
+		err := p.call(context.Background(), "Peer.GetBlock", header.Hash, &b, m.cfg.StallTimeout)
+		if err == nil && (!bytes.Equal(b.Hash, header.Hash) || !bytes.Equal(b.HashTransactions(), header.MerkleRoot)) {
+			err = ErrBadBlock
+		}
+		if err == nil {
+			return &b, nil
+		}
+
+		m.dropPeer(p, err)
+		*peers = (*peers)[1:]
+	}
+
+	return nil, ErrNoPeers
+}
+
+func (m *SyncManager) reportSnapshot(meta *blk.SnapshotMetadata, height, peers int) {
+	if m.cfg.Progress == nil || height != meta.BaseHeight && time.Since(m.lastReport) < progressInterval {
+		return
+	}
+	m.lastReport = time.Now()
+
+	m.cfg.Progress(&Progress{HeaderHeight: meta.BaseHeight, BlockHeight: height, Peers: peers})
+}
@@ -0,0 +1,343 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	blk "myBitCoin/block"
+	"myBitCoin/daemon"
+	"myBitCoin/mempool"
+	"myBitCoin/mining"
+	"myBitCoin/netsync"
+	"myBitCoin/notify"
+	"myBitCoin/utxo"
+	"myBitCoin/wallet"
+)
+
+var (
+	ErrNoDataDir  = errors.New("node: the data directory is not set")
+	ErrStarted    = errors.New("node: already started")
+	ErrNotStarted = errors.New("node: not started")
+)
+
+// Node runs the chain, the UTXO set, the mempool and the wallets of a data
+// directory in the process, and optionally syncs from peers and serves the
+// peer port and the cli socket. Several nodes with different data
+// directories can run in one process; the package level settings such as
+// blk.DBEngine, blk.DefaultMiner and utxo.DefaultCacheSize are shared by them.
+type Node struct {
+	cfg config
+
+	mu      sync.Mutex
+	started bool
+	server  *daemon.Server
+	// cancel 让启动中的同步停下来
+	cancel context.CancelFunc
+	// serving 在 Serve 返回时关闭，done 在 Stop 完成或 Start 失败时关闭
+	serving chan struct{}
+	done    chan struct{}
+}
+
+// New returns a Node configured by opts, nothing is opened before Start
+func New(opts ...Option) (*Node, error) {
+	n := &Node{
+		serving: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&n.cfg)
+	}
+	if n.cfg.dataDir == "" {
+		return nil, ErrNoDataDir
+	}
+
+	return n, nil
+}
+
+// Start opens the chain, creating or syncing it first when the options ask
+// for it, and serves the configured ports in the background. The node stops
+// when ctx is cancelled or Stop is called; cancelling ctx during the initial
+// sync stops the sync. A Node can be started only once, Done is closed when
+// Start fails.
+func (n *Node) Start(ctx context.Context) (err error) {
+	n.mu.Lock()
+	if n.started {
+		n.mu.Unlock()
+		return ErrStarted
+	}
+	n.started = true
+	ctx, cancel := context.WithCancel(ctx)
+	n.cancel = cancel
+	n.mu.Unlock()
+
+	defer func() {
+		if err != nil {
+			cancel()
+			close(n.done)
+		}
+	}()
+
+	// 同步可能要很久，不持有锁，访问器和 Stop 不用等它
+	if err := n.openChain(ctx); err != nil {
+		return err
+	}
+	server, err := daemon.NewServer(n.cfg.dataDir)
+	if err != nil {
+		return err
+	}
+	if server.BlockChain().SnapshotInvalid() {
+		server.Close()
+		return fmt.Errorf("%w, delete the chain and sync it again", blk.ErrSnapshotInvalid)
+	}
+	if err := n.listen(server); err != nil {
+		server.Close()
+		return err
+	}
+
+	n.mu.Lock()
+	// 启动过程中调用了 Stop 或者 ctx 被取消
+	if err := ctx.Err(); err != nil {
+		n.mu.Unlock()
+		server.Close()
+		return err
+	}
+	n.server = server
+	n.mu.Unlock()
+
+	go func() {
+		// Close 关闭监听后 Serve 返回
+		server.Serve()
+		close(n.serving)
+	}()
+	n.validateSnapshot(server)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			n.Stop()
+		case <-n.done:
+		}
+	}()
+
+	return nil
+}
+
+// openChain 创建或同步数据目录中的链，链由 NewServer 打开
+func (n *Node) openChain(ctx context.Context) error {
+	if n.cfg.genesisAddress != "" && !blk.ChainExists(n.cfg.dataDir) {
+		bc, err := blk.CreateBlockChain(n.cfg.genesisAddress, n.cfg.dataDir)
+		if err != nil {
+			return err
+		}
+		utxoSet := utxo.UTXOSet{bc}
+		err = utxoSet.Reindex()
+		bc.DB.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(n.cfg.peers) == 0 {
+		return nil
+	}
+	cfg := netsync.Config{
+		Peers:       n.cfg.peers,
+		PruneTarget: n.cfg.pruneTarget,
+		Progress:    n.cfg.progress,
+	}
+	return netsync.SyncChain(ctx, n.cfg.dataDir, cfg)
+}
+
+func (n *Node) listen(server *daemon.Server) error {
+	if err := server.BlockChain().SetPruneTarget(n.cfg.pruneTarget); err != nil {
+		return err
+	}
+	if n.cfg.rpc {
+		if err := server.Listen(n.cfg.rpcAuth); err != nil {
+			return err
+		}
+	}
+	if n.cfg.listen != "" {
+		return server.ListenPeers(n.cfg.listen)
+	}
+
+	return nil
+}
+
+// validateSnapshot 从快照启动的节点在后台验证快照以下的块，验证失败时停止节点
+func (n *Node) validateSnapshot(server *daemon.Server) {
+	snap := server.BlockChain().Snapshot()
+	if snap == nil {
+		return
+	}
+	if len(n.cfg.peers) == 0 {
+		log.Printf("the blocks below the snapshot at height %d are not validated, start with peers to validate them", snap.BaseHeight)
+		return
+	}
+
+	bc := server.BlockChain()
+	go func() {
+		cfg := netsync.Config{Peers: n.cfg.peers}
+		err := netsync.ValidateSnapshot(bc, cfg)
+		if err == nil {
+			return
+		}
+		log.Printf("snapshot validation failed: %v", err)
+		// UTXO 集合不可信，不能再提供服务
+		if errors.Is(err, blk.ErrSnapshotInvalid) {
+			n.Stop()
+		}
+	}()
+}
+
+// Stop closes the ports and then the chain, writing back the UTXO cache. A
+// node still starting stops its sync and Start fails. Stop returns when the
+// node is stopped.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if !n.started {
+		n.mu.Unlock()
+		return
+	}
+	n.cancel()
+	server := n.server
+	n.server = nil
+	n.mu.Unlock()
+
+	if server == nil {
+		// 还在启动，或者已经停止
+		<-n.done
+		return
+	}
+	server.Close()
+	<-n.serving
+	close(n.done)
+}
+
+// Done is closed when the node is stopped
+func (n *Node) Done() <-chan struct{} {
+	return n.done
+}
+
+// DataDir returns the data directory of the node
+func (n *Node) DataDir() string {
+	return n.cfg.dataDir
+}
+
+// Server returns the server of the running node, nil before Start and after Stop
+func (n *Node) Server() *daemon.Server {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.server
+}
+
+// running returns the server of the running node
+func (n *Node) running() (*daemon.Server, error) {
+	server := n.Server()
+	if server == nil {
+		return nil, ErrNotStarted
+	}
+	return server, nil
+}
+
+// Chain returns the chain of the running node, ErrNotStarted before Start
+// and after Stop
+func (n *Node) Chain() (*blk.BlockChain, error) {
+	server, err := n.running()
+	if err != nil {
+		return nil, err
+	}
+	return server.BlockChain(), nil
+}
+
+// UTXOSet returns the UTXO set of the chain of the running node
+func (n *Node) UTXOSet() (utxo.UTXOSet, error) {
+	bc, err := n.Chain()
+	if err != nil {
+		return utxo.UTXOSet{}, err
+	}
+	return utxo.UTXOSet{bc}, nil
+}
+
+// TxPool returns the mempool of the running node
+func (n *Node) TxPool() (*mempool.TxPool, error) {
+	server, err := n.running()
+	if err != nil {
+		return nil, err
+	}
+	return server.TxPool(), nil
+}
+
+// Wallets returns the wallets of the running node
+func (n *Node) Wallets() (*wallet.Wallets, error) {
+	server, err := n.running()
+	if err != nil {
+		return nil, err
+	}
+	return server.Wallets(), nil
+}
+
+// Notifier returns the notifier publishing the chain and mempool events
+func (n *Node) Notifier() (*notify.Notifier, error) {
+	server, err := n.running()
+	if err != nil {
+		return nil, err
+	}
+	return server.Notifier(), nil
+}
+
+// TemplateGenerator returns the generator of the block templates of the node
+func (n *Node) TemplateGenerator() (*mining.BlkTmplGenerator, error) {
+	server, err := n.running()
+	if err != nil {
+		return nil, err
+	}
+	return server.TemplateGenerator(), nil
+}
+
+// CreateWallet adds a wallet to the node and returns its address
+func (n *Node) CreateWallet() (string, error) {
+	server, err := n.running()
+	if err != nil {
+		return "", err
+	}
+	return server.CreateWallet()
+}
+
+// Send pays amount from a wallet of the node to the address to, and mines a
+// block with the transaction
+func (n *Node) Send(from, to string, amount int) error {
+	server, err := n.running()
+	if err != nil {
+		return err
+	}
+	return server.Send(from, to, amount)
+}
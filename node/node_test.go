@@ -0,0 +1,237 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package node_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"myBitCoin/chaintest"
+	"myBitCoin/node"
+)
+
+// newNode returns a node with a new chain in a temporary directory
+func newNode(t *testing.T, opts ...node.Option) (*node.Node, string) {
+	t.Helper()
+
+	address := string(chaintest.NewWallet(t).GetAddress())
+	opts = append([]node.Option{node.WithDataDir(t.TempDir()), node.WithGenesisAddress(address)}, opts...)
+	n, err := node.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, address
+}
+
+func closed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	chaintest.Setup(t)
+	n, address := newNode(t)
+
+	if _, err := n.Chain(); err != node.ErrNotStarted {
+		t.Fatalf("Chain before Start: %v", err)
+	}
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Start(context.Background()); err != node.ErrStarted {
+		t.Fatalf("second Start: %v", err)
+	}
+
+	bc, err := n.Chain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chaintest.MineBlocks(t, bc, address, 2)
+	if height, err := bc.GetBestHeight(); err != nil || height != 2 {
+		t.Fatalf("height %d, %v", height, err)
+	}
+
+	n.Stop()
+	if !closed(n.Done()) {
+		t.Fatal("Done is not closed after Stop")
+	}
+	if _, err := n.Chain(); err != node.ErrNotStarted {
+		t.Fatalf("Chain after Stop: %v", err)
+	}
+	// 停止后再调用不会阻塞
+	n.Stop()
+}
+
+func TestNotStarted(t *testing.T) {
+	chaintest.Setup(t)
+	n, address := newNode(t)
+
+	calls := map[string]func() error{
+		"Chain":             func() error { _, err := n.Chain(); return err },
+		"UTXOSet":           func() error { _, err := n.UTXOSet(); return err },
+		"TxPool":            func() error { _, err := n.TxPool(); return err },
+		"Wallets":           func() error { _, err := n.Wallets(); return err },
+		"Notifier":          func() error { _, err := n.Notifier(); return err },
+		"TemplateGenerator": func() error { _, err := n.TemplateGenerator(); return err },
+		"CreateWallet":      func() error { _, err := n.CreateWallet(); return err },
+		"Send":              func() error { return n.Send(address, address, 1) },
+	}
+	for name, call := range calls {
+		if err := call(); err != node.ErrNotStarted {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if n.Server() != nil {
+		t.Error("Server is not nil before Start")
+	}
+}
+
+func TestStartFails(t *testing.T) {
+	chaintest.Setup(t)
+	// 没有创世地址，也没有可以同步的节点
+	n, err := node.New(node.WithDataDir(t.TempDir()), node.WithPeers("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Start(context.Background()); err == nil {
+		t.Fatal("Start succeeded without a chain")
+	}
+	if !closed(n.Done()) {
+		t.Fatal("Done is not closed after Start failed")
+	}
+	if _, err := n.Chain(); err != node.ErrNotStarted {
+		t.Fatalf("Chain after a failed Start: %v", err)
+	}
+	n.Stop()
+}
+
+func TestStopDuringSync(t *testing.T) {
+	chaintest.Setup(t)
+	// 接受连接但从不回答的节点，同步会一直等到超时
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	n, err := node.New(node.WithDataDir(t.TempDir()), node.WithPeers(l.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan error, 1)
+	go func() {
+		started <- n.Start(context.Background())
+	}()
+
+	// 同步中访问器不等待
+	time.Sleep(100 * time.Millisecond)
+	if _, err := n.Chain(); err != node.ErrNotStarted {
+		t.Fatalf("Chain during the sync: %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		n.Stop()
+		close(stopped)
+	}()
+	if !closed(stopped) {
+		t.Fatal("Stop waits for the sync")
+	}
+	select {
+	case err := <-started:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Start: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+}
+
+func TestTwoNodes(t *testing.T) {
+	chaintest.Setup(t)
+	a, address := newNode(t, node.WithListen("127.0.0.1:0"))
+	if err := a.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+	bcA, err := a.Chain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chaintest.MineBlocks(t, bcA, address, 3)
+
+	b, err := node.New(node.WithDataDir(t.TempDir()), node.WithPeers(a.Server().PeerAddr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Stop()
+	bcB, err := b.Chain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tipA, err := bcA.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tipB, err := bcB.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tipA != 3 || tipB != tipA {
+		t.Fatalf("heights %d and %d, want 3", tipA, tipB)
+	}
+	hashA, _ := bcA.HashAtHeight(3)
+	hashB, _ := bcB.HashAtHeight(3)
+	if string(hashA) != string(hashB) {
+		t.Fatal("the nodes have different tips")
+	}
+
+	// 停止一个节点不影响另一个
+	b.Stop()
+	if _, err := a.Chain(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package node
+
+import (
+	"myBitCoin/daemon"
+	"myBitCoin/netsync"
+)
+
+// Option configures a Node, see New
+type Option func(*config)
+
+type config struct {
+	dataDir string
+	// 数据目录中没有链时，创建一条创世块付给这个地址的新链
+	genesisAddress string
+	peers          []string
+	listen         string
+	rpc            bool
+	rpcAuth        daemon.Credentials
+	pruneTarget    int64
+	progress       func(*netsync.Progress)
+}
+
+// WithDataDir sets the directory of the chain and the wallet file of the
+// node, it is the node ID used by the cli. It is required.
+func WithDataDir(dir string) Option {
+	return func(c *config) {
+		c.dataDir = dir
+	}
+}
+
+// WithGenesisAddress creates a new chain paying the genesis block to address
+// when the data directory has none
+func WithGenesisAddress(address string) Option {
+	return func(c *config) {
+		c.genesisAddress = address
+	}
+}
+
+// WithPeers syncs the chain from the full nodes at these tcp addresses when
+// the node starts, creating it when the data directory has none
+func WithPeers(addrs ...string) Option {
+	return func(c *config) {
+		c.peers = append(c.peers, addrs...)
+	}
+}
+
+// WithListen serves other nodes and light clients on the tcp address
+func WithListen(addr string) Option {
+	return func(c *config) {
+		c.listen = addr
+	}
+}
+
+// WithRPC serves the cli commands on the local socket of the data directory,
+// like mybitcoind
+func WithRPC() Option {
+	return func(c *config) {
+		c.rpc = true
+	}
+}
+
+// WithRPCAuth makes the clients of the local socket log in as user with
+// password, see daemon.Dial
+func WithRPCAuth(user, password string) Option {
+	return func(c *config) {
+		c.rpcAuth = daemon.Credentials{User: user, Password: password}
+	}
+}
+
+// WithPruneTarget keeps the block bodies below target bytes, see
+// BlockChain.SetPruneTarget
+func WithPruneTarget(target int64) Option {
+	return func(c *config) {
+		c.pruneTarget = target
+	}
+}
+
+// WithSyncProgress is called while the node syncs from its peers
+func WithSyncProgress(f func(*netsync.Progress)) Option {
+	return func(c *config) {
+		c.progress = f
+	}
+}
@@ -0,0 +1,207 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package notify
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+
+	blk "myBitCoin/block"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+// 客户端可以订阅的主题
+const (
+	TopicBlocks        = "blocks"
+	TopicAddressPrefix = "address:"
+	TopicTxPrefix      = "tx:"
+)
+
+// 通知中的事件
+const (
+	EventBlockConnected    = "blockconnected"
+	EventBlockDisconnected = "blockdisconnected"
+	EventTxAccepted        = "txaccepted"
+)
+
+type BlockInfo struct {
+	Hash     string   `json:"hash"`
+	PrevHash string   `json:"prev_hash"`
+	Height   int      `json:"height"`
+	Time     int64    `json:"time"`
+	TxIDs    []string `json:"txids"`
+}
+
+// Message is the json sent to the subscribers of a topic
+type Message struct {
+	Topic string     `json:"topic"`
+	Event string     `json:"event"`
+	Block *BlockInfo `json:"block,omitempty"`
+	TxID  string     `json:"txid,omitempty"`
+}
+
+// Subscriber receives the messages of the topics it subscribed
+type Subscriber interface {
+	Notify(msg []byte)
+}
+
+// Notifier fans chain and mempool events out to the subscribers by topic
+type Notifier struct {
+	mu     sync.RWMutex
+	topics map[string]map[Subscriber]struct{}
+
+	// 除了同源的页面，还允许这些 Origin 的页面连接 websocket
+	origins []string
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{topics: make(map[string]map[Subscriber]struct{})}
+}
+
+func (n *Notifier) Subscribe(s Subscriber, topic string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	subs := n.topics[topic]
+	if subs == nil {
+		subs = make(map[Subscriber]struct{})
+		n.topics[topic] = subs
+	}
+	subs[s] = struct{}{}
+}
+
+func (n *Notifier) Unsubscribe(s Subscriber, topic string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.topics[topic], s)
+	if len(n.topics[topic]) == 0 {
+		delete(n.topics, topic)
+	}
+}
+
+// UnsubscribeAll removes s from every topic
+func (n *Notifier) UnsubscribeAll(s Subscriber) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for topic, subs := range n.topics {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(n.topics, topic)
+		}
+	}
+}
+
+func (n *Notifier) publish(msg *Message) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	subs := n.topics[msg.Topic]
+	if len(subs) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for s := range subs {
+		s.Notify(data)
+	}
+}
+
+// HandleChainNotification is meant to be registered with BlockChain.Subscribe
+func (n *Notifier) HandleChainNotification(notification *blk.Notification) {
+	var event string
+	switch notification.Type {
+	case blk.NTBlockConnected:
+		event = EventBlockConnected
+	case blk.NTBlockDisconnected:
+		event = EventBlockDisconnected
+	default:
+		return
+	}
+
+	b := notification.Data.(*blk.Block)
+	info := &BlockInfo{
+		Hash:     hex.EncodeToString(b.Hash),
+		PrevHash: hex.EncodeToString(b.PrevHash),
+		Height:   b.Height,
+		Time:     b.TimeStamp,
+	}
+	for _, tx := range b.Transactions {
+		info.TxIDs = append(info.TxIDs, hex.EncodeToString(tx.ID))
+	}
+
+	n.publish(&Message{Topic: TopicBlocks, Event: event, Block: info})
+	for _, tx := range b.Transactions {
+		n.publishTx(tx, event, info)
+	}
+}
+
+// HandleTxAccepted is meant to be registered with TxPool.Subscribe
+func (n *Notifier) HandleTxAccepted(tx *transaction.Transaction) {
+	n.publishTx(tx, EventTxAccepted, nil)
+}
+
+func (n *Notifier) publishTx(tx *transaction.Transaction, event string, block *BlockInfo) {
+	txID := hex.EncodeToString(tx.ID)
+	n.publish(&Message{Topic: TopicTxPrefix + txID, Event: event, Block: block, TxID: txID})
+
+	for _, address := range addresses(tx) {
+		n.publish(&Message{Topic: TopicAddressPrefix + address, Event: event, Block: block, TxID: txID})
+	}
+}
+
+// addresses returns the addresses paid or spent by tx, without duplicates
+func addresses(tx *transaction.Transaction) []string {
+	var (
+		result []string
+		seen   = make(map[string]bool)
+	)
+	add := func(pubKeyHash []byte) {
+		address := string(wallet.AddressFromPubKeyHash(pubKeyHash))
+		if !seen[address] {
+			seen[address] = true
+			result = append(result, address)
+		}
+	}
+
+	if !tx.IsCoinbase() {
+		for _, in := range tx.Vin {
+			add(wallet.HashPubKey(in.PubKey))
+		}
+	}
+	for _, out := range tx.Vout {
+		add(out.PubKeyHash)
+	}
+
+	return result
+}
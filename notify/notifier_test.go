@@ -0,0 +1,215 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package notify_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/mempool"
+	"myBitCoin/notify"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+// fixture is a regtest chain with a mempool publishing to a notifier like
+// the one of a node
+type fixture struct {
+	bc       *blk.BlockChain
+	pool     *mempool.TxPool
+	notifier *notify.Notifier
+	w        *wallet.Wallet
+	// to 是另一个钱包的地址
+	to string
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	bc, w := chaintest.NewChain(t)
+	f := &fixture{
+		bc:       bc,
+		pool:     mempool.New(bc),
+		notifier: notify.NewNotifier(),
+		w:        w,
+		to:       string(chaintest.NewWallet(t).GetAddress()),
+	}
+	bc.Subscribe(f.notifier.HandleChainNotification)
+	f.pool.Subscribe(f.notifier.HandleTxAccepted)
+
+	return f
+}
+
+// send pays amount to f.to through the mempool and mines it in a block
+func (f *fixture) send(t *testing.T, amount int) {
+	t.Helper()
+
+	tx := chaintest.NewTx(t, f.bc, f.w, f.to, amount)
+	if err := f.pool.ProcessTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.bc.MineBlock([]*transaction.Transaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type recorder struct {
+	mu   sync.Mutex
+	msgs []notify.Message
+}
+
+func (r *recorder) Notify(data []byte) {
+	var msg notify.Message
+	json.Unmarshal(data, &msg)
+
+	r.mu.Lock()
+	r.msgs = append(r.msgs, msg)
+	r.mu.Unlock()
+}
+
+func (r *recorder) events(topic string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []string
+	for _, msg := range r.msgs {
+		if msg.Topic == topic {
+			events = append(events, msg.Event)
+		}
+	}
+	return events
+}
+
+func TestSendNotifies(t *testing.T) {
+	f := newFixture(t)
+	notifier, to := f.notifier, f.to
+
+	r := &recorder{}
+	notifier.Subscribe(r, notify.TopicBlocks)
+	notifier.Subscribe(r, notify.TopicAddressPrefix+to)
+
+	f.send(t, 2)
+
+	blocks := r.events(notify.TopicBlocks)
+	if len(blocks) != 1 || blocks[0] != notify.EventBlockConnected {
+		t.Errorf("blocks topic got %v", blocks)
+	}
+	// 先进入交易池，再被挖进块
+	want := []string{notify.EventTxAccepted, notify.EventBlockConnected}
+	if got := r.events(notify.TopicAddressPrefix + to); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("address topic got %v, want %v", got, want)
+	}
+
+	notifier.UnsubscribeAll(r)
+	f.send(t, 1)
+	if got := r.events(notify.TopicBlocks); len(got) != 1 {
+		t.Errorf("notified after unsubscribing: %v", got)
+	}
+}
+
+func dial(t *testing.T, url, origin string) (*websocket.Conn, int) {
+	t.Helper()
+
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), header)
+	if err != nil {
+		if resp == nil {
+			t.Fatal(err)
+		}
+		return nil, resp.StatusCode
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, http.StatusSwitchingProtocols
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	notifier := newFixture(t).notifier
+	srv := httptest.NewServer(notifier)
+	defer srv.Close()
+
+	if _, status := dial(t, srv.URL, ""); status != http.StatusSwitchingProtocols {
+		t.Errorf("client without origin: status %d", status)
+	}
+	if _, status := dial(t, srv.URL, srv.URL); status != http.StatusSwitchingProtocols {
+		t.Errorf("same origin: status %d", status)
+	}
+	if _, status := dial(t, srv.URL, "http://evil.example"); status != http.StatusForbidden {
+		t.Errorf("other origin: status %d, want %d", status, http.StatusForbidden)
+	}
+
+	notifier.SetAllowedOrigins([]string{"http://wallet.example"})
+	if _, status := dial(t, srv.URL, "http://wallet.example"); status != http.StatusSwitchingProtocols {
+		t.Errorf("allowed origin: status %d", status)
+	}
+	if _, status := dial(t, srv.URL, "http://evil.example"); status != http.StatusForbidden {
+		t.Errorf("other origin: status %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestWebsocketSubscribe(t *testing.T) {
+	f := newFixture(t)
+	srv := httptest.NewServer(f.notifier)
+	defer srv.Close()
+
+	conn, _ := dial(t, srv.URL, "")
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	topic := notify.TopicAddressPrefix + f.to
+	if err := conn.WriteJSON(notify.Request{Action: "subscribe", Topics: []string{topic}}); err != nil {
+		t.Fatal(err)
+	}
+	var reply map[string]interface{}
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply["error"] != nil {
+		t.Fatalf("subscribe failed: %v", reply["error"])
+	}
+
+	f.send(t, 2)
+	var msg notify.Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Topic != topic || msg.Event != notify.EventTxAccepted {
+		t.Errorf("got %s %s, want %s %s", msg.Topic, msg.Event, topic, notify.EventTxAccepted)
+	}
+	if _, err := hex.DecodeString(msg.TxID); err != nil || msg.TxID == "" {
+		t.Errorf("bad txid %q", msg.TxID)
+	}
+}
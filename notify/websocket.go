@@ -0,0 +1,207 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package notify
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+
+	// 消息积压超过这个数量的客户端会被断开
+	sendQueueSize = 256
+)
+
+// Request is sent by the websocket clients to manage their subscriptions
+type Request struct {
+	Action string   `json:"action"`
+	Topics []string `json:"topics"`
+}
+
+type response struct {
+	Action string   `json:"action"`
+	Topics []string `json:"topics,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// SetAllowedOrigins lets the pages of these origins, e.g.
+// https://example.com, connect besides the pages of the same origin. "*"
+// allows every origin. It must be called before serving.
+func (n *Notifier) SetAllowedOrigins(origins []string) {
+	n.origins = origins
+}
+
+// checkOrigin refuses the browsers running the scripts of other sites,
+// clients sending no Origin header are not browsers
+func (n *Notifier) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range n.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+	done chan struct{}
+}
+
+// Notify drops the client when it can not keep up
+func (c *wsClient) Notify(msg []byte) {
+	select {
+	case c.send <- msg:
+	case <-c.done:
+	default:
+		log.Println("notify: websocket client too slow, disconnecting")
+		c.conn.Close()
+	}
+}
+
+// ServeHTTP upgrades the request to a websocket and serves subscriptions on it
+func (n *Notifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: n.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &wsClient{
+		conn: conn,
+		send: make(chan []byte, sendQueueSize),
+		done: make(chan struct{}),
+	}
+	go c.writeLoop()
+	n.readLoop(c)
+
+	n.UnsubscribeAll(c)
+	close(c.done)
+	conn.Close()
+}
+
+func (n *Notifier) readLoop(c *wsClient) {
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req Request
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.reply(&response{Error: "malformed request"})
+			continue
+		}
+
+		resp := &response{Action: req.Action, Topics: req.Topics}
+		if err := n.handleRequest(c, &req); err != "" {
+			resp.Error = err
+		}
+		c.reply(resp)
+	}
+}
+
+func (n *Notifier) handleRequest(c *wsClient, req *Request) string {
+	for _, topic := range req.Topics {
+		if !validTopic(topic) {
+			return "unknown topic: " + topic
+		}
+	}
+
+	switch req.Action {
+	case "subscribe":
+		for _, topic := range req.Topics {
+			n.Subscribe(c, topic)
+		}
+	case "unsubscribe":
+		for _, topic := range req.Topics {
+			n.Unsubscribe(c, topic)
+		}
+	default:
+		return "unknown action: " + req.Action
+	}
+
+	return ""
+}
+
+func validTopic(topic string) bool {
+	return topic == TopicBlocks ||
+		strings.HasPrefix(topic, TopicAddressPrefix) && len(topic) > len(TopicAddressPrefix) ||
+		strings.HasPrefix(topic, TopicTxPrefix) && len(topic) > len(TopicTxPrefix)
+}
+
+func (c *wsClient) reply(resp *response) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c.Notify(data)
+}
+
+func (c *wsClient) writeLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
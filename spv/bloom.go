@@ -0,0 +1,112 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package spv
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"myBitCoin/bloom"
+	"myBitCoin/daemon"
+)
+
+// 过滤器的误判率，越高越能隐藏关注的地址，但要多下载无关的交易
+const bloomFPRate = 0.0001
+
+var ErrMissingMerkleBlock = errors.New("spv: merkle blocks are not consecutive")
+
+// scanBloom loads a bloom filter of the watched public key hashes and coins
+// into the full node, and checks the merkle blocks it returns
+func (c *Client) scanBloom() error {
+	start, err := c.scanStart()
+	if err != nil || len(c.pubKeyHashes) == 0 {
+		return err
+	}
+
+	var outPoints [][]byte
+	c.db.View(func(tx *bolt.Tx) error {
+		outPoints = coinOutPoints(tx, bloom.OutPoint)
+		return nil
+	})
+
+	tweak := rand.New(rand.NewSource(time.Now().UnixNano())).Uint32()
+	filter := bloom.NewFilter(len(c.pubKeyHashes)+len(outPoints), bloomFPRate, tweak, bloom.UpdateAll)
+	for _, item := range append(outPoints, c.pubKeyHashes...) {
+		filter.Add(item)
+	}
+
+	var ok bool
+	if err := c.peer.Call("Peer.FilterLoad", filter.MsgFilterLoad(), &ok); err != nil {
+		return err
+	}
+
+	tip := c.BestHeight()
+	for start <= tip {
+		var merkleBlocks []*bloom.MerkleBlock
+		args := &daemon.GetMerkleBlocksArgs{StartHeight: start}
+		if err := c.peer.Call("Peer.GetMerkleBlocks", args, &merkleBlocks); err != nil {
+			return err
+		}
+		if len(merkleBlocks) == 0 {
+			break
+		}
+
+		err := c.db.Update(func(tx *bolt.Tx) error {
+			for _, mb := range merkleBlocks {
+				height := mb.Header.Height
+				if height > tip {
+					// 比已知的头更新的块，下次同步时再处理
+					break
+				}
+				if height != start {
+					return ErrMissingMerkleBlock
+				}
+				if best := headerAt(tx, height); best == nil || !bytes.Equal(best.Hash, mb.Header.Hash) {
+					return ErrProofNotInBest
+				}
+
+				txs, err := mb.Verify()
+				if err != nil {
+					return err
+				}
+				if err := c.applyTransactions(tx, txs, height); err != nil {
+					return err
+				}
+				start = height + 1
+			}
+
+			return setScannedHeight(tx, start)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,394 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package spv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	blk "myBitCoin/block"
+	"myBitCoin/daemon"
+	"myBitCoin/transaction"
+)
+
+var (
+	ErrNotConnected   = errors.New("spv: not connected to a full node")
+	ErrUnknownHeader  = errors.New("spv: header does not connect to the known chain")
+	ErrBadGenesis     = errors.New("spv: first header is not a genesis block")
+	ErrProofNotInBest = errors.New("spv: proof is for a block not on the best header chain")
+)
+
+// ScanMode is how the light client finds the watched transactions
+type ScanMode int
+
+const (
+	// ScanProofs asks the full node for the proofs of the watched public key hashes
+	ScanProofs ScanMode = iota
+	// ScanFilters downloads the blocks whose compact filter matches, the full
+	// node does not learn which addresses are ours
+	ScanFilters
+	// ScanBloom loads a bloom filter into the full node and downloads merkle blocks
+	ScanBloom
+)
+
+// Client is a light client: it keeps the header chain and the coins of the
+// watched public key hashes, and relies on a full node for merkle proofs.
+type Client struct {
+	db           *bolt.DB
+	peer         *rpc.Client
+	pubKeyHashes [][]byte
+	mode         ScanMode
+}
+
+func Open(nodeID string) (*Client, error) {
+	db, err := bolt.Open(fmt.Sprintf(dbFile, nodeID), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Update(createBuckets); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Client{db: db}, nil
+}
+
+// Connect dials the peer port of a full node, see mybitcoind -listen
+func (c *Client) Connect(addr string) error {
+	peer, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	c.peer = peer
+
+	return nil
+}
+
+// Watch sets the public key hashes whose coins are tracked
+func (c *Client) Watch(pubKeyHashes [][]byte) {
+	c.pubKeyHashes = pubKeyHashes
+}
+
+// SetScanMode sets how Sync finds the watched transactions, ScanProofs by default
+func (c *Client) SetScanMode(mode ScanMode) {
+	c.mode = mode
+}
+
+func (c *Client) Close() {
+	if c.peer != nil {
+		c.peer.Close()
+	}
+	c.db.Close()
+}
+
+// Sync downloads and validates the new headers, then fetches the proofs of
+// the watched transactions in the new blocks.
+func (c *Client) Sync() error {
+	if c.peer == nil {
+		return ErrNotConnected
+	}
+
+	for {
+		var headers []*blk.BlockHeader
+		args := &daemon.GetHeadersArgs{Locator: c.locator()}
+		if err := c.peer.Call("Peer.GetHeaders", args, &headers); err != nil {
+			return err
+		}
+		if len(headers) == 0 {
+			break
+		}
+
+		if err := c.connectHeaders(headers); err != nil {
+			return err
+		}
+	}
+
+	switch c.mode {
+	case ScanFilters:
+		return c.scanFilters()
+	case ScanBloom:
+		return c.scanBloom()
+	}
+
+	return c.scan()
+}
+
+func (c *Client) locator() [][]byte {
+	var locator [][]byte
+
+	c.db.View(func(tx *bolt.Tx) error {
+		tip := tipHeader(tx)
+		if tip == nil {
+			return nil
+		}
+
+		locator = blk.BlockLocator(tip.Height, func(height int) []byte {
+			return tx.Bucket([]byte(heightsBucket)).Get(heightKey(height))
+		})
+		// 切片在事务结束后失效
+		for i := range locator {
+			locator[i] = append([]byte{}, locator[i]...)
+		}
+		return nil
+	})
+
+	return locator
+}
+
+func (c *Client) connectHeaders(headers []*blk.BlockHeader) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		headersB := tx.Bucket([]byte(headersBucket))
+		heightsB := tx.Bucket([]byte(heightsBucket))
+
+		first := headers[0]
+		var prev *blk.BlockHeader
+		if len(first.PrevHash) == 0 {
+			if first.Height != 0 {
+				return ErrBadGenesis
+			}
+		} else {
+			data := headersB.Get(first.PrevHash)
+			if data == nil {
+				return ErrUnknownHeader
+			}
+			var err error
+			if prev, err = blk.DeserializeHeader(data); err != nil {
+				return err
+			}
+		}
+
+		// 新的头不是接在当前链尾上，说明全节点的链分叉了
+		tip := tipHeader(tx)
+		if tip != nil && (prev == nil || !bytes.Equal(prev.Hash, tip.Hash)) {
+			forkHeight := -1
+			if prev != nil {
+				forkHeight = prev.Height
+			}
+			if forkHeight < blk.LastCheckpoint() {
+				return blk.ErrForkBelowCheckpoint
+			}
+			for height := tip.Height; height > forkHeight; height-- {
+				if err := heightsB.Delete(heightKey(height)); err != nil {
+					return err
+				}
+			}
+			if err := resetCoins(tx); err != nil {
+				return err
+			}
+		}
+
+		for _, h := range headers {
+			if err := h.CheckProofOfWork(); err != nil {
+				return err
+			}
+			if prev != nil {
+				if err := h.CheckConnects(prev); err != nil {
+					return err
+				}
+			}
+			if err := h.CheckCheckpoint(); err != nil {
+				return err
+			}
+
+			if err := headersB.Put(h.Hash, h.Serialize()); err != nil {
+				return err
+			}
+			if err := heightsB.Put(heightKey(h.Height), h.Hash); err != nil {
+				return err
+			}
+			prev = h
+		}
+
+		return tx.Bucket([]byte(metaBucket)).Put(tipKey, prev.Hash)
+	})
+}
+
+// scanStart returns the first height to scan, the coins are forgotten when
+// the watched public key hashes changed
+func (c *Client) scanStart() (int, error) {
+	var start int
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		// 关注的地址变了，需要从头扫描
+		watch, err := encode(c.pubKeyHashes)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tx.Bucket([]byte(metaBucket)).Get(watchKey), watch) {
+			if err := resetCoins(tx); err != nil {
+				return err
+			}
+			if err := tx.Bucket([]byte(metaBucket)).Put(watchKey, watch); err != nil {
+				return err
+			}
+		}
+		start = scannedHeight(tx)
+
+		return nil
+	})
+
+	return start, err
+}
+
+// scan fetches and checks the proofs of the watched transactions from the
+// last scanned height up to the header tip
+func (c *Client) scan() error {
+	start, err := c.scanStart()
+	if err != nil || len(c.pubKeyHashes) == 0 {
+		return err
+	}
+
+	tip := c.BestHeight()
+	for start <= tip {
+		var proofs []*blk.TxOutProof
+		args := &daemon.GetAddressProofsArgs{PubKeyHashes: c.pubKeyHashes, StartHeight: start}
+		if err := c.peer.Call("Peer.GetAddressProofs", args, &proofs); err != nil {
+			return err
+		}
+
+		// 每次请求全节点只扫描 MaxAddressProofBlocks 个块
+		stop := start + daemon.MaxAddressProofBlocks - 1
+		if stop > tip {
+			stop = tip
+		}
+		if err := c.applyProofs(proofs, stop); err != nil {
+			return err
+		}
+		start = stop + 1
+	}
+
+	return nil
+}
+
+// applyProofs applies the proofs up to the height stop, which is then scanned
+func (c *Client) applyProofs(proofs []*blk.TxOutProof, stop int) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		for _, proof := range proofs {
+			data := tx.Bucket([]byte(headersBucket)).Get(proof.BlockHash)
+			if data == nil {
+				// 比已知的头更新的块，下次同步时再处理
+				continue
+			}
+			header, err := blk.DeserializeHeader(data)
+			if err != nil {
+				return err
+			}
+			if header.Height > stop {
+				continue
+			}
+			if best := headerAt(tx, header.Height); best == nil || !bytes.Equal(best.Hash, header.Hash) {
+				return ErrProofNotInBest
+			}
+
+			txs, err := proof.Verify(header.MerkleRoot)
+			if err != nil {
+				return err
+			}
+			if err := c.applyTransactions(tx, txs, header.Height); err != nil {
+				return err
+			}
+		}
+
+		return setScannedHeight(tx, stop+1)
+	})
+}
+
+func (c *Client) watched(pubKeyHash []byte) bool {
+	for _, h := range c.pubKeyHashes {
+		if bytes.Equal(h, pubKeyHash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Client) applyTransactions(tx *bolt.Tx, txs []*transaction.Transaction, height int) error {
+	coins := tx.Bucket([]byte(coinsBucket))
+
+	for _, t := range txs {
+		if !t.IsCoinbase() {
+			for _, in := range t.Vin {
+				if err := coins.Delete(coinKey(in.TxID, in.Vout)); err != nil {
+					return err
+				}
+			}
+		}
+
+		for i, out := range t.Vout {
+			if !c.watched(out.PubKeyHash) {
+				continue
+			}
+			value, err := encode(coin{out.Value, out.PubKeyHash, height})
+			if err != nil {
+				return err
+			}
+			if err := coins.Put(coinKey(t.ID, i), value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetBalance sums the coins of pubKeyHash
+func (c *Client) GetBalance(pubKeyHash []byte) (int, error) {
+	balance := 0
+
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(coinsBucket)).ForEach(func(k, v []byte) error {
+			cn, err := decodeCoin(v)
+			if err != nil {
+				return fmt.Errorf("spv: coin %x: %v", k, err)
+			}
+			if bytes.Equal(cn.PubKeyHash, pubKeyHash) {
+				balance += cn.Value
+			}
+			return nil
+		})
+	})
+
+	return balance, err
+}
+
+// BestHeight returns the height of the header tip, -1 before the first sync
+func (c *Client) BestHeight() int {
+	height := -1
+
+	c.db.View(func(tx *bolt.Tx) error {
+		if tip := tipHeader(tx); tip != nil {
+			height = tip.Height
+		}
+		return nil
+	})
+
+	return height
+}
@@ -0,0 +1,139 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package spv_test
+
+import (
+	"context"
+	"testing"
+
+	"myBitCoin/chaintest"
+	"myBitCoin/node"
+	"myBitCoin/spv"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+// startNode runs a regtest full node in the process, serving light clients
+// on a local port, with a few blocks paying between two wallets
+func startNode(t *testing.T) (*node.Node, []string) {
+	t.Helper()
+
+	chaintest.Setup(t)
+	from, to := chaintest.NewWallet(t), chaintest.NewWallet(t)
+	n, err := node.New(
+		node.WithDataDir(t.TempDir()),
+		node.WithGenesisAddress(string(from.GetAddress())),
+		node.WithListen("127.0.0.1:0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Stop)
+	bc, err := n.Chain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(w, to *wallet.Wallet, amount int) {
+		tx := chaintest.NewTx(t, bc, w, string(to.GetAddress()), amount)
+		if _, err := bc.MineBlock([]*transaction.Transaction{tx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, amount := range []int{3, 2, 1} {
+		send(from, to, amount)
+	}
+	// 收到的币再花出去一部分
+	send(to, from, 4)
+
+	return n, []string{string(from.GetAddress()), string(to.GetAddress())}
+}
+
+func TestBalancesMatchFullNode(t *testing.T) {
+	n, addresses := startNode(t)
+	addr := n.Server().PeerAddr().String()
+
+	var pubKeyHashes [][]byte
+	for _, address := range addresses {
+		pubKeyHash, err := wallet.PubKeyHashFromAddress(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKeyHashes = append(pubKeyHashes, pubKeyHash)
+	}
+
+	modes := map[string]spv.ScanMode{
+		"proofs":   spv.ScanProofs,
+		"cfilters": spv.ScanFilters,
+		"bloom":    spv.ScanBloom,
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			client, err := spv.Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			client.Watch(pubKeyHashes)
+			client.SetScanMode(mode)
+			if err := client.Connect(addr); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			utxoSet, err := n.UTXOSet()
+			if err != nil {
+				t.Fatal(err)
+			}
+			height, err := utxoSet.BlockChain.GetBestHeight()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.BestHeight() != height {
+				t.Errorf("light client at height %d, full node at %d", client.BestHeight(), height)
+			}
+
+			for i, pubKeyHash := range pubKeyHashes {
+				want, err := utxoSet.GetBalance(pubKeyHash)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := client.GetBalance(pubKeyHash)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("balance of %s: light client %d, full node %d", addresses[i], got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package spv
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/boltdb/bolt"
+
+	blk "myBitCoin/block"
+	"myBitCoin/cfilter"
+	"myBitCoin/daemon"
+)
+
+var (
+	ErrBadFilterHeaders = errors.New("spv: filter headers do not connect")
+	ErrBadFilter        = errors.New("spv: filter does not match its filter header")
+	ErrBadBlock         = errors.New("spv: block does not match its header")
+)
+
+// scanFilters checks the filters of the blocks from the last scanned height
+// up to the header tip, and downloads only the blocks whose filter matches
+func (c *Client) scanFilters() error {
+	start, err := c.scanStart()
+	if err != nil || len(c.pubKeyHashes) == 0 {
+		return err
+	}
+
+	// 过滤器头要从上一个已验证的头接上，没有的话从头扫描
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		if start > 0 && filterHeaderAt(tx, start-1) == nil {
+			start = 0
+			return resetCoins(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tip := c.BestHeight()
+	for start <= tip {
+		stop := start + cfilter.MaxFiltersPerMsg - 1
+		if stop > tip {
+			stop = tip
+		}
+		if err := c.scanFilterBatch(start, stop); err != nil {
+			return err
+		}
+		start = stop + 1
+	}
+
+	return nil
+}
+
+func (c *Client) scanFilterBatch(start, stop int) error {
+	var (
+		headers    []*blk.BlockHeader
+		prevHeader []byte
+	)
+	c.db.View(func(tx *bolt.Tx) error {
+		for height := start; height <= stop; height++ {
+			headers = append(headers, headerAt(tx, height))
+		}
+		if start > 0 {
+			prevHeader = filterHeaderAt(tx, start-1)
+		}
+		return nil
+	})
+
+	args := &daemon.GetCFiltersArgs{StartHeight: start, StopHash: headers[len(headers)-1].Hash}
+	var cfHeaders daemon.CFHeaders
+	if err := c.peer.Call("Peer.GetCFHeaders", args, &cfHeaders); err != nil {
+		return err
+	}
+	if len(cfHeaders.FilterHashes) != len(headers) || !bytes.Equal(cfHeaders.PrevFilterHeader, prevHeader) {
+		return ErrBadFilterHeaders
+	}
+
+	var filters []daemon.CFilter
+	if err := c.peer.Call("Peer.GetCFilters", args, &filters); err != nil {
+		return err
+	}
+	if len(filters) != len(headers) {
+		return ErrBadFilter
+	}
+
+	for i, header := range headers {
+		f, err := cfilter.FromBytes(filters[i].Filter)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(filters[i].BlockHash, header.Hash) || !bytes.Equal(f.Hash(), cfHeaders.FilterHashes[i]) {
+			return ErrBadFilter
+		}
+
+		var items [][]byte
+		c.db.View(func(tx *bolt.Tx) error {
+			items = coinOutPoints(tx, cfilter.OutPointItem)
+			return nil
+		})
+		items = append(items, c.pubKeyHashes...)
+
+		var b *blk.Block
+		if f.MatchAny(cfilter.Key(header.Hash), items) {
+			if b, err = c.getBlock(header, f); err != nil {
+				return err
+			}
+		}
+
+		prevHeader = cfilter.FilterHeader(f.Hash(), prevHeader)
+		err = c.db.Update(func(tx *bolt.Tx) error {
+			if b != nil {
+				if err := c.applyTransactions(tx, b.Transactions, header.Height); err != nil {
+					return err
+				}
+			}
+			if err := tx.Bucket([]byte(cfheadersBucket)).Put(heightKey(header.Height), prevHeader); err != nil {
+				return err
+			}
+			return setScannedHeight(tx, header.Height+1)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getBlock downloads a block whose filter matched, the block must match both
+// the header and the filter
+func (c *Client) getBlock(header *blk.BlockHeader, f *cfilter.Filter) (*blk.Block, error) {
+	var b blk.Block
+	if err := c.peer.Call("Peer.GetBlock", header.Hash, &b); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(b.Hash, header.Hash) || !bytes.Equal(b.HashTransactions(), header.MerkleRoot) {
+		return nil, ErrBadBlock
+	}
+	if !bytes.Equal(cfilter.BlockFilter(&b).Hash(), f.Hash()) {
+		return nil, ErrBadFilter
+	}
+
+	return &b, nil
+}
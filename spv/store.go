@@ -0,0 +1,176 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package spv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"log"
+
+	"github.com/boltdb/bolt"
+
+	blk "myBitCoin/block"
+)
+
+const (
+	dbFile = "%s/spv.db"
+
+	headersBucket   = "headers"   // hash -> header
+	heightsBucket   = "heights"   // height -> hash of the header on the best chain
+	coinsBucket     = "coins"     // txid + vout -> coin
+	cfheadersBucket = "cfheaders" // height -> filter header of the block on the best chain
+	metaBucket      = "meta"
+)
+
+var (
+	tipKey     = []byte("l")
+	scannedKey = []byte("s") // 下一个需要扫描交易的高度
+	watchKey   = []byte("w") // 上次扫描时关注的公钥哈希
+)
+
+// coin is an unspent output paying to one of the watched public key hashes
+type coin struct {
+	Value      int
+	PubKeyHash []byte
+	Height     int
+}
+
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+func coinKey(txID []byte, vout int) []byte {
+	key := make([]byte, 0, len(txID)+4)
+	key = append(key, txID...)
+	return append(key, byte(vout>>24), byte(vout>>16), byte(vout>>8), byte(vout))
+}
+
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeCoin(data []byte) (coin, error) {
+	var c coin
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c)
+
+	return c, err
+}
+
+func createBuckets(tx *bolt.Tx) error {
+	for _, name := range []string{headersBucket, heightsBucket, coinsBucket, cfheadersBucket, metaBucket} {
+		if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tipHeader returns the last header of the best chain, nil when there is none
+func tipHeader(tx *bolt.Tx) *blk.BlockHeader {
+	hash := tx.Bucket([]byte(metaBucket)).Get(tipKey)
+	if hash == nil {
+		return nil
+	}
+
+	return storedHeader(tx, hash)
+}
+
+func headerAt(tx *bolt.Tx, height int) *blk.BlockHeader {
+	hash := tx.Bucket([]byte(heightsBucket)).Get(heightKey(height))
+	if hash == nil {
+		return nil
+	}
+
+	return storedHeader(tx, hash)
+}
+
+// storedHeader 解不开的头当作没有，下次同步时重新下载
+func storedHeader(tx *bolt.Tx, hash []byte) *blk.BlockHeader {
+	h, err := blk.DeserializeHeader(tx.Bucket([]byte(headersBucket)).Get(hash))
+	if err != nil {
+		log.Printf("spv: header %x: %v", hash, err)
+	}
+
+	return h
+}
+
+func scannedHeight(tx *bolt.Tx) int {
+	data := tx.Bucket([]byte(metaBucket)).Get(scannedKey)
+	if data == nil {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint64(data))
+}
+
+func setScannedHeight(tx *bolt.Tx, height int) error {
+	return tx.Bucket([]byte(metaBucket)).Put(scannedKey, heightKey(height))
+}
+
+// resetCoins forgets every coin so that the transactions are scanned again
+func resetCoins(tx *bolt.Tx) error {
+	if err := tx.DeleteBucket([]byte(coinsBucket)); err != nil {
+		return err
+	}
+	if _, err := tx.CreateBucket([]byte(coinsBucket)); err != nil {
+		return err
+	}
+
+	return setScannedHeight(tx, 0)
+}
+
+// filterHeaderAt returns the verified filter header of the block at height,
+// nil when the block was not scanned with filters
+func filterHeaderAt(tx *bolt.Tx, height int) []byte {
+	header := tx.Bucket([]byte(cfheadersBucket)).Get(heightKey(height))
+	if header == nil {
+		return nil
+	}
+
+	return append([]byte{}, header...)
+}
+
+// coinOutPoints returns the outpoints of the coins serialized by item, a
+// block spending one of them is relevant
+func coinOutPoints(tx *bolt.Tx, item func(txID []byte, vout int) []byte) [][]byte {
+	var items [][]byte
+
+	tx.Bucket([]byte(coinsBucket)).ForEach(func(k, v []byte) error {
+		n := len(k) - 4
+		items = append(items, item(k[:n], int(binary.BigEndian.Uint32(k[n:]))))
+		return nil
+	})
+
+	return items
+}
@@ -0,0 +1,163 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// 数据库被其他进程（如 mybitcoind）占用时，最多等待的时间
+const boltOpenTimeout = time.Second
+
+type boltDB struct {
+	db *bolt.DB
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+type boltBucket struct {
+	b *bolt.Bucket
+}
+
+type boltCursor struct {
+	c *bolt.Cursor
+}
+
+func openBolt(file string) (DB, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltDB{db}, nil
+}
+
+func (d *boltDB) View(fn func(tx Tx) error) error {
+	return d.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (d *boltDB) Update(fn func(tx Tx) error) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (d *boltDB) Batch(fn func(tx Tx) error) error {
+	return d.db.Batch(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (d *boltDB) Close() error {
+	return d.db.Close()
+}
+
+// boltError 把 bolt 的错误换成 storage 的
+func boltError(err error) error {
+	switch err {
+	case bolt.ErrBucketNotFound:
+		return ErrBucketNotFound
+	case bolt.ErrBucketExists:
+		return ErrBucketExists
+	case bolt.ErrTxNotWritable:
+		return ErrTxNotWritable
+	}
+
+	return err
+}
+
+func (t *boltTx) Bucket(name []byte) Bucket {
+	// 不能返回包着 nil 指针的接口
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+
+	return &boltBucket{b}
+}
+
+func (t *boltTx) CreateBucket(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	if err != nil {
+		return nil, boltError(err)
+	}
+
+	return &boltBucket{b}, nil
+}
+
+func (t *boltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, boltError(err)
+	}
+
+	return &boltBucket{b}, nil
+}
+
+func (t *boltTx) DeleteBucket(name []byte) error {
+	return boltError(t.tx.DeleteBucket(name))
+}
+
+func (t *boltTx) OnCommit(fn func()) {
+	t.tx.OnCommit(fn)
+}
+
+func (b *boltBucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b *boltBucket) Put(key, value []byte) error {
+	return boltError(b.b.Put(key, value))
+}
+
+func (b *boltBucket) Delete(key []byte) error {
+	return boltError(b.b.Delete(key))
+}
+
+func (b *boltBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.b.ForEach(fn)
+}
+
+func (b *boltBucket) Cursor() Cursor {
+	return &boltCursor{b.b.Cursor()}
+}
+
+func (c *boltCursor) First() ([]byte, []byte) {
+	return c.c.First()
+}
+
+func (c *boltCursor) Next() ([]byte, []byte) {
+	return c.c.Next()
+}
+
+func (c *boltCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.c.Seek(seek)
+}
@@ -0,0 +1,287 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// leveldb 没有 bucket，用键的前缀区分。'b' + 名字是 bucket 存在的标记，
+// 'd' + 名字长度 (uvarint) + 名字 + 键是 bucket 中的数据
+const (
+	bucketMarkerPrefix = 'b'
+	bucketDataPrefix   = 'd'
+)
+
+type levelDB struct {
+	db *leveldb.DB
+}
+
+// levelReader is a snapshot for View, or the open transaction for Update
+type levelReader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+type levelTx struct {
+	r  levelReader
+	tr *leveldb.Transaction
+	// 事务结束时释放
+	iters    []iterator.Iterator
+	onCommit []func()
+}
+
+type levelBucket struct {
+	tx     *levelTx
+	prefix []byte
+}
+
+type levelCursor struct {
+	b  *levelBucket
+	it iterator.Iterator
+}
+
+func openLevelDB(dir string) (DB, error) {
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &levelDB{db}, nil
+}
+
+func (d *levelDB) View(fn func(tx Tx) error) error {
+	snap, err := d.db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	tx := &levelTx{r: snap}
+	defer tx.release()
+
+	return fn(tx)
+}
+
+func (d *levelDB) Update(fn func(tx Tx) error) error {
+	tr, err := d.db.OpenTransaction()
+	if err != nil {
+		return err
+	}
+
+	tx := &levelTx{r: tr, tr: tr}
+	committed := false
+	defer func() {
+		tx.release()
+		if !committed {
+			tr.Discard()
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	tx.release()
+	if err := tr.Commit(); err != nil {
+		return err
+	}
+	committed = true
+
+	for _, f := range tx.onCommit {
+		f()
+	}
+	return nil
+}
+
+func (d *levelDB) Batch(fn func(tx Tx) error) error {
+	return d.Update(fn)
+}
+
+func (d *levelDB) Close() error {
+	return d.db.Close()
+}
+
+func (t *levelTx) release() {
+	for _, it := range t.iters {
+		it.Release()
+	}
+	t.iters = nil
+}
+
+func (t *levelTx) OnCommit(fn func()) {
+	t.onCommit = append(t.onCommit, fn)
+}
+
+func markerKey(name []byte) []byte {
+	return append([]byte{bucketMarkerPrefix}, name...)
+}
+
+func dataPrefix(name []byte) []byte {
+	prefix := []byte{bucketDataPrefix}
+	var n [binary.MaxVarintLen64]byte
+	prefix = append(prefix, n[:binary.PutUvarint(n[:], uint64(len(name)))]...)
+
+	return append(prefix, name...)
+}
+
+func (t *levelTx) has(key []byte) bool {
+	_, err := t.r.Get(key, nil)
+	return err == nil
+}
+
+func (t *levelTx) Bucket(name []byte) Bucket {
+	if !t.has(markerKey(name)) {
+		return nil
+	}
+
+	return &levelBucket{t, dataPrefix(name)}
+}
+
+func (t *levelTx) CreateBucket(name []byte) (Bucket, error) {
+	if t.tr == nil {
+		return nil, ErrTxNotWritable
+	}
+	if t.has(markerKey(name)) {
+		return nil, ErrBucketExists
+	}
+	if err := t.tr.Put(markerKey(name), nil, nil); err != nil {
+		return nil, err
+	}
+
+	return &levelBucket{t, dataPrefix(name)}, nil
+}
+
+func (t *levelTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if b := t.Bucket(name); b != nil {
+		return b, nil
+	}
+
+	return t.CreateBucket(name)
+}
+
+func (t *levelTx) DeleteBucket(name []byte) error {
+	if t.tr == nil {
+		return ErrTxNotWritable
+	}
+	if !t.has(markerKey(name)) {
+		return ErrBucketNotFound
+	}
+
+	it := t.tr.NewIterator(util.BytesPrefix(dataPrefix(name)), nil)
+	var keys [][]byte
+	for it.Next() {
+		keys = append(keys, append([]byte{}, it.Key()...))
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := t.tr.Delete(k, nil); err != nil {
+			return err
+		}
+	}
+
+	return t.tr.Delete(markerKey(name), nil)
+}
+
+func (b *levelBucket) key(k []byte) []byte {
+	return append(append([]byte{}, b.prefix...), k...)
+}
+
+func (b *levelBucket) Get(key []byte) []byte {
+	v, err := b.tx.r.Get(b.key(key), nil)
+	if err != nil {
+		return nil
+	}
+	// 和 bolt 一样，存在的键不返回 nil
+	if v == nil {
+		v = []byte{}
+	}
+
+	return v
+}
+
+func (b *levelBucket) Put(key, value []byte) error {
+	if b.tx.tr == nil {
+		return ErrTxNotWritable
+	}
+
+	return b.tx.tr.Put(b.key(key), value, nil)
+}
+
+func (b *levelBucket) Delete(key []byte) error {
+	if b.tx.tr == nil {
+		return ErrTxNotWritable
+	}
+
+	return b.tx.tr.Delete(b.key(key), nil)
+}
+
+func (b *levelBucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *levelBucket) Cursor() Cursor {
+	it := b.tx.r.NewIterator(util.BytesPrefix(b.prefix), nil)
+	b.tx.iters = append(b.tx.iters, it)
+
+	return &levelCursor{b, it}
+}
+
+// item 去掉前缀并复制，迭代器移动后原来的切片会被覆盖
+func (c *levelCursor) item(ok bool) ([]byte, []byte) {
+	if !ok {
+		return nil, nil
+	}
+
+	k := append([]byte{}, c.it.Key()[len(c.b.prefix):]...)
+	return k, append([]byte{}, c.it.Value()...)
+}
+
+func (c *levelCursor) First() ([]byte, []byte) {
+	return c.item(c.it.First())
+}
+
+func (c *levelCursor) Next() ([]byte, []byte) {
+	return c.item(c.it.Next())
+}
+
+func (c *levelCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.item(c.it.Seek(c.b.key(seek)))
+}
@@ -0,0 +1,309 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// memDB keeps the buckets in memory. The databases are registered by path, so
+// that a chain closed and opened again in the same process finds its blocks.
+type memDB struct {
+	mu      sync.RWMutex
+	buckets map[string]*memBucket
+}
+
+type memBucket struct {
+	// 有序的键，Cursor 按它遍历
+	keys   []string
+	values map[string][]byte
+}
+
+type memTx struct {
+	db       *memDB
+	writable bool
+	// 回滚时倒序执行
+	undo     []func()
+	onCommit []func()
+}
+
+type memBucketTx struct {
+	tx *memTx
+	b  *memBucket
+}
+
+type memCursor struct {
+	b   *memBucket
+	key string
+	// 还没有定位时 Next 从头开始
+	started bool
+}
+
+var memDBs = struct {
+	sync.Mutex
+	m map[string]*memDB
+}{m: make(map[string]*memDB)}
+
+// NewMemory returns an in-memory database which is not registered, for tests
+func NewMemory() DB {
+	return &memDB{buckets: make(map[string]*memBucket)}
+}
+
+func openMemory(path string) DB {
+	memDBs.Lock()
+	defer memDBs.Unlock()
+
+	db := memDBs.m[path]
+	if db == nil {
+		db = NewMemory().(*memDB)
+		memDBs.m[path] = db
+	}
+
+	return db
+}
+
+func memoryExists(path string) bool {
+	memDBs.Lock()
+	defer memDBs.Unlock()
+
+	return memDBs.m[path] != nil
+}
+
+func removeMemory(path string) {
+	memDBs.Lock()
+	defer memDBs.Unlock()
+
+	delete(memDBs.m, path)
+}
+
+func (d *memDB) View(fn func(tx Tx) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return fn(&memTx{db: d})
+}
+
+func (d *memDB) Update(fn func(tx Tx) error) error {
+	tx := &memTx{db: d, writable: true}
+	if err := d.update(tx, fn); err != nil {
+		return err
+	}
+
+	// 和 bolt 一样，释放锁之后再调用
+	for _, f := range tx.onCommit {
+		f()
+	}
+	return nil
+}
+
+func (d *memDB) update(tx *memTx, fn func(tx Tx) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	committed := false
+	defer func() {
+		if !committed {
+			tx.rollback()
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	committed = true
+
+	return nil
+}
+
+func (d *memDB) Batch(fn func(tx Tx) error) error {
+	return d.Update(fn)
+}
+
+// Close keeps the buckets, the database can be opened again by its path
+func (d *memDB) Close() error {
+	return nil
+}
+
+func (t *memTx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+}
+
+func (t *memTx) OnCommit(fn func()) {
+	t.onCommit = append(t.onCommit, fn)
+}
+
+func (t *memTx) Bucket(name []byte) Bucket {
+	b := t.db.buckets[string(name)]
+	if b == nil {
+		return nil
+	}
+
+	return &memBucketTx{t, b}
+}
+
+func (t *memTx) CreateBucket(name []byte) (Bucket, error) {
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+	if t.db.buckets[string(name)] != nil {
+		return nil, ErrBucketExists
+	}
+
+	key := string(name)
+	b := &memBucket{values: make(map[string][]byte)}
+	t.db.buckets[key] = b
+	t.undo = append(t.undo, func() { delete(t.db.buckets, key) })
+
+	return &memBucketTx{t, b}, nil
+}
+
+func (t *memTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if b := t.Bucket(name); b != nil {
+		return b, nil
+	}
+
+	return t.CreateBucket(name)
+}
+
+func (t *memTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return ErrTxNotWritable
+	}
+	key := string(name)
+	b := t.db.buckets[key]
+	if b == nil {
+		return ErrBucketNotFound
+	}
+
+	delete(t.db.buckets, key)
+	t.undo = append(t.undo, func() { t.db.buckets[key] = b })
+
+	return nil
+}
+
+func (b *memBucketTx) Get(key []byte) []byte {
+	return b.b.values[string(key)]
+}
+
+func (b *memBucketTx) Put(key, value []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+
+	k := string(key)
+	if old, ok := b.b.values[k]; ok {
+		b.tx.undo = append(b.tx.undo, func() { b.b.values[k] = old })
+	} else {
+		b.b.insertKey(k)
+		b.tx.undo = append(b.tx.undo, func() { b.b.remove(k) })
+	}
+	b.b.values[k] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (b *memBucketTx) Delete(key []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+
+	k := string(key)
+	old, ok := b.b.values[k]
+	if !ok {
+		return nil
+	}
+	b.b.remove(k)
+	b.tx.undo = append(b.tx.undo, func() {
+		b.b.insertKey(k)
+		b.b.values[k] = old
+	})
+
+	return nil
+}
+
+func (b *memBucketTx) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *memBucketTx) Cursor() Cursor {
+	return &memCursor{b: b.b}
+}
+
+func (b *memBucket) insertKey(k string) {
+	i := sort.SearchStrings(b.keys, k)
+	b.keys = append(b.keys, "")
+	copy(b.keys[i+1:], b.keys[i:])
+	b.keys[i] = k
+}
+
+func (b *memBucket) remove(k string) {
+	i := sort.SearchStrings(b.keys, k)
+	if i < len(b.keys) && b.keys[i] == k {
+		b.keys = append(b.keys[:i], b.keys[i+1:]...)
+	}
+	delete(b.values, k)
+}
+
+// at 返回第 i 个键，游标记住的是键而不是下标，遍历时删除键不会跳过其他键
+func (c *memCursor) at(i int) ([]byte, []byte) {
+	if i >= len(c.b.keys) {
+		return nil, nil
+	}
+	c.key = c.b.keys[i]
+
+	return []byte(c.key), c.b.values[c.key]
+}
+
+func (c *memCursor) First() ([]byte, []byte) {
+	c.started = true
+	return c.at(0)
+}
+
+func (c *memCursor) Next() ([]byte, []byte) {
+	if !c.started {
+		return c.First()
+	}
+
+	i := sort.SearchStrings(c.b.keys, c.key)
+	if i < len(c.b.keys) && c.b.keys[i] == c.key {
+		i++
+	}
+	return c.at(i)
+}
+
+func (c *memCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.started = true
+	return c.at(sort.SearchStrings(c.b.keys, string(seek)))
+}
@@ -0,0 +1,144 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package storage is the key/value store of the chain. A DB holds named
+// buckets of sorted keys, it is read and written in transactions, like bolt
+// which was used directly before. The engines are bolt, leveldb and an
+// in-memory one for tests.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	Bolt    = "bolt"
+	LevelDB = "leveldb"
+	Memory  = "memory"
+)
+
+var (
+	ErrBucketNotFound = errors.New("storage: bucket not found")
+	ErrBucketExists   = errors.New("storage: bucket already exists")
+	ErrTxNotWritable  = errors.New("storage: transaction is not writable")
+	ErrUnknownEngine  = errors.New("storage: unknown engine")
+)
+
+// DB is an open database. The slices returned by the buckets are only valid
+// in the transaction which returned them.
+type DB interface {
+	// View runs a read-only transaction
+	View(fn func(tx Tx) error) error
+	// Update runs a read-write transaction, it is rolled back when fn fails
+	Update(fn func(tx Tx) error) error
+	// Batch is Update, the engine may commit several concurrent calls
+	// together. fn may then run more than once.
+	Batch(fn func(tx Tx) error) error
+	Close() error
+}
+
+type Tx interface {
+	// Bucket returns nil when the bucket does not exist
+	Bucket(name []byte) Bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	DeleteBucket(name []byte) error
+	// OnCommit adds a function called after the transaction is committed
+	OnCommit(fn func())
+}
+
+type Bucket interface {
+	// Get returns nil when the key does not exist
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// ForEach calls fn for the keys in order, the bucket must not be
+	// changed by fn
+	ForEach(fn func(k, v []byte) error) error
+	Cursor() Cursor
+}
+
+// Cursor walks the keys of a bucket in order, the methods return a nil key
+// after the last one
+type Cursor interface {
+	First() (key, value []byte)
+	Next() (key, value []byte)
+	Seek(seek []byte) (key, value []byte)
+}
+
+// 每个引擎的文件名后缀，内存数据库按路径登记
+var suffixes = map[string]string{
+	Bolt:    ".db",
+	LevelDB: ".ldb",
+}
+
+// Exists returns the engine of the database at path, which is the file name
+// without the suffix of the engine
+func Exists(path string) (string, bool) {
+	if memoryExists(path) {
+		return Memory, true
+	}
+	for engine, suffix := range suffixes {
+		if _, err := os.Stat(path + suffix); err == nil {
+			return engine, true
+		}
+	}
+
+	return "", false
+}
+
+// Open opens the database at path, or creates it with engine. The engine of
+// an existing database is found from its files.
+func Open(engine, path string) (DB, error) {
+	if existing, ok := Exists(path); ok {
+		engine = existing
+	}
+
+	switch engine {
+	case Bolt:
+		return openBolt(path + suffixes[Bolt])
+	case LevelDB:
+		return openLevelDB(path + suffixes[LevelDB])
+	case Memory:
+		return openMemory(path), nil
+	}
+
+	return nil, fmt.Errorf("%v: %q", ErrUnknownEngine, engine)
+}
+
+// Remove deletes the closed database at path
+func Remove(path string) error {
+	engine, ok := Exists(path)
+	if !ok {
+		return nil
+	}
+	if engine == Memory {
+		removeMemory(path)
+		return nil
+	}
+
+	return os.RemoveAll(path + suffixes[engine])
+}
@@ -0,0 +1,375 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"myBitCoin/storage"
+)
+
+var engines = []string{storage.Bolt, storage.LevelDB, storage.Memory}
+
+// forEachEngine runs test against a new database of every engine
+func forEachEngine(t *testing.T, test func(t *testing.T, engine, path string, db storage.DB)) {
+	for _, engine := range engines {
+		t.Run(engine, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "chain")
+			db, err := storage.Open(engine, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				db.Close()
+				storage.Remove(path)
+			})
+
+			test(t, engine, path, db)
+		})
+	}
+}
+
+func update(t *testing.T, db storage.DB, fn func(tx storage.Tx) error) {
+	t.Helper()
+
+	if err := db.Update(fn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// keys returns the keys and values of the bucket name in the order of its
+// cursor
+func keys(t *testing.T, db storage.DB, name string) []string {
+	t.Helper()
+
+	var got []string
+	err := db.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return storage.ErrBucketNotFound
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			got = append(got, string(k)+"="+string(v))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+func TestBuckets(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, _, _ string, db storage.DB) {
+		update(t, db, func(tx storage.Tx) error {
+			if tx.Bucket([]byte("a")) != nil {
+				return errors.New("bucket exists before it is created")
+			}
+			if _, err := tx.CreateBucket([]byte("a")); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket([]byte("a")); err != storage.ErrBucketExists {
+				return fmt.Errorf("created twice: %v", err)
+			}
+			if _, err := tx.CreateBucketIfNotExists([]byte("a")); err != nil {
+				return err
+			}
+			// 名字是另一个桶的前缀，键不能混在一起
+			ab, err := tx.CreateBucketIfNotExists([]byte("ab"))
+			if err != nil {
+				return err
+			}
+			if err := ab.Put([]byte("k"), []byte("ab")); err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket([]byte("missing")); err != storage.ErrBucketNotFound {
+				return fmt.Errorf("deleted a missing bucket: %v", err)
+			}
+			return nil
+		})
+		if got := keys(t, db, "a"); len(got) != 0 {
+			t.Fatalf("bucket a has %v", got)
+		}
+
+		update(t, db, func(tx storage.Tx) error {
+			return tx.DeleteBucket([]byte("ab"))
+		})
+		update(t, db, func(tx storage.Tx) error {
+			if tx.Bucket([]byte("ab")) != nil {
+				return errors.New("deleted bucket exists")
+			}
+			// 重新建立的桶是空的
+			ab, err := tx.CreateBucket([]byte("ab"))
+			if err != nil {
+				return err
+			}
+			if v := ab.Get([]byte("k")); v != nil {
+				return fmt.Errorf("deleted key has %q", v)
+			}
+			return nil
+		})
+	})
+}
+
+func TestPutGetDelete(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, _, _ string, db storage.DB) {
+		update(t, db, func(tx storage.Tx) error {
+			b, err := tx.CreateBucket([]byte("b"))
+			if err != nil {
+				return err
+			}
+			for _, kv := range [][2]string{{"k1", "v1"}, {"k2", "v2"}, {"k1", "v3"}, {"empty", ""}} {
+				if err := b.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+					return err
+				}
+			}
+			// 事务中能读到自己的写入
+			if v := b.Get([]byte("k1")); string(v) != "v3" {
+				return fmt.Errorf("k1 is %q in the transaction", v)
+			}
+			if err := b.Delete([]byte("k2")); err != nil {
+				return err
+			}
+			return b.Delete([]byte("missing"))
+		})
+
+		err := db.View(func(tx storage.Tx) error {
+			b := tx.Bucket([]byte("b"))
+			if v := b.Get([]byte("k1")); string(v) != "v3" {
+				return fmt.Errorf("k1 is %q", v)
+			}
+			if v := b.Get([]byte("k2")); v != nil {
+				return fmt.Errorf("deleted k2 is %q", v)
+			}
+			if v := b.Get([]byte("empty")); v == nil || len(v) != 0 {
+				return fmt.Errorf("empty value is %#v", v)
+			}
+			if err := b.Put([]byte("k"), []byte("v")); err != storage.ErrTxNotWritable {
+				return fmt.Errorf("put in a read-only transaction: %v", err)
+			}
+			if _, err := tx.CreateBucket([]byte("c")); err != storage.ErrTxNotWritable {
+				return fmt.Errorf("bucket created in a read-only transaction: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestCursor(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, _, _ string, db storage.DB) {
+		update(t, db, func(tx storage.Tx) error {
+			if _, err := tx.CreateBucket([]byte("empty")); err != nil {
+				return err
+			}
+			b, err := tx.CreateBucket([]byte("c"))
+			if err != nil {
+				return err
+			}
+			for _, k := range []string{"d", "b", "a\xff", "c", "a"} {
+				if err := b.Put([]byte(k), []byte(k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		want := []string{"a=a", "a\xff=a\xff", "b=b", "c=c", "d=d"}
+		if got := keys(t, db, "c"); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("cursor walks %q, want %q", got, want)
+		}
+		if got := keys(t, db, "empty"); len(got) != 0 {
+			t.Fatalf("empty bucket has %q", got)
+		}
+
+		err := db.View(func(tx storage.Tx) error {
+			b := tx.Bucket([]byte("c"))
+			var visited []string
+			err := b.ForEach(func(k, v []byte) error {
+				visited = append(visited, string(k)+"="+string(v))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if fmt.Sprint(visited) != fmt.Sprint(want) {
+				return fmt.Errorf("ForEach visits %q", visited)
+			}
+
+			c := b.Cursor()
+			for _, seek := range [][2]string{{"a", "a"}, {"a\x00", "a\xff"}, {"bb", "c"}, {"d", "d"}, {"e", ""}} {
+				k, _ := c.Seek([]byte(seek[0]))
+				if string(k) != seek[1] || (seek[1] == "" && k != nil) {
+					return fmt.Errorf("seek %q found %q, want %q", seek[0], k, seek[1])
+				}
+			}
+			if k, _ := c.Seek([]byte("c")); !bytes.Equal(k, []byte("c")) {
+				return fmt.Errorf("seek c found %q", k)
+			}
+			if k, _ := c.Next(); !bytes.Equal(k, []byte("d")) {
+				return fmt.Errorf("next after c is %q", k)
+			}
+			if k, _ := c.Next(); k != nil {
+				return fmt.Errorf("next after the last key is %q", k)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestRollback(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, _, _ string, db storage.DB) {
+		update(t, db, func(tx storage.Tx) error {
+			b, err := tx.CreateBucket([]byte("b"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("k"), []byte("v"))
+		})
+
+		failed := errors.New("failed")
+		committed := false
+		err := db.Update(func(tx storage.Tx) error {
+			tx.OnCommit(func() { committed = true })
+			b := tx.Bucket([]byte("b"))
+			if err := b.Put([]byte("k"), []byte("changed")); err != nil {
+				return err
+			}
+			if err := b.Put([]byte("new"), []byte("v")); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket([]byte("created")); err != nil {
+				return err
+			}
+			return failed
+		})
+		if err != failed {
+			t.Fatalf("Update returned %v", err)
+		}
+		if committed {
+			t.Fatal("OnCommit was called on a rolled back transaction")
+		}
+		if got := keys(t, db, "b"); fmt.Sprint(got) != "[k=v]" {
+			t.Fatalf("rolled back bucket has %q", got)
+		}
+
+		err = db.View(func(tx storage.Tx) error {
+			if tx.Bucket([]byte("created")) != nil {
+				return errors.New("bucket of a rolled back transaction exists")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		update(t, db, func(tx storage.Tx) error {
+			tx.OnCommit(func() {
+				// 提交之后调用，不能再持有写锁
+				committed = db.View(func(tx storage.Tx) error { return nil }) == nil
+			})
+			return tx.Bucket([]byte("b")).Delete([]byte("k"))
+		})
+		if !committed {
+			t.Fatal("OnCommit was not called")
+		}
+	})
+}
+
+func TestBatch(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, _, _ string, db storage.DB) {
+		update(t, db, func(tx storage.Tx) error {
+			_, err := tx.CreateBucket([]byte("b"))
+			return err
+		})
+
+		const n = 20
+		var wg sync.WaitGroup
+		errs := make(chan error, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- db.Batch(func(tx storage.Tx) error {
+					return tx.Bucket([]byte("b")).Put([]byte(fmt.Sprintf("%02d", i)), nil)
+				})
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := keys(t, db, "b"); len(got) != n {
+			t.Fatalf("%d of %d batched puts were written", len(got), n)
+		}
+	})
+}
+
+func TestReopen(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, engine, path string, db storage.DB) {
+		update(t, db, func(tx storage.Tx) error {
+			b, err := tx.CreateBucket([]byte("b"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("k"), []byte("v"))
+		})
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if found, ok := storage.Exists(path); !ok || found != engine {
+			t.Fatalf("Exists found %q %v", found, ok)
+		}
+		// 已经存在的数据库按文件识别引擎，传入的引擎不起作用
+		other := storage.Bolt
+		if engine == storage.Bolt {
+			other = storage.LevelDB
+		}
+		reopened, err := storage.Open(other, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		if got := keys(t, reopened, "b"); fmt.Sprint(got) != "[k=v]" {
+			t.Fatalf("reopened bucket has %q", got)
+		}
+	})
+}
@@ -0,0 +1,371 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package stratum
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+
+	blk "myBitCoin/block"
+)
+
+var ErrNotAuthorized = errors.New("stratum: worker is not authorized")
+
+// Miner mines for a pool: it solves the jobs of the server at the share
+// difficulty and submits every share it finds.
+type Miner struct {
+	Addr     string
+	Worker   string
+	Password string
+	// Threads and HashRate are those of blk.Miner
+	Threads  int
+	HashRate func(hashesPerSec float64)
+	// Share is called with the answer of the server to each share
+	Share func(jobID string, accepted bool, err error)
+
+	conn        net.Conn
+	enc         *json.Encoder
+	extraNonce1 []byte
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan *message
+	target  *big.Int
+	job     *minerJob
+	newJob  chan struct{}
+}
+
+// minerJob 是解析后的 mining.notify
+type minerJob struct {
+	id       string
+	prevHash []byte
+	coinb1   []byte
+	coinb2   []byte
+	branch   [][]byte
+	nTime    int64
+}
+
+// Run connects to the pool and mines until ctx is done or the connection is lost
+func (m *Miner) Run(ctx context.Context) error {
+	conn, err := net.Dial("tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m.conn = conn
+	m.enc = json.NewEncoder(conn)
+	m.pending = make(map[int]chan *message)
+	m.target = ShareTarget(1)
+	m.newJob = make(chan struct{}, 1)
+
+	readErr := make(chan error, 1)
+	go func() { readErr <- m.readLoop() }()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var lost error
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case lost = <-readErr:
+			cancel()
+		}
+		conn.Close()
+		close(done)
+	}()
+
+	err = m.handshake()
+	if err == nil {
+		m.mine(ctx)
+	}
+	cancel()
+	<-done
+
+	if lost != nil {
+		return lost
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (m *Miner) handshake() error {
+	var sub []json.RawMessage
+	if err := m.call("mining.subscribe", []interface{}{"mybitcoin"}, &sub); err != nil {
+		return err
+	}
+	if len(sub) < 3 {
+		return errors.New("stratum: bad subscribe result")
+	}
+	var en1 string
+	var en2Size int
+	if err := json.Unmarshal(sub[1], &en1); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(sub[2], &en2Size); err != nil {
+		return err
+	}
+	extraNonce1, err := hex.DecodeString(en1)
+	if err != nil || en2Size != ExtraNonce2Size {
+		return errors.New("stratum: bad extranonce")
+	}
+	m.extraNonce1 = extraNonce1
+
+	var ok bool
+	if err := m.call("mining.authorize", []interface{}{m.Worker, m.Password}, &ok); err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotAuthorized
+	}
+
+	return nil
+}
+
+// mine 解当前任务，新任务到达时放弃当前的搜索
+func (m *Miner) mine(ctx context.Context) {
+	miner := &blk.Miner{Threads: m.Threads, HashRate: m.HashRate}
+	var hashes uint64
+	defer miner.ReportHashRate(&hashes)()
+
+	for {
+		select {
+		case <-m.newJob:
+		case <-ctx.Done():
+			return
+		}
+
+		m.mu.Lock()
+		job, target := m.job, m.target
+		m.mu.Unlock()
+		if job == nil {
+			continue
+		}
+
+		jobCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-m.newJob:
+				cancel()
+				// 留给下一轮循环读取
+				select {
+				case m.newJob <- struct{}{}:
+				default:
+				}
+			case <-jobCtx.Done():
+			}
+		}()
+		m.solveJob(jobCtx, miner, job, target, &hashes)
+		cancel()
+	}
+}
+
+// solveJob searches the nonces of each extranonce2 in turn
+func (m *Miner) solveJob(ctx context.Context, miner *blk.Miner, job *minerJob, target *big.Int, hashes *uint64) {
+	extraNonce2 := make([]byte, ExtraNonce2Size)
+
+	for en2 := uint32(0); ctx.Err() == nil; en2++ {
+		binary.BigEndian.PutUint32(extraNonce2, en2)
+		coinbase, err := WorkerCoinbase(job.coinb1, append(m.extraNonce1[:len(m.extraNonce1):len(m.extraNonce1)], extraNonce2...), job.coinb2)
+		if err != nil {
+			m.share(job.id, false, err)
+			return
+		}
+
+		header := &blk.BlockHeader{
+			TimeStamp:  job.nTime,
+			PrevHash:   job.prevHash,
+			MerkleRoot: MerkleRoot(coinbase, job.branch),
+		}
+		nonce, _, ok := miner.SolveHeader(ctx, header, target, hashes)
+		if !ok {
+			continue
+		}
+
+		// 一个 extranonce2 只提交一个 share，服务器不用检查同一个头的重复
+		go m.submit(job.id, hex.EncodeToString(extraNonce2), fmt.Sprintf("%08x", job.nTime), fmt.Sprintf("%08x", nonce))
+	}
+}
+
+func (m *Miner) submit(jobID, extraNonce2, nTime, nonce string) {
+	var accepted bool
+	err := m.call("mining.submit", []interface{}{m.Worker, jobID, extraNonce2, nTime, nonce}, &accepted)
+	m.share(jobID, err == nil && accepted, err)
+}
+
+func (m *Miner) share(jobID string, accepted bool, err error) {
+	if m.Share != nil {
+		m.Share(jobID, accepted, err)
+	}
+}
+
+// call sends a request and waits for its response
+func (m *Miner) call(method string, params []interface{}, result interface{}) error {
+	ch := make(chan *message, 1)
+
+	m.mu.Lock()
+	m.nextID++
+	id := m.nextID
+	m.pending[id] = ch
+	err := m.enc.Encode(&Request{ID: id, Method: method, Params: params})
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	resp, ok := <-ch
+	if !ok {
+		return errors.New("stratum: connection closed")
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	return json.Unmarshal(resp.Result, result)
+}
+
+func (m *Miner) readLoop() error {
+	defer func() {
+		m.mu.Lock()
+		for id, ch := range m.pending {
+			close(ch)
+			delete(m.pending, id)
+		}
+		m.mu.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(m.conn)
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return err
+		}
+
+		if msg.Method == "" {
+			id, _ := msg.ID.(float64)
+			m.mu.Lock()
+			ch, ok := m.pending[int(id)]
+			delete(m.pending, int(id))
+			m.mu.Unlock()
+			if ok {
+				ch <- &msg
+			}
+			continue
+		}
+
+		if err := m.handleNotification(msg.Method, msg.Params); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return errors.New("stratum: connection closed")
+}
+
+func (m *Miner) handleNotification(method string, params json.RawMessage) error {
+	switch method {
+	case "mining.set_difficulty":
+		var p []float64
+		if err := json.Unmarshal(params, &p); err != nil || len(p) < 1 || p[0] <= 0 {
+			return fmt.Errorf("stratum: bad difficulty %s", params)
+		}
+		m.mu.Lock()
+		m.target = ShareTarget(p[0])
+		m.mu.Unlock()
+
+	case "mining.notify":
+		job, err := parseJob(params)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.job = job
+		m.mu.Unlock()
+
+		select {
+		case m.newJob <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func parseJob(params json.RawMessage) (*minerJob, error) {
+	var p []json.RawMessage
+	if err := json.Unmarshal(params, &p); err != nil || len(p) < 8 {
+		return nil, fmt.Errorf("stratum: bad job %s", params)
+	}
+
+	var id, prevHash, coinb1, coinb2, nTime string
+	var branch []string
+	fields := []struct {
+		index int
+		v     interface{}
+	}{{0, &id}, {1, &prevHash}, {2, &coinb1}, {3, &coinb2}, {4, &branch}, {7, &nTime}}
+	for _, f := range fields {
+		if err := json.Unmarshal(p[f.index], f.v); err != nil {
+			return nil, fmt.Errorf("stratum: bad job %s", params)
+		}
+	}
+
+	job := &minerJob{id: id}
+	var err error
+	if job.prevHash, err = hex.DecodeString(prevHash); err != nil {
+		return nil, err
+	}
+	if job.coinb1, err = hex.DecodeString(coinb1); err != nil {
+		return nil, err
+	}
+	if job.coinb2, err = hex.DecodeString(coinb2); err != nil {
+		return nil, err
+	}
+	for _, h := range branch {
+		hash, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, err
+		}
+		job.branch = append(job.branch, hash)
+	}
+	if job.nTime, err = strconv.ParseInt(nTime, 16, 64); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
@@ -0,0 +1,191 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package stratum lets CPU miners of several machines mine for one node with
+// the Stratum v1 protocol: mining.subscribe, mining.authorize, mining.notify,
+// mining.set_difficulty and mining.submit, as newline separated JSON-RPC.
+//
+// coinb1 and coinb2 of mining.notify are the gob encoded coinbase without
+// its ID, split around the extranonce at the end of the data of its input.
+// The ID is part of the serialization of a transaction, so a miner decodes
+// coinb1 + extranonce1 + extranonce2 + coinb2, sets the ID and hashes the
+// serialized coinbase to get its merkle leaf. The leaf is hashed with each
+// hash of merkle_branch in turn, an empty branch meaning the coinbase is
+// alone and hashed with itself.
+package stratum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	blk "myBitCoin/block"
+	"myBitCoin/transaction"
+)
+
+const (
+	ExtraNonce1Size = 4
+	ExtraNonce2Size = 4
+)
+
+// Stratum error codes
+const (
+	ErrCodeOther          = 20
+	ErrCodeJobNotFound    = 21
+	ErrCodeDuplicateShare = 22
+	ErrCodeLowDifficulty  = 23
+	ErrCodeUnauthorized   = 24
+	ErrCodeNotSubscribed  = 25
+)
+
+// diff1Target is the share target of difficulty 1, one hash in 65536 is below it
+var diff1Target = new(big.Int).Lsh(big.NewInt(1), 256-16)
+
+// Error is a stratum error, sent as [code, message, null]
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stratum: %d %s", e.Code, e.Message)
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+func (e *Error) UnmarshalJSON(data []byte) error {
+	var fields []interface{}
+	if err := json.Unmarshal(data, &fields); err != nil || len(fields) < 2 {
+		return fmt.Errorf("stratum: bad error %s", data)
+	}
+	code, _ := fields[0].(float64)
+	e.Code = int(code)
+	e.Message, _ = fields[1].(string)
+
+	return nil
+}
+
+// Request is a call, or a notification when ID is nil
+type Request struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+type Response struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	Error  *Error      `json:"error"`
+}
+
+// message 同时能解析请求和响应，客户端读到的两种都有
+type message struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// ShareTarget returns the target of a share difficulty, never below the block target
+func ShareTarget(difficulty float64) *big.Int {
+	target, _ := new(big.Float).Quo(new(big.Float).SetInt(diff1Target), big.NewFloat(difficulty)).Int(nil)
+	if blockTarget := blk.Target(); target.Cmp(blockTarget) < 0 {
+		return blockTarget
+	}
+
+	return target
+}
+
+// SplitCoinbase encodes coinbase without its ID and with room for the
+// extranonce at the end of the data of its input, and returns the bytes
+// before and after the extranonce
+func SplitCoinbase(coinbase *transaction.Transaction) (coinb1, coinb2 []byte, err error) {
+	if !coinbase.IsCoinbase() {
+		return nil, nil, fmt.Errorf("stratum: not a coinbase")
+	}
+
+	// 用两种填充编码两次，第一个不同的字节就是 extranonce 的位置
+	encode := func(fill byte) []byte {
+		tx := *coinbase
+		tx.ID = nil
+		tx.Vin = []transaction.TxInput{coinbase.Vin[0]}
+		data := tx.Vin[0].PubKey
+		tx.Vin[0].PubKey = append(data[:len(data):len(data)], bytes.Repeat([]byte{fill}, ExtraNonce1Size+ExtraNonce2Size)...)
+		return tx.Serialize()
+	}
+	zeros, ones := encode(0), encode(0xff)
+
+	i := 0
+	for i < len(zeros) && zeros[i] == ones[i] {
+		i++
+	}
+	end := i + ExtraNonce1Size + ExtraNonce2Size
+	if len(zeros) != len(ones) || end > len(zeros) || !bytes.Equal(zeros[end:], ones[end:]) {
+		return nil, nil, fmt.Errorf("stratum: can not split the coinbase")
+	}
+
+	return zeros[:i], zeros[end:], nil
+}
+
+// WorkerCoinbase returns the coinbase of a worker: coinb1, extraNonce and
+// coinb2 decoded, with its ID set
+func WorkerCoinbase(coinb1, extraNonce, coinb2 []byte) (*transaction.Transaction, error) {
+	if len(extraNonce) != ExtraNonce1Size+ExtraNonce2Size {
+		return nil, fmt.Errorf("stratum: extranonce is %d bytes", len(extraNonce))
+	}
+	data := append(append(append([]byte{}, coinb1...), extraNonce...), coinb2...)
+	coinbase, err := transaction.DeserializeTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+	if !coinbase.IsCoinbase() || len(coinbase.ID) != 0 {
+		return nil, fmt.Errorf("stratum: coinb1 and coinb2 are not a coinbase")
+	}
+	if err := coinbase.SetID(); err != nil {
+		return nil, err
+	}
+
+	return coinbase, nil
+}
+
+// MerkleRoot folds the merkle branch of the coinbase
+func MerkleRoot(coinbase *transaction.Transaction, branch [][]byte) []byte {
+	leaf := sha256.Sum256(coinbase.Serialize())
+	root := leaf[:]
+
+	if len(branch) == 0 {
+		branch = [][]byte{root}
+	}
+	for _, h := range branch {
+		sum := sha256.Sum256(append(append([]byte{}, root...), h...))
+		root = sum[:]
+	}
+
+	return root
+}
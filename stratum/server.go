@@ -0,0 +1,557 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package stratum
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	blk "myBitCoin/block"
+	"myBitCoin/merkle"
+	"myBitCoin/mining"
+	"myBitCoin/transaction"
+)
+
+const (
+	// 同时有效的任务数，更早的任务提交的 share 会被拒绝
+	maxJobs = 16
+
+	// ntime 最多比现在超前这么多秒
+	maxTimeOffset = 2 * 60 * 60
+
+	defaultJobInterval = 30 * time.Second
+)
+
+var ErrServerClosed = errors.New("stratum: server closed")
+
+// Config configures a Server
+type Config struct {
+	Addr string
+	// PayToAddress receives the subsidy and the fees of the blocks found by the pool
+	PayToAddress string
+	// ShareDifficulty of the shares, 1 when 0. A share of difficulty 1 is a
+	// hash below 2^240.
+	ShareDifficulty float64
+	Generator       *mining.BlkTmplGenerator
+	Chain           *blk.BlockChain
+	// Workers are the names and passwords of the miners allowed to mine
+	Workers map[string]string
+	// SubmitBlock connects a block solved by a worker
+	SubmitBlock func(b *blk.Block) error
+	// JobInterval is how often a job with the new mempool transactions is sent
+	// when the tip does not change, 30s when 0
+	JobInterval time.Duration
+}
+
+// WorkerStats counts the shares of a worker
+type WorkerStats struct {
+	Accepted int
+	Rejected int
+	Blocks   int
+}
+
+// job 是发给矿工的一个区块模板
+type job struct {
+	id     string
+	tmpl   *mining.BlockTemplate
+	coinb1 []byte
+	coinb2 []byte
+	branch [][]byte
+	shares map[string]bool
+}
+
+// Server is a Stratum v1 server handing out block templates to pool miners
+type Server struct {
+	cfg         Config
+	shareTarget *big.Int
+	listener    net.Listener
+	quit        chan struct{}
+	wg          sync.WaitGroup
+
+	mu          sync.Mutex
+	jobs        map[string]*job
+	current     *job
+	nextJobID   uint64
+	extraNonce1 uint32
+	sessions    map[*session]bool
+	stats       map[string]*WorkerStats
+}
+
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.Generator == nil || cfg.Chain == nil || cfg.SubmitBlock == nil {
+		return nil, errors.New("stratum: Generator, Chain and SubmitBlock are required")
+	}
+	if len(cfg.Workers) == 0 {
+		return nil, errors.New("stratum: no worker is allowed to mine")
+	}
+	if cfg.ShareDifficulty <= 0 {
+		cfg.ShareDifficulty = 1
+	}
+	if cfg.JobInterval <= 0 {
+		cfg.JobInterval = defaultJobInterval
+	}
+
+	s := &Server{
+		cfg:         cfg,
+		shareTarget: ShareTarget(cfg.ShareDifficulty),
+		quit:        make(chan struct{}),
+		jobs:        make(map[string]*job),
+		sessions:    make(map[*session]bool),
+		stats:       make(map[string]*WorkerStats),
+	}
+	// 第一个模板也用来检查收款地址
+	if err := s.newJob(true); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Listen opens the tcp port of the pool
+func (s *Server) Listen() error {
+	l, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+
+	return nil
+}
+
+// Addr returns the address of the pool, nil when Listen was not called
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Addr()
+}
+
+// Serve accepts miners and sends them new jobs until Close is called
+func (s *Server) Serve() error {
+	s.wg.Add(1)
+	go s.jobLoop()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return ErrServerClosed
+			default:
+				return err
+			}
+		}
+
+		sess := &session{s: s, conn: conn, enc: json.NewEncoder(conn), workers: make(map[string]bool)}
+		s.mu.Lock()
+		s.extraNonce1++
+		binary.BigEndian.PutUint32(sess.extraNonce1[:], s.extraNonce1)
+		s.sessions[sess] = true
+		s.mu.Unlock()
+
+		go sess.serve()
+	}
+}
+
+func (s *Server) Close() {
+	close(s.quit)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	s.mu.Lock()
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// Stats returns the share counts of the workers
+func (s *Server) Stats() map[string]WorkerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]WorkerStats)
+	for worker, st := range s.stats {
+		stats[worker] = *st
+	}
+
+	return stats
+}
+
+// jobLoop 链尾变化时发送 clean 任务，否则定时带上交易池的新交易
+func (s *Server) jobLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.JobInterval)
+	defer ticker.Stop()
+
+	// 先取下一次变化的通道再生成模板，生成期间的变化也不会错过
+	tipChanged := s.cfg.Chain.TipChanged()
+	for {
+		clean := false
+		select {
+		case <-tipChanged:
+			tipChanged = s.cfg.Chain.TipChanged()
+			clean = true
+		case <-ticker.C:
+		case <-s.quit:
+			return
+		}
+
+		if err := s.newJob(clean); err != nil {
+			log.Printf("stratum: new job: %v", err)
+			continue
+		}
+		s.broadcastJob(clean)
+	}
+}
+
+func (s *Server) newJob(clean bool) error {
+	tmpl, err := s.cfg.Generator.NewBlockTemplate(s.cfg.PayToAddress)
+	if err != nil {
+		return err
+	}
+
+	coinb1, coinb2, err := SplitCoinbase(tmpl.Block.Transactions[0])
+	if err != nil {
+		return err
+	}
+
+	var leaves [][]byte
+	for _, tx := range tmpl.Block.Transactions {
+		leaves = append(leaves, tx.Serialize())
+	}
+	// coinbase 单独一笔时和自己哈希，分支留空，见 MerkleRoot
+	var branch [][]byte
+	if len(leaves) > 1 {
+		tree, err := merkle.NewMerkleTree(leaves)
+		if err != nil {
+			return err
+		}
+		proof, err := tree.Proof(0)
+		if err != nil {
+			return err
+		}
+		branch = proof.Siblings
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if clean {
+		s.jobs = make(map[string]*job)
+	}
+	if len(s.jobs) >= maxJobs {
+		var oldest *job
+		for _, j := range s.jobs {
+			if oldest == nil || j.tmpl.Block.TimeStamp < oldest.tmpl.Block.TimeStamp {
+				oldest = j
+			}
+		}
+		delete(s.jobs, oldest.id)
+	}
+
+	s.nextJobID++
+	j := &job{
+		id:     strconv.FormatUint(s.nextJobID, 16),
+		tmpl:   tmpl,
+		coinb1: coinb1,
+		coinb2: coinb2,
+		branch: branch,
+		shares: make(map[string]bool),
+	}
+	s.jobs[j.id] = j
+	s.current = j
+
+	return nil
+}
+
+func (s *Server) broadcastJob(clean bool) {
+	s.mu.Lock()
+	j := s.current
+	var sessions []*session
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		if sess.isSubscribed() {
+			sess.notify("mining.notify", j.params(clean))
+		}
+	}
+}
+
+// params are job_id, prevhash, coinb1, coinb2, merkle_branch, version, nbits,
+// ntime and clean_jobs. Blocks have no version, it is always 1.
+func (j *job) params(clean bool) []interface{} {
+	branch := []string{}
+	for _, h := range j.branch {
+		branch = append(branch, hex.EncodeToString(h))
+	}
+
+	b := j.tmpl.Block
+	return []interface{}{
+		j.id,
+		hex.EncodeToString(b.PrevHash),
+		hex.EncodeToString(j.coinb1),
+		hex.EncodeToString(j.coinb2),
+		branch,
+		"00000001",
+		fmt.Sprintf("%08x", j.tmpl.Bits),
+		fmt.Sprintf("%08x", b.TimeStamp),
+		clean,
+	}
+}
+
+func (s *Server) removeSession(sess *session) {
+	s.mu.Lock()
+	delete(s.sessions, sess)
+	s.mu.Unlock()
+}
+
+// authorized checks the password of a worker. Only the configured workers
+// have stats, so they can not grow without bound.
+func (s *Server) authorized(worker, password string) bool {
+	want, ok := s.cfg.Workers[worker]
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+func (s *Server) count(worker string, f func(st *WorkerStats)) {
+	s.mu.Lock()
+	st, ok := s.stats[worker]
+	if !ok {
+		st = &WorkerStats{}
+		s.stats[worker] = st
+	}
+	f(st)
+	s.mu.Unlock()
+}
+
+// submit checks a share of a worker, and submits the block when the share
+// is also below the block target
+func (s *Server) submit(sess *session, worker, jobID, extraNonce2, nTime, nonce string) *Error {
+	s.mu.Lock()
+	j, ok := s.jobs[jobID]
+	s.mu.Unlock()
+	if !ok {
+		return &Error{ErrCodeJobNotFound, "Job not found"}
+	}
+
+	en2, err := hex.DecodeString(extraNonce2)
+	if err != nil || len(en2) != ExtraNonce2Size {
+		return &Error{ErrCodeOther, "Bad extranonce2"}
+	}
+	timeStamp, err := strconv.ParseInt(nTime, 16, 64)
+	if err != nil || timeStamp < j.tmpl.Block.TimeStamp || timeStamp > time.Now().Unix()+maxTimeOffset {
+		return &Error{ErrCodeOther, "Bad ntime"}
+	}
+	n, err := strconv.ParseUint(nonce, 16, 32)
+	if err != nil {
+		return &Error{ErrCodeOther, "Bad nonce"}
+	}
+
+	key := hex.EncodeToString(sess.extraNonce1[:]) + extraNonce2 + nTime + nonce
+	s.mu.Lock()
+	dup := j.shares[key]
+	j.shares[key] = true
+	s.mu.Unlock()
+	if dup {
+		return &Error{ErrCodeDuplicateShare, "Duplicate share"}
+	}
+
+	coinbase, err := WorkerCoinbase(j.coinb1, append(sess.extraNonce1[:], en2...), j.coinb2)
+	if err != nil {
+		return &Error{ErrCodeOther, err.Error()}
+	}
+	header := &blk.BlockHeader{
+		TimeStamp:  timeStamp,
+		PrevHash:   j.tmpl.Block.PrevHash,
+		MerkleRoot: MerkleRoot(coinbase, j.branch),
+		Nonce:      int(n),
+		Height:     j.tmpl.Height,
+	}
+	header.Hash = blk.NewHeaderProofOfWork(header).CalcHash()
+
+	hashInt := new(big.Int).SetBytes(header.Hash)
+	if hashInt.Cmp(s.shareTarget) >= 0 {
+		return &Error{ErrCodeLowDifficulty, "Low difficulty share"}
+	}
+	if hashInt.Cmp(blk.Target()) < 0 {
+		s.submitBlock(worker, j, coinbase, header)
+	}
+
+	return nil
+}
+
+func (s *Server) submitBlock(worker string, j *job, coinbase *transaction.Transaction, header *blk.BlockHeader) {
+	b := *j.tmpl.Block
+	b.Transactions = append([]*transaction.Transaction{coinbase}, b.Transactions[1:]...)
+	b.TimeStamp = header.TimeStamp
+	b.Nonce = header.Nonce
+	b.Hash = header.Hash
+
+	if err := s.cfg.SubmitBlock(&b); err != nil {
+		log.Printf("stratum: block %x of %s rejected: %v", b.Hash, worker, err)
+		return
+	}
+	s.count(worker, func(st *WorkerStats) { st.Blocks++ })
+	log.Printf("stratum: %s found block %x at height %d", worker, b.Hash, b.Height)
+}
+
+// session 是一个矿工连接，extranonce1 每个连接不同，矿工之间不会重复工作
+type session struct {
+	s           *Server
+	conn        net.Conn
+	extraNonce1 [ExtraNonce1Size]byte
+
+	mu         sync.Mutex
+	enc        *json.Encoder
+	subscribed bool
+	workers    map[string]bool
+}
+
+func (sess *session) serve() {
+	defer sess.s.removeSession(sess)
+	defer sess.conn.Close()
+
+	scanner := bufio.NewScanner(sess.conn)
+	for scanner.Scan() {
+		var req struct {
+			ID     interface{}       `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Printf("stratum: %s: %v", sess.conn.RemoteAddr(), err)
+			return
+		}
+
+		var params []string
+		for _, p := range req.Params {
+			var str string
+			json.Unmarshal(p, &str)
+			params = append(params, str)
+		}
+
+		result, stratumErr := sess.handle(req.Method, params)
+		sess.write(&Response{ID: req.ID, Result: result, Error: stratumErr})
+
+		if req.Method == "mining.subscribe" && stratumErr == nil {
+			sess.sendWork()
+		}
+	}
+}
+
+func (sess *session) handle(method string, params []string) (interface{}, *Error) {
+	switch method {
+	case "mining.subscribe":
+		sess.mu.Lock()
+		sess.subscribed = true
+		sess.mu.Unlock()
+
+		id := hex.EncodeToString(sess.extraNonce1[:])
+		subscriptions := [][]string{{"mining.set_difficulty", id}, {"mining.notify", id}}
+		return []interface{}{subscriptions, id, ExtraNonce2Size}, nil
+
+	case "mining.authorize":
+		if len(params) < 2 || !sess.s.authorized(params[0], params[1]) {
+			return false, &Error{ErrCodeUnauthorized, "Unauthorized worker"}
+		}
+		sess.mu.Lock()
+		sess.workers[params[0]] = true
+		sess.mu.Unlock()
+		return true, nil
+
+	case "mining.submit":
+		if !sess.isSubscribed() {
+			return nil, &Error{ErrCodeNotSubscribed, "Not subscribed"}
+		}
+		if len(params) < 5 {
+			return nil, &Error{ErrCodeOther, "Bad params"}
+		}
+		worker := params[0]
+		sess.mu.Lock()
+		authorized := sess.workers[worker]
+		sess.mu.Unlock()
+		if !authorized {
+			return nil, &Error{ErrCodeUnauthorized, "Unauthorized worker"}
+		}
+
+		if err := sess.s.submit(sess, worker, params[1], params[2], params[3], params[4]); err != nil {
+			sess.s.count(worker, func(st *WorkerStats) { st.Rejected++ })
+			return false, err
+		}
+		sess.s.count(worker, func(st *WorkerStats) { st.Accepted++ })
+		return true, nil
+	}
+
+	return nil, &Error{ErrCodeOther, "Unknown method " + method}
+}
+
+// sendWork 订阅后马上发送难度和当前任务
+func (sess *session) sendWork() {
+	sess.s.mu.Lock()
+	j := sess.s.current
+	sess.s.mu.Unlock()
+
+	sess.notify("mining.set_difficulty", []interface{}{sess.s.cfg.ShareDifficulty})
+	sess.notify("mining.notify", j.params(true))
+}
+
+func (sess *session) isSubscribed() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	return sess.subscribed
+}
+
+func (sess *session) notify(method string, params []interface{}) {
+	sess.write(&Request{Method: method, Params: params})
+}
+
+func (sess *session) write(v interface{}) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if err := sess.enc.Encode(v); err != nil {
+		sess.conn.Close()
+	}
+}
@@ -0,0 +1,280 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package stratum_test
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/mempool"
+	"myBitCoin/mining"
+	"myBitCoin/stratum"
+)
+
+// startPool runs a pool with the worker w1 mining on a regtest chain in the
+// process
+func startPool(t *testing.T) (*blk.BlockChain, *stratum.Server) {
+	t.Helper()
+
+	bc, w := chaintest.NewChain(t)
+	pool, err := stratum.NewServer(stratum.Config{
+		Addr:         "127.0.0.1:0",
+		PayToAddress: string(w.GetAddress()),
+		Workers:      map[string]string{"w1": "secret"},
+		Generator:    mining.NewBlkTmplGenerator(bc, mempool.New(bc)),
+		Chain:        bc,
+		SubmitBlock:  bc.AcceptBlock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go pool.Serve()
+	t.Cleanup(pool.Close)
+
+	return bc, pool
+}
+
+// client is a raw stratum connection, it reads the lines of the server in turn
+type client struct {
+	t       *testing.T
+	conn    net.Conn
+	scanner *bufio.Scanner
+	nextID  int
+}
+
+type line struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  *stratum.Error    `json:"error"`
+}
+
+func dial(t *testing.T, pool *stratum.Server) *client {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", pool.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	return &client{t: t, conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+func (c *client) read() *line {
+	c.t.Helper()
+
+	if !c.scanner.Scan() {
+		c.t.Fatalf("connection closed: %v", c.scanner.Err())
+	}
+	var l line
+	if err := json.Unmarshal(c.scanner.Bytes(), &l); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return &l
+}
+
+// call sends a request and returns its response, the notifications read
+// before it are returned too
+func (c *client) call(method string, params ...interface{}) (*line, []*line) {
+	c.t.Helper()
+
+	c.nextID++
+	req, _ := json.Marshal(stratum.Request{ID: c.nextID, Method: method, Params: params})
+	if _, err := c.conn.Write(append(req, '\n')); err != nil {
+		c.t.Fatal(err)
+	}
+
+	var notes []*line
+	for {
+		l := c.read()
+		if l.Method != "" {
+			notes = append(notes, l)
+			continue
+		}
+		if id, ok := l.ID.(float64); !ok || int(id) != c.nextID {
+			c.t.Fatalf("response %v to request %d", l.ID, c.nextID)
+		}
+		return l, notes
+	}
+}
+
+// waitNotify reads the lines of the server until a mining.notify
+func (c *client) waitNotify() []json.RawMessage {
+	c.t.Helper()
+
+	for {
+		if l := c.read(); l.Method == "mining.notify" {
+			return l.Params
+		}
+	}
+}
+
+func hexParam(t *testing.T, p json.RawMessage) []byte {
+	t.Helper()
+
+	var s string
+	if err := json.Unmarshal(p, &s); err != nil {
+		t.Fatal(err)
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// solve searches a share of the job of a mining.notify, it is also a block
+// on regtest
+func solve(t *testing.T, notify []json.RawMessage, extraNonce1, extraNonce2 []byte) (nTime, nonce string) {
+	t.Helper()
+
+	coinbase, err := stratum.WorkerCoinbase(hexParam(t, notify[2]), append(append([]byte{}, extraNonce1...), extraNonce2...), hexParam(t, notify[3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var branch []string
+	if err := json.Unmarshal(notify[4], &branch); err != nil {
+		t.Fatal(err)
+	}
+	var hashes [][]byte
+	for _, h := range branch {
+		hash, err := hex.DecodeString(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := json.Unmarshal(notify[7], &nTime); err != nil {
+		t.Fatal(err)
+	}
+	timeStamp, ok := new(big.Int).SetString(nTime, 16)
+	if !ok {
+		t.Fatalf("bad ntime %s", nTime)
+	}
+
+	header := &blk.BlockHeader{
+		TimeStamp:  timeStamp.Int64(),
+		PrevHash:   hexParam(t, notify[1]),
+		MerkleRoot: stratum.MerkleRoot(coinbase, hashes),
+	}
+	target := stratum.ShareTarget(1)
+	for header.Nonce = 0; header.Nonce < 1<<24; header.Nonce++ {
+		hash := blk.NewHeaderProofOfWork(header).CalcHash()
+		if new(big.Int).SetBytes(hash).Cmp(target) < 0 {
+			return nTime, fmt.Sprintf("%08x", header.Nonce)
+		}
+	}
+	t.Fatal("no share found")
+	return "", ""
+}
+
+func TestPoolMinesBlock(t *testing.T) {
+	bc, pool := startPool(t)
+	c := dial(t, pool)
+
+	// 订阅后马上收到难度和任务
+	sub, notes := c.call("mining.subscribe", "test")
+	if sub.Error != nil {
+		t.Fatal(sub.Error)
+	}
+	var result []json.RawMessage
+	if err := json.Unmarshal(sub.Result, &result); err != nil || len(result) < 3 {
+		t.Fatalf("subscribe result %s", sub.Result)
+	}
+	extraNonce1 := hexParam(t, result[1])
+	if len(extraNonce1) != stratum.ExtraNonce1Size {
+		t.Fatalf("extranonce1 %x", extraNonce1)
+	}
+	if len(notes) != 0 {
+		t.Fatalf("notifications before the subscribe result: %v", notes)
+	}
+	if l := c.read(); l.Method != "mining.set_difficulty" {
+		t.Fatalf("got %s, want mining.set_difficulty", l.Method)
+	}
+	job := c.waitNotify()
+	if len(hexParam(t, job[3])) == 0 {
+		t.Fatal("coinb2 is empty")
+	}
+
+	for _, auth := range []struct {
+		worker, password string
+		ok               bool
+	}{{"w1", "wrong", false}, {"w2", "secret", false}, {"w1", "secret", true}} {
+		res, _ := c.call("mining.authorize", auth.worker, auth.password)
+		if (res.Error == nil) != auth.ok {
+			t.Fatalf("authorize %s:%s: error %v", auth.worker, auth.password, res.Error)
+		}
+	}
+
+	var jobID string
+	json.Unmarshal(job[0], &jobID)
+	extraNonce2 := []byte{0, 0, 0, 1}
+	nTime, nonce := solve(t, job, extraNonce1, extraNonce2)
+
+	res, _ := c.call("mining.submit", "w2", jobID, hex.EncodeToString(extraNonce2), nTime, nonce)
+	if res.Error == nil || res.Error.Code != stratum.ErrCodeUnauthorized {
+		t.Fatalf("submit of an unauthorized worker: error %v", res.Error)
+	}
+	res, _ = c.call("mining.submit", "w1", jobID, hex.EncodeToString(extraNonce2), nTime, nonce)
+	if res.Error != nil {
+		t.Fatalf("submit: %v", res.Error)
+	}
+
+	// 出块后链尾变化，马上收到新的任务
+	next := c.waitNotify()
+	var clean bool
+	json.Unmarshal(next[8], &clean)
+	if !clean || hex.EncodeToString(hexParam(t, next[1])) != hex.EncodeToString(bc.Tip()) {
+		t.Fatalf("job after the block: prevhash %s, clean %v", next[1], clean)
+	}
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 1 {
+		t.Fatalf("height %d, want 1", height)
+	}
+
+	stats := pool.Stats()
+	if len(stats) != 1 || stats["w1"] != (stratum.WorkerStats{Accepted: 1, Blocks: 1}) {
+		t.Fatalf("stats %+v", stats)
+	}
+}
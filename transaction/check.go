@@ -0,0 +1,107 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrBadValue       = errors.New("transaction: output value is not positive or the values overflow")
+	ErrNegativeFee    = errors.New("transaction spends more than its inputs")
+	ErrDuplicateInput = errors.New("transaction spends an output twice")
+	ErrWrongKey       = errors.New("transaction: input key does not lock the spent output")
+)
+
+// CheckOutputs returns the sum of the outputs of tx, ErrBadValue when one of
+// them is not positive or the sum overflows
+func (tx *Transaction) CheckOutputs() (int, error) {
+	sum := 0
+	for _, out := range tx.Vout {
+		if out.Value <= 0 || sum > math.MaxInt-out.Value {
+			return 0, ErrBadValue
+		}
+		sum += out.Value
+	}
+
+	return sum, nil
+}
+
+// CheckInputs checks the values of tx and that every input spends a
+// different output with the key the output is locked to. prevOut returns the
+// output spent by an input, or an error when there is none. It returns the
+// fee of tx and the previous transactions to verify its signatures with.
+func (tx *Transaction) CheckInputs(prevOut func(in *TxInput) (*TxOutput, error)) (int, map[string]Transaction, error) {
+	prevTxs := make(map[string]Transaction)
+	seen := make(map[string]bool)
+	in := 0
+
+	for i := range tx.Vin {
+		vin := &tx.Vin[i]
+		key := fmt.Sprintf("%x:%d", vin.TxID, vin.Vout)
+		if vin.Vout < 0 {
+			return 0, nil, fmt.Errorf("%w: %s", ErrPrevTxNotFound, key)
+		}
+		if seen[key] {
+			return 0, nil, fmt.Errorf("%w: %s", ErrDuplicateInput, key)
+		}
+		seen[key] = true
+
+		out, err := prevOut(vin)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !vin.UsesKey(out.PubKeyHash) {
+			return 0, nil, fmt.Errorf("%w: %s", ErrWrongKey, key)
+		}
+		if out.Value <= 0 || in > math.MaxInt-out.Value {
+			return 0, nil, ErrBadValue
+		}
+		in += out.Value
+
+		// 验证签名只需要被花费的输出
+		prevID := hex.EncodeToString(vin.TxID)
+		prev := prevTxs[prevID]
+		prev.ID = vin.TxID
+		for len(prev.Vout) <= vin.Vout {
+			prev.Vout = append(prev.Vout, TxOutput{})
+		}
+		prev.Vout[vin.Vout] = *out
+		prevTxs[prevID] = prev
+	}
+
+	out, err := tx.CheckOutputs()
+	if err != nil {
+		return 0, nil, err
+	}
+	if out > in {
+		return 0, nil, ErrNegativeFee
+	}
+
+	return in - out, prevTxs, nil
+}
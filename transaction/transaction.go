@@ -32,7 +32,7 @@ package transaction
 import (
 	"fmt"
 	"encoding/gob"
-	"log"
+	"errors"
 	"crypto/sha256"
 	"bytes"
 	"crypto/rand"
@@ -41,9 +41,24 @@ import (
 	"encoding/hex"
 	"crypto/elliptic"
 	"math/big"
+	"io/ioutil"
 )
 
-const subsidy = 10
+// Subsidy is the reward of a coinbase transaction, the fees are added to it
+const Subsidy = 10
+
+var (
+	// ErrPrevTxNotFound is returned by Sign and Verify when an input spends an
+	// output missing from the previous transactions they are given
+	ErrPrevTxNotFound = errors.New("transaction: previous output is not found")
+	ErrBadSignature   = errors.New("transaction has an invalid signature")
+)
+
+func init() {
+	// gob 按进程内第一次使用的顺序给类型编号，编号会写进序列化结果。
+	// 先编码一次交易，保证交易 ID 和 merkle 根在不同进程中一致
+	gob.NewEncoder(ioutil.Discard).Encode(Transaction{})
+}
 
 type Transaction struct {
 	ID   []byte
@@ -56,20 +71,23 @@ type TxOutput struct {
 	PubKeyHash []byte
 }
 
-func (out *TxOutput) Lock(addr []byte) {
-	hashPubKey := wallet.Base58Decode(addr)
-	hashPubKey = hashPubKey[1:len(hashPubKey)-4]
+func (out *TxOutput) Lock(addr []byte) error {
+	hashPubKey, err := wallet.PubKeyHashFromAddress(string(addr))
+	if err != nil {
+		return err
+	}
 	out.PubKeyHash = hashPubKey
+	return nil
 }
 
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
 	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
 }
 
-func NewTxOut(value int, address string) TxOutput {
+func NewTxOut(value int, address string) (TxOutput, error) {
 	txo := TxOutput{value, nil}
-	txo.Lock([]byte(address))
-	return txo
+	err := txo.Lock([]byte(address))
+	return txo, err
 }
 
 type TxInput struct {
@@ -85,31 +103,37 @@ func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
 
-func NewCoinbaseTx(to, data string) *Transaction {
+func NewCoinbaseTx(to, data string) (*Transaction, error) {
 	if data == "" {
 		data = fmt.Sprintf("Reward to '%s'", to)
 	}
-	fmt.Println(data)
 	txIn := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txOut := NewTxOut(subsidy, to) //TxOutput{subsidy, to}
+	txOut, err := NewTxOut(Subsidy, to) //TxOutput{subsidy, to}
+	if err != nil {
+		return nil, err
+	}
 	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{txOut}}
-	tx.SetID()
+	if err := tx.SetID(); err != nil {
+		return nil, err
+	}
 
-	return &tx
+	return &tx, nil
 }
 
-func (tx *Transaction) SetID() {
+// SetID sets the ID of tx to the hash of its encoding
+func (tx *Transaction) SetID() error {
 	var (
 		encode bytes.Buffer
 		hash   [32]byte
 	)
 	encoder := gob.NewEncoder(&encode)
-	err := encoder.Encode(tx)
-	if err != nil {
-		log.Panic(err)
+	if err := encoder.Encode(tx); err != nil {
+		return err
 	}
 	hash = sha256.Sum256(encode.Bytes())
 	tx.ID = hash[:]
+
+	return nil
 }
 
 // IsCoinbase 判断是否是 coinbase 交易
@@ -120,14 +144,21 @@ func (tx *Transaction) IsCoinbase() bool {
 func (tx *Transaction)Serialize() []byte{
 	var encoded bytes.Buffer
 	encoder := gob.NewEncoder(&encoded)
-	err := encoder.Encode(tx)
-	if err!=nil{
-		log.Panic(err)
-	}
+	encoder.Encode(tx)
 
 	return encoded.Bytes()
 }
 
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&tx); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
 /*func (in *TxInput) CanUnlockOutputWith(unlockingData string) bool {
 	return in.ScriptSig == unlockingData
 }
@@ -136,15 +167,25 @@ func (out *TxOutput) CanBeUnlockedWith(unlockingData string) bool {
 	return out.ScriptPubKey == unlockingData
 }*/
 
-func (tr *Transaction) Sign(privKey ecdsa.PrivateKey, txs map[string]Transaction) {
+// prevOutExists 检查每个输入花费的输出都在 txs 中
+func (tr *Transaction) prevOutExists(txs map[string]Transaction) error {
+	for _, in := range tr.Vin {
+		prev := txs[hex.EncodeToString(in.TxID)]
+		if prev.ID == nil || in.Vout < 0 || in.Vout >= len(prev.Vout) {
+			return fmt.Errorf("%w: %x:%d", ErrPrevTxNotFound, in.TxID, in.Vout)
+		}
+	}
+
+	return nil
+}
+
+func (tr *Transaction) Sign(privKey ecdsa.PrivateKey, txs map[string]Transaction) error {
 	if tr.IsCoinbase() {
-		return
+		return nil
 	}
 
-	for _, in := range tr.Vin {
-		if txs[hex.EncodeToString(in.TxID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
-		}
+	if err := tr.prevOutExists(txs); err != nil {
+		return err
 	}
 
 	txCopy := tr.TrimmedCopy()
@@ -156,13 +197,19 @@ func (tr *Transaction) Sign(privKey ecdsa.PrivateKey, txs map[string]Transaction
 		dataToSign := fmt.Sprintf("%x\n", txCopy)
 		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+		// r 和 s 补齐到同样长度，Verify 从中间分开
+		size := (privKey.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
 
 		tr.Vin[inID].Signature = signature
 		txCopy.Vin[inID].PubKey = nil
 	}
+
+	return nil
 }
 
 func (tr *Transaction) TrimmedCopy() Transaction {
@@ -182,16 +229,15 @@ func (tr *Transaction) TrimmedCopy() Transaction {
 	return Transaction{tr.ID, inputs, outputs}
 }
 
-// Verify verifies signatures of Transaction inputs
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+// Verify verifies signatures of Transaction inputs, it returns
+// ErrBadSignature when one of them is invalid
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.TxID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
-		}
+	if err := tx.prevOutExists(prevTXs); err != nil {
+		return err
 	}
 
 	txCopy := tx.TrimmedCopy()
@@ -218,37 +264,33 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 
 		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
 		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
-			return false
+			return ErrBadSignature
 		}
 		txCopy.Vin[inID].PubKey = nil
 	}
 
-	return true
+	return nil
 }
 
 type TxOutPuts struct {
 	Outputs []TxOutput
 }
 
-func (outs *TxOutPuts) Serialize() []byte{
+func (outs *TxOutPuts) Serialize() ([]byte, error) {
 	var buf bytes.Buffer
 
 	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(outs)
-	if err != nil {
-		log.Panic(err)
+	if err := enc.Encode(outs); err != nil {
+		return nil, err
 	}
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
-func DeserializeOutPuts(data []byte) TxOutPuts {
+func DeserializeOutPuts(data []byte) (TxOutPuts, error) {
 	var out TxOutPuts
 	dec := gob.NewDecoder(bytes.NewReader(data))
 	err := dec.Decode(&out)
-	if err != nil {
-		log.Panic(err)
-	}
 
-	return out
+	return out, err
 }
@@ -0,0 +1,323 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package txindex keeps the block of every transaction and the transactions
+// of every address in the chain database, so that they are found without
+// scanning the chain.
+package txindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	blk "myBitCoin/block"
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+	"myBitCoin/wallet"
+)
+
+const (
+	txBucket      = "txindex"
+	addressBucket = "addrindex"
+	// 已建好索引的块，值为块高
+	blocksBucket = "txindexblocks"
+)
+
+var ErrNotIndexed = errors.New("txindex: transaction is not indexed")
+
+// Index maps the transaction ids to their block and the public key hashes to
+// the transactions paying to or spending from them
+type Index struct {
+	bc *blk.BlockChain
+}
+
+// NewIndex opens the index and indexes the blocks missing from it
+func NewIndex(bc *blk.BlockChain) (*Index, error) {
+	idx := &Index{bc}
+
+	err := bc.DB.Update(func(tx storage.Tx) error {
+		for _, name := range []string{txBucket, addressBucket, blocksBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 补索引时可能有新块连接上来，它们的父块还没有索引就被跳过了，
+	// 所以一直补到链尾已经有索引为止
+	for {
+		missing, err := idx.missing()
+		if err != nil {
+			return nil, err
+		}
+		if len(missing) == 0 {
+			return idx, nil
+		}
+
+		for i := len(missing) - 1; i >= 0; i-- {
+			b, err := idx.load(missing[i])
+			if err != nil {
+				return nil, err
+			}
+			err = bc.DB.Update(func(tx storage.Tx) error {
+				return connect(tx, b, i == len(missing)-1)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// missing returns the hashes of the blocks to index, from the tip back to
+// the first indexed block, the genesis block or a pruned block
+func (idx *Index) missing() ([][]byte, error) {
+	var hashes [][]byte
+
+	bci := idx.bc.Iterator()
+	for {
+		b, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+		if idx.indexed(b.Hash) {
+			break
+		}
+		hashes = append(hashes, b.Hash)
+
+		if len(b.PrevHash) == 0 || b.Pruned() {
+			break
+		}
+	}
+
+	return hashes, nil
+}
+
+// load reads a block to index, a pruned block has no transactions left
+func (idx *Index) load(hash []byte) (*blk.Block, error) {
+	b, err := idx.bc.GetBlock(hash)
+	if err != blk.ErrBlockPruned {
+		return b, err
+	}
+
+	h, err := idx.bc.GetHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &blk.Block{Hash: h.Hash, PrevHash: h.PrevHash, Height: h.Height}, nil
+}
+
+func (idx *Index) indexed(hash []byte) bool {
+	var ok bool
+
+	idx.bc.DB.View(func(tx storage.Tx) error {
+		ok = tx.Bucket([]byte(blocksBucket)).Get(hash) != nil
+		return nil
+	})
+
+	return ok
+}
+
+func init() {
+	blk.RegisterConnectHandler(connectBlock)
+	blk.RegisterDisconnectHandler(disconnectBlock)
+}
+
+// connectBlock indexes the block in the transaction connecting it. Chains
+// without the index and blocks after a gap are left to NewIndex.
+func connectBlock(bc *blk.BlockChain, tx storage.Tx, b *blk.Block) error {
+	if tx.Bucket([]byte(blocksBucket)) == nil {
+		return nil
+	}
+	err := connect(tx, b, false)
+	if err == ErrNotIndexed {
+		return nil
+	}
+
+	return err
+}
+
+// disconnectBlock removes the transactions of the block from the index, in
+// the transaction disconnecting it
+func disconnectBlock(bc *blk.BlockChain, tx storage.Tx, b *blk.Block) error {
+	if tx.Bucket([]byte(blocksBucket)) == nil {
+		return nil
+	}
+
+	return each(b, func(txID []byte, keys [][]byte) error {
+		if err := tx.Bucket([]byte(txBucket)).Delete(txID); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := tx.Bucket([]byte(addressBucket)).Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func() error {
+		return tx.Bucket([]byte(blocksBucket)).Delete(b.Hash)
+	})
+}
+
+// connect indexes b, its parent must be indexed unless b is the first block
+// of the index
+func connect(tx storage.Tx, b *blk.Block, first bool) error {
+	if !first && len(b.PrevHash) > 0 && tx.Bucket([]byte(blocksBucket)).Get(b.PrevHash) == nil {
+		return ErrNotIndexed
+	}
+
+	return each(b, func(txID []byte, keys [][]byte) error {
+		if err := tx.Bucket([]byte(txBucket)).Put(txID, b.Hash); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := tx.Bucket([]byte(addressBucket)).Put(key, txID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func() error {
+		return tx.Bucket([]byte(blocksBucket)).Put(b.Hash, uint32Bytes(uint32(b.Height)))
+	})
+}
+
+// each calls fn with the id and the address index keys of every transaction
+// of b, then done
+func each(b *blk.Block, fn func(txID []byte, keys [][]byte) error, done func() error) error {
+	for i, t := range b.Transactions {
+		var keys [][]byte
+		for _, pubKeyHash := range addresses(t) {
+			keys = append(keys, addressKey(pubKeyHash, b.Height, i))
+		}
+		if err := fn(t.ID, keys); err != nil {
+			return err
+		}
+	}
+
+	return done()
+}
+
+// addresses returns the public key hashes a transaction pays to or spends
+// from, once each
+func addresses(t *transaction.Transaction) [][]byte {
+	var hashes [][]byte
+	seen := make(map[string]bool)
+
+	add := func(pubKeyHash []byte) {
+		if !seen[string(pubKeyHash)] {
+			seen[string(pubKeyHash)] = true
+			hashes = append(hashes, pubKeyHash)
+		}
+	}
+	if !t.IsCoinbase() {
+		for _, in := range t.Vin {
+			add(wallet.HashPubKey(in.PubKey))
+		}
+	}
+	for _, out := range t.Vout {
+		add(out.PubKeyHash)
+	}
+
+	return hashes
+}
+
+// addressKey 是公钥哈希加上反转的块高和交易在块里的位置，
+// 按键的顺序遍历时新的交易在前
+func addressKey(pubKeyHash []byte, height, pos int) []byte {
+	key := append([]byte{}, pubKeyHash...)
+	key = append(key, uint32Bytes(math.MaxUint32-uint32(height))...)
+	return append(key, uint32Bytes(uint32(pos))...)
+}
+
+func uint32Bytes(h uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, h)
+	return b
+}
+
+// Transaction returns a transaction and the block holding it.
+// ErrNotIndexed is returned for the unknown transactions and the
+// transactions of the pruned blocks.
+func (idx *Index) Transaction(txID []byte) (*transaction.Transaction, *blk.Block, error) {
+	var hash []byte
+
+	err := idx.bc.DB.View(func(tx storage.Tx) error {
+		v := tx.Bucket([]byte(txBucket)).Get(txID)
+		if v == nil {
+			return ErrNotIndexed
+		}
+		hash = append([]byte{}, v...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := idx.bc.GetBlock(hash)
+	if err == blk.ErrBlockPruned {
+		return nil, nil, ErrNotIndexed
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, t := range b.Transactions {
+		if bytes.Equal(t.ID, txID) {
+			return t, b, nil
+		}
+	}
+
+	return nil, nil, ErrNotIndexed
+}
+
+// AddressTransactions returns the ids of at most count transactions of a
+// public key hash, the newest first, after skipping the skip newest ones.
+// more tells whether older transactions are left.
+func (idx *Index) AddressTransactions(pubKeyHash []byte, skip, count int) (ids [][]byte, more bool, err error) {
+	err = idx.bc.DB.View(func(tx storage.Tx) error {
+		c := tx.Bucket([]byte(addressBucket)).Cursor()
+		for k, v := c.Seek(pubKeyHash); k != nil && bytes.HasPrefix(k, pubKeyHash); k, v = c.Next() {
+			if len(k) != len(pubKeyHash)+8 {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if len(ids) == count {
+				more = true
+				break
+			}
+			ids = append(ids, append([]byte{}, v...))
+		}
+		return nil
+	})
+
+	return ids, more, err
+}
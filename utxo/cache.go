@@ -0,0 +1,540 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo
+
+import (
+	"bytes"
+	"container/list"
+	"log"
+	"sync"
+
+	blk "myBitCoin/block"
+	"myBitCoin/storage"
+)
+
+// DefaultCacheSize is the size in bytes of the coins a chain keeps in
+// memory. Above it the changes are written to the coins bucket and the least
+// recently used entries are dropped.
+var DefaultCacheSize = 32 << 20
+
+// maxUnflushedBlocks 个块之后一定写回，写回的状态总在不会被裁剪的块之内，
+// 启动时可以重新接上之后的块，见 blk.MinBlocksToKeep
+const maxUnflushedBlocks = 100
+
+type entry struct {
+	key string
+	// nil 表示输出被花掉了，写回时从 coins bucket 删除
+	coin *Coin
+	// 键和序列化后的输出的长度，和 TxOutSetInfo.SerializedSize 的算法一致
+	size  int
+	dirty bool
+	// 只有干净的条目在 lru 里，脏条目写回之前不能丢
+	elem *list.Element
+}
+
+// cache is the in-memory view of the coins bucket of a chain, shared by all
+// its UTXOSets. The changes of the connected blocks stay in memory as dirty
+// entries. They are written back in the transaction of the block that takes
+// them above the cache size or maxUnflushedBlocks, of a disconnected block,
+// and by Close. The statistics in the database always describe the written
+// coins, they are at an ancestor of the tip and Open connects the blocks
+// after it again.
+type cache struct {
+	mu      sync.Mutex
+	db      storage.DB
+	maxSize int
+	size    int
+	entries map[string]*entry
+	lru     *list.List
+	dirty   map[string]*entry
+	// 脏条目按交易 ID 索引，查一笔交易还有没有未花费的输出时和 coins bucket 合并
+	dirtyTxs  map[string]map[string]*entry
+	dirtySize int
+	// 上次写回之后接上的块数
+	unflushed int
+	// 整个 coins bucket 都在内存中，查不到就是不存在
+	full  bool
+	stats *utxoStats
+	// 块的事务中算好、提交后才放进缓存的改动，见 resolvePending
+	pending *pendingChanges
+	// 每次改动加一，不持锁读数据库的 get 和 forEach 据此判断读到的是否过时
+	gen uint64
+}
+
+// pendingChanges are the changes of a block to the cache, applied once the
+// transaction of the block is committed
+type pendingChanges struct {
+	// 块的哈希，写回时为 nil
+	block   []byte
+	connect bool
+	changes map[string]*entry
+	stats   *utxoStats
+	flushed bool
+}
+
+var caches = struct {
+	sync.Mutex
+	m map[storage.DB]*cache
+}{m: make(map[storage.DB]*cache)}
+
+// cacheOf returns the cache of the chain, creating it on first use
+func cacheOf(bc *blk.BlockChain) (*cache, error) {
+	caches.Lock()
+	defer caches.Unlock()
+
+	c := caches.m[bc.DB]
+	if c == nil {
+		var err error
+		if c, err = newCache(bc); err != nil {
+			return nil, err
+		}
+		caches.m[bc.DB] = c
+	}
+
+	return c, nil
+}
+
+// loadedCache returns the cache of the chain if there is one. It is used in
+// the connect handler, where newCache can't read the DB.
+func loadedCache(bc *blk.BlockChain) *cache {
+	caches.Lock()
+	defer caches.Unlock()
+
+	return caches.m[bc.DB]
+}
+
+// dropCache forgets the cache of the chain without writing it back
+func dropCache(bc *blk.BlockChain) *cache {
+	caches.Lock()
+	defer caches.Unlock()
+
+	c := caches.m[bc.DB]
+	delete(caches.m, bc.DB)
+
+	return c
+}
+
+func newCache(bc *blk.BlockChain) (*cache, error) {
+	c := &cache{
+		db:       bc.DB,
+		maxSize:  DefaultCacheSize,
+		entries:  make(map[string]*entry),
+		lru:      list.New(),
+		dirty:    make(map[string]*entry),
+		dirtyTxs: make(map[string]map[string]*entry),
+	}
+
+	tip := bc.Tip()
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	legacy := false
+	err = bc.DB.View(func(tx storage.Tx) error {
+		legacy = tx.Bucket([]byte(legacyBucket)) != nil
+		if tx.Bucket([]byte(coinsBucket)) == nil {
+			return nil
+		}
+		var err error
+		if c.stats, err = readStats(tx); err != nil || c.stats != nil {
+			return err
+		}
+		if c.stats, err = computeStats(tx.Bucket([]byte(coinsBucket))); err != nil {
+			return err
+		}
+		c.stats.BestBlock = tip
+		c.stats.Height = height
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 旧的 chainstate 按交易保存剩下的输出，序号已经错位，只能按链重建
+	if legacy {
+		log.Printf("utxo: migrating the chainstate to per-outpoint records")
+		c.stats, err = reindex(bc)
+	} else if c.stats == nil {
+		c.stats, err = reindex(bc)
+	} else if !bytes.Equal(c.stats.BestBlock, tip) {
+		c.stats, err = catchUp(bc, c.stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// catchUp connects the blocks after the one the coins bucket was written at,
+// the changes of the cache were not written back before the node stopped. The
+// coins bucket is rebuilt when that block is not an ancestor of the tip.
+func catchUp(bc *blk.BlockChain, stats *utxoStats) (*utxoStats, error) {
+	tipHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := bc.HashAtHeight(stats.Height)
+	if err != nil && err != blk.ErrBlockNotFound {
+		return nil, err
+	}
+	if !bytes.Equal(hash, stats.BestBlock) || stats.Height > tipHeight {
+		log.Printf("utxo: chainstate is at block %x which is not in the chain, reindexing", stats.BestBlock)
+		return reindex(bc)
+	}
+
+	log.Printf("utxo: chainstate is at height %d, connecting the blocks up to %d", stats.Height, tipHeight)
+	for height := stats.Height + 1; height <= tipHeight; height++ {
+		hash, err := bc.HashAtHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		// 缓存还没有建立，改动直接写进 coins bucket
+		err = bc.DB.Update(func(tx storage.Tx) error {
+			return connect(tx, nil, block)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var caughtUp *utxoStats
+	err = bc.DB.View(func(tx storage.Tx) error {
+		caughtUp, err = readStats(tx)
+		return err
+	})
+
+	return caughtUp, err
+}
+
+// lookup returns the entry of an outpoint key if the cache has it, a spent
+// output has an entry with a nil coin until it is written back
+func (c *cache) lookup(key string) (*entry, bool) {
+	e, ok := c.entries[key]
+	if ok && e.elem != nil {
+		c.lru.MoveToFront(e.elem)
+	}
+
+	return e, ok
+}
+
+// get returns the coin of an outpoint key, or nil when it is spent. The lock
+// is released while the database is read.
+func (c *cache) get(outPoint []byte) (*entry, error) {
+	key := string(outPoint)
+	for {
+		if e, ok := c.lookup(key); ok {
+			if e.coin == nil {
+				return nil, nil
+			}
+			return e, nil
+		}
+		if c.full {
+			return nil, nil
+		}
+
+		gen := c.gen
+		c.mu.Unlock()
+		var e *entry
+		err := c.db.View(func(tx storage.Tx) error {
+			v := tx.Bucket([]byte(coinsBucket)).Get(outPoint)
+			if v == nil {
+				return nil
+			}
+			coin, err := decodeCoin(v)
+			if err != nil {
+				return err
+			}
+			e = &entry{key: key, coin: coin, size: len(key) + len(v)}
+			return nil
+		})
+		c.mu.Lock()
+		if err != nil {
+			return nil, err
+		}
+		// 读的时候有块接上或者写回，重新查
+		if c.gen != gen {
+			continue
+		}
+		if e != nil {
+			c.add(e)
+			c.evict()
+		}
+		return e, nil
+	}
+}
+
+func (c *cache) add(e *entry) {
+	c.entries[e.key] = e
+	c.size += e.size
+	if e.dirty {
+		c.addDirty(e)
+	} else {
+		e.elem = c.lru.PushFront(e)
+	}
+}
+
+func (c *cache) remove(key string) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	if e.elem != nil {
+		c.lru.Remove(e.elem)
+	}
+	if e.dirty {
+		c.removeDirty(e)
+	}
+	delete(c.entries, key)
+	c.size -= e.size
+}
+
+func (c *cache) addDirty(e *entry) {
+	c.dirty[e.key] = e
+	c.dirtySize += e.size
+	txID, _ := splitOutPointKey([]byte(e.key))
+	outs := c.dirtyTxs[string(txID)]
+	if outs == nil {
+		outs = make(map[string]*entry)
+		c.dirtyTxs[string(txID)] = outs
+	}
+	outs[e.key] = e
+}
+
+func (c *cache) removeDirty(e *entry) {
+	delete(c.dirty, e.key)
+	c.dirtySize -= e.size
+	txID, _ := splitOutPointKey([]byte(e.key))
+	if outs := c.dirtyTxs[string(txID)]; outs != nil {
+		delete(outs, e.key)
+		if len(outs) == 0 {
+			delete(c.dirtyTxs, string(txID))
+		}
+	}
+}
+
+// resolvePending applies the changes of the last block, or of the last flush,
+// if its transaction was committed and drops them otherwise. The undo data of
+// a block is written with it and deleted when it is disconnected, a flush
+// moves the statistics in the database to the tip of the cache.
+func (c *cache) resolvePending(tx storage.Tx) error {
+	p := c.pending
+	if p == nil {
+		return nil
+	}
+	c.pending = nil
+
+	var committed bool
+	if p.block == nil {
+		stats, err := readStats(tx)
+		if err != nil {
+			return err
+		}
+		committed = stats != nil && bytes.Equal(stats.BestBlock, p.stats.BestBlock)
+	} else {
+		b := tx.Bucket([]byte(undoBucket))
+		connected := b != nil && b.Get(p.block) != nil
+		committed = connected == p.connect
+	}
+	if committed {
+		c.apply(p)
+	}
+
+	return nil
+}
+
+// apply copies the changes of a committed block. The entries written back
+// with the block are clean, the others stay dirty until the next write back.
+func (c *cache) apply(p *pendingChanges) {
+	if c.pending == p {
+		c.pending = nil
+	}
+	if p.flushed {
+		c.markClean()
+	}
+	for key, e := range p.changes {
+		c.remove(key)
+		// 写回的已花费输出不用再记着
+		if p.flushed && e.coin == nil {
+			continue
+		}
+		e.dirty = !p.flushed
+		c.add(e)
+	}
+	c.stats = p.stats
+	if !p.flushed {
+		c.unflushed++
+	}
+	c.gen++
+	c.evict()
+}
+
+// markClean 写回之后，已花费的输出从缓存中删除，其余的放进 lru
+func (c *cache) markClean() {
+	for key, e := range c.dirty {
+		e.dirty = false
+		if e.coin == nil {
+			delete(c.entries, key)
+			c.size -= e.size
+		} else {
+			e.elem = c.lru.PushFront(e)
+		}
+	}
+	c.dirty = make(map[string]*entry)
+	c.dirtyTxs = make(map[string]map[string]*entry)
+	c.dirtySize = 0
+	c.unflushed = 0
+}
+
+// needsFlush reports whether the dirty entries must be written back with the
+// changes of the next block
+func (c *cache) needsFlush(changes map[string]*entry) bool {
+	size := c.dirtySize
+	for _, e := range changes {
+		size += e.size
+	}
+
+	return size > c.maxSize || c.unflushed+1 >= maxUnflushedBlocks
+}
+
+// flush writes the dirty entries and the statistics back in one transaction.
+// They stay dirty until it is committed.
+func (c *cache) flush() error {
+	var p *pendingChanges
+	err := c.db.Update(func(tx storage.Tx) error {
+		// 和连接块的处理函数一样先取数据库的锁，再取缓存的锁
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if err := c.resolvePending(tx); err != nil {
+			return err
+		}
+		if c.unflushed == 0 {
+			return nil
+		}
+		if err := newView(tx.Bucket([]byte(coinsBucket)), c).writeBack(); err != nil {
+			return err
+		}
+		if err := writeStats(tx, c.stats); err != nil {
+			return err
+		}
+		p = &pendingChanges{stats: c.stats, flushed: true}
+		c.pending = p
+		return nil
+	})
+	if err != nil || p == nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.pending == p {
+		c.apply(p)
+	}
+	c.mu.Unlock()
+
+	return nil
+}
+
+// forEach calls f with every unspent output locked with pubKeyHash. When the
+// coins bucket fits the cache it is read into memory once, otherwise the
+// records not containing pubKeyHash are skipped without being decoded. The
+// lock is released while the database is read.
+func (c *cache) forEach(pubKeyHash []byte, f func(txID []byte, vout int, coin *Coin)) error {
+	for !c.full {
+		gen := c.gen
+		load := c.stats.SerializedSize <= c.maxSize
+		c.mu.Unlock()
+		var found []*entry
+		err := c.db.View(func(tx storage.Tx) error {
+			cursor := tx.Bucket([]byte(coinsBucket)).Cursor()
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if !load && !bytes.Contains(v, pubKeyHash) {
+					continue
+				}
+				coin, err := decodeCoin(v)
+				if err != nil {
+					return err
+				}
+				found = append(found, &entry{key: string(k), coin: coin, size: len(k) + len(v)})
+			}
+			return nil
+		})
+		c.mu.Lock()
+		if err != nil {
+			return err
+		}
+		if c.gen != gen {
+			continue
+		}
+
+		// 缓存中的条目比数据库新
+		for _, e := range found {
+			if _, ok := c.entries[e.key]; !ok && e.coin.IsLockedWithKey(pubKeyHash) {
+				txID, vout := splitOutPointKey([]byte(e.key))
+				f(txID, vout, e.coin)
+			}
+		}
+		for key, e := range c.entries {
+			if e.coin != nil && e.coin.IsLockedWithKey(pubKeyHash) {
+				txID, vout := splitOutPointKey([]byte(key))
+				f(txID, vout, e.coin)
+			}
+		}
+
+		if load {
+			for _, e := range found {
+				if _, ok := c.entries[e.key]; !ok {
+					c.add(e)
+				}
+			}
+			c.full = true
+			c.evict()
+		}
+		return nil
+	}
+
+	for key, e := range c.entries {
+		if e.coin != nil && e.coin.IsLockedWithKey(pubKeyHash) {
+			txID, vout := splitOutPointKey([]byte(key))
+			f(txID, vout, e.coin)
+		}
+	}
+
+	return nil
+}
+
+// evict drops the least recently used clean entries above the cache size
+func (c *cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		e := c.lru.Remove(c.lru.Back()).(*entry)
+		delete(c.entries, e.key)
+		c.size -= e.size
+		c.full = false
+	}
+}
@@ -0,0 +1,272 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+	"myBitCoin/wallet"
+)
+
+var errCrash = errors.New("crash")
+
+// failConnect 为真时接上块的事务在 UTXO 集合的处理函数之后失败
+var failConnect atomic.Bool
+
+func init() {
+	blk.RegisterConnectHandler(func(c *blk.BlockChain, tx storage.Tx, b *blk.Block) error {
+		if failConnect.Load() {
+			return errCrash
+		}
+		return nil
+	})
+}
+
+// setCacheSize sets the cache size of the UTXO sets opened by the test
+func setCacheSize(t *testing.T, size int) {
+	old := utxo.DefaultCacheSize
+	t.Cleanup(func() { utxo.DefaultCacheSize = old })
+	utxo.DefaultCacheSize = size
+}
+
+// reopen writes the cache of bc back and loads it again with the current
+// cache size
+func reopen(t *testing.T, bc *blk.BlockChain) utxo.UTXOSet {
+	t.Helper()
+
+	u := utxo.UTXOSet{bc}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func balance(t *testing.T, u utxo.UTXOSet, w *wallet.Wallet) int {
+	t.Helper()
+
+	balance, err := u.GetBalance(wallet.HashPubKey(w.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+func TestCacheKeepsDirtyEntries(t *testing.T) {
+	bc, _ := chaintest.NewChain(t)
+	var coinbases []*transaction.Transaction
+	for i := 0; i < 30; i++ {
+		b := chaintest.MineBlocks(t, bc, string(chaintest.NewWallet(t).GetAddress()), 1)[0]
+		coinbases = append(coinbases, b.Transactions[0])
+	}
+
+	// 装得下一个块的改动，装不下之前所有的输出
+	setCacheSize(t, 2000)
+	u := reopen(t, bc)
+	w := chaintest.NewWallet(t)
+	dirty := chaintest.MineBlocks(t, bc, string(w.GetAddress()), 1)[0].Transactions[0]
+
+	// 读旧的输出把干净的条目挤出缓存
+	for _, tx := range coinbases {
+		if coin, err := u.GetTxOut(tx.ID, 0); err != nil || coin == nil {
+			t.Fatalf("output %x: %v, %v", tx.ID, coin, err)
+		}
+	}
+	coin, err := u.GetTxOut(dirty.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coin == nil || coin.Value != transaction.Subsidy {
+		t.Fatalf("the output of the last block was evicted before it was written back: %v", coin)
+	}
+	if got := balance(t, u, w); got != transaction.Subsidy {
+		t.Fatalf("balance %d, want %d", got, transaction.Subsidy)
+	}
+
+	u = reopen(t, bc)
+	if got := balance(t, u, w); got != transaction.Subsidy {
+		t.Fatalf("balance after writing back %d, want %d", got, transaction.Subsidy)
+	}
+}
+
+func TestCatchUpAfterFailedFlush(t *testing.T) {
+	chaintest.Setup(t)
+	// 内存数据库重新打开还是同一个，缓存也不会重建
+	blk.DBEngine = storage.Bolt
+	dir := t.TempDir()
+	w := chaintest.NewWallet(t)
+	address := string(w.GetAddress())
+	bc, err := blk.CreateBlockChain(address, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := utxo.UTXOSet{bc}
+	if err := u.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第 100 个没有写回的块和缓存一起写回，见 maxUnflushedBlocks
+	chaintest.MineBlocks(t, bc, address, 99)
+	tip := bc.Tip()
+	coinbase, err := transaction.NewCoinbaseTx(address, "crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failConnect.Store(true)
+	_, err = bc.MineBlock([]*transaction.Transaction{coinbase})
+	failConnect.Store(false)
+	if !errors.Is(err, errCrash) {
+		t.Fatalf("MineBlock: %v", err)
+	}
+	if !bytes.Equal(bc.Tip(), tip) {
+		t.Fatal("the failed block was connected")
+	}
+	if got := balance(t, u, w); got != 100*transaction.Subsidy {
+		t.Fatalf("balance after the failed write back %d, want %d", got, 100*transaction.Subsidy)
+	}
+	// 没有提交的写回不影响下一个块，它和缓存一起写回
+	chaintest.MineBlocks(t, bc, address, 1)
+
+	// 再接上几个块，不写回缓存就关闭，好像进程在这里退出了
+	chaintest.MineBlocks(t, bc, address, 5)
+	tip = bc.Tip()
+	bc.DB.Close()
+	bc, err = blk.NewBlockChain(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u = utxo.UTXOSet{bc}
+	t.Cleanup(func() {
+		u.Close()
+		bc.DB.Close()
+	})
+	if err := u.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(t, u, w); got != 106*transaction.Subsidy {
+		t.Fatalf("balance after catching up %d, want %d", got, 106*transaction.Subsidy)
+	}
+	caughtUp, err := u.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caughtUp.Height != 105 || !bytes.Equal(caughtUp.BestBlock, tip) {
+		t.Fatalf("UTXO set at height %d, want 105", caughtUp.Height)
+	}
+
+	if err := u.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := u.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(caughtUp) != fmt.Sprint(rebuilt) {
+		t.Fatalf("caught up %+v, rebuilt %+v", caughtUp, rebuilt)
+	}
+}
+
+func TestForEachPrefilter(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	chaintest.MineBlocks(t, bc, string(w.GetAddress()), 3)
+
+	// 缓存装不下整个集合，按 pubKeyHash 在记录里查找
+	setCacheSize(t, 0)
+	u := reopen(t, bc)
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+	if got, err := u.GetBalance(pubKeyHash); err != nil || got != 4*transaction.Subsidy {
+		t.Fatalf("balance %d, %v, want %d", got, err, 4*transaction.Subsidy)
+	}
+	// 前缀也出现在记录里，但不是锁定输出的 pubKeyHash
+	if got, err := u.GetBalance(pubKeyHash[:8]); err != nil || got != 0 {
+		t.Fatalf("balance of a prefix of the key %d, %v", got, err)
+	}
+}
+
+func TestCacheMatchesCoinsBucket(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	others := []*wallet.Wallet{chaintest.NewWallet(t), chaintest.NewWallet(t)}
+	wallets := append([]*wallet.Wallet{w}, others...)
+	chaintest.MineBlocks(t, bc, string(w.GetAddress()), 3)
+
+	// 两种缓存看到的余额和统计数据一样
+	check := func(when string) []int {
+		t.Helper()
+
+		var results [2][]int
+		var infos [2]string
+		for i, size := range []int{utxo.DefaultCacheSize, 0} {
+			setCacheSize(t, size)
+			u := reopen(t, bc)
+			for _, w := range wallets {
+				results[i] = append(results[i], balance(t, u, w))
+			}
+			info, err := u.GetTxOutSetInfo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			infos[i] = fmt.Sprint(info)
+		}
+		if fmt.Sprint(results[0]) != fmt.Sprint(results[1]) || infos[0] != infos[1] {
+			t.Fatalf("%s: balances %v with the cache and %v without, stats %s and %s",
+				when, results[0], results[1], infos[0], infos[1])
+		}
+		return results[0]
+	}
+	before := check("before")
+
+	for i, amount := range []int{7, 5} {
+		tx := chaintest.NewTx(t, bc, w, string(others[i].GetAddress()), amount)
+		if _, err := bc.MineBlock([]*transaction.Transaction{tx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := check("after connecting"); fmt.Sprint(got) != fmt.Sprint([]int{before[0] - 12, 7, 5}) {
+		t.Fatalf("balances %v after paying 7 and 5", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := bc.DisconnectBlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := check("after disconnecting"); fmt.Sprint(got) != fmt.Sprint(before) {
+		t.Fatalf("balances %v after disconnecting, want %v", got, before)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"myBitCoin/transaction"
+)
+
+// ErrBadChainstate is returned when a record of the UTXO set can't be decoded,
+// verifychain reports which one
+var ErrBadChainstate = errors.New("utxo: chainstate is corrupt")
+
+// Coin is an unspent output of the UTXO set, stored under its outpoint
+type Coin struct {
+	transaction.TxOutput
+	// 创建这个输出的块的高度
+	Height   int
+	Coinbase bool
+}
+
+func (c *Coin) serialize() []byte {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(c)
+
+	return buf.Bytes()
+}
+
+func decodeCoin(data []byte) (*Coin, error) {
+	var c Coin
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadChainstate, err)
+	}
+
+	return &c, nil
+}
+
+// outPointKey is the key of an output in the coins bucket: the transaction ID
+// followed by the index of the output, so that the outputs of a transaction
+// are next to each other
+func outPointKey(txID []byte, vout int) []byte {
+	key := make([]byte, len(txID)+4)
+	copy(key, txID)
+	binary.BigEndian.PutUint32(key[len(txID):], uint32(vout))
+
+	return key
+}
+
+func splitOutPointKey(key []byte) ([]byte, int) {
+	n := len(key) - 4
+	return key[:n], int(binary.BigEndian.Uint32(key[n:]))
+}
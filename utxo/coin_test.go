@@ -0,0 +1,159 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo_test
+
+import (
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+	"myBitCoin/wallet"
+)
+
+func getTxOut(t *testing.T, u utxo.UTXOSet, txID []byte, vout int) *utxo.Coin {
+	t.Helper()
+
+	coin, err := u.GetTxOut(txID, vout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coin
+}
+
+// spendFirstOutput mines a payment of 3 from w with change, then spends
+// the payment, so that only the second output of the payment is unspent
+func spendFirstOutput(t *testing.T, bc *blk.BlockChain, w *wallet.Wallet) *transaction.Transaction {
+	t.Helper()
+
+	to := chaintest.NewWallet(t)
+	pay := chaintest.NewTx(t, bc, w, string(to.GetAddress()), 3)
+	if _, err := bc.MineBlock([]*transaction.Transaction{pay}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.MineBlock([]*transaction.Transaction{chaintest.NewTx(t, bc, to, string(to.GetAddress()), 3)}); err != nil {
+		t.Fatal(err)
+	}
+
+	return pay
+}
+
+func TestCoinsByOutPoint(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	u := utxo.UTXOSet{bc}
+	genesis, err := bc.GetBlock(mustHashAt(t, bc, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := getTxOut(t, u, genesis.Transactions[0].ID, 0)
+	if coinbase == nil || !coinbase.Coinbase || coinbase.Height != 0 || coinbase.Value != transaction.Subsidy {
+		t.Fatalf("genesis coinbase %+v", coinbase)
+	}
+
+	pay := spendFirstOutput(t, bc, w)
+	if getTxOut(t, u, pay.ID, 0) != nil {
+		t.Fatal("the spent output is still in the UTXO set")
+	}
+	// 第一个输出花掉之后，第二个输出的序号不变
+	change := getTxOut(t, u, pay.ID, 1)
+	if change == nil || change.Coinbase || change.Height != 1 || change.Value != transaction.Subsidy-3 {
+		t.Fatalf("change %+v", change)
+	}
+	if getTxOut(t, u, pay.ID, 2) != nil {
+		t.Fatal("an output which doesn't exist was found")
+	}
+
+	// 花费第二个输出的交易引用的是正确的输出
+	spend := chaintest.NewTx(t, bc, w, string(w.GetAddress()), transaction.Subsidy-3)
+	if len(spend.Vin) != 1 || spend.Vin[0].Vout != 1 {
+		t.Fatalf("spending %+v", spend.Vin)
+	}
+	if _, err := bc.MineBlock([]*transaction.Transaction{spend}); err != nil {
+		t.Fatal(err)
+	}
+	if getTxOut(t, u, pay.ID, 1) != nil {
+		t.Fatal("the change is still in the UTXO set")
+	}
+}
+
+func TestMigrateLegacyChainstate(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	pay := spendFirstOutput(t, bc, w)
+
+	// 旧格式把剩下的输出挤到前面，找零变成了第 0 个
+	u := utxo.UTXOSet{bc}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err := bc.DB.Update(func(tx storage.Tx) error {
+		if err := tx.DeleteBucket([]byte("coins")); err != nil {
+			return err
+		}
+		legacy, err := tx.CreateBucket([]byte("chainstate"))
+		if err != nil {
+			return err
+		}
+		outs := transaction.TxOutPuts{Outputs: pay.Vout[1:]}
+		data, err := outs.Serialize()
+		if err != nil {
+			return err
+		}
+		return legacy.Put(pay.ID, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if getTxOut(t, u, pay.ID, 0) != nil {
+		t.Fatal("the migrated UTXO set has the spent output")
+	}
+	if change := getTxOut(t, u, pay.ID, 1); change == nil || change.Value != transaction.Subsidy-3 {
+		t.Fatalf("migrated change %+v", change)
+	}
+	err = bc.DB.View(func(tx storage.Tx) error {
+		if tx.Bucket([]byte("chainstate")) != nil {
+			t.Error("the legacy bucket was not removed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustHashAt(t *testing.T, bc *blk.BlockChain, height int) []byte {
+	t.Helper()
+
+	hash, err := bc.HashAtHeight(height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
@@ -0,0 +1,103 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	blk "myBitCoin/block"
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+)
+
+// DumpSnapshot writes the coins bucket to w as a snapshot at the block the
+// UTXO set is at, after writing the cache back. No block may be connected
+// while it runs.
+func (u UTXOSet) DumpSnapshot(w io.Writer) (*blk.SnapshotMetadata, error) {
+	c, err := cacheOf(u.BlockChain)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+
+	var meta *blk.SnapshotMetadata
+	err = u.BlockChain.DB.View(func(tx storage.Tx) error {
+		stats, err := readStats(tx)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket([]byte(coinsBucket))
+		if stats == nil || bucket == nil {
+			return errors.New("utxo: there is no UTXO set")
+		}
+
+		meta, err = blk.WriteSnapshot(w, stats.BestBlock, stats.Height, func(f func(coin *blk.SnapshotCoin) error) error {
+			return forEachSnapshotCoin(bucket, f)
+		})
+		return err
+	})
+
+	return meta, err
+}
+
+// forEachSnapshotCoin 把同一笔交易的输出合成一个快照中的 coin，键按交易 ID 排序
+func forEachSnapshotCoin(bucket storage.Bucket, f func(coin *blk.SnapshotCoin) error) error {
+	var (
+		txID     []byte
+		height   int
+		coinbase bool
+		outs     map[int]transaction.TxOutput
+	)
+	flush := func() error {
+		if len(outs) == 0 {
+			return nil
+		}
+		return f(blk.NewSnapshotCoin(txID, height, coinbase, outs))
+	}
+
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		id, vout := splitOutPointKey(k)
+		coin, err := decodeCoin(v)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(id, txID) {
+			if err := flush(); err != nil {
+				return err
+			}
+			txID = append([]byte{}, id...)
+			outs = make(map[int]transaction.TxOutput)
+		}
+		height, coinbase = coin.Height, coin.Coinbase
+		outs[vout] = coin.TxOutput
+	}
+
+	return flush()
+}
@@ -0,0 +1,231 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+)
+
+// assumeUTXO lists the snapshot meta for the duration of the test
+func assumeUTXO(t *testing.T, meta *blk.SnapshotMetadata) {
+	old := blk.AssumeUTXOs
+	t.Cleanup(func() { blk.AssumeUTXOs = old })
+	blk.AssumeUTXOs = append([]blk.AssumeUTXO{}, blk.AssumeUTXO{BaseHash: meta.BaseHash, ContentHash: meta.ContentHash})
+}
+
+// snapshotBase returns the headers from the genesis block to the tip and the tip
+func snapshotBase(t *testing.T, bc *blk.BlockChain) ([]*blk.BlockHeader, *blk.Block) {
+	t.Helper()
+
+	tip, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var headers []*blk.BlockHeader
+	for h := 0; h <= tip.Height; h++ {
+		hash, err := bc.HashAtHeight(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := bc.GetHeader(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers = append(headers, header)
+	}
+
+	return headers, tip
+}
+
+// readCoins reads the metadata and all the coins of a snapshot
+func readCoins(t *testing.T, data []byte) (*blk.SnapshotMetadata, []*blk.SnapshotCoin) {
+	t.Helper()
+
+	snap, err := blk.NewSnapshotReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var coins []*blk.SnapshotCoin
+	for {
+		coin, err := snap.Next()
+		if err == io.EOF {
+			return &snap.Metadata, coins
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		coins = append(coins, coin)
+	}
+}
+
+// loadSnapshot creates a chain in a new directory from the snapshot data
+func loadSnapshot(t *testing.T, data []byte, headers []*blk.BlockHeader, base *blk.Block) (*blk.BlockChain, error) {
+	t.Helper()
+
+	snap, err := blk.NewSnapshotReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := blk.CreateBlockChainFromSnapshot(t.TempDir(), snap, headers, base)
+	if err != nil {
+		return nil, err
+	}
+	u := utxo.UTXOSet{bc}
+	if err := u.Reindex(); err != nil {
+		bc.DB.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		u.Close()
+		bc.DB.Close()
+	})
+
+	return bc, nil
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	to := chaintest.NewWallet(t)
+	chaintest.MineBlocks(t, bc, string(w.GetAddress()), 2)
+	if _, err := bc.MineBlock([]*transaction.Transaction{chaintest.NewTx(t, bc, w, string(to.GetAddress()), 13)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.MineBlock([]*transaction.Transaction{chaintest.NewTx(t, bc, to, string(w.GetAddress()), 4)}); err != nil {
+		t.Fatal(err)
+	}
+
+	u := utxo.UTXOSet{bc}
+	var buf bytes.Buffer
+	meta, err := u.DumpSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers, base := snapshotBase(t, bc)
+	if !bytes.Equal(meta.BaseHash, base.Hash) || meta.BaseHeight != base.Height {
+		t.Fatalf("snapshot at %x height %d", meta.BaseHash, meta.BaseHeight)
+	}
+	if _, coins := readCoins(t, buf.Bytes()); len(coins) != meta.TxCount {
+		t.Fatalf("%d coins, metadata says %d", len(coins), meta.TxCount)
+	}
+
+	// 不在 assumeutxo 列表中的快照不能加载
+	if _, err := loadSnapshot(t, buf.Bytes(), headers, base); err != blk.ErrUnknownSnapshot {
+		t.Fatalf("unknown snapshot: %v", err)
+	}
+	assumeUTXO(t, meta)
+	if _, err := loadSnapshot(t, buf.Bytes(), headers[:len(headers)-1], base); err != blk.ErrSnapshotMismatch {
+		t.Fatalf("missing header: %v", err)
+	}
+
+	loaded, err := loadSnapshot(t, buf.Bytes(), headers, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := u.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lu := utxo.UTXOSet{loaded}
+	got, err := lu.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("loaded UTXO set %+v, want %+v", got, want)
+	}
+	if balance(t, lu, to) != 9 || balance(t, lu, w) != balance(t, u, w) {
+		t.Fatal("the balances of the loaded chain differ")
+	}
+
+	// 新链可以花费快照中的输出
+	spend := chaintest.NewTx(t, loaded, to, string(w.GetAddress()), 9)
+	if _, err := loaded.MineBlock([]*transaction.Transaction{spend}); err != nil {
+		t.Fatal(err)
+	}
+	if balance(t, lu, to) != 0 {
+		t.Fatal("the snapshot output was not spent")
+	}
+
+	// 重放历史得到同一个集合
+	replay := blk.NewSnapshotReplay(meta)
+	for _, h := range headers {
+		b, err := bc.GetBlock(h.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := replay.ConnectBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := replay.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotTampered(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	chaintest.MineBlocks(t, bc, string(w.GetAddress()), 2)
+
+	var buf bytes.Buffer
+	meta, err := utxo.UTXOSet{bc}.DumpSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assumeUTXO(t, meta)
+	headers, base := snapshotBase(t, bc)
+
+	// 改掉一个输出的金额，元数据不变
+	_, coins := readCoins(t, buf.Bytes())
+	coins[0].Tx.Vout[coins[0].Unspent[0]].Value++
+	var tampered bytes.Buffer
+	enc := gob.NewEncoder(&tampered)
+	if err := enc.Encode(meta); err != nil {
+		t.Fatal(err)
+	}
+	for _, coin := range coins {
+		if err := enc.Encode(coin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := loadSnapshot(t, tampered.Bytes(), headers, base); !errors.Is(err, blk.ErrBadSnapshot) {
+		t.Fatalf("tampered snapshot: %v", err)
+	}
+
+	// 历史和快照对不上
+	replay := blk.NewSnapshotReplay(meta)
+	if err := replay.Verify(); err != blk.ErrSnapshotMismatch {
+		t.Fatalf("replay before the base block: %v", err)
+	}
+}
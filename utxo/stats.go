@@ -0,0 +1,189 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+
+	"myBitCoin/muhash"
+	"myBitCoin/storage"
+)
+
+// UTXO 集合的统计数据单独存放，coins 里只有输出
+const (
+	statsBucket = "chainstatestats"
+	statsKey    = "s"
+)
+
+// TxOutSetInfo describes the UTXO set at the block BestBlock. Commitment is
+// the MuHash3072 of the unspent outputs, two nodes with the same UTXO set have
+// the same commitment.
+type TxOutSetInfo struct {
+	BestBlock      []byte
+	Height         int
+	Transactions   int
+	TxOuts         int
+	TotalAmount    int
+	SerializedSize int
+	Commitment     []byte
+}
+
+// utxoStats 在连接块时增量维护，MuHash 保存的是可以继续更新的状态
+type utxoStats struct {
+	BestBlock      []byte
+	Height         int
+	Transactions   int
+	TxOuts         int
+	TotalAmount    int
+	SerializedSize int
+	MuHash         []byte
+
+	hash *muhash.MuHash
+}
+
+// outputElement is the element of the MuHash for an unspent output: its
+// outpoint, height, coinbase flag, value and locking script
+func outputElement(key []byte, coin *Coin) []byte {
+	var buf bytes.Buffer
+	buf.Write(key)
+	code := int64(coin.Height) << 1
+	if coin.Coinbase {
+		code |= 1
+	}
+	binary.Write(&buf, binary.BigEndian, code)
+	binary.Write(&buf, binary.BigEndian, int64(coin.Value))
+	buf.Write(coin.PubKeyHash)
+
+	return buf.Bytes()
+}
+
+// addCoin adds an output, size is the size of its record in the coins bucket.
+// The transactions are counted by the caller.
+func (s *utxoStats) addCoin(key []byte, coin *Coin, size int) {
+	s.TxOuts++
+	s.TotalAmount += coin.Value
+	s.SerializedSize += size
+	s.hash.Insert(outputElement(key, coin))
+}
+
+func (s *utxoStats) removeCoin(key []byte, coin *Coin, size int) {
+	s.TxOuts--
+	s.TotalAmount -= coin.Value
+	s.SerializedSize -= size
+	s.hash.Remove(outputElement(key, coin))
+}
+
+// copy 复制一份可以独立更新的统计数据
+func (s *utxoStats) copy() *utxoStats {
+	cp := *s
+	cp.hash = muhash.New()
+	cp.hash.Combine(s.hash)
+
+	return &cp
+}
+
+// computeStats 遍历整个 coins bucket，用于 Reindex 和没有统计数据的旧数据库。
+// 同一个交易的输出是相邻的
+func computeStats(bucket storage.Bucket) (*utxoStats, error) {
+	s := &utxoStats{hash: muhash.New()}
+	var lastTxID []byte
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		txID, _ := splitOutPointKey(k)
+		if !bytes.Equal(txID, lastTxID) {
+			s.Transactions++
+			lastTxID = append(lastTxID[:0], txID...)
+		}
+		coin, err := decodeCoin(v)
+		if err != nil {
+			return nil, err
+		}
+		s.addCoin(k, coin, len(k)+len(v))
+	}
+
+	return s, nil
+}
+
+// readStats returns nil when the statistics were never written
+func readStats(tx storage.Tx) (*utxoStats, error) {
+	b := tx.Bucket([]byte(statsBucket))
+	if b == nil {
+		return nil, nil
+	}
+	data := b.Get([]byte(statsKey))
+	if data == nil {
+		return nil, nil
+	}
+
+	var s utxoStats
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return nil, fmt.Errorf("%w: statistics: %v", ErrBadChainstate, err)
+	}
+	hash, err := muhash.Deserialize(s.MuHash)
+	if err != nil {
+		return nil, fmt.Errorf("%w: statistics: %v", ErrBadChainstate, err)
+	}
+	s.hash = hash
+
+	return &s, nil
+}
+
+func writeStats(tx storage.Tx, s *utxoStats) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(statsBucket))
+	if err != nil {
+		return err
+	}
+
+	s.MuHash = s.hash.Serialize()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return err
+	}
+
+	return b.Put([]byte(statsKey), buf.Bytes())
+}
+
+// GetTxOutSetInfo returns the statistics of the UTXO set
+func (u UTXOSet) GetTxOutSetInfo() (*TxOutSetInfo, error) {
+	c, err := cacheOf(u.BlockChain)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &TxOutSetInfo{
+		BestBlock:      c.stats.BestBlock,
+		Height:         c.stats.Height,
+		Transactions:   c.stats.Transactions,
+		TxOuts:         c.stats.TxOuts,
+		TotalAmount:    c.stats.TotalAmount,
+		SerializedSize: c.stats.SerializedSize,
+		Commitment:     c.stats.hash.Finalize(),
+	}, nil
+}
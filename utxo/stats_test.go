@@ -0,0 +1,83 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"myBitCoin/chaintest"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+)
+
+func txOutSetInfo(t *testing.T, u utxo.UTXOSet) *utxo.TxOutSetInfo {
+	t.Helper()
+
+	info, err := u.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestTxOutSetInfo(t *testing.T) {
+	bc, w := chaintest.NewChain(t)
+	u := utxo.UTXOSet{bc}
+	to := chaintest.NewWallet(t)
+	chaintest.MineBlocks(t, bc, string(w.GetAddress()), 1)
+	before := txOutSetInfo(t, u)
+
+	tx := chaintest.NewTx(t, bc, w, string(to.GetAddress()), 3)
+	b, err := bc.MineBlock([]*transaction.Transaction{tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := txOutSetInfo(t, u)
+	// 花掉一个 coinbase，得到付款和找零两个输出
+	if info.Height != 2 || !bytes.Equal(info.BestBlock, b.Hash) || info.Transactions != 2 || info.TxOuts != 3 ||
+		info.TotalAmount != 2*transaction.Subsidy {
+		t.Fatalf("info %+v", info)
+	}
+	if bytes.Equal(info.Commitment, before.Commitment) {
+		t.Fatal("the commitment did not change")
+	}
+
+	// 增量维护的结果和重建的一样
+	if err := u.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	if got := txOutSetInfo(t, u); !reflect.DeepEqual(got, info) {
+		t.Fatalf("after a reindex %+v, want %+v", got, info)
+	}
+
+	if _, err := bc.DisconnectBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if got := txOutSetInfo(t, u); !reflect.DeepEqual(got, before) {
+		t.Fatalf("after a disconnect %+v, want %+v", got, before)
+	}
+}
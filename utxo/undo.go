@@ -0,0 +1,156 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+
+	blk "myBitCoin/block"
+	"myBitCoin/storage"
+)
+
+// 块哈希 -> blockUndo，和块在同一个事务中写入
+const undoBucket = "undo"
+
+var (
+	ErrNoUndoData  = errors.New("utxo: block has no undo data")
+	ErrBadUndoData = errors.New("utxo: undo data does not match the block")
+)
+
+// undoCoin is an output removed from the UTXO set by a block
+type undoCoin struct {
+	OutPoint []byte
+	Coin     *Coin
+}
+
+// blockUndo holds the outputs removed by each transaction of a block, in the
+// order they were removed: the outputs spent by the inputs, then the outputs
+// of an earlier transaction with the same ID
+type blockUndo [][]undoCoin
+
+func writeUndo(tx storage.Tx, hash []byte, undo blockUndo) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(undo); err != nil {
+		return err
+	}
+
+	return b.Put(hash, buf.Bytes())
+}
+
+// readUndo returns ErrNoUndoData when the block was connected before the
+// undo data was kept, or by a reindex
+func readUndo(tx storage.Tx, hash []byte) (blockUndo, error) {
+	b := tx.Bucket([]byte(undoBucket))
+	if b == nil {
+		return nil, ErrNoUndoData
+	}
+	data := b.Get(hash)
+	if data == nil {
+		return nil, ErrNoUndoData
+	}
+
+	var undo blockUndo
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo); err != nil {
+		return nil, err
+	}
+
+	return undo, nil
+}
+
+func init() {
+	blk.RegisterDisconnectHandler(disconnectBlock)
+	blk.RegisterPruneHandler(pruneUndo)
+}
+
+// pruneUndo deletes the undo data of a pruned block
+func pruneUndo(bc *blk.BlockChain, tx storage.Tx, hash []byte) error {
+	b := tx.Bucket([]byte(undoBucket))
+	if b == nil {
+		return nil
+	}
+
+	return b.Delete(hash)
+}
+
+// disconnectBlock restores the UTXO set before block from its undo data, in
+// the transaction disconnecting the block
+func disconnectBlock(bc *blk.BlockChain, tx storage.Tx, block *blk.Block) error {
+	bucket := tx.Bucket([]byte(coinsBucket))
+	if bucket == nil {
+		return nil
+	}
+
+	c := loadedCache(bc)
+	if c != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+
+	stats, err := currentStats(tx, bucket, c, block.Hash)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stats.BestBlock, block.Hash) {
+		return ErrChainstateMismatch
+	}
+
+	undo, err := readUndo(tx, block.Hash)
+	if err != nil {
+		return err
+	}
+	if len(undo) != len(block.Transactions) {
+		return ErrBadUndoData
+	}
+
+	v := newView(bucket, c)
+	// 倒序撤销，后面的交易花掉的输出先放回去
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		if _, err := removeOutputs(v, block.Transactions[i].ID, stats); err != nil {
+			return err
+		}
+		for j := len(undo[i]) - 1; j >= 0; j-- {
+			if err := restoreCoin(v, undo[i][j], stats); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Bucket([]byte(undoBucket)).Delete(block.Hash); err != nil {
+		return err
+	}
+
+	stats.BestBlock = block.PrevHash
+	stats.Height = block.Height - 1
+
+	// 断开的块总是和缓存一起写回，数据库中的 UTXO 集合不会停在主链之外
+	return v.commit(tx, block.Hash, false, stats, true)
+}
@@ -30,120 +30,163 @@
 package utxo
 
 import (
-	"github.com/boltdb/bolt"
+	"bytes"
+	"errors"
+	"fmt"
 	"encoding/hex"
 
 	blk "myBitCoin/block"
+	"myBitCoin/storage"
 	"myBitCoin/transaction"
-	"log"
 )
 
-const utxoBucket = "chainstate"
+const (
+	// outpoint -> Coin
+	coinsBucket = "coins"
+	// 旧格式：交易 ID -> 剩下的输出，打开时迁移到 coins
+	legacyBucket = "chainstate"
+)
 
 type UTXOSet struct {
 	BlockChain *blk.BlockChain
 }
 
-func (utxo *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+func (utxo *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int, error) {
 	unspendOutputs := make(map[string][]int)
 	accumulation := 0
-	db := utxo.BlockChain.DB
-
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucket))
-		c := bucket.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			txID := hex.EncodeToString(k)
-			outs := transaction.DeserializeOutPuts(v)
-
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					accumulation += out.Value
-					unspendOutputs[txID] = append(unspendOutputs[txID], outIdx)
-				}
-			}
-		}
-		return nil
-	})
+	c, err := cacheOf(utxo.BlockChain)
 	if err != nil {
-		log.Panic(err)
+		return 0, nil, err
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = c.forEach(pubKeyHash, func(k []byte, vout int, coin *Coin) {
+		txID := hex.EncodeToString(k)
+		accumulation += coin.Value
+		unspendOutputs[txID] = append(unspendOutputs[txID], vout)
+	})
 
-	return accumulation, unspendOutputs
+	return accumulation, unspendOutputs, err
 }
 
 // FindUTXO finds UTXO for a public key hash
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []transaction.TxOutput {
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]transaction.TxOutput, error) {
 	var UTXOs []transaction.TxOutput
-	db := u.BlockChain.DB
+	c, err := cacheOf(u.BlockChain)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
+	err = c.forEach(pubKeyHash, func(_ []byte, _ int, coin *Coin) {
+		UTXOs = append(UTXOs, coin.TxOutput)
+	})
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs := transaction.DeserializeOutPuts(v)
+	return UTXOs, err
+}
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
-			}
-		}
+// GetBalance sums all the unspent outputs locked with pubKeyHash
+func (u UTXOSet) GetBalance(pubKeyHash []byte) (int, error) {
+	outs, err := u.FindUTXO(pubKeyHash)
+	if err != nil {
+		return 0, err
+	}
 
-		return nil
-	})
+	balance := 0
+	for _, out := range outs {
+		balance += out.Value
+	}
+
+	return balance, nil
+}
+
+// GetTxOut returns the output vout of the transaction txID, or nil when it is
+// spent or doesn't exist
+func (u UTXOSet) GetTxOut(txID []byte, vout int) (*Coin, error) {
+	c, err := cacheOf(u.BlockChain)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.get(outPointKey(txID, vout))
+	if e == nil || err != nil {
+		return nil, err
 	}
+	coin := *e.coin
 
-	return UTXOs
+	return &coin, nil
 }
 
-func (utxo *UTXOSet) Reindex() {
-	db := utxo.BlockChain.DB
-	bucketName := []byte(utxoBucket)
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
-		}
+// Reindex rebuilds the coins bucket from the blocks
+func (utxo *UTXOSet) Reindex() error {
+	dropCache(utxo.BlockChain)
+	_, err := reindex(utxo.BlockChain)
 
-		_, err = tx.CreateBucket(bucketName)
-		if err != nil {
-			log.Panic(err)
-		}
+	return err
+}
 
+func reindex(bc *blk.BlockChain) (*utxoStats, error) {
+	// 只保留输出，不保留整笔交易
+	type kv struct{ key, value []byte }
+	var coins []kv
+	err := bc.ForEachUnspentCoin(func(c *blk.SnapshotCoin) error {
+		for _, vout := range c.Unspent {
+			coin := &Coin{c.Tx.Vout[vout], c.Height, c.Tx.IsCoinbase()}
+			coins = append(coins, kv{outPointKey(c.Tx.ID, vout), coin.serialize()})
+		}
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	tip := bc.Tip()
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, err
 	}
 
-	UTXOs := utxo.BlockChain.FindUTXO()
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(bucketName)
-
-		for txID, out := range UTXOs {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				log.Panic(err)
+	var stats *utxoStats
+	err = bc.DB.Update(func(tx storage.Tx) error {
+		for _, name := range []string{coinsBucket, legacyBucket} {
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil && err != storage.ErrBucketNotFound {
+				return err
 			}
+		}
 
-			err = bucket.Put(key, out.Serialize())
-			if err != nil {
-				log.Panic(err)
+		bucket, err := tx.CreateBucket([]byte(coinsBucket))
+		if err != nil {
+			return err
+		}
+
+		for _, e := range coins {
+			if err := bucket.Put(e.key, e.value); err != nil {
+				return err
 			}
 		}
-		return nil
+
+		if stats, err = computeStats(bucket); err != nil {
+			return err
+		}
+		stats.BestBlock = tip
+		stats.Height = height
+		return writeStats(tx, stats)
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
 }
 /*
 func (utxo *UTXOSet) Update(block *blk.Block) {
 	db := utxo.BlockChain.DB
 
-	err := db.Update(func(tx *bolt.Tx) error {
+	err := db.Update(func(tx storage.Tx) error {
 		bucket := tx.Bucket([]byte(utxoBucket))
 
 		for _, tr := range block.Transactions {
@@ -191,54 +234,189 @@ func (utxo *UTXOSet) Update(block *blk.Block) {
 	}
 }*/
 
-func (u UTXOSet) Update(block *blk.Block) {
-	db := u.BlockChain.DB
+// ErrChainstateMismatch is returned when a block doesn't extend the block
+// the UTXO set is at. Open rebuilds such a UTXO set.
+var ErrChainstateMismatch = errors.New("utxo: chainstate is not at the parent of the block")
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
+func init() {
+	blk.RegisterConnectHandler(connectBlock)
+	blk.RegisterCoinLookup(lookupCoin)
+}
 
-		for _, tr := range block.Transactions {
-			if tr.IsCoinbase() == false {
-				for _, vin := range tr.Vin {
-					updatedOuts := transaction.TxOutPuts{}
-					outsBytes := b.Get(vin.TxID)
-					outs := transaction.DeserializeOutPuts(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+// lookupCoin 返回块中交易的输入花费的输出
+func lookupCoin(bc *blk.BlockChain, txID []byte, vout int) (*transaction.TxOutput, error) {
+	coin, err := UTXOSet{bc}.GetTxOut(txID, vout)
+	if coin == nil || err != nil {
+		return nil, err
+	}
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.TxID)
-						if err != nil {
-							log.Panic(err)
-						}
-					} else {
-						err := b.Put(vin.TxID, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
-					}
+	return &coin.TxOutput, nil
+}
 
+// connectBlock applies the transactions of block to the UTXO set, in the
+// transaction connecting the block. The cache is updated once the
+// transaction is committed.
+func connectBlock(bc *blk.BlockChain, tx storage.Tx, block *blk.Block) error {
+	return connect(tx, loadedCache(bc), block)
+}
+
+// connect keeps the changes of block in the cache c until they are written
+// back, they are written to the coins bucket at once when c is nil
+func connect(tx storage.Tx, c *cache, block *blk.Block) error {
+	bucket := tx.Bucket([]byte(coinsBucket))
+	if bucket == nil {
+		// 还没有建立 UTXO 集合的链，Reindex 或 Open 时一起建立
+		return nil
+	}
+	if c != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+
+	stats, err := currentStats(tx, bucket, c, block.PrevHash)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stats.BestBlock, block.PrevHash) {
+		return ErrChainstateMismatch
+	}
+
+	v := newView(bucket, c)
+	undo := make(blockUndo, len(block.Transactions))
+
+	for i, tr := range block.Transactions {
+		if tr.IsCoinbase() == false {
+			for _, vin := range tr.Vin {
+				key := outPointKey(vin.TxID, vin.Vout)
+				e, err := v.get(key)
+				if err != nil {
+					return err
 				}
-			}
+				if e == nil {
+					return fmt.Errorf("utxo: output %x:%d is not unspent", vin.TxID, vin.Vout)
+				}
+				stats.removeCoin(key, e.coin, e.size)
+				v.put(key, nil)
+				undo[i] = append(undo[i], undoCoin{key, e.coin})
 
-			newOutputs := transaction.TxOutPuts{}
-			for _, out := range tr.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+				has, err := hasOutputs(v, vin.TxID)
+				if err != nil {
+					return err
+				}
+				if !has {
+					stats.Transactions--
+				}
 			}
+		}
 
-			err := b.Put(tr.ID, newOutputs.Serialize())
-			if err != nil {
-				log.Panic(err)
-			}
+		// 相同 ID 的交易被覆盖
+		removed, err := removeOutputs(v, tr.ID, stats)
+		if err != nil {
+			return err
 		}
+		undo[i] = append(undo[i], removed...)
 
-		return nil
-	})
+		for vout, out := range tr.Vout {
+			key := outPointKey(tr.ID, vout)
+			coin := &Coin{out, block.Height, tr.IsCoinbase()}
+			e := v.put(key, coin)
+			stats.addCoin(key, coin, e.size)
+		}
+		if len(tr.Vout) > 0 {
+			stats.Transactions++
+		}
+	}
+
+	if err := writeUndo(tx, block.Hash, undo); err != nil {
+		return err
+	}
+
+	stats.BestBlock = block.Hash
+	stats.Height = block.Height
+
+	return v.commit(tx, block.Hash, true, stats, c == nil || c.needsFlush(v.changes))
+}
+
+// currentStats returns a copy of the statistics of the UTXO set, the ones of
+// the cache when there is one. best is the block the coins bucket is at when
+// it has no statistics.
+func currentStats(tx storage.Tx, bucket storage.Bucket, c *cache, best []byte) (*utxoStats, error) {
+	if c != nil {
+		if err := c.resolvePending(tx); err != nil {
+			return nil, err
+		}
+		return c.stats.copy(), nil
+	}
+
+	stats, err := readStats(tx)
+	if err != nil || stats != nil {
+		return stats, err
+	}
+	if stats, err = computeStats(bucket); err != nil {
+		return nil, err
+	}
+	stats.BestBlock = best
+
+	return stats, nil
+}
+
+// hasOutputs reports whether the transaction txID has unspent outputs
+func hasOutputs(v *view, txID []byte) (bool, error) {
+	outs, err := v.outputs(txID)
+	return len(outs) > 0, err
+}
+
+// removeOutputs removes the unspent outputs of the transaction txID and
+// returns them
+func removeOutputs(v *view, txID []byte, stats *utxoStats) ([]undoCoin, error) {
+	outs, err := v.outputs(txID)
+	if err != nil || len(outs) == 0 {
+		return nil, err
+	}
+
+	removed := make([]undoCoin, 0, len(outs))
+	for _, e := range outs {
+		key := []byte(e.key)
+		stats.removeCoin(key, e.coin, e.size)
+		v.put(key, nil)
+		removed = append(removed, undoCoin{key, e.coin})
+	}
+	stats.Transactions--
+
+	return removed, nil
+}
+
+// restoreCoin puts back an output removed by a block
+func restoreCoin(v *view, u undoCoin, stats *utxoStats) error {
+	txID, _ := splitOutPointKey(u.OutPoint)
+	has, err := hasOutputs(v, txID)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	if !has {
+		stats.Transactions++
+	}
+
+	e := v.put(u.OutPoint, u.Coin)
+	stats.addCoin(u.OutPoint, u.Coin, e.size)
+
+	return nil
+}
+
+// Open checks the UTXO set against the tip of the chain, migrates or rebuilds
+// it when they differ and loads the cache. It is called when a node starts.
+func (u UTXOSet) Open() error {
+	_, err := cacheOf(u.BlockChain)
+	return err
+}
+
+// Close writes the cache of the chain back and releases it, it is called
+// before the DB of the chain is closed
+func (u UTXOSet) Close() error {
+	c := dropCache(u.BlockChain)
+	if c == nil {
+		return nil
 	}
+
+	return c.flush()
 }
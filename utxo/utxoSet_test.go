@@ -0,0 +1,107 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo_test
+
+import (
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/utxo"
+	"myBitCoin/wallet"
+)
+
+// benchChain returns a regtest chain with blocks paying the coinbases to
+// several wallets in turn, and the public key hash of one of them
+func benchChain(b *testing.B, blocks, wallets int) (*blk.BlockChain, []byte) {
+	b.Helper()
+
+	bc, w := chaintest.NewChain(b)
+	addresses := []string{string(w.GetAddress())}
+	var pubKeyHash []byte
+	for len(addresses) < wallets {
+		w := chaintest.NewWallet(b)
+		address := string(w.GetAddress())
+		if !wallet.ValidateAddress(address) {
+			continue
+		}
+		addresses = append(addresses, address)
+		pubKeyHash = wallet.HashPubKey(w.PublicKey)
+	}
+
+	for i := 0; i < blocks; i++ {
+		chaintest.MineBlocks(b, bc, addresses[i%wallets], 1)
+	}
+
+	return bc, pubKeyHash
+}
+
+// benchCache runs f with the default cache and with a cache that keeps
+// nothing, so that every call reads the coins bucket
+func benchCache(b *testing.B, bc *blk.BlockChain, f func(u utxo.UTXOSet) error) {
+	for _, c := range []struct {
+		name string
+		size int
+	}{
+		{"cache", utxo.DefaultCacheSize},
+		{"nocache", 0},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			defer func(size int) { utxo.DefaultCacheSize = size }(utxo.DefaultCacheSize)
+			utxo.DefaultCacheSize = c.size
+
+			// 重新打开才会按新的大小建立缓存
+			u := utxo.UTXOSet{bc}
+			if err := u.Close(); err != nil {
+				b.Fatal(err)
+			}
+			if err := u.Open(); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := f(u); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetBalance(b *testing.B) {
+	bc, pubKeyHash := benchChain(b, 200, 10)
+	benchCache(b, bc, func(u utxo.UTXOSet) error {
+		_, err := u.GetBalance(pubKeyHash)
+		return err
+	})
+}
+
+func BenchmarkFindSpendableOutputs(b *testing.B) {
+	bc, pubKeyHash := benchChain(b, 200, 10)
+	benchCache(b, bc, func(u utxo.UTXOSet) error {
+		_, _, err := u.FindSpendableOutputs(pubKeyHash, 50)
+		return err
+	})
+}
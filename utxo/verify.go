@@ -0,0 +1,186 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	blk "myBitCoin/block"
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+)
+
+// VerifyChain checks the depth blocks below the tip at level, one of the
+// levels of blk.VerifyBlocks. The blocks connected by a reindex have no undo
+// data to check. At blk.VerifyUTXO the UTXO set is rebuilt from all the
+// blocks and compared with the coins bucket. It returns the number of blocks
+// checked.
+func (u UTXOSet) VerifyChain(level, depth int) (int, error) {
+	bc := u.BlockChain
+
+	var check func(b *blk.Block) error
+	if level >= blk.VerifyUndo {
+		check = func(b *blk.Block) error {
+			return verifyUndo(bc, b)
+		}
+	}
+	n, err := bc.VerifyBlocks(level, depth, check)
+	if err != nil || level < blk.VerifyUTXO {
+		return n, err
+	}
+	// 比较的是 coins bucket，先把缓存写回
+	if c := loadedCache(bc); c != nil {
+		if err := c.flush(); err != nil {
+			return n, err
+		}
+	}
+
+	return n, verifyCoins(bc)
+}
+
+// verifyUndo checks that the undo data of b holds the outputs its inputs spent
+func verifyUndo(bc *blk.BlockChain, b *blk.Block) error {
+	var undo blockUndo
+	var err error
+	bc.DB.View(func(tx storage.Tx) error {
+		undo, err = readUndo(tx, b.Hash)
+		return nil
+	})
+	if err == ErrNoUndoData {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(undo) != len(b.Transactions) {
+		return ErrBadUndoData
+	}
+
+	inBlock := make(map[string]*transaction.Transaction)
+	for i, tr := range b.Transactions {
+		if !tr.IsCoinbase() {
+			if len(undo[i]) < len(tr.Vin) {
+				return ErrBadUndoData
+			}
+			for j, vin := range tr.Vin {
+				prev, ok := inBlock[hex.EncodeToString(vin.TxID)]
+				if !ok {
+					found, err := bc.FindTransaction(vin.TxID)
+					if err != nil {
+						return err
+					}
+					prev = &found
+				}
+
+				// 输入的检查在 VerifyTransactions 中做过，序号不会越界
+				out := prev.Vout[vin.Vout]
+				spent := undo[i][j]
+				if !bytes.Equal(spent.OutPoint, outPointKey(vin.TxID, vin.Vout)) ||
+					spent.Coin.Value != out.Value ||
+					!bytes.Equal(spent.Coin.PubKeyHash, out.PubKeyHash) ||
+					spent.Coin.Coinbase != prev.IsCoinbase() {
+					return ErrBadUndoData
+				}
+			}
+		}
+		inBlock[hex.EncodeToString(tr.ID)] = tr
+	}
+
+	return nil
+}
+
+// verifyCoins compares the coins bucket and its statistics with the UTXO set
+// rebuilt from the blocks
+func verifyCoins(bc *blk.BlockChain) error {
+	expected := make(map[string]*Coin)
+	err := bc.ForEachUnspentCoin(func(c *blk.SnapshotCoin) error {
+		for _, vout := range c.Unspent {
+			expected[string(outPointKey(c.Tx.ID, vout))] = &Coin{c.Tx.Vout[vout], c.Height, c.Tx.IsCoinbase()}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	tip := bc.Tip()
+
+	return bc.DB.View(func(tx storage.Tx) error {
+		bucket := tx.Bucket([]byte(coinsBucket))
+		if bucket == nil {
+			return errors.New("utxo: there is no UTXO set")
+		}
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			txID, vout := splitOutPointKey(k)
+			want, ok := expected[string(k)]
+			if !ok {
+				return fmt.Errorf("utxo: output %x:%d is not unspent in the chain", txID, vout)
+			}
+			got, err := decodeCoin(v)
+			if err != nil {
+				return fmt.Errorf("utxo: output %x:%d: %v", txID, vout, err)
+			}
+			if got.Value != want.Value || !bytes.Equal(got.PubKeyHash, want.PubKeyHash) ||
+				got.Height != want.Height || got.Coinbase != want.Coinbase {
+				return fmt.Errorf("utxo: output %x:%d does not match the chain", txID, vout)
+			}
+			delete(expected, string(k))
+		}
+
+		if len(expected) > 0 {
+			var missing []string
+			for k := range expected {
+				missing = append(missing, k)
+			}
+			sort.Strings(missing)
+			txID, vout := splitOutPointKey([]byte(missing[0]))
+			return fmt.Errorf("utxo: output %x:%d is missing from the UTXO set", txID, vout)
+		}
+
+		stats, err := readStats(tx)
+		if err != nil {
+			return err
+		}
+		if stats == nil || !bytes.Equal(stats.BestBlock, tip) {
+			return errors.New("utxo: the UTXO set is not at the tip")
+		}
+		computed, err := computeStats(bucket)
+		if err != nil {
+			return err
+		}
+		if stats.Transactions != computed.Transactions || stats.TxOuts != computed.TxOuts ||
+			stats.TotalAmount != computed.TotalAmount || stats.SerializedSize != computed.SerializedSize ||
+			!bytes.Equal(stats.hash.Finalize(), computed.hash.Finalize()) {
+			return errors.New("utxo: the statistics of the UTXO set do not match its outputs")
+		}
+
+		return nil
+	})
+}
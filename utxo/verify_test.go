@@ -0,0 +1,160 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo_test
+
+import (
+	"errors"
+	"testing"
+
+	blk "myBitCoin/block"
+	"myBitCoin/chaintest"
+	"myBitCoin/storage"
+	"myBitCoin/transaction"
+	"myBitCoin/utxo"
+)
+
+// verifyChain returns a chain of height 4 with a payment in each block
+func verifyChain(t *testing.T) *blk.BlockChain {
+	t.Helper()
+
+	bc, w := chaintest.NewChain(t)
+	to := chaintest.NewWallet(t)
+	for i := 0; i < 4; i++ {
+		cb, err := transaction.NewCoinbaseTx(string(w.GetAddress()), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx := chaintest.NewTx(t, bc, w, string(to.GetAddress()), 1)
+		if _, err := bc.MineBlock([]*transaction.Transaction{cb, tx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc
+}
+
+// putBlock overwrites the stored block hash with data
+func putBlock(t *testing.T, bc *blk.BlockChain, hash, data []byte) {
+	t.Helper()
+
+	err := bc.DB.Update(func(tx storage.Tx) error {
+		return tx.Bucket([]byte("blocks")).Put(hash, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wantBlockError checks that err is a BlockError at height
+func wantBlockError(t *testing.T, err error, height int) {
+	t.Helper()
+
+	var be *blk.BlockError
+	if !errors.As(err, &be) || be.Height != height {
+		t.Fatalf("got %v, want an error at height %d", err, height)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	bc := verifyChain(t)
+	u := utxo.UTXOSet{bc}
+
+	for level := blk.VerifyHeaders; level <= blk.VerifyUTXO; level++ {
+		if n, err := u.VerifyChain(level, 0); err != nil || n != 5 {
+			t.Fatalf("level %d: %d blocks, %v", level, n, err)
+		}
+	}
+	if n, err := u.VerifyChain(blk.VerifyUTXO, 2); err != nil || n != 2 {
+		t.Fatalf("depth 2: %d blocks, %v", n, err)
+	}
+}
+
+func TestVerifyChainFindsCorruptBlock(t *testing.T) {
+	bc := verifyChain(t)
+	u := utxo.UTXOSet{bc}
+
+	hash, err := bc.HashAtHeight(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putBlock(t, bc, hash, []byte("garbage"))
+
+	n, err := u.VerifyChain(blk.VerifyHeaders, 0)
+	if n != 2 {
+		t.Errorf("%d blocks checked before the corrupt one", n)
+	}
+	wantBlockError(t, err, 2)
+	// 损坏的块在检查的深度之外
+	if _, err := u.VerifyChain(blk.VerifyMerkle, 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyChainFindsChangedTransaction(t *testing.T) {
+	bc := verifyChain(t)
+	u := utxo.UTXOSet{bc}
+
+	hash, err := bc.HashAtHeight(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := bc.GetBlock(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 块的哈希不变，交易的金额改了
+	b.Transactions[1].Vout[0].Value++
+	putBlock(t, bc, hash, b.Serialize())
+
+	if _, err := u.VerifyChain(blk.VerifyHeaders, 0); err != nil {
+		t.Fatalf("headers only: %v", err)
+	}
+	_, err = u.VerifyChain(blk.VerifyMerkle, 0)
+	wantBlockError(t, err, 3)
+}
+
+func TestVerifyChainFindsCorruptUTXOSet(t *testing.T) {
+	bc := verifyChain(t)
+	u := utxo.UTXOSet{bc}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 删掉一个输出
+	err := bc.DB.Update(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte("coins"))
+		k, _ := b.Cursor().First()
+		return b.Delete(k)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := u.VerifyChain(blk.VerifyUndo, 0); err != nil {
+		t.Fatalf("blocks only: %v", err)
+	}
+	if _, err := u.VerifyChain(blk.VerifyUTXO, 0); err == nil {
+		t.Fatal("the missing output was not found")
+	}
+}
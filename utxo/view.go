@@ -0,0 +1,204 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 SmartestEE Co., Ltd.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utxo
+
+import (
+	"bytes"
+	"sort"
+
+	"myBitCoin/storage"
+)
+
+// view is the UTXO set seen by a block being connected or disconnected: its
+// own changes over the dirty entries of the cache over the coins bucket. The
+// caller holds the lock of the cache.
+type view struct {
+	bucket storage.Bucket
+	c      *cache
+	// nil 表示没有缓存，改动直接写进 coins bucket
+	changes map[string]*entry
+	// 改动按交易 ID 索引
+	txs map[string]map[string]*entry
+}
+
+func newView(bucket storage.Bucket, c *cache) *view {
+	return &view{
+		bucket:  bucket,
+		c:       c,
+		changes: make(map[string]*entry),
+		txs:     make(map[string]map[string]*entry),
+	}
+}
+
+// get returns the entry of an unspent output, or nil
+func (v *view) get(key []byte) (*entry, error) {
+	if e, ok := v.changes[string(key)]; ok {
+		return unspent(e), nil
+	}
+	if v.c != nil {
+		if e, ok := v.c.entries[string(key)]; ok {
+			return unspent(e), nil
+		}
+		if v.c.full {
+			return nil, nil
+		}
+	}
+
+	data := v.bucket.Get(key)
+	if data == nil {
+		return nil, nil
+	}
+	coin, err := decodeCoin(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry{key: string(key), coin: coin, size: len(key) + len(data)}, nil
+}
+
+func unspent(e *entry) *entry {
+	if e.coin == nil {
+		return nil
+	}
+
+	return e
+}
+
+// put adds an output, or marks it spent when coin is nil, and returns its
+// entry
+func (v *view) put(key []byte, coin *Coin) *entry {
+	e := &entry{key: string(key), coin: coin, size: len(key)}
+	if coin != nil {
+		e.size += len(coin.serialize())
+	}
+	v.changes[e.key] = e
+
+	txID, _ := splitOutPointKey(key)
+	outs := v.txs[string(txID)]
+	if outs == nil {
+		outs = make(map[string]*entry)
+		v.txs[string(txID)] = outs
+	}
+	outs[e.key] = e
+
+	return e
+}
+
+// outputs returns the unspent outputs of the transaction txID in the order
+// of their keys
+func (v *view) outputs(txID []byte) ([]*entry, error) {
+	found := make(map[string]*entry)
+	cursor := v.bucket.Cursor()
+	for k, data := cursor.Seek(txID); k != nil && bytes.HasPrefix(k, txID); k, data = cursor.Next() {
+		coin, err := decodeCoin(data)
+		if err != nil {
+			return nil, err
+		}
+		found[string(k)] = &entry{key: string(k), coin: coin, size: len(k) + len(data)}
+	}
+	// 干净的条目和 coins bucket 中的一样，只要合并脏条目和块里的改动
+	if v.c != nil {
+		for key, e := range v.c.dirtyTxs[string(txID)] {
+			found[key] = e
+		}
+	}
+	for key, e := range v.txs[string(txID)] {
+		found[key] = e
+	}
+
+	var outs []*entry
+	for _, e := range found {
+		if e.coin != nil {
+			outs = append(outs, e)
+		}
+	}
+	sort.Slice(outs, func(i, j int) bool {
+		return outs[i].key < outs[j].key
+	})
+
+	return outs, nil
+}
+
+// writeBack writes the dirty entries of the cache and the changes of the
+// block to the coins bucket
+func (v *view) writeBack() error {
+	if v.c != nil {
+		for key, e := range v.c.dirty {
+			// 块里的改动比缓存中的新
+			if _, ok := v.changes[key]; ok {
+				continue
+			}
+			if err := writeEntry(v.bucket, e); err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range v.changes {
+		if err := writeEntry(v.bucket, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeEntry(bucket storage.Bucket, e *entry) error {
+	if e.coin == nil {
+		return bucket.Delete([]byte(e.key))
+	}
+
+	return bucket.Put([]byte(e.key), e.coin.serialize())
+}
+
+// commit ends the changes of a block in its transaction. When flush is set
+// they are written back with the dirty entries and the statistics, the cache
+// takes them once the transaction is committed.
+func (v *view) commit(tx storage.Tx, block []byte, connect bool, stats *utxoStats, flush bool) error {
+	if flush {
+		if err := v.writeBack(); err != nil {
+			return err
+		}
+		if err := writeStats(tx, stats); err != nil {
+			return err
+		}
+	}
+	if v.c == nil {
+		return nil
+	}
+
+	c := v.c
+	p := &pendingChanges{block: block, connect: connect, changes: v.changes, stats: stats, flushed: flush}
+	c.pending = p
+	// 提交后另一个块可能先拿到缓存的锁，那时由它的 resolvePending 放进缓存
+	tx.OnCommit(func() {
+		c.mu.Lock()
+		if c.pending == p {
+			c.apply(p)
+		}
+		c.mu.Unlock()
+	})
+
+	return nil
+}
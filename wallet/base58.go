@@ -40,6 +40,9 @@ var b58Alphabet = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuv
 // Base58Encode encodes a byte array to Base58
 func Base58Encode(input []byte) []byte {
 	var result []byte
+	if len(input) == 0 {
+		return result
+	}
 
 	x := big.NewInt(0).SetBytes(input)
 
@@ -53,7 +56,11 @@ func Base58Encode(input []byte) []byte {
 	}
 
 	// https://en.bitcoin.it/wiki/Base58Check_encoding#Version_bytes
-	if input[0] == 0x00 {
+	// 每个前导的 0 字节编码成一个 '1'，大整数会把它们丢掉
+	for _, b := range input {
+		if b != 0x00 {
+			break
+		}
 		result = append(result, b58Alphabet[0])
 	}
 
@@ -64,6 +71,9 @@ func Base58Encode(input []byte) []byte {
 
 // Base58Decode decodes Base58-encoded data
 func Base58Decode(input []byte) []byte {
+	if len(input) == 0 {
+		return nil
+	}
 	result := big.NewInt(0)
 
 	for _, b := range input {
@@ -74,7 +84,10 @@ func Base58Decode(input []byte) []byte {
 
 	decoded := result.Bytes()
 
-	if input[0] == b58Alphabet[0] {
+	for _, b := range input {
+		if b != b58Alphabet[0] {
+			break
+		}
 		decoded = append([]byte{0x00}, decoded...)
 	}
 
@@ -35,33 +35,50 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"golang.org/x/crypto/ripemd160"
-	"log"
+	"errors"
 	"bytes"
 )
 
 const version = byte(0x00)
 const addressChecksumLen = 4
 
+var ErrInvalidAddress = errors.New("wallet: address is not valid")
+
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
 }
 
-func NewWallet() *Wallet {
-	private, public := newPair()
-	return &Wallet{private, public}
+func NewWallet() (*Wallet, error) {
+	private, public, err := newPair()
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{private, public}, nil
 }
 
-func newPair() (ecdsa.PrivateKey, []byte) {
+func newPair() (ecdsa.PrivateKey, []byte, error) {
 	curve := elliptic.P256()
-	private, _ := ecdsa.GenerateKey(curve, rand.Reader)
-	public := append(private.X.Bytes(), private.Y.Bytes()...)
-	return *private, public
+	private, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return ecdsa.PrivateKey{}, nil, err
+	}
+	// X 和 Y 补齐到同样长度，验证签名时从中间分开
+	size := (curve.Params().BitSize + 7) / 8
+	public := make([]byte, 2*size)
+	private.X.FillBytes(public[:size])
+	private.Y.FillBytes(public[size:])
+	return *private, public, nil
 }
 
 func (w Wallet) GetAddress() []byte {
 	pubKeyHash := HashPubKey(w.PublicKey)
 
+	return AddressFromPubKeyHash(pubKeyHash)
+}
+
+// AddressFromPubKeyHash encodes a public key hash the same way GetAddress does
+func AddressFromPubKeyHash(pubKeyHash []byte) []byte {
 	versionedPayload := append([]byte{version}, pubKeyHash...)
 	checksum := checksum(versionedPayload)
 
@@ -74,11 +91,9 @@ func (w Wallet) GetAddress() []byte {
 func HashPubKey(pubKey []byte) []byte {
 	publicSHA256 := sha256.Sum256(pubKey)
 
+	// hash.Hash 的 Write 不会返回错误
 	RIPEMD160Hasher := ripemd160.New()
-	_, err := RIPEMD160Hasher.Write(publicSHA256[:])
-	if err != nil {
-		log.Panic(err)
-	}
+	RIPEMD160Hasher.Write(publicSHA256[:])
 	publicRIPEMD160 := RIPEMD160Hasher.Sum(nil)
 
 	return publicRIPEMD160
@@ -92,17 +107,29 @@ func checksum(payload []byte) []byte {
 }
 
 func ValidateAddress(addr string) bool {
-	pubKeyHash := Base58Decode([]byte(addr))
-	checkSum := pubKeyHash[:addressChecksumLen]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1: len(pubKeyHash)-addressChecksumLen]
-	tarCheckSum := checksum(append([]byte{version}, pubKeyHash...))
-
-	return bytes.Compare(checkSum, tarCheckSum) == -1
+	_, err := PubKeyHashFromAddress(addr)
+	return err == nil
 }
 
 func GetKey(addr string) []byte {
 	pubKeyHash := []byte(addr)
+	if len(pubKeyHash) <= 1+addressChecksumLen {
+		return nil
+	}
 	pubKeyHash = pubKeyHash[1:len(pubKeyHash)-addressChecksumLen]
 	return pubKeyHash
 }
+
+// PubKeyHashFromAddress decodes the public key hash locked by addr
+func PubKeyHashFromAddress(addr string) ([]byte, error) {
+	payload := Base58Decode([]byte(addr))
+	if len(payload) <= 1+addressChecksumLen {
+		return nil, ErrInvalidAddress
+	}
+	versioned := payload[:len(payload)-addressChecksumLen]
+	if !bytes.Equal(checksum(versioned), payload[len(versioned):]) {
+		return nil, ErrInvalidAddress
+	}
+
+	return versioned[1:], nil
+}
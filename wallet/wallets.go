@@ -33,7 +33,6 @@ import (
 	"fmt"
 	"os"
 	"io/ioutil"
-	"log"
 	"encoding/gob"
 	"crypto/elliptic"
 	"bytes"
@@ -52,11 +51,14 @@ func NewWallets(nodeId string) (*Wallets, error) {
 	return wallets, err
 }
 
-func (ws *Wallets) CreateWallet() string {
-	wallet := NewWallet()
+func (ws *Wallets) CreateWallet() (string, error) {
+	wallet, err := NewWallet()
+	if err != nil {
+		return "", err
+	}
 	address := string(wallet.GetAddress())
 	ws.Wallets[address] = wallet
-	return address
+	return address, nil
 }
 
 func (ws *Wallets) GetAddresses() []string {
@@ -74,6 +76,8 @@ func (ws *Wallets) GetWallet(address string) *Wallet {
 	return ws.Wallets[address]
 }
 
+// LoadFromFile reads the wallet file of the node, the error of a missing file
+// satisfies os.IsNotExist
 func (ws *Wallets) LoadFromFile(nodeId string) error {
 	file := fmt.Sprintf(walletFile, nodeId)
 	if _, err := os.Stat(file); os.IsNotExist(err) {
@@ -82,7 +86,7 @@ func (ws *Wallets) LoadFromFile(nodeId string) error {
 
 	content, err := ioutil.ReadFile(file)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	var wallets Wallets
@@ -90,27 +94,23 @@ func (ws *Wallets) LoadFromFile(nodeId string) error {
 	decoder := gob.NewDecoder(bytes.NewReader(content))
 	err = decoder.Decode(&wallets)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("wallet: %s: %w", file, err)
 	}
 
 	ws.Wallets = wallets.Wallets
 	return nil
 }
 
-func (ws *Wallets) SaveToFile(nodeId string) {
+func (ws *Wallets) SaveToFile(nodeId string) error {
 	file := fmt.Sprintf(walletFile, nodeId)
-	fmt.Println("file: " + file)
 	var content bytes.Buffer
 
 	gob.Register(elliptic.P256())
 	encoder := gob.NewEncoder(&content)
 	err := encoder.Encode(ws)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	err = ioutil.WriteFile(file, content.Bytes(), 0644)
-	if err != nil {
-		log.Panic(err)
-	}
+	return ioutil.WriteFile(file, content.Bytes(), 0644)
 }